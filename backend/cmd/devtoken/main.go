@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-jose/go-jose/v3/jwt"
+
+	"github.com/ozoli99/Harmonia/auth"
+	"github.com/ozoli99/Harmonia/config"
+)
+
+// devtoken mints a token for the local auth provider, e.g.
+//
+//	go run ./cmd/devtoken -sub user_1 -role client -email client@example.com
+func main() {
+	configPath := flag.String("config", "../../harmonia-config.yaml", "path to the Harmonia config file")
+	subject := flag.String("sub", "", "user ID to put in the token subject")
+	role := flag.String("role", "client", "user role (client, masseur, admin)")
+	email := flag.String("email", "", "user email")
+	orgID := flag.String("org", "", "external organization ID")
+	orgSlug := flag.String("org-slug", "", "organization slug, for organizations without an external ID")
+	ttl := flag.Duration("ttl", time.Hour, "token lifetime")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fail("Error loading configuration: %v", err)
+	}
+	if cfg.AuthProvider != "local" {
+		fail("AuthProvider is %q; devtoken only works with the local auth provider", cfg.AuthProvider)
+	}
+	if cfg.IsProduction() {
+		fail("Refusing to issue local tokens in production")
+	}
+
+	issuer, err := auth.NewLocalIssuer(cfg.LocalAuthSigningKey, cfg.LocalAuthIssuer)
+	if err != nil {
+		fail("Error creating issuer: %v", err)
+	}
+
+	token, err := issuer.Issue(auth.Claims{
+		Claims:  jwt.Claims{Subject: *subject},
+		Email:   *email,
+		Role:    *role,
+		OrgID:   *orgID,
+		OrgSlug: *orgSlug,
+	}, *ttl)
+	if err != nil {
+		fail("Error issuing token: %v", err)
+	}
+
+	fmt.Println(token)
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
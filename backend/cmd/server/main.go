@@ -12,7 +12,11 @@ import (
 	"github.com/ozoli99/Harmonia/config"
 	"github.com/ozoli99/Harmonia/db"
 	"github.com/ozoli99/Harmonia/handlers"
+	"github.com/ozoli99/Harmonia/jobs"
 	"github.com/ozoli99/Harmonia/middleware"
+	"github.com/ozoli99/Harmonia/payments"
+	"github.com/ozoli99/Harmonia/reconciliation"
+	"github.com/ozoli99/Harmonia/webhooks"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/lib/pq"
@@ -31,15 +35,73 @@ func main() {
 		logger.Fatal("Error loading configuration", zap.Error(err))
 	}
 
+	if err := cfg.Validate(); err != nil {
+		logger.Fatal("Invalid configuration", zap.Error(err))
+	}
+
 	dbConn := db.NewPostgresDB(cfg.DatabaseURL, logger)
 
 	appointmentRepo := db.NewAppointmentRepository(dbConn, logger)
-	
-	appointmentHandler := handlers.NewAppointmentHandler(appointmentRepo, logger)
-	paymentHandler := handlers.NewPaymentHandler(dbConn, cfg, logger)
-	subscriptionHandler := handlers.NewSubscriptionHandler(dbConn, cfg, logger)
+	paymentRepo := db.NewPaymentRepository(dbConn, logger)
+	subscriptionRepo := db.NewSubscriptionRepository(dbConn, logger)
+	apiKeyRepo := db.NewAPIKeyRepository(dbConn, logger)
+	auditRepo := db.NewAuditRepository(dbConn, logger)
+	organizationRepo := db.NewOrganizationRepository(dbConn, logger)
+	userRepo := db.NewUserRepository(dbConn, logger)
+	serviceRepo := db.NewServiceRepository(dbConn, logger)
+	webhookEventRepo := db.NewWebhookEventRepository(dbConn, logger)
+	idempotencyRepo := db.NewIdempotencyRepository(dbConn, logger)
+	feePolicyRepo := db.NewFeePolicyRepository(dbConn, logger)
+	earningsRepo := db.NewEarningsRepository(dbConn, logger)
+	creditRepo := db.NewCreditRepository(dbConn, logger)
+	promoCodeRepo := db.NewPromoCodeRepository(dbConn, logger)
+	giftCardRepo := db.NewGiftCardRepository(dbConn, logger)
+	receiptRepo := db.NewReceiptRepository(dbConn, logger)
+	reconciliationRepo := db.NewReconciliationRepository(dbConn, logger)
+
+	identityProvider, err := handlers.NewIdentityProvider(cfg, userRepo, logger)
+	if err != nil {
+		logger.Fatal("Error initializing auth provider", zap.Error(err))
+	}
+
+	paymentAdapter, err := payments.New(cfg, logger)
+	if err != nil {
+		logger.Fatal("Error initializing payment adapter", zap.Error(err))
+	}
 
-	handlers.InitializeClerk(cfg, logger)
+	webhookProcessor := webhooks.NewProcessor(webhookEventRepo, logger)
+
+	paymentHandler := handlers.NewPaymentHandler(paymentRepo, userRepo, serviceRepo, feePolicyRepo, promoCodeRepo, giftCardRepo, paymentAdapter, cfg, logger)
+	appointmentHandler := handlers.NewAppointmentHandler(appointmentRepo, subscriptionRepo, creditRepo, paymentHandler, logger)
+	subscriptionHandler := handlers.NewSubscriptionHandler(subscriptionRepo, paymentAdapter, cfg, logger)
+	serviceHandler := handlers.NewServiceHandler(serviceRepo, logger)
+	packageHandler := handlers.NewPackageHandler(creditRepo, feePolicyRepo, paymentAdapter, logger)
+	giftCardHandler := handlers.NewGiftCardHandler(giftCardRepo, feePolicyRepo, paymentAdapter, logger)
+	promoCodeHandler := handlers.NewPromoCodeHandler(promoCodeRepo, logger)
+	receiptHandler := handlers.NewReceiptHandler(receiptRepo, logger)
+	feePolicyHandler := handlers.NewFeePolicyHandler(feePolicyRepo, logger)
+	apiKeyHandler := handlers.NewAPIKeyHandler(apiKeyRepo, logger)
+	impersonationHandler := handlers.NewImpersonationHandler(auditRepo, identityProvider, cfg, logger)
+	organizationHandler := handlers.NewOrganizationHandler(organizationRepo, logger)
+	clerkWebhookHandler := handlers.NewClerkWebhookHandler(userRepo, organizationRepo, cfg, logger)
+	payoutAccountHandler := handlers.NewPayoutAccountHandler(userRepo, paymentAdapter, cfg, logger)
+	paymentMethodHandler := handlers.NewPaymentMethodHandler(userRepo, paymentAdapter, logger)
+	earningsHandler := handlers.NewEarningsHandler(earningsRepo, userRepo, paymentAdapter, logger)
+	webhookHandler := handlers.NewWebhookHandler(paymentAdapter, webhookEventRepo, webhookProcessor, cfg, logger)
+	reconciliationHandler := handlers.NewReconciliationHandler(reconciliationRepo, logger)
+
+	paymentHandler.RegisterWebhookHandlers(webhookProcessor)
+	subscriptionHandler.RegisterWebhookHandlers(webhookProcessor)
+	payoutAccountHandler.RegisterWebhookHandlers(webhookProcessor)
+	packageHandler.RegisterWebhookHandlers(webhookProcessor)
+	giftCardHandler.RegisterWebhookHandlers(webhookProcessor)
+
+	workerCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+	go webhookProcessor.Run(workerCtx)
+	go jobs.Every(workerCtx, time.Hour, "expire_credits", logger, jobs.ExpireCredits(creditRepo, logger))
+	reconciler := reconciliation.NewReconciler(paymentAdapter, reconciliationRepo, webhookProcessor, logger)
+	go jobs.Daily(workerCtx, cfg.ReconciliationHour, "reconcile_payments", logger, reconciler.Run)
 
 	router := gin.New()
 	router.Use(
@@ -52,55 +114,44 @@ func main() {
 		c.JSON(http.StatusOK, gin.H{"status": "healthy"})
 	})
 
-	apiV1 := router.Group("/api/v1")
-	{
-		apiV1.Use(handlers.ClerkMiddleware(cfg))
-
-		apiV1.GET("/appointments", appointmentHandler.GetAppointments)
-		apiV1.POST("/appointments", appointmentHandler.CreateAppointment)
-		apiV1.PUT("/appointments/:id", appointmentHandler.UpdateAppointment)
-		apiV1.DELETE("/appointments/:id", appointmentHandler.DeleteAppointment)
-
-	}
-	
-	paymentRoutes := apiV1.Group("/payments")
-	paymentRoutes.Use(handlers.RoleMiddleware("client"))
-	{
-		paymentRoutes.POST("/checkout", paymentHandler.CreatePaymentIntent)
-		paymentRoutes.POST("/webhook", paymentHandler.HandleStripeWebhook)
-	}
-
-	subscriptionRoutes := apiV1.Group("/subscriptions")
-	subscriptionRoutes.Use(handlers.RoleMiddleware("client"))
+	// Webhooks authenticate with provider signatures, not user tokens.
+	webhookRoutes := router.Group("/webhooks")
 	{
-		subscriptionRoutes.POST("/checkout", subscriptionHandler.CreateSubscription)
-		subscriptionRoutes.POST("/webhook", subscriptionHandler.HandleStripeSubscriptionWebhook)
+		webhookRoutes.POST("/clerk", clerkWebhookHandler.HandleClerkWebhook)
+		webhookRoutes.POST("/stripe", webhookHandler.HandleStripeWebhook)
+		webhookRoutes.POST("/stripe/connect", webhookHandler.HandleStripeConnectWebhook)
+		webhookRoutes.POST("/paypal", webhookHandler.HandlePayPalWebhook)
 	}
 
-	// Clients can only book/view appointments
-	clientRoutes := apiV1.Group("/clients")
-	clientRoutes.Use(handlers.RoleMiddleware("client"))
-	{
-		clientRoutes.POST("/appointments", appointmentHandler.CreateAppointment)
-		clientRoutes.GET("/appointments", appointmentHandler.GetAppointments)
-	}
-	
-	// Masseurs can manage their own appointments
-	masseurRoutes := apiV1.Group("/masseurs")
-	masseurRoutes.Use(handlers.RoleMiddleware("masseur"))
-	{
-		//masseurRoutes.GET("/appointments", getMasseurAppointments)
-		masseurRoutes.PUT("/appointments/:id", appointmentHandler.UpdateAppointment)
-	}
+	apiV1 := router.Group("/api/v1")
+	apiV1.Use(
+		handlers.AuthMiddleware(identityProvider, apiKeyRepo, middleware.NewRateLimiter(time.Minute), logger),
+		handlers.APIKeyScopeMiddleware(apiKeyScopes),
+		handlers.TenantMiddleware(cfg, organizationRepo, userRepo, logger),
+		handlers.ImpersonationMiddleware(cfg, auditRepo, logger),
+		handlers.IdentityMiddleware(userRepo, logger),
+		handlers.IdempotencyMiddleware(idempotencyRepo, logger),
+	)
 
-	// Admins have full control
-	adminRoutes := apiV1.Group("/admin")
-	adminRoutes.Use(handlers.RoleMiddleware("admin"))
-	{
-		//adminRoutes.GET("/users", listUsers)
-		//adminRoutes.DELETE("/users/:id", deleteUser)
-		adminRoutes.DELETE("/appointments/:id", appointmentHandler.DeleteAppointment)
-	}
+	registerAPIRoutes(apiV1, cfg, apiHandlers{
+		appointments:   appointmentHandler,
+		payments:       paymentHandler,
+		subscriptions:  subscriptionHandler,
+		services:       serviceHandler,
+		packages:       packageHandler,
+		giftCards:      giftCardHandler,
+		promoCodes:     promoCodeHandler,
+		receipts:       receiptHandler,
+		feePolicies:    feePolicyHandler,
+		apiKeys:        apiKeyHandler,
+		impersonation:  impersonationHandler,
+		organizations:  organizationHandler,
+		payoutAccounts: payoutAccountHandler,
+		paymentMethods: paymentMethodHandler,
+		earnings:       earningsHandler,
+		webhooks:       webhookHandler,
+		reconciliation: reconciliationHandler,
+	})
 
 	srv := &http.Server{
 		Addr: ":" + cfg.Port,
@@ -118,6 +169,7 @@ func main() {
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
 	logger.Info("Shutting down server...")
+	stopWorkers()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
 	defer cancel()
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/handlers"
+)
+
+// API keys can call only the routes listed here; everything else is for
+// signed-in users.
+var apiKeyScopes = handlers.RouteScopes{
+	"GET /api/v1/appointments":         "appointments:read",
+	"POST /api/v1/appointments":        "appointments:write",
+	"PUT /api/v1/appointments/:id":     "appointments:write",
+	"DELETE /api/v1/appointments/:id":  "appointments:write",
+	"GET /api/v1/payments":             "payments:read",
+	"GET /api/v1/payments/:id/receipt": "payments:read",
+}
+
+// apiHandlers are the handlers behind the /api/v1 routes.
+type apiHandlers struct {
+	appointments   *handlers.AppointmentHandler
+	payments       *handlers.PaymentHandler
+	subscriptions  *handlers.SubscriptionHandler
+	services       *handlers.ServiceHandler
+	packages       *handlers.PackageHandler
+	giftCards      *handlers.GiftCardHandler
+	promoCodes     *handlers.PromoCodeHandler
+	receipts       *handlers.ReceiptHandler
+	feePolicies    *handlers.FeePolicyHandler
+	apiKeys        *handlers.APIKeyHandler
+	impersonation  *handlers.ImpersonationHandler
+	organizations  *handlers.OrganizationHandler
+	payoutAccounts *handlers.PayoutAccountHandler
+	paymentMethods *handlers.PaymentMethodHandler
+	earnings       *handlers.EarningsHandler
+	webhooks       *handlers.WebhookHandler
+	reconciliation *handlers.ReconciliationHandler
+}
+
+// registerAPIRoutes adds the /api/v1 routes to apiV1, which already carries
+// the authentication middleware. Routes that move money or delete data are
+// marked with destructive so that impersonating admins cannot call them.
+func registerAPIRoutes(apiV1 *gin.RouterGroup, cfg *config.Config, h apiHandlers) {
+	destructive := handlers.DestructiveActionMiddleware(cfg)
+
+	apiV1.GET("/appointments", h.appointments.GetAppointments)
+	apiV1.POST("/appointments", h.appointments.CreateAppointment)
+	apiV1.PUT("/appointments/:id", h.appointments.UpdateAppointment)
+	apiV1.DELETE("/appointments/:id", destructive, h.appointments.DeleteAppointment)
+
+	apiV1.GET("/services", h.services.ListServices)
+	apiV1.GET("/add-ons", h.services.ListAddOns)
+	apiV1.GET("/packages", h.packages.ListPackages)
+	apiV1.POST("/packages/:id/purchase", handlers.RoleMiddleware("client"), destructive, h.packages.PurchasePackage)
+	apiV1.POST("/gift-cards/purchase", handlers.RoleMiddleware("client"), destructive, h.giftCards.PurchaseGiftCard)
+	apiV1.POST("/gift-cards/check", h.giftCards.CheckGiftCard)
+
+	paymentRoutes := apiV1.Group("/payments")
+	clientPaymentRoutes := paymentRoutes.Group("")
+	clientPaymentRoutes.Use(handlers.RoleMiddleware("client"))
+	{
+		clientPaymentRoutes.GET("/quote/:appointment_id", h.payments.GetPaymentQuote)
+		clientPaymentRoutes.POST("/checkout", destructive, h.payments.CreatePaymentIntent)
+		clientPaymentRoutes.POST("/tip", destructive, h.payments.CreateTip)
+	}
+	paymentRoutes.GET("", h.payments.ListPayments)
+	paymentRoutes.POST("/:id/refund", handlers.RoleMiddleware("admin", "masseur"), destructive, h.payments.RefundPayment)
+	paymentRoutes.POST("/:id/capture", handlers.RoleMiddleware("admin", "masseur"), destructive, h.payments.CapturePayment)
+	paymentRoutes.POST("/:id/void", handlers.RoleMiddleware("admin", "masseur"), destructive, h.payments.VoidPayment)
+	paymentRoutes.GET("/:id/receipt", h.receipts.GetReceipt)
+
+	subscriptionRoutes := apiV1.Group("/subscriptions")
+	subscriptionRoutes.Use(handlers.RoleMiddleware("client"))
+	{
+		subscriptionRoutes.POST("/checkout", destructive, h.subscriptions.CreateSubscription)
+	}
+
+	// Clients can only book/view appointments
+	clientRoutes := apiV1.Group("/clients")
+	clientRoutes.Use(handlers.RoleMiddleware("client"))
+	{
+		clientRoutes.POST("/appointments", h.appointments.CreateAppointment)
+		clientRoutes.GET("/appointments", h.appointments.GetAppointments)
+		clientRoutes.GET("/credits", h.packages.GetMyCredits)
+		clientRoutes.GET("/gift-cards", h.giftCards.GetMyGiftCards)
+		clientRoutes.GET("/payment-methods", h.paymentMethods.ListPaymentMethods)
+		clientRoutes.POST("/payment-methods/setup", destructive, h.paymentMethods.SetupPaymentMethod)
+		clientRoutes.DELETE("/payment-methods/:id", destructive, h.paymentMethods.RemovePaymentMethod)
+	}
+
+	// Masseurs can manage their own appointments
+	masseurRoutes := apiV1.Group("/masseurs")
+	masseurRoutes.Use(handlers.RoleMiddleware("masseur"))
+	{
+		//masseurRoutes.GET("/appointments", getMasseurAppointments)
+		masseurRoutes.PUT("/appointments/:id", h.appointments.UpdateAppointment)
+
+		masseurRoutes.GET("/payout-account", h.payoutAccounts.GetPayoutAccount)
+		masseurRoutes.POST("/payout-account", destructive, h.payoutAccounts.CreatePayoutAccount)
+		masseurRoutes.POST("/payout-account/link", h.payoutAccounts.RegenerateOnboardingLink)
+		masseurRoutes.GET("/earnings", h.earnings.GetMyEarnings)
+	}
+
+	// Admins have full control
+	adminRoutes := apiV1.Group("/admin")
+	adminRoutes.Use(handlers.RoleMiddleware("admin"))
+	{
+		//adminRoutes.GET("/users", listUsers)
+		//adminRoutes.DELETE("/users/:id", deleteUser)
+		adminRoutes.DELETE("/appointments/:id", destructive, h.appointments.DeleteAppointment)
+		adminRoutes.PUT("/appointments/:id/discount", h.appointments.SetAppointmentDiscount)
+
+		adminRoutes.POST("/api-keys", h.apiKeys.CreateAPIKey)
+		adminRoutes.GET("/api-keys", h.apiKeys.ListAPIKeys)
+		adminRoutes.DELETE("/api-keys/:id", destructive, h.apiKeys.RevokeAPIKey)
+
+		adminRoutes.POST("/services", h.services.CreateService)
+		adminRoutes.POST("/add-ons", h.services.CreateAddOn)
+		adminRoutes.POST("/packages", h.packages.CreatePackage)
+		adminRoutes.GET("/clients/:id/credits", h.packages.GetClientCredits)
+
+		adminRoutes.GET("/promo-codes", h.promoCodes.ListPromoCodes)
+		adminRoutes.POST("/promo-codes", h.promoCodes.CreatePromoCode)
+		adminRoutes.PUT("/promo-codes/:id", h.promoCodes.UpdatePromoCode)
+		adminRoutes.DELETE("/promo-codes/:id", destructive, h.promoCodes.DeactivatePromoCode)
+
+		adminRoutes.GET("/gift-cards", h.giftCards.ListGiftCards)
+		adminRoutes.POST("/gift-cards", destructive, h.giftCards.IssueGiftCard)
+		adminRoutes.GET("/gift-cards/:id", h.giftCards.GetGiftCard)
+		adminRoutes.PUT("/gift-cards/:id/status", h.giftCards.UpdateGiftCardStatus)
+		adminRoutes.POST("/gift-cards/:id/adjust", destructive, h.giftCards.AdjustGiftCard)
+
+		adminRoutes.GET("/fee-policies", h.feePolicies.ListFeePolicies)
+		adminRoutes.PUT("/fee-policies", h.feePolicies.SaveFeePolicy)
+		adminRoutes.DELETE("/fee-policies/:id", destructive, h.feePolicies.DeleteFeePolicy)
+
+		adminRoutes.GET("/earnings", h.earnings.ListEarnings)
+		adminRoutes.PUT("/masseurs/:id/currency", h.payoutAccounts.SetMasseurCurrency)
+
+		adminRoutes.GET("/organization", h.organizations.GetOrganization)
+		adminRoutes.PATCH("/organization", h.organizations.UpdateOrganizationSettings)
+		// Kept for callers written before the endpoint became PATCH.
+		adminRoutes.PUT("/organization", h.organizations.UpdateOrganizationSettings)
+
+		adminRoutes.POST("/impersonate", h.impersonation.StartImpersonation)
+		adminRoutes.GET("/impersonation-audit", h.impersonation.ListImpersonationAudit)
+
+		adminRoutes.GET("/webhook-events", h.webhooks.ListWebhookEvents)
+		adminRoutes.GET("/webhook-events/:id", h.webhooks.GetWebhookEvent)
+		adminRoutes.POST("/webhook-events/:id/replay", destructive, h.webhooks.ReplayWebhookEvent)
+
+		adminRoutes.GET("/reconciliation-issues", h.reconciliation.ListReconciliationIssues)
+		adminRoutes.POST("/reconciliation-issues/:id/resolve", h.reconciliation.ResolveReconciliationIssue)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ozoli99/Harmonia/config"
+)
+
+// Writes that impersonating admins may make. Every other route that is not
+// a GET must be marked destructive.
+var allowedWhileImpersonating = map[string]bool{
+	"POST /api/v1/appointments":                            true,
+	"PUT /api/v1/appointments/:id":                         true,
+	"POST /api/v1/gift-cards/check":                        true,
+	"POST /api/v1/clients/appointments":                    true,
+	"PUT /api/v1/masseurs/appointments/:id":                true,
+	"POST /api/v1/masseurs/payout-account/link":            true,
+	"PUT /api/v1/admin/appointments/:id/discount":          true,
+	"POST /api/v1/admin/api-keys":                          true,
+	"POST /api/v1/admin/services":                          true,
+	"POST /api/v1/admin/add-ons":                           true,
+	"POST /api/v1/admin/packages":                          true,
+	"POST /api/v1/admin/promo-codes":                       true,
+	"PUT /api/v1/admin/promo-codes/:id":                    true,
+	"PUT /api/v1/admin/gift-cards/:id/status":              true,
+	"PUT /api/v1/admin/fee-policies":                       true,
+	"PUT /api/v1/admin/masseurs/:id/currency":              true,
+	"PATCH /api/v1/admin/organization":                     true,
+	"PUT /api/v1/admin/organization":                       true,
+	"POST /api/v1/admin/impersonate":                       true,
+	"POST /api/v1/admin/reconciliation-issues/:id/resolve": true,
+}
+
+// TestImpersonationBlocksDestructiveRoutes walks the API routes as an
+// impersonating admin and checks that every write outside the allowlist is
+// refused before its handler runs. The handlers are nil, so a route that is
+// not marked panics into a 500.
+func TestImpersonationBlocksDestructiveRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(gin.RecoveryWithWriter(io.Discard))
+	apiV1 := router.Group("/api/v1")
+	apiV1.Use(func(c *gin.Context) {
+		c.Set("impersonating", true)
+		c.Set("user_role", c.GetHeader("X-Test-Role"))
+	})
+	registerAPIRoutes(apiV1, &config.Config{ImpersonationBlockDestructive: true}, apiHandlers{})
+
+	seen := map[string]bool{}
+	for _, route := range router.Routes() {
+		name := route.Method + " " + route.Path
+		seen[name] = true
+		if route.Method == http.MethodGet || allowedWhileImpersonating[name] {
+			continue
+		}
+
+		// Group middleware runs first, so try each role until one gets past
+		// the role check.
+		blocked := false
+		var last *httptest.ResponseRecorder
+		for _, role := range []string{"client", "masseur", "admin"} {
+			req := httptest.NewRequest(route.Method, strings.ReplaceAll(route.Path, ":id", "1"), nil)
+			req.Header.Set("X-Test-Role", role)
+			last = httptest.NewRecorder()
+			router.ServeHTTP(last, req)
+			if last.Code == http.StatusForbidden && strings.Contains(last.Body.String(), "while impersonating") {
+				blocked = true
+				break
+			}
+		}
+		if !blocked {
+			t.Errorf("%s is not blocked while impersonating: %d %s", name, last.Code, last.Body.String())
+		}
+	}
+
+	for name := range allowedWhileImpersonating {
+		if !seen[name] {
+			t.Errorf("allowlisted route %s does not exist", name)
+		}
+	}
+}
+
+func TestAPIKeyScopesNameRoutes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	registerAPIRoutes(router.Group("/api/v1"), &config.Config{}, apiHandlers{})
+
+	seen := map[string]bool{}
+	for _, route := range router.Routes() {
+		seen[route.Method+" "+route.Path] = true
+	}
+	for name := range apiKeyScopes {
+		if !seen[name] {
+			t.Errorf("API key scope is declared for %s, which does not exist", name)
+		}
+	}
+}
@@ -1,19 +1,75 @@
 package config
 
 import (
+	"errors"
 	"os"
 
 	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
-	Port                string `yaml:"Port"`
-	DatabaseURL         string `yaml:"DatabaseURL"`
-	ClerkSecretKey      string `yaml:"ClerkSecretKey"`
-	StripeSecretKey     string `yaml:"StripeSecretKey"`
-	StripeWebhookSecret string `yaml:"StripeWebhookSecret"`
-	StripeSuccessURL    string `yaml:"StripeSuccessURL"`
-	StripeCancelURL     string `yaml:"StripeCancelURL"`
+	Environment                string `yaml:"Environment"`
+	Port                       string `yaml:"Port"`
+	DatabaseURL                string `yaml:"DatabaseURL"`
+	AuthProvider               string `yaml:"AuthProvider"`
+	ClerkSecretKey             string `yaml:"ClerkSecretKey"`
+	ClerkWebhookSecret         string `yaml:"ClerkWebhookSecret"`
+	LocalAuthSigningKey        string `yaml:"LocalAuthSigningKey"`
+	LocalAuthIssuer            string `yaml:"LocalAuthIssuer"`
+	PaymentAdapter             string `yaml:"PaymentAdapter"`
+	StripeSecretKey            string `yaml:"StripeSecretKey"`
+	StripeWebhookSecret        string `yaml:"StripeWebhookSecret"`
+	StripeConnectWebhookSecret string `yaml:"StripeConnectWebhookSecret"`
+	StripeSuccessURL           string `yaml:"StripeSuccessURL"`
+	StripeCancelURL            string `yaml:"StripeCancelURL"`
+	StripeConnectRefreshURL    string `yaml:"StripeConnectRefreshURL"`
+	StripeConnectReturnURL     string `yaml:"StripeConnectReturnURL"`
+	StripeConnectCountry       string `yaml:"StripeConnectCountry"`
+	PayPalClientID             string `yaml:"PayPalClientID"`
+	PayPalClientSecret         string `yaml:"PayPalClientSecret"`
+	PayPalMode                 string `yaml:"PayPalMode"`
+	PayPalWebhookID            string `yaml:"PayPalWebhookID"`
+
+	TenantBaseDomain        string `yaml:"TenantBaseDomain"`
+	DefaultOrganizationSlug string `yaml:"DefaultOrganizationSlug"`
+
+	ImpersonationSigningKey       string `yaml:"ImpersonationSigningKey"`
+	ImpersonationTTLMinutes       int    `yaml:"ImpersonationTTLMinutes"`
+	ImpersonationBlockDestructive bool   `yaml:"ImpersonationBlockDestructive"`
+
+	// ReconciliationHour is the hour of the day (UTC) at which payments and
+	// subscriptions are reconciled with the payment provider.
+	ReconciliationHour int `yaml:"ReconciliationHour"`
+}
+
+func (c *Config) IsProduction() bool {
+	return c.Environment == "" || c.Environment == "production"
+}
+
+func (c *Config) IsDevelopment() bool {
+	return c.Environment == "development"
+}
+
+// minSecretLength is the shortest signing key accepted outside development.
+const minSecretLength = 32
+
+// placeholderSecrets are example values from documentation and sample
+// configs that must never sign anything real.
+var placeholderSecrets = map[string]bool{
+	"change-me": true,
+	"changeme":  true,
+	"secret":    true,
+	"...":       true,
+}
+
+// Validate rejects settings that are only safe in development. An empty
+// ImpersonationSigningKey disables impersonation and is always accepted.
+func (c *Config) Validate() error {
+	key := c.ImpersonationSigningKey
+	if key != "" && !c.IsDevelopment() && (placeholderSecrets[key] || len(key) < minSecretLength) {
+		return errors.New("ImpersonationSigningKey must be a random value of at least 32 bytes; leave it empty to disable impersonation")
+	}
+	return nil
 }
 
 func LoadConfig(filename string) (*Config, error) {
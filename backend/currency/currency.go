@@ -0,0 +1,165 @@
+// Package currency knows the currencies payments can be taken in and how
+// their amounts are written. Amounts are kept in the minor unit the payment
+// provider uses: cents for USD, yen for JPY and fillér for HUF.
+package currency
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnsupported      = errors.New("unsupported currency")
+	ErrFractionalAmount = errors.New("amount must be a whole number of units")
+)
+
+type Currency struct {
+	// Code is the lower-case ISO 4217 code.
+	Code string
+	// Decimals is the number of minor-unit digits in amounts.
+	Decimals int
+	// WholeUnits marks currencies that have two decimals at the provider
+	// but are only charged and paid out in whole units, such as HUF and TWD.
+	// Their amounts must be multiples of 100 and are shown without
+	// decimals.
+	WholeUnits bool
+}
+
+var supported = map[string]Currency{}
+
+func init() {
+	for _, code := range []string{
+		"aed", "aud", "bgn", "brl", "cad", "chf", "czk", "dkk", "eur", "gbp", "hkd", "ils",
+		"inr", "mxn", "myr", "nok", "nzd", "php", "pln", "ron", "sek", "sgd", "thb", "usd", "zar",
+	} {
+		supported[code] = Currency{Code: code, Decimals: 2}
+	}
+	for _, code := range []string{"clp", "jpy", "krw", "vnd"} {
+		supported[code] = Currency{Code: code}
+	}
+	for _, code := range []string{"huf", "isk", "twd"} {
+		supported[code] = Currency{Code: code, Decimals: 2, WholeUnits: true}
+	}
+}
+
+// Normalize returns code trimmed and in lower case, the form it is stored in.
+func Normalize(code string) string {
+	return strings.ToLower(strings.TrimSpace(code))
+}
+
+// Lookup returns the supported currency with code, in any case.
+func Lookup(code string) (Currency, error) {
+	c, ok := supported[Normalize(code)]
+	if !ok {
+		return Currency{}, fmt.Errorf("%w %q", ErrUnsupported, code)
+	}
+	return c, nil
+}
+
+func Supported(code string) bool {
+	_, ok := supported[Normalize(code)]
+	return ok
+}
+
+// Codes lists the supported currency codes in order.
+func Codes() []string {
+	codes := make([]string, 0, len(supported))
+	for code := range supported {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// Validate checks that amount can be charged in code.
+func Validate(code string, amount int64) error {
+	c, err := Lookup(code)
+	if err != nil {
+		return err
+	}
+	if amount%c.Step() != 0 {
+		return fmt.Errorf("%w of %s", ErrFractionalAmount, strings.ToUpper(c.Code))
+	}
+	return nil
+}
+
+// Round rounds amount, half away from zero, to an amount that can be
+// charged in c.
+func (c Currency) Round(amount int64) int64 {
+	step := c.Step()
+	if step == 1 {
+		return amount
+	}
+	if amount < 0 {
+		return -c.Round(-amount)
+	}
+	return (amount + step/2) / step * step
+}
+
+// Format renders amount as a plain decimal string, e.g. "12.50" for USD,
+// "1500" for JPY and "15000" for HUF. Unknown currencies are assumed to
+// have two decimals.
+func Format(amount int64, code string) string {
+	c, err := Lookup(code)
+	if err != nil {
+		c = Currency{Code: Normalize(code), Decimals: 2}
+	}
+	return c.Format(amount)
+}
+
+func (c Currency) Format(amount int64) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	if c.Decimals == 0 {
+		return sign + strconv.FormatInt(amount, 10)
+	}
+	unit := pow10(c.Decimals)
+	if c.WholeUnits && amount%unit == 0 {
+		return sign + strconv.FormatInt(amount/unit, 10)
+	}
+	return fmt.Sprintf("%s%d.%0*d", sign, amount/unit, c.Decimals, amount%unit)
+}
+
+// Parse reads a decimal string as written by Format, or with the full
+// number of decimals, into minor units. Digits beyond the currency's
+// decimals are dropped.
+func Parse(value, code string) (int64, error) {
+	c, err := Lookup(code)
+	if err != nil {
+		return 0, err
+	}
+	value = strings.TrimSpace(value)
+	negative := strings.HasPrefix(value, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(value, "-"), ".")
+	frac = (frac + strings.Repeat("0", c.Decimals))[:c.Decimals]
+	amount, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// Step is the smallest amount that can be charged in c; every amount must
+// be a multiple of it.
+func (c Currency) Step() int64 {
+	if c.WholeUnits {
+		return pow10(c.Decimals)
+	}
+	return 1
+}
+
+func pow10(n int) int64 {
+	p := int64(1)
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
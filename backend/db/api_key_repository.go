@@ -0,0 +1,114 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	List(ctx context.Context) ([]models.APIKey, error)
+	Revoke(ctx context.Context, id int) error
+	TouchLastUsed(ctx context.Context, id int, usedAt time.Time) error
+}
+
+type PostgresAPIKeyRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewAPIKeyRepository(db *sqlx.DB, logger *zap.Logger) APIKeyRepository {
+	return &PostgresAPIKeyRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresAPIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	key.OrganizationID = orgID
+
+	query := `
+		INSERT INTO api_keys (organization_id, name, prefix, key_hash, scopes, rate_limit_per_minute, created_by, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(ctx, query,
+		key.OrganizationID,
+		key.Name,
+		key.Prefix,
+		key.KeyHash,
+		key.Scopes,
+		key.RateLimitPerMinute,
+		key.CreatedBy,
+		key.ExpiresAt,
+		key.CreatedAt,
+	).Scan(&key.ID)
+}
+
+// GetByPrefix is used during authentication, before a tenant is known, so it
+// is the one lookup not scoped to the current organization.
+func (r *PostgresAPIKeyRepository) GetByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	query := `
+		SELECT id, organization_id, name, prefix, key_hash, scopes, rate_limit_per_minute, created_by, expires_at, last_used_at, revoked_at, created_at
+		FROM api_keys
+		WHERE prefix = $1
+	`
+	var key models.APIKey
+	if err := r.db.GetContext(ctx, &key, query, prefix); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *PostgresAPIKeyRepository) List(ctx context.Context) ([]models.APIKey, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, organization_id, name, prefix, key_hash, scopes, rate_limit_per_minute, created_by, expires_at, last_used_at, revoked_at, created_at
+		FROM api_keys
+		WHERE organization_id = $1
+		ORDER BY created_at DESC
+	`
+	var keys []models.APIKey
+	if err := r.db.SelectContext(ctx, &keys, query, orgID); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return keys, nil
+}
+
+func (r *PostgresAPIKeyRepository) Revoke(ctx context.Context, id int) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `UPDATE api_keys SET revoked_at = NOW() WHERE id = $1 AND organization_id = $2 AND revoked_at IS NULL`
+	res, err := r.db.ExecContext(ctx, query, id, orgID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresAPIKeyRepository) TouchLastUsed(ctx context.Context, id int, usedAt time.Time) error {
+	query := `UPDATE api_keys SET last_used_at = $1 WHERE id = $2`
+	_, err := r.db.ExecContext(ctx, query, usedAt, id)
+	return err
+}
@@ -2,21 +2,35 @@ package db
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/ozoli99/Harmonia/models"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	"go.uber.org/zap"
 )
 
 type AppointmentRepository interface {
 	GetAll(ctx context.Context, filters map[string]string, limit, offset int) ([]models.Appointment, error)
-	GetAppointmentOwner(ctx context.Context, appointmentID int, ownerID *int) error
-	GetSubscriptionStatus(ctx context.Context, userID string, status *string) error
+	// GetByID returns an appointment of the current organization with its
+	// add-ons.
+	GetByID(ctx context.Context, id int) (*models.Appointment, error)
+	// HasPayments reports whether any payment other than a failed or
+	// canceled one was made for the appointment; its price is then fixed.
+	HasPayments(ctx context.Context, appointmentID int) (bool, error)
+	// SetDiscount sets the staff discount of an appointment in percent.
+	SetDiscount(ctx context.Context, id int, percent float64) error
+	// IsMasseurPayoutReady reports whether payments for the masseur can be
+	// paid out: to their own connected account once onboarding is complete,
+	// or to the organization's account when they have none.
+	IsMasseurPayoutReady(ctx context.Context, masseurID int) (bool, error)
 	Create(ctx context.Context, appt *models.Appointment) error
-	Update(ctx context.Context, id int, appt *models.Appointment) error
+	Update(ctx context.Context, id int, fromStatus string, appt *models.Appointment) error
 	Delete(ctx context.Context, id int) error
 }
 
@@ -33,13 +47,20 @@ func NewAppointmentRepository(db *sqlx.DB, logger *zap.Logger) AppointmentReposi
 }
 
 func (r *PostgresAppointmentRepository) GetAll(ctx context.Context, filters map[string]string, limit, offset int) ([]models.Appointment, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	query := `
-		SELECT id, client_id, masseur_id, appointment_date, start_time, end_time, type, status, description, location, recurrence_rule, created_at, updated_at 
+		SELECT id, organization_id, client_id, masseur_id, service_id, appointment_date, start_time, end_time, type, status, description, location, recurrence_rule, paid_with_credits, created_at, updated_at 
 		FROM appointments
-		WHERE 1=1
+		WHERE organization_id = :organization_id
 	`
 
-	args := map[string]interface{}{}
+	args := map[string]interface{}{
+		"organization_id": orgID,
+	}
 
 	if status, ok := filters["status"]; ok && status != "" {
 		query += " AND status = :status"
@@ -87,25 +108,113 @@ func (r *PostgresAppointmentRepository) GetAll(ctx context.Context, filters map[
 	return appointments, nil
 }
 
-func (r *PostgresAppointmentRepository) GetAppointmentOwner(ctx context.Context, appointmentID int, ownerID *int) error {
-	query := `SELECT client_id FROM appointments WHERE id=$1`
-	return r.db.GetContext(ctx, ownerID, query, appointmentID)
+func (r *PostgresAppointmentRepository) GetByID(ctx context.Context, id int) (*models.Appointment, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var appt models.Appointment
+	query := `
+		SELECT id, organization_id, client_id, masseur_id, service_id, appointment_date, start_time, end_time, type, status, description, location, recurrence_rule, paid_with_credits, created_at, updated_at
+		FROM appointments
+		WHERE id = $1 AND organization_id = $2
+	`
+	if err := r.db.GetContext(ctx, &appt, query, id, orgID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	appt.AddOnIDs = []int{}
+	if err := r.db.SelectContext(ctx, &appt.AddOnIDs, `SELECT add_on_id FROM appointment_add_ons WHERE appointment_id = $1 ORDER BY add_on_id`, id); err != nil {
+		return nil, fmt.Errorf("select add-ons error: %w", err)
+	}
+	return &appt, nil
+}
+
+func (r *PostgresAppointmentRepository) HasPayments(ctx context.Context, appointmentID int) (bool, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return false, err
+	}
+	var exists bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM payments
+			WHERE appointment_id = $1 AND organization_id = $2 AND status NOT IN ('failed', 'canceled')
+		)
+	`
+	err = r.db.GetContext(ctx, &exists, query, appointmentID, orgID)
+	return exists, err
+}
+
+func (r *PostgresAppointmentRepository) SetDiscount(ctx context.Context, id int, percent float64) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE appointments SET discount_percent = $1, updated_at = NOW()
+		WHERE id = $2 AND organization_id = $3`, percent, id, orgID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-func (r *PostgresAppointmentRepository) GetSubscriptionStatus(ctx context.Context, userID string, status *string) error {
-	query := `SELECT status FROM subscriptions WHERE user_id = $1`
-	return r.db.GetContext(ctx, status, query, userID)
+func (r *PostgresAppointmentRepository) IsMasseurPayoutReady(ctx context.Context, masseurID int) (bool, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return false, err
+	}
+	query := `
+		SELECT CASE
+			WHEN u.stripe_account_id IS NOT NULL THEN u.charges_enabled AND u.payouts_enabled
+			ELSE o.stripe_account_id <> ''
+		END
+		FROM user_profiles u
+		JOIN organizations o ON o.id = u.organization_id
+		WHERE u.id = $1 AND u.organization_id = $2 AND u.role = 'masseur'
+	`
+	var ready bool
+	err = r.db.GetContext(ctx, &ready, query, masseurID, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrNotFound
+	}
+	return ready, err
 }
 
 func (r *PostgresAppointmentRepository) Create(ctx context.Context, appt *models.Appointment) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	appt.OrganizationID = orgID
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkService(ctx, tx, orgID, 0, appt); err != nil {
+		return err
+	}
+
 	query := `
-		INSERT INTO appointments (client_id, masseur_id, appointment_date, start_time, end_time, type, status, description, location, recurrence_rule, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		INSERT INTO appointments (client_id, masseur_id, service_id, appointment_date, start_time, end_time, type, status, description, location, recurrence_rule, created_at, updated_at, organization_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
 		RETURNING id
 	`
-	return r.db.QueryRowContext(ctx, query,
+	err = tx.QueryRowContext(ctx, query,
 		appt.ClientID,
 		appt.MasseurID,
+		appt.ServiceID,
 		appt.AppointmentDate,
 		appt.StartTime,
 		appt.EndTime,
@@ -116,18 +225,47 @@ func (r *PostgresAppointmentRepository) Create(ctx context.Context, appt *models
 		appt.RecurrenceRule,
 		appt.CreatedAt,
 		appt.UpdatedAt,
+		orgID,
 	).Scan(&appt.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := replaceAddOns(ctx, tx, orgID, appt.ID, appt.AddOnIDs); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *PostgresAppointmentRepository) Update(ctx context.Context, id int, appt *models.Appointment) error {
+// Update saves appt if the appointment still has fromStatus and returns
+// ErrConflict otherwise. Like Create it returns ErrServiceUnavailable and
+// ErrWrongDuration for a service that cannot be booked as given; the service
+// the appointment already has stays bookable if it is deactivated.
+func (r *PostgresAppointmentRepository) Update(ctx context.Context, id int, fromStatus string, appt *models.Appointment) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkService(ctx, tx, orgID, id, appt); err != nil {
+		return err
+	}
+
 	query := `
 		UPDATE appointments
-		SET client_id=$1, masseur_id=$2, appointment_date=$3, start_time=$4, end_time=$5, type=$6, status=$7, description=$8, location=$9, recurrence_rule=$10, updated_at=$11
-		WHERE id=$12
+		SET client_id=$1, masseur_id=$2, service_id=$3, appointment_date=$4, start_time=$5, end_time=$6, type=$7, status=$8, description=$9, location=$10, recurrence_rule=$11, updated_at=$12
+		WHERE id=$13 AND organization_id=$14 AND status=$15
 	`
-	_, err := r.db.ExecContext(ctx, query,
+	res, err := tx.ExecContext(ctx, query,
 		appt.ClientID,
 		appt.MasseurID,
+		appt.ServiceID,
 		appt.AppointmentDate,
 		appt.StartTime,
 		appt.EndTime,
@@ -138,12 +276,99 @@ func (r *PostgresAppointmentRepository) Update(ctx context.Context, id int, appt
 		appt.RecurrenceRule,
 		appt.UpdatedAt,
 		id,
+		orgID,
+		fromStatus,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrConflict
+	}
+
+	if appt.AddOnIDs != nil {
+		if err := replaceAddOns(ctx, tx, orgID, id, appt.AddOnIDs); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func (r *PostgresAppointmentRepository) Delete(ctx context.Context, id int) error {
-	query := `DELETE FROM appointments WHERE id=$1`
-	_, err := r.db.ExecContext(ctx, query, id)
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	query := `DELETE FROM appointments WHERE id=$1 AND organization_id=$2`
+	_, err = r.db.ExecContext(ctx, query, id, orgID)
+	return err
+}
+
+// checkService makes sure the service of an appointment is one the
+// organization offers, or the one appointmentID already has, and that the
+// booked times last exactly as long as it does. Services are priced for their
+// duration, so a longer slot would be paid at the shorter price.
+func checkService(ctx context.Context, tx *sqlx.Tx, orgID, appointmentID int, appt *models.Appointment) error {
+	if appt.ServiceID == nil {
+		return nil
+	}
+
+	var duration int
+	err := tx.GetContext(ctx, &duration, `
+		SELECT duration_minutes FROM services
+		WHERE id = $1 AND organization_id = $2
+			AND (active OR id = (SELECT service_id FROM appointments WHERE id = $3 AND organization_id = $2))`,
+		*appt.ServiceID, orgID, appointmentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrServiceUnavailable
+	}
+	if err != nil {
+		return err
+	}
+
+	booked, err := bookedMinutes(appt.StartTime, appt.EndTime)
+	if err != nil || booked != duration {
+		return ErrWrongDuration
+	}
+	return nil
+}
+
+// bookedMinutes is the length of an appointment from its start and end
+// times, given as "15:04" or "15:04:05".
+func bookedMinutes(start, end string) (int, error) {
+	from, err := parseClock(start)
+	if err != nil {
+		return 0, err
+	}
+	to, err := parseClock(end)
+	if err != nil {
+		return 0, err
+	}
+	return int(to.Sub(from).Minutes()), nil
+}
+
+func parseClock(value string) (time.Time, error) {
+	if t, err := time.Parse("15:04:05", value); err == nil {
+		return t, nil
+	}
+	return time.Parse("15:04", value)
+}
+
+// replaceAddOns sets the add-ons booked with an appointment. Add-ons from
+// other organizations are silently dropped.
+func replaceAddOns(ctx context.Context, tx *sqlx.Tx, orgID, appointmentID int, addOnIDs []int) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM appointment_add_ons WHERE appointment_id = $1`, appointmentID); err != nil {
+		return err
+	}
+	if len(addOnIDs) == 0 {
+		return nil
+	}
+
+	query := `
+		INSERT INTO appointment_add_ons (appointment_id, add_on_id)
+		SELECT $1, id FROM add_ons WHERE id = ANY($2) AND organization_id = $3 AND active
+		ON CONFLICT DO NOTHING
+	`
+	_, err := tx.ExecContext(ctx, query, appointmentID, pq.Array(addOnIDs), orgID)
 	return err
 }
\ No newline at end of file
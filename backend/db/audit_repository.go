@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+type AuditRepository interface {
+	RecordImpersonation(ctx context.Context, entry *models.ImpersonationAuditEntry) error
+	ListImpersonations(ctx context.Context, filters map[string]string, limit, offset int) ([]models.ImpersonationAuditEntry, error)
+}
+
+type PostgresAuditRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewAuditRepository(db *sqlx.DB, logger *zap.Logger) AuditRepository {
+	return &PostgresAuditRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresAuditRepository) RecordImpersonation(ctx context.Context, entry *models.ImpersonationAuditEntry) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	entry.OrganizationID = orgID
+
+	query := `
+		INSERT INTO impersonation_audit (organization_id, admin_id, target_user_id, action, method, path, status, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(ctx, query,
+		entry.OrganizationID,
+		entry.AdminID,
+		entry.TargetUserID,
+		entry.Action,
+		entry.Method,
+		entry.Path,
+		entry.Status,
+		entry.Reason,
+		entry.CreatedAt,
+	).Scan(&entry.ID)
+}
+
+func (r *PostgresAuditRepository) ListImpersonations(ctx context.Context, filters map[string]string, limit, offset int) ([]models.ImpersonationAuditEntry, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, organization_id, admin_id, target_user_id, action, method, path, status, reason, created_at
+		FROM impersonation_audit
+		WHERE organization_id = :organization_id
+	`
+
+	args := map[string]interface{}{
+		"organization_id": orgID,
+	}
+
+	if adminID, ok := filters["admin_id"]; ok && adminID != "" {
+		query += " AND admin_id = :admin_id"
+		args["admin_id"] = adminID
+	}
+	if targetID, ok := filters["target_user_id"]; ok && targetID != "" {
+		query += " AND target_user_id = :target_user_id"
+		args["target_user_id"] = targetID
+	}
+
+	query += " ORDER BY created_at DESC LIMIT :limit OFFSET :offset"
+	args["limit"] = limit
+	args["offset"] = offset
+
+	var entries []models.ImpersonationAuditEntry
+	namedStmt, err := r.db.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("prepare statement error: %w", err)
+	}
+	defer namedStmt.Close()
+
+	if err := namedStmt.SelectContext(ctx, &entries, args); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,512 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+const packageColumns = `id, organization_id, name, description, credits, price, currency, service_id, validity_days, active, created_at, updated_at`
+
+const purchaseColumns = `id, organization_id, client_id, package_id, credits, amount, currency, application_fee, status, provider, provider_payment_id, expires_at, created_at, updated_at`
+
+// lotsQuery selects a client's usable lots: paid, unexpired purchases with
+// credits left, soonest to expire first. $1 is the organization, $2 the
+// client; a lot of a service-specific package is only usable for that
+// service, given as $3, unless $4 asks for every lot.
+const lotsQuery = `
+	SELECT pp.id AS purchase_id, pp.package_id, pk.name AS package_name, pk.service_id, pp.credits, pp.expires_at,
+		COALESCE(SUM(l.delta), 0) AS remaining
+	FROM package_purchases pp
+	JOIN packages pk ON pk.id = pp.package_id
+	LEFT JOIN credit_ledger l ON l.purchase_id = pp.id
+	WHERE pp.organization_id = $1 AND pp.client_id = $2 AND pp.status = 'paid'
+		AND (pp.expires_at IS NULL OR pp.expires_at > NOW())
+		AND (pk.service_id IS NULL OR pk.service_id = $3 OR $4)
+	GROUP BY pp.id, pk.name, pk.service_id
+	HAVING COALESCE(SUM(l.delta), 0) > 0
+	ORDER BY pp.expires_at NULLS LAST, pp.id
+`
+
+// CreditRepository stores packages, their purchases and the ledger of the
+// credits they grant.
+type CreditRepository interface {
+	ListPackages(ctx context.Context, activeOnly bool) ([]models.Package, error)
+	GetPackage(ctx context.Context, id int) (*models.Package, error)
+	CreatePackage(ctx context.Context, pkg *models.Package) error
+	// CreatePurchase records a pending purchase. Recording the same provider
+	// payment again returns the existing purchase.
+	CreatePurchase(ctx context.Context, purchase *models.PackagePurchase) error
+	// UpdatePurchaseStatus moves the unpaid purchase of a provider payment to
+	// status, granting its credits when it is paid. It is not tenant scoped
+	// as it serves provider webhooks, and returns ErrNotFound when there is
+	// no unpaid purchase for the payment.
+	UpdatePurchaseStatus(ctx context.Context, providerPaymentID, status string) (*models.PackagePurchase, error)
+	ListPurchases(ctx context.Context, clientID int) ([]models.PackagePurchase, error)
+	Lots(ctx context.Context, clientID int) ([]models.CreditLot, error)
+	Ledger(ctx context.Context, clientID int) ([]models.CreditLedgerEntry, error)
+	// Available counts the client's credits usable for serviceID.
+	Available(ctx context.Context, clientID int, serviceID *int) (int, error)
+	// Apply marks an appointment as paid with credits if its client has a
+	// usable credit that no other upcoming appointment is holding, and takes
+	// the credit in the same transaction when consume is set.
+	Apply(ctx context.Context, appointmentID int, consume bool) (bool, error)
+	// Consume takes one credit for an appointment paid with credits, from the
+	// lot that expires first. It does nothing if a credit was ever taken for
+	// the appointment, even if it was restored since, and returns
+	// ErrNoCredits, unmarking the appointment, if none is left.
+	Consume(ctx context.Context, appointmentID int) error
+	// Restore returns the credits taken for an appointment to their lots,
+	// once. It returns ErrAppointmentCompleted for completed appointments,
+	// whose credit was used.
+	Restore(ctx context.Context, appointmentID int) (int, error)
+	// ExpireDue writes off what is left of expired lots across all
+	// organizations and returns the number of lots expired.
+	ExpireDue(ctx context.Context) (int64, error)
+}
+
+type PostgresCreditRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewCreditRepository(db *sqlx.DB, logger *zap.Logger) CreditRepository {
+	return &PostgresCreditRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresCreditRepository) ListPackages(ctx context.Context, activeOnly bool) ([]models.Package, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + packageColumns + `
+		FROM packages
+		WHERE organization_id = $1 AND (active OR NOT $2)
+		ORDER BY name
+	`
+	var packages []models.Package
+	if err := r.db.SelectContext(ctx, &packages, query, orgID, activeOnly); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return packages, nil
+}
+
+func (r *PostgresCreditRepository) GetPackage(ctx context.Context, id int) (*models.Package, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg models.Package
+	query := `SELECT ` + packageColumns + ` FROM packages WHERE id = $1 AND organization_id = $2`
+	if err := r.db.GetContext(ctx, &pkg, query, id, orgID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+func (r *PostgresCreditRepository) CreatePackage(ctx context.Context, pkg *models.Package) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	pkg.OrganizationID = orgID
+	pkg.CreatedAt = time.Now()
+	pkg.UpdatedAt = pkg.CreatedAt
+
+	// The service must be one of the organization's own.
+	query := `
+		INSERT INTO packages (organization_id, name, description, credits, price, currency, service_id, validity_days, active, created_at, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11
+		WHERE $7::INTEGER IS NULL OR EXISTS (SELECT 1 FROM services WHERE id = $7 AND organization_id = $1)
+		RETURNING id
+	`
+	err = r.db.QueryRowContext(ctx, query,
+		pkg.OrganizationID,
+		pkg.Name,
+		pkg.Description,
+		pkg.Credits,
+		pkg.Price,
+		pkg.Currency,
+		pkg.ServiceID,
+		pkg.ValidityDays,
+		pkg.Active,
+		pkg.CreatedAt,
+		pkg.UpdatedAt,
+	).Scan(&pkg.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *PostgresCreditRepository) CreatePurchase(ctx context.Context, purchase *models.PackagePurchase) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	purchase.OrganizationID = orgID
+
+	query := `
+		INSERT INTO package_purchases (organization_id, client_id, package_id, credits, amount, currency, application_fee, status, provider, provider_payment_id, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, 'pending', $8, $9, NOW(), NOW())
+		ON CONFLICT (provider_payment_id) DO UPDATE SET updated_at = package_purchases.updated_at
+		RETURNING ` + purchaseColumns
+	return r.db.QueryRowxContext(ctx, query,
+		purchase.OrganizationID,
+		purchase.ClientID,
+		purchase.PackageID,
+		purchase.Credits,
+		purchase.Amount,
+		purchase.Currency,
+		purchase.ApplicationFee,
+		purchase.Provider,
+		purchase.ProviderPaymentID,
+	).StructScan(purchase)
+}
+
+func (r *PostgresCreditRepository) UpdatePurchaseStatus(ctx context.Context, providerPaymentID, status string) (*models.PackagePurchase, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Paid purchases are final; validity runs from the moment of payment.
+	var purchase models.PackagePurchase
+	err = tx.QueryRowxContext(ctx, `
+		UPDATE package_purchases pp
+		SET status = $1,
+			expires_at = CASE WHEN $1 = 'paid' THEN NOW() + make_interval(days => pk.validity_days) END,
+			updated_at = NOW()
+		FROM packages pk
+		WHERE pk.id = pp.package_id AND pp.provider_payment_id = $2 AND pp.status IN ('pending', 'failed')
+		RETURNING pp.id, pp.organization_id, pp.client_id, pp.package_id, pp.credits, pp.amount, pp.currency, pp.application_fee,
+			pp.status, pp.provider, pp.provider_payment_id, pp.expires_at, pp.created_at, pp.updated_at
+	`, status, providerPaymentID).StructScan(&purchase)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if status == "paid" {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO credit_ledger (organization_id, client_id, purchase_id, delta, reason, created_at)
+			VALUES ($1, $2, $3, $4, 'purchase', NOW())
+			ON CONFLICT DO NOTHING
+		`, purchase.OrganizationID, purchase.ClientID, purchase.ID, purchase.Credits)
+		if err != nil {
+			return nil, fmt.Errorf("grant credits: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &purchase, nil
+}
+
+func (r *PostgresCreditRepository) ListPurchases(ctx context.Context, clientID int) ([]models.PackagePurchase, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + purchaseColumns + `
+		FROM package_purchases
+		WHERE organization_id = $1 AND client_id = $2
+		ORDER BY created_at DESC
+	`
+	var purchases []models.PackagePurchase
+	if err := r.db.SelectContext(ctx, &purchases, query, orgID, clientID); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return purchases, nil
+}
+
+func (r *PostgresCreditRepository) Lots(ctx context.Context, clientID int) ([]models.CreditLot, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var lots []models.CreditLot
+	if err := r.db.SelectContext(ctx, &lots, lotsQuery, orgID, clientID, nil, true); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return lots, nil
+}
+
+func (r *PostgresCreditRepository) Ledger(ctx context.Context, clientID int) ([]models.CreditLedgerEntry, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, organization_id, client_id, purchase_id, appointment_id, delta, reason, created_at
+		FROM credit_ledger
+		WHERE organization_id = $1 AND client_id = $2
+		ORDER BY created_at DESC, id DESC
+	`
+	var entries []models.CreditLedgerEntry
+	if err := r.db.SelectContext(ctx, &entries, query, orgID, clientID); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return entries, nil
+}
+
+func (r *PostgresCreditRepository) Available(ctx context.Context, clientID int, serviceID *int) (int, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var available int
+	query := `SELECT COALESCE(SUM(remaining), 0) FROM (` + lotsQuery + `) lots`
+	if err := r.db.GetContext(ctx, &available, query, orgID, clientID, serviceID, false); err != nil {
+		return 0, fmt.Errorf("select error: %w", err)
+	}
+	return available, nil
+}
+
+func (r *PostgresCreditRepository) Apply(ctx context.Context, appointmentID int, consume bool) (bool, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var appt struct {
+		ClientID  int  `db:"client_id"`
+		ServiceID *int `db:"service_id"`
+	}
+	err = tx.GetContext(ctx, &appt, `
+		SELECT client_id, service_id
+		FROM appointments
+		WHERE id = $1 AND organization_id = $2
+		FOR UPDATE`, appointmentID, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrNotFound
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := lockPurchases(ctx, tx, orgID, appt.ClientID); err != nil {
+		return false, err
+	}
+
+	var available int
+	err = tx.GetContext(ctx, &available, `SELECT COALESCE(SUM(remaining), 0) FROM (`+lotsQuery+`) lots`, orgID, appt.ClientID, appt.ServiceID, false)
+	if err != nil {
+		return false, err
+	}
+	// Appointments booked on credits that are only taken on completion still
+	// hold one each. They are counted whatever their service, which errs on
+	// the side of charging.
+	var held int
+	err = tx.GetContext(ctx, &held, `
+		SELECT COUNT(*)
+		FROM appointments a
+		WHERE a.organization_id = $1 AND a.client_id = $2 AND a.id <> $3
+			AND a.paid_with_credits AND a.status NOT IN ('completed', 'canceled')
+			AND NOT EXISTS (SELECT 1 FROM credit_ledger l WHERE l.appointment_id = a.id AND l.reason = 'consume')
+	`, orgID, appt.ClientID, appointmentID)
+	if err != nil {
+		return false, err
+	}
+	if available <= held {
+		return false, nil
+	}
+
+	if _, err := tx.ExecContext(ctx, `UPDATE appointments SET paid_with_credits = TRUE WHERE id = $1`, appointmentID); err != nil {
+		return false, err
+	}
+	if consume {
+		if err := takeCredit(ctx, tx, orgID, appt.ClientID, appt.ServiceID, appointmentID); err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *PostgresCreditRepository) Consume(ctx context.Context, appointmentID int) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var appt struct {
+		ClientID        int  `db:"client_id"`
+		ServiceID       *int `db:"service_id"`
+		PaidWithCredits bool `db:"paid_with_credits"`
+	}
+	err = tx.GetContext(ctx, &appt, `
+		SELECT client_id, service_id, paid_with_credits
+		FROM appointments
+		WHERE id = $1 AND organization_id = $2
+		FOR UPDATE`, appointmentID, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if !appt.PaidWithCredits {
+		return ErrNoCredits
+	}
+
+	var consumed bool
+	err = tx.GetContext(ctx, &consumed, `SELECT EXISTS (SELECT 1 FROM credit_ledger WHERE appointment_id = $1 AND reason = 'consume')`, appointmentID)
+	if err != nil {
+		return err
+	}
+	if consumed {
+		return nil
+	}
+
+	if err := lockPurchases(ctx, tx, orgID, appt.ClientID); err != nil {
+		return err
+	}
+	err = takeCredit(ctx, tx, orgID, appt.ClientID, appt.ServiceID, appointmentID)
+	if errors.Is(err, ErrNoCredits) {
+		if _, err := tx.ExecContext(ctx, `UPDATE appointments SET paid_with_credits = FALSE WHERE id = $1`, appointmentID); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrNoCredits
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// lockPurchases locks the client's purchases so concurrent bookings cannot
+// spend the same credit.
+func lockPurchases(ctx context.Context, tx *sqlx.Tx, orgID, clientID int) error {
+	_, err := tx.ExecContext(ctx, `
+		SELECT id FROM package_purchases
+		WHERE organization_id = $1 AND client_id = $2 AND status = 'paid'
+		FOR UPDATE`, orgID, clientID)
+	return err
+}
+
+// takeCredit writes one credit of the lot that expires first off for an
+// appointment, or returns ErrNoCredits.
+func takeCredit(ctx context.Context, tx *sqlx.Tx, orgID, clientID int, serviceID *int, appointmentID int) error {
+	var lot models.CreditLot
+	err := tx.GetContext(ctx, &lot, lotsQuery+` LIMIT 1`, orgID, clientID, serviceID, false)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoCredits
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO credit_ledger (organization_id, client_id, purchase_id, appointment_id, delta, reason, created_at)
+		VALUES ($1, $2, $3, $4, -1, 'consume', NOW())
+	`, orgID, clientID, lot.PurchaseID, appointmentID)
+	if err != nil {
+		return fmt.Errorf("consume credit: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresCreditRepository) Restore(ctx context.Context, appointmentID int) (int, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var status string
+	err = tx.GetContext(ctx, &status, `SELECT status FROM appointments WHERE id = $1 AND organization_id = $2 FOR UPDATE`, appointmentID, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	if status == "completed" {
+		return 0, ErrAppointmentCompleted
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO credit_ledger (organization_id, client_id, purchase_id, appointment_id, delta, reason, created_at)
+		SELECT organization_id, client_id, purchase_id, appointment_id, -SUM(delta), 'refund', NOW()
+		FROM credit_ledger
+		WHERE appointment_id = $1 AND organization_id = $2
+		GROUP BY organization_id, client_id, purchase_id, appointment_id
+		HAVING SUM(delta) < 0
+	`, appointmentID, orgID)
+	if err != nil {
+		return 0, err
+	}
+	restored, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(restored), nil
+}
+
+func (r *PostgresCreditRepository) ExpireDue(ctx context.Context) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+		INSERT INTO credit_ledger (organization_id, client_id, purchase_id, delta, reason, created_at)
+		SELECT pp.organization_id, pp.client_id, pp.id, -SUM(l.delta), 'expire', NOW()
+		FROM package_purchases pp
+		JOIN credit_ledger l ON l.purchase_id = pp.id
+		WHERE pp.status = 'paid' AND pp.expires_at <= NOW()
+		GROUP BY pp.id
+		HAVING SUM(l.delta) > 0
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
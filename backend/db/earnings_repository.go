@@ -0,0 +1,119 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// Payments in these states have been charged and count towards earnings.
+const earningPaymentStatuses = `('paid', 'partially_refunded', 'refunded', 'disputed', 'dispute_lost')`
+
+// The platform fee kept on a payment, after Stripe returned the share of it
+// belonging to refunded amounts. CAST rather than :: keeps it usable in
+// named queries.
+const netPlatformFee = `CAST(p.application_fee - COALESCE(ROUND(CAST(p.application_fee AS NUMERIC) * p.refunded_amount / NULLIF(p.amount, 0)), 0) AS BIGINT)`
+
+type EarningsRepository interface {
+	// Summaries totals earnings per masseur and currency for payments made
+	// in [from, to). The masseur_id filter limits it to one masseur.
+	Summaries(ctx context.Context, filters map[string]string, from, to time.Time) ([]models.EarningsSummary, error)
+	Lines(ctx context.Context, masseurID int, from, to time.Time) ([]models.EarningsLine, error)
+}
+
+type PostgresEarningsRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewEarningsRepository(db *sqlx.DB, logger *zap.Logger) EarningsRepository {
+	return &PostgresEarningsRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresEarningsRepository) Summaries(ctx context.Context, filters map[string]string, from, to time.Time) ([]models.EarningsSummary, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT a.masseur_id, u.email AS masseur_email, p.currency,
+			COUNT(*) AS payments,
+			SUM(p.amount) AS gross,
+			SUM(CASE WHEN p.kind = 'tip' THEN p.amount ELSE 0 END) AS tips,
+			SUM(` + netPlatformFee + `) AS platform_fees,
+			SUM(p.refunded_amount) AS refunds,
+			SUM(p.amount - p.refunded_amount - ` + netPlatformFee + `) AS net
+		FROM payments p
+		JOIN appointments a ON a.id = p.appointment_id
+		JOIN user_profiles u ON u.id = a.masseur_id
+		WHERE p.organization_id = :organization_id
+			AND p.status IN ` + earningPaymentStatuses + `
+			AND p.created_at >= :from AND p.created_at < :to
+	`
+
+	args := map[string]interface{}{
+		"organization_id": orgID,
+		"from":            from,
+		"to":              to,
+	}
+
+	if masseurID, ok := filters["masseur_id"]; ok && masseurID != "" {
+		id, err := strconv.Atoi(masseurID)
+		if err == nil {
+			query += " AND a.masseur_id = :masseur_id"
+			args["masseur_id"] = id
+		}
+	}
+
+	query += " GROUP BY a.masseur_id, u.email, p.currency ORDER BY u.email, p.currency"
+
+	var summaries []models.EarningsSummary
+	namedStmt, err := r.db.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("prepare statement error: %w", err)
+	}
+	defer namedStmt.Close()
+
+	if err := namedStmt.SelectContext(ctx, &summaries, args); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+
+	return summaries, nil
+}
+
+func (r *PostgresEarningsRepository) Lines(ctx context.Context, masseurID int, from, to time.Time) ([]models.EarningsLine, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT p.id AS payment_id, p.appointment_id, a.appointment_date, p.created_at AS paid_at, p.kind, p.status, p.currency,
+			p.amount AS gross,
+			` + netPlatformFee + ` AS platform_fee,
+			p.refunded_amount AS refunded,
+			p.amount - p.refunded_amount - ` + netPlatformFee + ` AS net,
+			p.destination_account
+		FROM payments p
+		JOIN appointments a ON a.id = p.appointment_id
+		WHERE p.organization_id = $1 AND a.masseur_id = $2
+			AND p.status IN ` + earningPaymentStatuses + `
+			AND p.created_at >= $3 AND p.created_at < $4
+		ORDER BY p.created_at
+	`
+	var lines []models.EarningsLine
+	if err := r.db.SelectContext(ctx, &lines, query, orgID, masseurID, from, to); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return lines, nil
+}
@@ -0,0 +1,16 @@
+package db
+
+import "errors"
+
+var (
+	ErrNotFound            = errors.New("not found")
+	ErrDuplicate           = errors.New("already exists")
+	ErrNoCredits           = errors.New("no credits available")
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrLimitReached        = errors.New("usage limit reached")
+	ErrConflict            = errors.New("changed concurrently")
+
+	ErrAppointmentCompleted = errors.New("appointment already completed")
+	ErrServiceUnavailable   = errors.New("service is not offered")
+	ErrWrongDuration        = errors.New("booked time does not match the service duration")
+)
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+const feePolicyColumns = `id, organization_id, scope, masseur_id, plan_id, percent, fixed_amount, tiers, created_at, updated_at`
+
+type FeePolicyRepository interface {
+	List(ctx context.Context) ([]models.FeePolicy, error)
+	// Save replaces the policy for the same scope and target, or creates it.
+	Save(ctx context.Context, policy *models.FeePolicy) error
+	Delete(ctx context.Context, id int) error
+	// Resolve returns the policy that applies to a payment for masseurID's
+	// appointment with clientID: the masseur's own policy, then the one for
+	// the client's active subscription plan, then the organization default.
+	Resolve(ctx context.Context, masseurID, clientID int) (*models.FeePolicy, error)
+}
+
+type PostgresFeePolicyRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewFeePolicyRepository(db *sqlx.DB, logger *zap.Logger) FeePolicyRepository {
+	return &PostgresFeePolicyRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresFeePolicyRepository) List(ctx context.Context) ([]models.FeePolicy, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + feePolicyColumns + `
+		FROM fee_policies
+		WHERE organization_id = $1
+		ORDER BY scope, masseur_id, plan_id
+	`
+	var policies []models.FeePolicy
+	if err := r.db.SelectContext(ctx, &policies, query, orgID); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return policies, nil
+}
+
+func (r *PostgresFeePolicyRepository) Save(ctx context.Context, policy *models.FeePolicy) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	policy.OrganizationID = orgID
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE fee_policies
+		SET percent = $1, fixed_amount = $2, tiers = $3, updated_at = NOW()
+		WHERE organization_id = $4 AND scope = $5
+			AND masseur_id IS NOT DISTINCT FROM $6 AND plan_id IS NOT DISTINCT FROM $7
+		RETURNING id, created_at, updated_at
+	`, policy.Percent, policy.FixedAmount, policy.Tiers, orgID, policy.Scope, policy.MasseurID, policy.PlanID,
+	).Scan(&policy.ID, &policy.CreatedAt, &policy.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO fee_policies (organization_id, scope, masseur_id, plan_id, percent, fixed_amount, tiers, created_at, updated_at)
+			SELECT $1, $2, $3, $4, $5, $6, $7, NOW(), NOW()
+			WHERE $3::INTEGER IS NULL OR EXISTS (
+				SELECT 1 FROM user_profiles WHERE id = $3 AND organization_id = $1 AND role = 'masseur'
+			)
+			RETURNING id, created_at, updated_at
+		`, orgID, policy.Scope, policy.MasseurID, policy.PlanID, policy.Percent, policy.FixedAmount, policy.Tiers,
+		).Scan(&policy.ID, &policy.CreatedAt, &policy.UpdatedAt)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNotFound
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresFeePolicyRepository) Delete(ctx context.Context, id int) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, `DELETE FROM fee_policies WHERE id = $1 AND organization_id = $2`, id, orgID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresFeePolicyRepository) Resolve(ctx context.Context, masseurID, clientID int) (*models.FeePolicy, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + feePolicyColumns + `
+		FROM fee_policies
+		WHERE organization_id = $1 AND (
+			(scope = 'masseur' AND masseur_id = $2)
+			OR (scope = 'plan' AND plan_id IN (
+				SELECT plan_id FROM subscriptions WHERE user_id = $3 AND organization_id = $1 AND status = 'active'
+			))
+			OR scope = 'default'
+		)
+		ORDER BY CASE scope WHEN 'masseur' THEN 0 WHEN 'plan' THEN 1 ELSE 2 END, id
+		LIMIT 1
+	`
+	var policy models.FeePolicy
+	err = r.db.GetContext(ctx, &policy, query, orgID, masseurID, clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
@@ -0,0 +1,376 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// giftCardColumns selects a gift card with its balance from the ledger.
+const giftCardColumns = `
+	g.id, g.organization_id, g.code_hash, g.last4, g.initial_amount, g.currency, g.status, g.purchaser_id, g.recipient_email,
+	g.provider, g.provider_payment_id, g.application_fee, g.expires_at, g.created_at, g.updated_at,
+	(SELECT COALESCE(SUM(l.delta), 0) FROM gift_card_ledger l WHERE l.gift_card_id = g.id) AS balance`
+
+const giftCardLedgerColumns = `id, organization_id, gift_card_id, payment_id, delta, reason, note, created_by, created_at`
+
+// GiftCardRepository stores gift cards and the ledger their balances are
+// kept in. Cards are looked up by the hash of their code.
+type GiftCardRepository interface {
+	// Issue creates an active card funded with its initial amount.
+	Issue(ctx context.Context, card *models.GiftCard, createdBy *int) error
+	// CreatePurchase records a pending card paid for by a provider payment.
+	// It returns ErrDuplicate if the payment already has a card.
+	CreatePurchase(ctx context.Context, card *models.GiftCard) error
+	// UpdatePurchaseStatus activates, funding it, or cancels the pending card
+	// of a provider payment. It is not tenant scoped as it serves provider
+	// webhooks, and returns ErrNotFound when there is no pending card.
+	UpdatePurchaseStatus(ctx context.Context, providerPaymentID, status string) (*models.GiftCard, error)
+	List(ctx context.Context) ([]models.GiftCard, error)
+	ListPurchased(ctx context.Context, purchaserID int) ([]models.GiftCard, error)
+	Get(ctx context.Context, id int) (*models.GiftCard, error)
+	GetByCodeHash(ctx context.Context, codeHash string) (*models.GiftCard, error)
+	Ledger(ctx context.Context, id int) ([]models.GiftCardLedgerEntry, error)
+	// SetStatus enables or disables an issued card.
+	SetStatus(ctx context.Context, id int, status string) error
+	// Adjust corrects a card's balance by delta, returning
+	// ErrInsufficientBalance if that would take it below zero.
+	Adjust(ctx context.Context, id int, delta int64, note string, createdBy *int) (*models.GiftCardLedgerEntry, error)
+	// Redeem holds amount of a usable card's balance for a payment. It
+	// returns ErrNotFound if the card cannot be used and
+	// ErrInsufficientBalance if its balance does not cover amount.
+	Redeem(ctx context.Context, cardID, paymentID int, amount int64) error
+	// Release returns what a payment held back to its card. It is not tenant
+	// scoped as it serves provider webhooks.
+	Release(ctx context.Context, paymentID int) error
+}
+
+type PostgresGiftCardRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewGiftCardRepository(db *sqlx.DB, logger *zap.Logger) GiftCardRepository {
+	return &PostgresGiftCardRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresGiftCardRepository) Issue(ctx context.Context, card *models.GiftCard, createdBy *int) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	card.OrganizationID = orgID
+	card.Status = "active"
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertGiftCard(ctx, tx, card); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO gift_card_ledger (organization_id, gift_card_id, delta, reason, created_by, created_at)
+		VALUES ($1, $2, $3, 'issue', $4, NOW())
+	`, orgID, card.ID, card.InitialAmount, createdBy)
+	if err != nil {
+		return fmt.Errorf("fund gift card: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	card.Balance = card.InitialAmount
+	return nil
+}
+
+func (r *PostgresGiftCardRepository) CreatePurchase(ctx context.Context, card *models.GiftCard) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	card.OrganizationID = orgID
+	card.Status = "pending"
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.GetContext(ctx, &exists, `SELECT EXISTS (SELECT 1 FROM gift_cards WHERE provider_payment_id = $1)`, card.ProviderPaymentID); err != nil {
+		return err
+	}
+	if exists {
+		return ErrDuplicate
+	}
+	if err := insertGiftCard(ctx, tx, card); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertGiftCard(ctx context.Context, tx *sqlx.Tx, card *models.GiftCard) error {
+	now := time.Now()
+	card.CreatedAt, card.UpdatedAt = now, now
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO gift_cards (organization_id, code_hash, last4, initial_amount, currency, status, purchaser_id, recipient_email,
+			provider, provider_payment_id, application_fee, expires_at, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $13)
+		RETURNING id`,
+		card.OrganizationID,
+		card.CodeHash,
+		card.Last4,
+		card.InitialAmount,
+		card.Currency,
+		card.Status,
+		card.PurchaserID,
+		card.RecipientEmail,
+		card.Provider,
+		card.ProviderPaymentID,
+		card.ApplicationFee,
+		card.ExpiresAt,
+		now,
+	).Scan(&card.ID)
+	if err != nil {
+		return fmt.Errorf("insert gift card: %w", err)
+	}
+	return nil
+}
+
+func (r *PostgresGiftCardRepository) UpdatePurchaseStatus(ctx context.Context, providerPaymentID, status string) (*models.GiftCard, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var card models.GiftCard
+	err = tx.GetContext(ctx, &card, `
+		UPDATE gift_cards g SET status = $1, updated_at = NOW()
+		WHERE g.provider_payment_id = $2 AND g.status = 'pending'
+		RETURNING `+giftCardColumns, status, providerPaymentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if status == "active" {
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO gift_card_ledger (organization_id, gift_card_id, delta, reason, created_at)
+			VALUES ($1, $2, $3, 'issue', NOW())
+			ON CONFLICT DO NOTHING
+		`, card.OrganizationID, card.ID, card.InitialAmount)
+		if err != nil {
+			return nil, fmt.Errorf("fund gift card: %w", err)
+		}
+		card.Balance = card.InitialAmount
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+func (r *PostgresGiftCardRepository) List(ctx context.Context) ([]models.GiftCard, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + giftCardColumns + ` FROM gift_cards g WHERE g.organization_id = $1 ORDER BY g.created_at DESC`
+	var cards []models.GiftCard
+	if err := r.db.SelectContext(ctx, &cards, query, orgID); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return cards, nil
+}
+
+func (r *PostgresGiftCardRepository) ListPurchased(ctx context.Context, purchaserID int) ([]models.GiftCard, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + giftCardColumns + ` FROM gift_cards g WHERE g.organization_id = $1 AND g.purchaser_id = $2 ORDER BY g.created_at DESC`
+	var cards []models.GiftCard
+	if err := r.db.SelectContext(ctx, &cards, query, orgID, purchaserID); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return cards, nil
+}
+
+func (r *PostgresGiftCardRepository) Get(ctx context.Context, id int) (*models.GiftCard, error) {
+	return r.get(ctx, `g.id = $2`, id)
+}
+
+func (r *PostgresGiftCardRepository) GetByCodeHash(ctx context.Context, codeHash string) (*models.GiftCard, error) {
+	return r.get(ctx, `g.code_hash = $2`, codeHash)
+}
+
+func (r *PostgresGiftCardRepository) get(ctx context.Context, condition string, arg interface{}) (*models.GiftCard, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var card models.GiftCard
+	query := `SELECT ` + giftCardColumns + ` FROM gift_cards g WHERE g.organization_id = $1 AND ` + condition
+	if err := r.db.GetContext(ctx, &card, query, orgID, arg); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &card, nil
+}
+
+func (r *PostgresGiftCardRepository) Ledger(ctx context.Context, id int) ([]models.GiftCardLedgerEntry, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + giftCardLedgerColumns + `
+		FROM gift_card_ledger
+		WHERE gift_card_id = $1 AND organization_id = $2
+		ORDER BY created_at DESC, id DESC
+	`
+	var entries []models.GiftCardLedgerEntry
+	if err := r.db.SelectContext(ctx, &entries, query, id, orgID); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return entries, nil
+}
+
+func (r *PostgresGiftCardRepository) SetStatus(ctx context.Context, id int, status string) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE gift_cards SET status = $1, updated_at = NOW()
+		WHERE id = $2 AND organization_id = $3 AND status IN ('active', 'disabled')
+	`, status, id, orgID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresGiftCardRepository) Adjust(ctx context.Context, id int, delta int64, note string, createdBy *int) (*models.GiftCardLedgerEntry, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	balance, err := lockGiftCard(ctx, tx, id, orgID, `status IN ('active', 'disabled')`)
+	if err != nil {
+		return nil, err
+	}
+	if balance+delta < 0 {
+		return nil, ErrInsufficientBalance
+	}
+
+	var entry models.GiftCardLedgerEntry
+	err = tx.GetContext(ctx, &entry, `
+		INSERT INTO gift_card_ledger (organization_id, gift_card_id, delta, reason, note, created_by, created_at)
+		VALUES ($1, $2, $3, 'adjustment', $4, $5, NOW())
+		RETURNING `+giftCardLedgerColumns, orgID, id, delta, note, createdBy)
+	if err != nil {
+		return nil, fmt.Errorf("adjust gift card: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func (r *PostgresGiftCardRepository) Redeem(ctx context.Context, cardID, paymentID int, amount int64) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	balance, err := lockGiftCard(ctx, tx, cardID, orgID, `status = 'active' AND (expires_at IS NULL OR expires_at > NOW())`)
+	if err != nil {
+		return err
+	}
+	if balance < amount {
+		return ErrInsufficientBalance
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO gift_card_ledger (organization_id, gift_card_id, payment_id, delta, reason, created_at)
+		VALUES ($1, $2, $3, $4, 'redeem', NOW())
+	`, orgID, cardID, paymentID, -amount)
+	if err != nil {
+		return fmt.Errorf("redeem gift card: %w", err)
+	}
+	return tx.Commit()
+}
+
+// lockGiftCard locks a card matching condition so concurrent redemptions and
+// adjustments cannot overdraw it, and returns its balance.
+func lockGiftCard(ctx context.Context, tx *sqlx.Tx, id, orgID int, condition string) (int64, error) {
+	var locked int
+	err := tx.GetContext(ctx, &locked, `SELECT id FROM gift_cards WHERE id = $1 AND organization_id = $2 AND `+condition+` FOR UPDATE`, id, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var balance int64
+	if err := tx.GetContext(ctx, &balance, `SELECT COALESCE(SUM(delta), 0) FROM gift_card_ledger WHERE gift_card_id = $1`, id); err != nil {
+		return 0, err
+	}
+	return balance, nil
+}
+
+func (r *PostgresGiftCardRepository) Release(ctx context.Context, paymentID int) error {
+	_, err := r.db.ExecContext(ctx, `
+		INSERT INTO gift_card_ledger (organization_id, gift_card_id, payment_id, delta, reason, created_at)
+		SELECT organization_id, gift_card_id, payment_id, -SUM(delta), 'release', NOW()
+		FROM gift_card_ledger
+		WHERE payment_id = $1
+		GROUP BY organization_id, gift_card_id, payment_id
+		HAVING SUM(delta) < 0
+		ON CONFLICT DO NOTHING
+	`, paymentID)
+	return err
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+type IdempotencyRepository interface {
+	Begin(ctx context.Context, record *models.IdempotencyRecord, ttl, lease time.Duration) (*models.IdempotencyRecord, error)
+	Complete(ctx context.Context, record *models.IdempotencyRecord, status int, contentType string, body []byte) error
+	Release(ctx context.Context, record *models.IdempotencyRecord) error
+}
+
+type PostgresIdempotencyRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewIdempotencyRepository(db *sqlx.DB, logger *zap.Logger) IdempotencyRepository {
+	return &PostgresIdempotencyRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Begin claims record's key for the tenant in ctx. If the key was claimed
+// before and has not outlived ttl, the existing record is returned and
+// record is left untouched; otherwise it returns nil and sets record.ID.
+// A key still in progress after lease is assumed abandoned by a request that
+// never finished and is claimed again.
+func (r *PostgresIdempotencyRepository) Begin(ctx context.Context, record *models.IdempotencyRecord, ttl, lease time.Duration) (*models.IdempotencyRecord, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	record.OrganizationID = orgID
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Expired and abandoned keys are overwritten in place so they can be
+	// reused. created_at is reset, so it tells the claims of a key apart.
+	rows, err := tx.QueryxContext(ctx, `
+		INSERT INTO idempotency_keys (organization_id, caller, key, request_hash, status, created_at)
+		VALUES ($1, $2, $3, $4, 'in_progress', NOW())
+		ON CONFLICT (organization_id, caller, key) DO UPDATE
+		SET request_hash = EXCLUDED.request_hash, status = 'in_progress', response_status = 0,
+			response_type = '', response_body = NULL, created_at = NOW(), completed_at = NULL
+		WHERE idempotency_keys.created_at < NOW() - $5 * INTERVAL '1 second'
+			OR (idempotency_keys.status = 'in_progress' AND idempotency_keys.created_at < NOW() - $6 * INTERVAL '1 second')
+		RETURNING id, created_at
+	`, orgID, record.Caller, record.Key, record.RequestHash, ttl.Seconds(), lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	claimed := rows.Next()
+	if claimed {
+		err = rows.Scan(&record.ID, &record.CreatedAt)
+	}
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if claimed {
+		record.Status = "in_progress"
+		return nil, tx.Commit()
+	}
+
+	var existing models.IdempotencyRecord
+	err = tx.GetContext(ctx, &existing, `
+		SELECT id, organization_id, caller, key, request_hash, status, response_status, response_type, response_body, created_at, completed_at
+		FROM idempotency_keys
+		WHERE organization_id = $1 AND caller = $2 AND key = $3
+	`, orgID, record.Caller, record.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &existing, tx.Commit()
+}
+
+// Complete stores the response of a claimed key. It does nothing if the
+// claim's lease ran out and the key was claimed again.
+func (r *PostgresIdempotencyRepository) Complete(ctx context.Context, record *models.IdempotencyRecord, status int, contentType string, body []byte) error {
+	query := `
+		UPDATE idempotency_keys
+		SET status = 'completed', response_status = $1, response_type = $2, response_body = $3, completed_at = NOW()
+		WHERE id = $4 AND created_at = $5 AND status = 'in_progress'
+	`
+	_, err := r.db.ExecContext(ctx, query, status, contentType, body, record.ID, record.CreatedAt)
+	return err
+}
+
+// Release forgets a claimed key so that the request can be retried.
+func (r *PostgresIdempotencyRepository) Release(ctx context.Context, record *models.IdempotencyRecord) error {
+	_, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE id = $1 AND created_at = $2 AND status = 'in_progress'`, record.ID, record.CreatedAt)
+	return err
+}
@@ -0,0 +1,497 @@
+// Package memory has in-memory implementations of the db repositories, so
+// that the logic built on them can be exercised without a database. They
+// keep the behavior the Postgres implementations document, including tenant
+// scoping, but not their performance or concurrency characteristics beyond
+// a single lock.
+package memory
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+// PaymentRepository is an in-memory db.PaymentRepository. The appointments
+// payments belong to are registered with AddAppointment. Callbacks run with
+// the repository locked and must not call back into it.
+type PaymentRepository struct {
+	mu           sync.Mutex
+	payments     map[int]*models.Payment
+	refunds      map[int]*models.Refund
+	appointments map[int]*appointment
+	nextID       int
+	nextRefundID int
+}
+
+type appointment struct {
+	models.Appointment
+	masseur             models.User
+	statusBeforeDispute string
+}
+
+var _ db.PaymentRepository = (*PaymentRepository)(nil)
+
+func NewPaymentRepository() *PaymentRepository {
+	return &PaymentRepository{
+		payments:     map[int]*models.Payment{},
+		refunds:      map[int]*models.Refund{},
+		appointments: map[int]*appointment{},
+	}
+}
+
+// AddAppointment registers an appointment and its masseur, whose connected
+// account its payments go to.
+func (r *PaymentRepository) AddAppointment(appt models.Appointment, masseur models.User) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appointments[appt.ID] = &appointment{Appointment: appt, masseur: masseur}
+}
+
+// AppointmentStatus returns the status payments have left an appointment
+// in.
+func (r *PaymentRepository) AppointmentStatus(id int) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if appt, ok := r.appointments[id]; ok {
+		return appt.Status
+	}
+	return ""
+}
+
+// Refunds lists the refunds of a payment.
+func (r *PaymentRepository) Refunds(paymentID int) []models.Refund {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var refunds []models.Refund
+	for _, refund := range r.refunds {
+		if refund.PaymentID == paymentID {
+			refunds = append(refunds, *refund)
+		}
+	}
+	sort.Slice(refunds, func(i, j int) bool { return refunds[i].ID < refunds[j].ID })
+	return refunds
+}
+
+func (r *PaymentRepository) Create(ctx context.Context, payment *models.Payment) error {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return db.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.byProviderID(payment.StripePaymentID) != nil {
+		return db.ErrDuplicate
+	}
+	r.nextID++
+	payment.ID = r.nextID
+	payment.OrganizationID = orgID
+	payment.CreatedAt = time.Now()
+	payment.UpdatedAt = payment.CreatedAt
+	stored := *payment
+	r.payments[stored.ID] = &stored
+	return nil
+}
+
+func (r *PaymentRepository) Get(ctx context.Context, id int) (*models.AppointmentPayment, error) {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return nil, db.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.get(id, orgID)
+}
+
+func (r *PaymentRepository) ListByAppointment(ctx context.Context, appointmentID int, statuses []string) ([]models.Payment, error) {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return nil, db.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payments := []models.Payment{}
+	for _, p := range r.payments {
+		if p.AppointmentID == appointmentID && p.OrganizationID == orgID && p.Kind != "tip" && slices.Contains(statuses, p.Status) {
+			payments = append(payments, *p)
+		}
+	}
+	sort.Slice(payments, func(i, j int) bool { return payments[i].ID < payments[j].ID })
+	return payments, nil
+}
+
+// List lists payments as the Postgres repository does. Client emails and
+// service names are not known to the fake and are left empty.
+func (r *PaymentRepository) List(ctx context.Context, filters map[string]string, limit, offset int) ([]models.PaymentHistoryEntry, error) {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return nil, db.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := []models.PaymentHistoryEntry{}
+	for _, p := range r.payments {
+		appt, ok := r.appointments[p.AppointmentID]
+		if p.OrganizationID != orgID || !ok || !matchesFilters(p, appt, filters) {
+			continue
+		}
+		entries = append(entries, models.PaymentHistoryEntry{
+			AppointmentPayment: models.AppointmentPayment{Payment: *p, ClientID: appt.ClientID, MasseurID: appt.MasseurID},
+			MasseurEmail:       appt.masseur.Email,
+			AppointmentDate:    appt.AppointmentDate,
+			StartTime:          appt.StartTime,
+			AppointmentStatus:  appt.Status,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].CreatedAt.Equal(entries[j].CreatedAt) {
+			return entries[i].CreatedAt.After(entries[j].CreatedAt)
+		}
+		return entries[i].ID > entries[j].ID
+	})
+
+	if offset >= len(entries) {
+		return []models.PaymentHistoryEntry{}, nil
+	}
+	entries = entries[offset:]
+	if limit < len(entries) {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+func (r *PaymentRepository) Payee(ctx context.Context, appointmentID int) (*models.Payee, error) {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return nil, db.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	appt, ok := r.appointments[appointmentID]
+	if !ok || appt.OrganizationID != orgID {
+		return nil, db.ErrNotFound
+	}
+	return &models.Payee{
+		AppointmentStatus: appt.Status,
+		PaidWithCredits:   appt.PaidWithCredits,
+		StripeAccountID:   appt.masseur.StripeAccountID,
+		ChargesEnabled:    appt.masseur.ChargesEnabled,
+		PayoutsEnabled:    appt.masseur.PayoutsEnabled,
+	}, nil
+}
+
+func (r *PaymentRepository) Cancel(ctx context.Context, id int) error {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return db.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.payments[id]; ok && p.OrganizationID == orgID {
+		p.Status = "canceled"
+		p.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (r *PaymentRepository) Transition(ctx context.Context, change db.StatusChange, apply func(payment *models.Payment) error) (*models.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := r.byProviderID(change.ProviderPaymentID)
+	if stored == nil || (len(change.From) > 0 && !slices.Contains(change.From, stored.Status)) {
+		return nil, db.ErrNotFound
+	}
+	payment := *stored
+	payment.Status = change.Status
+	payment.FailureCode = change.FailureCode
+	payment.FailureReason = change.FailureReason
+	payment.UpdatedAt = time.Now()
+	if apply != nil {
+		if err := apply(&payment); err != nil {
+			return nil, err
+		}
+	}
+
+	*stored = payment
+	r.updateAppointmentStatus(payment.AppointmentID, change.AppointmentStatus)
+	return &payment, nil
+}
+
+func (r *PaymentRepository) RecordDispute(ctx context.Context, change db.StatusChange, disputeID, disputeStatus, reason string) (*models.Payment, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored := r.byProviderID(change.ProviderPaymentID)
+	if stored == nil || (len(change.From) > 0 && !slices.Contains(change.From, stored.Status)) {
+		return nil, db.ErrNotFound
+	}
+	closed := []string{"won", "lost", "warning_closed"}
+	if stored.DisputeID != nil && *stored.DisputeID == disputeID && slices.Contains(closed, stored.DisputeStatus) && !slices.Contains(closed, disputeStatus) {
+		return nil, db.ErrNotFound
+	}
+
+	switch {
+	case change.Status != "":
+		stored.Status = change.Status
+	case stored.RefundedAmount > 0 && stored.RefundedAmount >= stored.Amount:
+		stored.Status = "refunded"
+	case stored.RefundedAmount > 0:
+		stored.Status = "partially_refunded"
+	default:
+		stored.Status = "paid"
+	}
+	stored.DisputeID = &disputeID
+	stored.DisputeStatus = disputeStatus
+	stored.DisputeReason = reason
+	stored.UpdatedAt = time.Now()
+
+	appt, ok := r.appointments[stored.AppointmentID]
+	switch {
+	case !ok:
+	case change.Status == "disputed":
+		if appt.Status != "completed" && appt.Status != "canceled" && appt.Status != "disputed" {
+			appt.statusBeforeDispute, appt.Status = appt.Status, "disputed"
+		}
+	case change.Status == "":
+		if appt.Status == "disputed" && appt.statusBeforeDispute != "" {
+			appt.Status, appt.statusBeforeDispute = appt.statusBeforeDispute, ""
+		}
+	default:
+		r.updateAppointmentStatus(stored.AppointmentID, change.AppointmentStatus)
+	}
+	payment := *stored
+	return &payment, nil
+}
+
+func (r *PaymentRepository) Settle(ctx context.Context, id int, settle func(payment *models.AppointmentPayment) error) (*models.Payment, error) {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return nil, db.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payment, err := r.get(id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if err := settle(payment); err != nil {
+		return nil, err
+	}
+
+	stored := r.payments[id]
+	stored.Amount = payment.Amount
+	stored.ApplicationFee = payment.ApplicationFee
+	stored.Status = payment.Status
+	stored.UpdatedAt = time.Now()
+	updated := *stored
+	return &updated, nil
+}
+
+func (r *PaymentRepository) ReserveRefund(ctx context.Context, refund *models.Refund, check func(payment *models.AppointmentPayment) error) (int64, error) {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return 0, db.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payment, err := r.get(refund.PaymentID, orgID)
+	if err != nil {
+		return 0, err
+	}
+	if check != nil {
+		if err := check(payment); err != nil {
+			return 0, err
+		}
+	}
+
+	refundable := payment.Amount
+	for _, existing := range r.refunds {
+		if existing.PaymentID == payment.ID && existing.Status != "failed" && existing.Status != "canceled" {
+			refundable -= existing.Amount
+		}
+	}
+	if refund.Amount == 0 {
+		refund.Amount = refundable
+	}
+	if refundable <= 0 || refund.Amount > refundable {
+		return refundable, db.ErrNotRefundable
+	}
+
+	r.nextRefundID++
+	refund.ID = r.nextRefundID
+	refund.OrganizationID = orgID
+	refund.Currency = payment.Currency
+	refund.CreatedAt = time.Now()
+	refund.UpdatedAt = refund.CreatedAt
+	stored := *refund
+	r.refunds[stored.ID] = &stored
+	return refundable, nil
+}
+
+func (r *PaymentRepository) UpdateRefund(ctx context.Context, id int, providerRefundID, status, failureReason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	refund, ok := r.refunds[id]
+	if !ok {
+		return db.ErrNotFound
+	}
+	refund.ProviderRefundID = &providerRefundID
+	refund.Status = status
+	refund.FailureReason = failureReason
+	refund.UpdatedAt = time.Now()
+
+	payment, ok := r.payments[refund.PaymentID]
+	if !ok || !slices.Contains([]string{"paid", "partially_refunded", "refunded"}, payment.Status) {
+		return nil
+	}
+	var total int64
+	for _, other := range r.refunds {
+		if other.PaymentID == payment.ID && other.Status == "succeeded" {
+			total += other.Amount
+		}
+	}
+	payment.RefundedAmount = total
+	switch {
+	case total >= payment.Amount:
+		payment.Status = "refunded"
+	case total > 0:
+		payment.Status = "partially_refunded"
+	default:
+		payment.Status = "paid"
+	}
+	payment.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *PaymentRepository) FailRefund(ctx context.Context, id int, failureReason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if refund, ok := r.refunds[id]; ok {
+		refund.Status = "failed"
+		refund.FailureReason = failureReason
+		refund.UpdatedAt = time.Now()
+	}
+	return nil
+}
+
+func (r *PaymentRepository) RecordProviderRefund(ctx context.Context, providerPaymentID, providerRefundID, status string, amount int64) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, refund := range r.refunds {
+		if refund.ProviderRefundID != nil && *refund.ProviderRefundID == providerRefundID {
+			return refund.ID, nil
+		}
+	}
+	payment := r.byProviderID(providerPaymentID)
+	if payment == nil {
+		return 0, db.ErrNotFound
+	}
+
+	r.nextRefundID++
+	now := time.Now()
+	r.refunds[r.nextRefundID] = &models.Refund{
+		ID:               r.nextRefundID,
+		OrganizationID:   payment.OrganizationID,
+		PaymentID:        payment.ID,
+		Amount:           amount,
+		Currency:         payment.Currency,
+		Status:           status,
+		ProviderRefundID: &providerRefundID,
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	return r.nextRefundID, nil
+}
+
+func (r *PaymentRepository) SyncRefundedAmount(ctx context.Context, providerPaymentID string, total int64) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	payment := r.byProviderID(providerPaymentID)
+	if payment == nil || !slices.Contains([]string{"paid", "partially_refunded", "refunded"}, payment.Status) {
+		return nil
+	}
+	payment.RefundedAmount = max(payment.RefundedAmount, total)
+	if payment.RefundedAmount >= payment.Amount {
+		payment.Status = "refunded"
+	} else {
+		payment.Status = "partially_refunded"
+	}
+	payment.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *PaymentRepository) get(id, orgID int) (*models.AppointmentPayment, error) {
+	p, ok := r.payments[id]
+	if !ok || p.OrganizationID != orgID {
+		return nil, db.ErrNotFound
+	}
+	payment := &models.AppointmentPayment{Payment: *p}
+	if appt, ok := r.appointments[p.AppointmentID]; ok {
+		payment.ClientID = appt.ClientID
+		payment.MasseurID = appt.MasseurID
+	}
+	return payment, nil
+}
+
+func matchesFilters(p *models.Payment, appt *appointment, filters map[string]string) bool {
+	if id, err := strconv.Atoi(filters["client_id"]); err == nil && appt.ClientID != id {
+		return false
+	}
+	if id, err := strconv.Atoi(filters["masseur_id"]); err == nil && appt.MasseurID != id {
+		return false
+	}
+	if id, err := strconv.Atoi(filters["appointment_id"]); err == nil && p.AppointmentID != id {
+		return false
+	}
+	if status := filters["status"]; status != "" && p.Status != status {
+		return false
+	}
+	if from, err := time.Parse(time.RFC3339, filters["from"]); err == nil && p.CreatedAt.Before(from) {
+		return false
+	}
+	if to, err := time.Parse(time.RFC3339, filters["to"]); err == nil && !p.CreatedAt.Before(to) {
+		return false
+	}
+	if amount, err := strconv.ParseInt(filters["min_amount"], 10, 64); err == nil && p.Amount < amount {
+		return false
+	}
+	if amount, err := strconv.ParseInt(filters["max_amount"], 10, 64); err == nil && p.Amount > amount {
+		return false
+	}
+	return true
+}
+
+func (r *PaymentRepository) byProviderID(providerPaymentID string) *models.Payment {
+	for _, p := range r.payments {
+		if p.StripePaymentID == providerPaymentID {
+			return p
+		}
+	}
+	return nil
+}
+
+func (r *PaymentRepository) updateAppointmentStatus(id int, status string) {
+	appt, ok := r.appointments[id]
+	if !ok || status == "" || appt.Status == "completed" || appt.Status == "canceled" {
+		return
+	}
+	appt.Status = status
+	appt.UpdatedAt = time.Now()
+}
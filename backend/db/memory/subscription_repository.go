@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+// SubscriptionRepository is an in-memory db.SubscriptionRepository.
+type SubscriptionRepository struct {
+	mu            sync.Mutex
+	subscriptions map[int]*models.Subscription
+	nextID        int
+}
+
+var _ db.SubscriptionRepository = (*SubscriptionRepository)(nil)
+
+func NewSubscriptionRepository() *SubscriptionRepository {
+	return &SubscriptionRepository{subscriptions: map[int]*models.Subscription{}}
+}
+
+// List returns every stored subscription.
+func (r *SubscriptionRepository) List() []models.Subscription {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	subs := make([]models.Subscription, 0, len(r.subscriptions))
+	for _, sub := range r.subscriptions {
+		subs = append(subs, *sub)
+	}
+	sort.Slice(subs, func(i, j int) bool { return subs[i].ID < subs[j].ID })
+	return subs
+}
+
+func (r *SubscriptionRepository) Create(ctx context.Context, sub *models.Subscription) error {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return db.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, existing := range r.subscriptions {
+		if existing.StripeSessionID == sub.StripeSessionID {
+			return db.ErrDuplicate
+		}
+	}
+	r.nextID++
+	sub.ID = r.nextID
+	sub.OrganizationID = orgID
+	sub.CreatedAt = time.Now()
+	sub.UpdatedAt = sub.CreatedAt
+	stored := *sub
+	r.subscriptions[stored.ID] = &stored
+	return nil
+}
+
+func (r *SubscriptionRepository) Activate(ctx context.Context, sessionID, subscriptionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, sub := range r.subscriptions {
+		if sub.StripeSessionID == sessionID {
+			id := subscriptionID
+			sub.Status = "active"
+			sub.StripeSubscriptionID = &id
+			sub.UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return db.ErrNotFound
+}
+
+func (r *SubscriptionRepository) Cancel(ctx context.Context, subscriptionID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, sub := range r.subscriptions {
+		if sub.StripeSubscriptionID != nil && *sub.StripeSubscriptionID == subscriptionID {
+			sub.Status = "canceled"
+			sub.UpdatedAt = time.Now()
+		}
+	}
+	return nil
+}
+
+func (r *SubscriptionRepository) HasActive(ctx context.Context, userID int) (bool, error) {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return false, db.ErrNoTenant
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, sub := range r.subscriptions {
+		if sub.UserID == userID && sub.OrganizationID == orgID && sub.Status == "active" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id                    SERIAL PRIMARY KEY,
+    name                  TEXT NOT NULL,
+    prefix                TEXT NOT NULL UNIQUE,
+    key_hash              TEXT NOT NULL,
+    scopes                TEXT[] NOT NULL DEFAULT '{}',
+    rate_limit_per_minute INTEGER NOT NULL DEFAULT 60,
+    created_by            TEXT NOT NULL,
+    expires_at            TIMESTAMPTZ,
+    last_used_at          TIMESTAMPTZ,
+    revoked_at            TIMESTAMPTZ,
+    created_at            TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
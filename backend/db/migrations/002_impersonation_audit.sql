@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS impersonation_audit (
+    id             SERIAL PRIMARY KEY,
+    admin_id       TEXT NOT NULL,
+    target_user_id TEXT NOT NULL,
+    action         TEXT NOT NULL,
+    method         TEXT NOT NULL DEFAULT '',
+    path           TEXT NOT NULL DEFAULT '',
+    status         INTEGER NOT NULL DEFAULT 0,
+    reason         TEXT NOT NULL DEFAULT '',
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS impersonation_audit_admin_idx ON impersonation_audit (admin_id, created_at);
+CREATE INDEX IF NOT EXISTS impersonation_audit_target_idx ON impersonation_audit (target_user_id, created_at);
@@ -0,0 +1,41 @@
+CREATE TABLE IF NOT EXISTS organizations (
+    id                SERIAL PRIMARY KEY,
+    slug              TEXT NOT NULL UNIQUE,
+    name              TEXT NOT NULL,
+    clerk_org_id      TEXT UNIQUE,
+    stripe_account_id TEXT NOT NULL DEFAULT '',
+    settings          JSONB NOT NULL DEFAULT '{}',
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+-- Existing single-business data is moved into a default organization.
+INSERT INTO organizations (id, slug, name) VALUES (1, 'default', 'Default')
+ON CONFLICT (id) DO NOTHING;
+SELECT setval('organizations_id_seq', GREATEST((SELECT MAX(id) FROM organizations), 1));
+
+-- user_profiles predates the migrations on existing databases; create it on
+-- fresh ones so that it can be scoped below. 004 adds the identity columns.
+CREATE TABLE IF NOT EXISTS user_profiles (
+    id                SERIAL PRIMARY KEY,
+    role              TEXT NOT NULL DEFAULT 'client',
+    stripe_account_id TEXT
+);
+
+ALTER TABLE user_profiles       ADD COLUMN IF NOT EXISTS organization_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations (id);
+ALTER TABLE appointments        ADD COLUMN IF NOT EXISTS organization_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations (id);
+ALTER TABLE payments            ADD COLUMN IF NOT EXISTS organization_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations (id);
+ALTER TABLE subscriptions       ADD COLUMN IF NOT EXISTS organization_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations (id);
+ALTER TABLE api_keys            ADD COLUMN IF NOT EXISTS organization_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations (id);
+ALTER TABLE impersonation_audit ADD COLUMN IF NOT EXISTS organization_id INTEGER NOT NULL DEFAULT 1 REFERENCES organizations (id);
+
+ALTER TABLE user_profiles       ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE appointments        ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE payments            ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE subscriptions       ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE api_keys            ALTER COLUMN organization_id DROP DEFAULT;
+ALTER TABLE impersonation_audit ALTER COLUMN organization_id DROP DEFAULT;
+
+CREATE INDEX IF NOT EXISTS appointments_organization_idx ON appointments (organization_id, appointment_date);
+CREATE INDEX IF NOT EXISTS payments_organization_idx ON payments (organization_id);
+CREATE INDEX IF NOT EXISTS subscriptions_organization_idx ON subscriptions (organization_id);
@@ -0,0 +1,41 @@
+-- Auth subjects (e.g. Clerk "user_2abc...") are mapped to our numeric IDs.
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS external_id TEXT;
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS email TEXT NOT NULL DEFAULT '';
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+
+CREATE UNIQUE INDEX IF NOT EXISTS user_profiles_external_idx ON user_profiles (organization_id, external_id);
+
+-- subscriptions.user_id used to hold the auth subject; point it at user_profiles instead.
+-- Subscribers without a profile get one first so that no subscription loses
+-- its owner.
+INSERT INTO user_profiles (organization_id, external_id, role)
+SELECT DISTINCT s.organization_id, s.user_id, 'client'
+FROM subscriptions s
+WHERE s.user_id IS NOT NULL
+ON CONFLICT (organization_id, external_id) DO NOTHING;
+
+ALTER TABLE subscriptions ADD COLUMN IF NOT EXISTS user_profile_id INTEGER REFERENCES user_profiles (id);
+UPDATE subscriptions s
+SET user_profile_id = u.id
+FROM user_profiles u
+WHERE u.external_id = s.user_id AND u.organization_id = s.organization_id;
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM subscriptions WHERE user_profile_id IS NULL) THEN
+        RAISE EXCEPTION 'subscriptions without a user remain; assign or delete them before migrating';
+    END IF;
+END $$;
+ALTER TABLE subscriptions DROP COLUMN user_id;
+ALTER TABLE subscriptions RENAME COLUMN user_profile_id TO user_id;
+ALTER TABLE subscriptions ALTER COLUMN user_id SET NOT NULL;
+
+-- Profiles are looked up by their auth subject from now on, and there is no
+-- older column to take it from; a profile without one would lock its user out.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM user_profiles WHERE external_id IS NULL) THEN
+        RAISE EXCEPTION 'user_profiles without external_id remain; set them to the users'' auth subjects before migrating';
+    END IF;
+END $$;
+ALTER TABLE user_profiles ALTER COLUMN external_id SET NOT NULL;
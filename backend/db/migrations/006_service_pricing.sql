@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS services (
+    id               SERIAL PRIMARY KEY,
+    organization_id  INTEGER NOT NULL REFERENCES organizations (id),
+    name             TEXT NOT NULL,
+    duration_minutes INTEGER NOT NULL CHECK (duration_minutes > 0),
+    price            BIGINT NOT NULL CHECK (price >= 0),
+    currency         TEXT NOT NULL DEFAULT 'usd',
+    tax_rate         NUMERIC(5, 2) NOT NULL DEFAULT 0,
+    active           BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS add_ons (
+    id              SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations (id),
+    name            TEXT NOT NULL,
+    price           BIGINT NOT NULL CHECK (price >= 0),
+    active          BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS appointment_add_ons (
+    appointment_id INTEGER NOT NULL REFERENCES appointments (id) ON DELETE CASCADE,
+    add_on_id      INTEGER NOT NULL REFERENCES add_ons (id),
+    PRIMARY KEY (appointment_id, add_on_id)
+);
+
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS service_id INTEGER REFERENCES services (id);
+-- Set by staff only; never bound from client requests.
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS discount_percent NUMERIC(5, 2) NOT NULL DEFAULT 0;
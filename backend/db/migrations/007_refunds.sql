@@ -0,0 +1,18 @@
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS refunded_amount BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS refunds (
+    id                 SERIAL PRIMARY KEY,
+    organization_id    INTEGER NOT NULL REFERENCES organizations (id),
+    payment_id         INTEGER NOT NULL REFERENCES payments (id),
+    amount             BIGINT NOT NULL CHECK (amount > 0),
+    currency           TEXT NOT NULL,
+    status             TEXT NOT NULL,
+    reason             TEXT NOT NULL DEFAULT '',
+    provider_refund_id TEXT UNIQUE,
+    failure_reason     TEXT NOT NULL DEFAULT '',
+    created_by         INTEGER REFERENCES user_profiles (id),
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS refunds_payment_idx ON refunds (payment_id);
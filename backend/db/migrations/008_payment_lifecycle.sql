@@ -0,0 +1,7 @@
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS failure_code TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS failure_reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS dispute_id TEXT;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS dispute_status TEXT NOT NULL DEFAULT '';
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS dispute_reason TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS payments_provider_payment_idx ON payments (stripe_payment_id);
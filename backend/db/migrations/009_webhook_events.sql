@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS webhook_events (
+    id              SERIAL PRIMARY KEY,
+    organization_id INTEGER REFERENCES organizations (id),
+    provider        TEXT NOT NULL,
+    event_id        TEXT NOT NULL,
+    event_type      TEXT NOT NULL DEFAULT '',
+    provider_type   TEXT NOT NULL DEFAULT '',
+    payload         JSONB NOT NULL,
+    event           JSONB NOT NULL,
+    status          TEXT NOT NULL DEFAULT 'pending',
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    last_error      TEXT NOT NULL DEFAULT '',
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    processed_at    TIMESTAMPTZ,
+    received_at     TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (provider, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_events_due_idx ON webhook_events (next_attempt_at) WHERE status IN ('pending', 'failed', 'processing');
+CREATE INDEX IF NOT EXISTS webhook_events_org_status_idx ON webhook_events (organization_id, status);
@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS fee_policies (
+    id              SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations (id),
+    scope           TEXT NOT NULL CHECK (scope IN ('default', 'masseur', 'plan')),
+    masseur_id      INTEGER REFERENCES user_profiles (id) ON DELETE CASCADE,
+    plan_id         TEXT,
+    percent         NUMERIC(5, 2) NOT NULL DEFAULT 0,
+    fixed_amount    BIGINT NOT NULL DEFAULT 0,
+    tiers           JSONB NOT NULL DEFAULT '[]',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS fee_policies_default_idx ON fee_policies (organization_id) WHERE scope = 'default';
+CREATE UNIQUE INDEX IF NOT EXISTS fee_policies_masseur_idx ON fee_policies (organization_id, masseur_id) WHERE scope = 'masseur';
+CREATE UNIQUE INDEX IF NOT EXISTS fee_policies_plan_idx ON fee_policies (organization_id, plan_id) WHERE scope = 'plan';
+
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS application_fee BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS fee_policy_id INTEGER REFERENCES fee_policies (id) ON DELETE SET NULL;
+
+-- Payments taken so far were charged the organization's percentage, or 10%.
+UPDATE payments p
+SET application_fee = ROUND(p.amount * COALESCE(NULLIF(o.settings->>'applicationFeePercent', '')::NUMERIC, 10) / 100)
+FROM organizations o
+WHERE o.id = p.organization_id AND p.application_fee = 0;
@@ -0,0 +1,12 @@
+-- The connected account a payment was paid out to, for reconciling earnings
+-- against the provider.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS destination_account TEXT NOT NULL DEFAULT '';
+
+UPDATE payments p
+SET destination_account = COALESCE(u.stripe_account_id, o.stripe_account_id)
+FROM appointments a
+JOIN user_profiles u ON u.id = a.masseur_id
+JOIN organizations o ON o.id = a.organization_id
+WHERE a.id = p.appointment_id AND p.destination_account = '';
+
+CREATE INDEX IF NOT EXISTS payments_org_created_idx ON payments (organization_id, created_at);
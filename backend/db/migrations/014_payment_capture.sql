@@ -0,0 +1,10 @@
+-- full: the whole appointment in one payment. deposit: part of it taken at
+-- booking. balance: the remainder after a deposit, paid after the session.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'full'
+    CHECK (kind IN ('full', 'deposit', 'balance'));
+-- manual payments are authorized at booking and captured or voided when the
+-- appointment is completed or canceled.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS capture_method TEXT NOT NULL DEFAULT 'automatic'
+    CHECK (capture_method IN ('automatic', 'manual'));
+
+CREATE INDEX IF NOT EXISTS payments_appointment_idx ON payments (appointment_id);
@@ -0,0 +1,5 @@
+-- Tips are separate charges on a completed appointment, paid out in full to
+-- the masseur.
+ALTER TABLE payments DROP CONSTRAINT IF EXISTS payments_kind_check;
+ALTER TABLE payments ADD CONSTRAINT payments_kind_check
+    CHECK (kind IN ('full', 'deposit', 'balance', 'tip'));
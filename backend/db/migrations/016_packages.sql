@@ -0,0 +1,56 @@
+CREATE TABLE IF NOT EXISTS packages (
+    id              SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations (id),
+    name            TEXT NOT NULL,
+    description     TEXT NOT NULL DEFAULT '',
+    credits         INTEGER NOT NULL CHECK (credits > 0),
+    price           BIGINT NOT NULL CHECK (price >= 0),
+    currency        TEXT NOT NULL DEFAULT 'usd',
+    -- NULL: the credits are good for any service.
+    service_id      INTEGER REFERENCES services (id),
+    -- NULL: the credits never expire.
+    validity_days   INTEGER CHECK (validity_days > 0),
+    active          BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS package_purchases (
+    id                  SERIAL PRIMARY KEY,
+    organization_id     INTEGER NOT NULL REFERENCES organizations (id),
+    client_id           INTEGER NOT NULL REFERENCES user_profiles (id),
+    package_id          INTEGER NOT NULL REFERENCES packages (id),
+    credits             INTEGER NOT NULL CHECK (credits > 0),
+    amount              BIGINT NOT NULL,
+    currency            TEXT NOT NULL,
+    application_fee     BIGINT NOT NULL DEFAULT 0,
+    status              TEXT NOT NULL CHECK (status IN ('pending', 'paid', 'failed', 'canceled')),
+    provider            TEXT NOT NULL,
+    provider_payment_id TEXT NOT NULL UNIQUE,
+    -- Set when the purchase is paid, from the package's validity.
+    expires_at          TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS package_purchases_client_idx ON package_purchases (organization_id, client_id);
+
+-- Every credit movement, against the purchase (lot) it draws on. A lot's
+-- balance is the sum of its entries.
+CREATE TABLE IF NOT EXISTS credit_ledger (
+    id              SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations (id),
+    client_id       INTEGER NOT NULL REFERENCES user_profiles (id),
+    purchase_id     INTEGER NOT NULL REFERENCES package_purchases (id),
+    appointment_id  INTEGER REFERENCES appointments (id) ON DELETE SET NULL,
+    delta           INTEGER NOT NULL CHECK (delta <> 0),
+    reason          TEXT NOT NULL CHECK (reason IN ('purchase', 'consume', 'refund', 'expire')),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS credit_ledger_purchase_idx ON credit_ledger (purchase_id);
+CREATE INDEX IF NOT EXISTS credit_ledger_appointment_idx ON credit_ledger (appointment_id);
+-- Replayed payment webhooks must not grant a purchase twice.
+CREATE UNIQUE INDEX IF NOT EXISTS credit_ledger_grant_idx ON credit_ledger (purchase_id) WHERE reason = 'purchase';
+
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS paid_with_credits BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,82 @@
+CREATE TABLE IF NOT EXISTS gift_cards (
+    id                  SERIAL PRIMARY KEY,
+    organization_id     INTEGER NOT NULL REFERENCES organizations (id),
+    -- Codes are bearer secrets; only their hash and last characters are kept.
+    code_hash           TEXT NOT NULL UNIQUE,
+    last4               TEXT NOT NULL,
+    initial_amount      BIGINT NOT NULL CHECK (initial_amount > 0),
+    currency            TEXT NOT NULL,
+    status              TEXT NOT NULL CHECK (status IN ('pending', 'active', 'disabled', 'canceled')),
+    purchaser_id        INTEGER REFERENCES user_profiles (id),
+    recipient_email     TEXT NOT NULL DEFAULT '',
+    provider            TEXT NOT NULL DEFAULT '',
+    provider_payment_id TEXT UNIQUE,
+    application_fee     BIGINT NOT NULL DEFAULT 0,
+    expires_at          TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at          TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS gift_cards_org_idx ON gift_cards (organization_id);
+
+-- A gift card's balance is the sum of its entries.
+CREATE TABLE IF NOT EXISTS gift_card_ledger (
+    id              SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations (id),
+    gift_card_id    INTEGER NOT NULL REFERENCES gift_cards (id),
+    payment_id      INTEGER REFERENCES payments (id),
+    delta           BIGINT NOT NULL CHECK (delta <> 0),
+    reason          TEXT NOT NULL CHECK (reason IN ('issue', 'redeem', 'release', 'adjustment')),
+    note            TEXT NOT NULL DEFAULT '',
+    created_by      INTEGER REFERENCES user_profiles (id),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS gift_card_ledger_card_idx ON gift_card_ledger (gift_card_id);
+CREATE UNIQUE INDEX IF NOT EXISTS gift_card_ledger_issue_idx ON gift_card_ledger (gift_card_id) WHERE reason = 'issue';
+CREATE UNIQUE INDEX IF NOT EXISTS gift_card_ledger_release_idx ON gift_card_ledger (payment_id) WHERE reason = 'release';
+
+CREATE TABLE IF NOT EXISTS promo_codes (
+    id              SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations (id),
+    code            TEXT NOT NULL,
+    description     TEXT NOT NULL DEFAULT '',
+    discount_type   TEXT NOT NULL CHECK (discount_type IN ('percent', 'fixed')),
+    percent         NUMERIC(5, 2) NOT NULL DEFAULT 0,
+    amount_off      BIGINT NOT NULL DEFAULT 0,
+    currency        TEXT NOT NULL DEFAULT '',
+    max_redemptions INTEGER CHECK (max_redemptions > 0),
+    max_per_client  INTEGER CHECK (max_per_client > 0),
+    valid_from      TIMESTAMPTZ,
+    valid_until     TIMESTAMPTZ,
+    -- Empty: the code applies to every service.
+    service_ids     INTEGER[] NOT NULL DEFAULT '{}',
+    active          BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, code)
+);
+
+-- Pending redemptions count against usage limits until their payment is
+-- canceled, when they are released.
+CREATE TABLE IF NOT EXISTS promo_redemptions (
+    id              SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations (id),
+    promo_code_id   INTEGER NOT NULL REFERENCES promo_codes (id),
+    client_id       INTEGER NOT NULL REFERENCES user_profiles (id),
+    payment_id      INTEGER NOT NULL UNIQUE REFERENCES payments (id),
+    appointment_id  INTEGER REFERENCES appointments (id) ON DELETE SET NULL,
+    discount        BIGINT NOT NULL,
+    status          TEXT NOT NULL CHECK (status IN ('pending', 'redeemed', 'released')),
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS promo_redemptions_code_idx ON promo_redemptions (promo_code_id);
+
+-- amount stays what was charged through the provider; the discount and the
+-- part paid by gift card are kept alongside.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS discount_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS promo_code_id INTEGER REFERENCES promo_codes (id);
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS gift_card_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS gift_card_id INTEGER REFERENCES gift_cards (id);
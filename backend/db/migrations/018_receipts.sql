@@ -0,0 +1,17 @@
+-- The last receipt number handed out by each organization. Incrementing it in
+-- the transaction that issues a receipt keeps numbering free of gaps.
+CREATE TABLE IF NOT EXISTS receipt_counters (
+    organization_id INTEGER PRIMARY KEY REFERENCES organizations (id),
+    last_number     INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS receipts (
+    id              SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations (id),
+    payment_id      INTEGER NOT NULL UNIQUE REFERENCES payments (id),
+    number          INTEGER NOT NULL,
+    prefix          TEXT NOT NULL,
+    document        JSONB NOT NULL,
+    issued_at       TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (organization_id, number)
+);
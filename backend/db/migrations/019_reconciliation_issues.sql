@@ -0,0 +1,21 @@
+-- Drift between our tables and the payment provider that the nightly
+-- reconciliation could not repair on its own. An unresolved issue is updated
+-- in place each night it is still seen.
+CREATE TABLE IF NOT EXISTS reconciliation_issues (
+    id              SERIAL PRIMARY KEY,
+    organization_id INTEGER NOT NULL REFERENCES organizations (id),
+    provider        TEXT NOT NULL,
+    kind            TEXT NOT NULL,
+    reference       TEXT NOT NULL,
+    local_status    TEXT NOT NULL DEFAULT '',
+    provider_status TEXT NOT NULL DEFAULT '',
+    detail          TEXT NOT NULL DEFAULT '',
+    first_seen_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_seen_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    resolved_at     TIMESTAMPTZ,
+    resolved_by     INTEGER
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS reconciliation_issues_open_idx
+    ON reconciliation_issues (provider, kind, reference) WHERE resolved_at IS NULL;
+CREATE INDEX IF NOT EXISTS reconciliation_issues_org_idx ON reconciliation_issues (organization_id, last_seen_at);
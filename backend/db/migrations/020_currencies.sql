@@ -0,0 +1,6 @@
+-- The currency a masseur takes payments in; empty means the organization's.
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS currency TEXT NOT NULL DEFAULT '';
+
+-- Services are priced in the currency given when they are created, or the
+-- organization's; there is no implicit USD any more.
+ALTER TABLE services ALTER COLUMN currency DROP DEFAULT;
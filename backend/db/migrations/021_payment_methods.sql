@@ -0,0 +1,3 @@
+-- The provider customer that holds a client's saved payment methods.
+ALTER TABLE user_profiles ADD COLUMN IF NOT EXISTS stripe_customer_id TEXT;
+CREATE UNIQUE INDEX IF NOT EXISTS user_profiles_stripe_customer_id_key ON user_profiles (stripe_customer_id);
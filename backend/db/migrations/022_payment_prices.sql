@@ -0,0 +1,3 @@
+-- The appointment's price as quoted at checkout, which receipts are built
+-- from. NULL for tips and for payments taken before it was recorded.
+ALTER TABLE payments ADD COLUMN IF NOT EXISTS price JSONB;
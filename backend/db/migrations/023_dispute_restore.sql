@@ -0,0 +1,3 @@
+-- The status an appointment had when a dispute of its payment opened, to go
+-- back to when the dispute closes without being lost.
+ALTER TABLE appointments ADD COLUMN IF NOT EXISTS status_before_dispute TEXT;
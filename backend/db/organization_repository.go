@@ -0,0 +1,66 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+type OrganizationRepository interface {
+	GetByID(ctx context.Context, id int) (*models.Organization, error)
+	GetBySlug(ctx context.Context, slug string) (*models.Organization, error)
+	GetByClerkOrgID(ctx context.Context, clerkOrgID string) (*models.Organization, error)
+	UpdateSettings(ctx context.Context, id int, stripeAccountID string, settings models.OrganizationSettings) error
+}
+
+type PostgresOrganizationRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewOrganizationRepository(db *sqlx.DB, logger *zap.Logger) OrganizationRepository {
+	return &PostgresOrganizationRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const organizationColumns = `id, slug, name, clerk_org_id, stripe_account_id, settings, created_at, updated_at`
+
+func (r *PostgresOrganizationRepository) GetByID(ctx context.Context, id int) (*models.Organization, error) {
+	return r.getOne(ctx, `SELECT `+organizationColumns+` FROM organizations WHERE id = $1`, id)
+}
+
+func (r *PostgresOrganizationRepository) GetBySlug(ctx context.Context, slug string) (*models.Organization, error) {
+	return r.getOne(ctx, `SELECT `+organizationColumns+` FROM organizations WHERE slug = $1`, slug)
+}
+
+func (r *PostgresOrganizationRepository) GetByClerkOrgID(ctx context.Context, clerkOrgID string) (*models.Organization, error) {
+	return r.getOne(ctx, `SELECT `+organizationColumns+` FROM organizations WHERE clerk_org_id = $1`, clerkOrgID)
+}
+
+func (r *PostgresOrganizationRepository) UpdateSettings(ctx context.Context, id int, stripeAccountID string, settings models.OrganizationSettings) error {
+	query := `
+		UPDATE organizations
+		SET stripe_account_id = $1, settings = $2, updated_at = NOW()
+		WHERE id = $3
+	`
+	_, err := r.db.ExecContext(ctx, query, stripeAccountID, settings, id)
+	return err
+}
+
+func (r *PostgresOrganizationRepository) getOne(ctx context.Context, query string, arg interface{}) (*models.Organization, error) {
+	var org models.Organization
+	if err := r.db.GetContext(ctx, &org, query, arg); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &org, nil
+}
@@ -0,0 +1,567 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const paymentColumns = `p.id, p.organization_id, p.appointment_id, p.amount, p.currency, p.status, p.kind, p.capture_method, p.provider, p.stripe_payment_id,
+	p.refunded_amount, p.application_fee, p.fee_policy_id, p.destination_account, p.discount_amount, p.promo_code_id, p.gift_card_amount, p.gift_card_id, p.price,
+	p.failure_code, p.failure_reason, p.dispute_id, p.dispute_status, p.dispute_reason, p.created_at, p.updated_at`
+
+// ErrNotRefundable is returned for a refund larger than what is left to
+// refund of a payment.
+var ErrNotRefundable = errors.New("amount exceeds what can be refunded")
+
+// StatusChange moves the payment of a provider payment, and its appointment,
+// to a new status.
+type StatusChange struct {
+	ProviderPaymentID string
+	Status            string
+	// From limits the change to payments in one of these statuses, so that
+	// late or replayed events cannot undo a later state. Empty allows any.
+	From []string
+	// AppointmentStatus is mirrored onto the appointment unless it was
+	// already completed or canceled; empty leaves it alone.
+	AppointmentStatus string
+	FailureCode       string
+	FailureReason     string
+}
+
+// PaymentRepository stores appointment payments and their refunds. Methods
+// that look payments up by provider payment ID serve provider webhooks and
+// are not tenant scoped.
+type PaymentRepository interface {
+	// Create records a payment of the current organization. It returns
+	// ErrDuplicate if the provider payment is already recorded, as happens
+	// when a checkout is retried.
+	Create(ctx context.Context, payment *models.Payment) error
+	Get(ctx context.Context, id int) (*models.AppointmentPayment, error)
+	// ListByAppointment lists the payments of an appointment in one of
+	// statuses. Tips are not part of the appointment's price and are left
+	// out.
+	ListByAppointment(ctx context.Context, appointmentID int, statuses []string) ([]models.Payment, error)
+	// List lists payments of the current organization with their
+	// appointments, newest first. Filters are client_id, masseur_id,
+	// appointment_id, status, from and to (RFC 3339, to exclusive) on when
+	// the payment was made, and min_amount and max_amount in minor units.
+	List(ctx context.Context, filters map[string]string, limit, offset int) ([]models.PaymentHistoryEntry, error)
+	// Payee returns where the payments of an appointment go.
+	Payee(ctx context.Context, appointmentID int) (*models.Payee, error)
+	// Cancel marks a payment of the current organization as canceled
+	// whatever its status.
+	Cancel(ctx context.Context, id int) error
+	// Transition applies change, returning ErrNotFound when the payment is
+	// not in one of its From statuses. apply, if given, runs before the
+	// change is committed; when it fails nothing changes.
+	Transition(ctx context.Context, change StatusChange, apply func(payment *models.Payment) error) (*models.Payment, error)
+	// RecordDispute applies change and records the provider dispute on the
+	// payment. Moving a payment to "disputed" keeps its appointment's status
+	// to come back to; an empty Status ends the dispute, restoring the payment
+	// to paid, partially_refunded or refunded from its refunded amount and
+	// the appointment to that kept status. A closed dispute is never opened
+	// again by a late event.
+	RecordDispute(ctx context.Context, change StatusChange, disputeID, disputeStatus, reason string) (*models.Payment, error)
+	// Settle locks a payment of the current organization while settle runs,
+	// so that concurrent captures, voids and refunds wait for it. The
+	// amount, application fee and status settle leaves on the payment are
+	// saved when it succeeds; when it fails nothing changes.
+	Settle(ctx context.Context, id int, settle func(payment *models.AppointmentPayment) error) (*models.Payment, error)
+	// ReserveRefund records a pending refund of a payment of the current
+	// organization. check runs with the payment locked and can refuse the
+	// refund. A zero amount refunds everything that is left; more than is
+	// left returns ErrNotRefundable. The amount left before the refund is
+	// returned in either case.
+	ReserveRefund(ctx context.Context, refund *models.Refund, check func(payment *models.AppointmentPayment) error) (int64, error)
+	// UpdateRefund stores the provider's view of a refund and recomputes the
+	// refunded total and status of its payment.
+	UpdateRefund(ctx context.Context, id int, providerRefundID, status, failureReason string) error
+	// FailRefund marks a refund the provider never accepted as failed.
+	FailRefund(ctx context.Context, id int, failureReason string) error
+	// RecordProviderRefund returns the refund of a provider refund, recording
+	// it first if it was issued outside Harmonia.
+	RecordProviderRefund(ctx context.Context, providerPaymentID, providerRefundID, status string, amount int64) (int, error)
+	// SyncRefundedAmount raises the refunded amount of a provider payment to
+	// total, as reported by the provider.
+	SyncRefundedAmount(ctx context.Context, providerPaymentID string, total int64) error
+}
+
+type PostgresPaymentRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewPaymentRepository(db *sqlx.DB, logger *zap.Logger) PaymentRepository {
+	return &PostgresPaymentRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresPaymentRepository) Create(ctx context.Context, payment *models.Payment) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	payment.OrganizationID = orgID
+	payment.CreatedAt = time.Now()
+	payment.UpdatedAt = payment.CreatedAt
+
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO payments (organization_id, appointment_id, amount, currency, status, provider, stripe_payment_id, application_fee, fee_policy_id, destination_account, kind, capture_method,
+			discount_amount, promo_code_id, gift_card_amount, gift_card_id, price, created_at, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $18
+		WHERE NOT EXISTS (SELECT 1 FROM payments WHERE stripe_payment_id = $7)
+		RETURNING id`,
+		payment.OrganizationID, payment.AppointmentID, payment.Amount, payment.Currency, payment.Status, payment.Provider, payment.StripePaymentID,
+		payment.ApplicationFee, payment.FeePolicyID, payment.DestinationAccount, payment.Kind, payment.CaptureMethod,
+		payment.DiscountAmount, payment.PromoCodeID, payment.GiftCardAmount, payment.GiftCardID, payment.Price, payment.CreatedAt,
+	).Scan(&payment.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *PostgresPaymentRepository) Get(ctx context.Context, id int) (*models.AppointmentPayment, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return getAppointmentPayment(ctx, r.db, ``, id, orgID)
+}
+
+func (r *PostgresPaymentRepository) ListByAppointment(ctx context.Context, appointmentID int, statuses []string) ([]models.Payment, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	payments := []models.Payment{}
+	err = r.db.SelectContext(ctx, &payments, `
+		SELECT `+paymentColumns+`
+		FROM payments p
+		WHERE p.appointment_id = $1 AND p.organization_id = $2 AND p.status = ANY($3) AND p.kind <> 'tip'
+		ORDER BY p.id`, appointmentID, orgID, pq.Array(statuses))
+	if err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return payments, nil
+}
+
+func (r *PostgresPaymentRepository) List(ctx context.Context, filters map[string]string, limit, offset int) ([]models.PaymentHistoryEntry, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT ` + paymentColumns + `, a.client_id, a.masseur_id, cu.email AS client_email, mu.email AS masseur_email,
+			a.appointment_date, a.start_time, a.status AS appointment_status, s.name AS service_name
+		FROM payments p
+		JOIN appointments a ON a.id = p.appointment_id
+		JOIN user_profiles cu ON cu.id = a.client_id
+		JOIN user_profiles mu ON mu.id = a.masseur_id
+		LEFT JOIN services s ON s.id = a.service_id
+		WHERE p.organization_id = :organization_id
+	`
+
+	args := map[string]interface{}{
+		"organization_id": orgID,
+	}
+
+	if clientID, ok := filters["client_id"]; ok && clientID != "" {
+		id, err := strconv.Atoi(clientID)
+		if err == nil {
+			query += " AND a.client_id = :client_id"
+			args["client_id"] = id
+		}
+	}
+	if masseurID, ok := filters["masseur_id"]; ok && masseurID != "" {
+		id, err := strconv.Atoi(masseurID)
+		if err == nil {
+			query += " AND a.masseur_id = :masseur_id"
+			args["masseur_id"] = id
+		}
+	}
+	if appointmentID, ok := filters["appointment_id"]; ok && appointmentID != "" {
+		id, err := strconv.Atoi(appointmentID)
+		if err == nil {
+			query += " AND p.appointment_id = :appointment_id"
+			args["appointment_id"] = id
+		}
+	}
+	if status, ok := filters["status"]; ok && status != "" {
+		query += " AND p.status = :status"
+		args["status"] = status
+	}
+	if from, ok := filters["from"]; ok && from != "" {
+		query += " AND p.created_at >= :from"
+		args["from"] = from
+	}
+	if to, ok := filters["to"]; ok && to != "" {
+		query += " AND p.created_at < :to"
+		args["to"] = to
+	}
+	if minAmount, ok := filters["min_amount"]; ok && minAmount != "" {
+		amount, err := strconv.ParseInt(minAmount, 10, 64)
+		if err == nil {
+			query += " AND p.amount >= :min_amount"
+			args["min_amount"] = amount
+		}
+	}
+	if maxAmount, ok := filters["max_amount"]; ok && maxAmount != "" {
+		amount, err := strconv.ParseInt(maxAmount, 10, 64)
+		if err == nil {
+			query += " AND p.amount <= :max_amount"
+			args["max_amount"] = amount
+		}
+	}
+
+	query += " ORDER BY p.created_at DESC, p.id DESC LIMIT :limit OFFSET :offset"
+	args["limit"] = limit
+	args["offset"] = offset
+
+	entries := []models.PaymentHistoryEntry{}
+	namedStmt, err := r.db.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("prepare statement error: %w", err)
+	}
+	defer namedStmt.Close()
+
+	if err := namedStmt.SelectContext(ctx, &entries, args); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+
+	return entries, nil
+}
+
+func (r *PostgresPaymentRepository) Payee(ctx context.Context, appointmentID int) (*models.Payee, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var payee models.Payee
+	err = r.db.GetContext(ctx, &payee, `
+		SELECT a.status AS appointment_status, a.paid_with_credits, u.stripe_account_id, u.charges_enabled, u.payouts_enabled
+		FROM appointments a
+		JOIN user_profiles u ON a.masseur_id = u.id
+		WHERE a.id = $1 AND a.organization_id = $2`, appointmentID, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payee, nil
+}
+
+func (r *PostgresPaymentRepository) Cancel(ctx context.Context, id int) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.db.ExecContext(ctx, `
+		UPDATE payments SET status = 'canceled', updated_at = NOW()
+		WHERE id = $1 AND organization_id = $2`, id, orgID)
+	return err
+}
+
+func (r *PostgresPaymentRepository) Transition(ctx context.Context, change StatusChange, apply func(payment *models.Payment) error) (*models.Payment, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var payment models.Payment
+	err = tx.GetContext(ctx, &payment, `
+		UPDATE payments p
+		SET status = $1, failure_code = $2, failure_reason = $3, updated_at = NOW()
+		WHERE p.stripe_payment_id = $4 AND (COALESCE(cardinality($5::text[]), 0) = 0 OR p.status = ANY($5))
+		RETURNING `+paymentColumns,
+		change.Status, change.FailureCode, change.FailureReason, change.ProviderPaymentID, pq.Array(change.From))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := updateAppointmentStatus(ctx, tx, payment.AppointmentID, change.AppointmentStatus); err != nil {
+		return nil, fmt.Errorf("update appointment %d: %w", payment.AppointmentID, err)
+	}
+	if apply != nil {
+		if err := apply(&payment); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *PostgresPaymentRepository) RecordDispute(ctx context.Context, change StatusChange, disputeID, disputeStatus, reason string) (*models.Payment, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var payment models.Payment
+	err = tx.GetContext(ctx, &payment, `
+		UPDATE payments p
+		SET status = CASE
+				WHEN $1 <> '' THEN $1
+				WHEN p.refunded_amount >= p.amount AND p.refunded_amount > 0 THEN 'refunded'
+				WHEN p.refunded_amount > 0 THEN 'partially_refunded'
+				ELSE 'paid'
+			END,
+			dispute_id = $2, dispute_status = $3, dispute_reason = $4, updated_at = NOW()
+		WHERE p.stripe_payment_id = $5 AND (COALESCE(cardinality($6::text[]), 0) = 0 OR p.status = ANY($6))
+			AND NOT (COALESCE(p.dispute_id = $2, FALSE) AND p.dispute_status = ANY($7) AND NOT $3 = ANY($7))
+		RETURNING `+paymentColumns,
+		change.Status, disputeID, disputeStatus, reason, change.ProviderPaymentID, pq.Array(change.From), pq.Array(closedDisputeStatuses))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch change.Status {
+	case "disputed":
+		_, err = tx.ExecContext(ctx, `
+			UPDATE appointments
+			SET status_before_dispute = status, status = 'disputed', updated_at = NOW()
+			WHERE id = $1 AND status NOT IN ('completed', 'canceled', 'disputed')`, payment.AppointmentID)
+	case "":
+		_, err = tx.ExecContext(ctx, `
+			UPDATE appointments
+			SET status = status_before_dispute, status_before_dispute = NULL, updated_at = NOW()
+			WHERE id = $1 AND status = 'disputed' AND status_before_dispute IS NOT NULL`, payment.AppointmentID)
+	default:
+		err = updateAppointmentStatus(ctx, tx, payment.AppointmentID, change.AppointmentStatus)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("update appointment %d: %w", payment.AppointmentID, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *PostgresPaymentRepository) Settle(ctx context.Context, id int, settle func(payment *models.AppointmentPayment) error) (*models.Payment, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	payment, err := getAppointmentPayment(ctx, tx, `FOR UPDATE OF p`, id, orgID)
+	if err != nil {
+		return nil, err
+	}
+	if err := settle(payment); err != nil {
+		return nil, err
+	}
+
+	err = tx.QueryRowContext(ctx, `
+		UPDATE payments
+		SET amount = $1, application_fee = $2, status = $3, updated_at = NOW()
+		WHERE id = $4
+		RETURNING updated_at`, payment.Amount, payment.ApplicationFee, payment.Status, payment.ID).Scan(&payment.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &payment.Payment, nil
+}
+
+func (r *PostgresPaymentRepository) ReserveRefund(ctx context.Context, refund *models.Refund, check func(payment *models.AppointmentPayment) error) (int64, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// The payment stays locked so concurrent refunds cannot exceed its
+	// amount.
+	payment, err := getAppointmentPayment(ctx, tx, `FOR UPDATE OF p`, refund.PaymentID, orgID)
+	if err != nil {
+		return 0, err
+	}
+	if check != nil {
+		if err := check(payment); err != nil {
+			return 0, err
+		}
+	}
+
+	var reserved int64
+	err = tx.GetContext(ctx, &reserved, `
+		SELECT COALESCE(SUM(amount), 0) FROM refunds
+		WHERE payment_id = $1 AND status NOT IN ('failed', 'canceled')`, payment.ID)
+	if err != nil {
+		return 0, fmt.Errorf("refund total: %w", err)
+	}
+	refundable := payment.Amount - reserved
+	if refund.Amount == 0 {
+		refund.Amount = refundable
+	}
+	if refundable <= 0 || refund.Amount > refundable {
+		return refundable, ErrNotRefundable
+	}
+
+	refund.OrganizationID = orgID
+	refund.Currency = payment.Currency
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO refunds (organization_id, payment_id, amount, currency, status, reason, created_by, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+		RETURNING id, created_at, updated_at`,
+		refund.OrganizationID, refund.PaymentID, refund.Amount, refund.Currency, refund.Status, refund.Reason, refund.CreatedBy,
+	).Scan(&refund.ID, &refund.CreatedAt, &refund.UpdatedAt)
+	if err != nil {
+		return refundable, fmt.Errorf("insert refund: %w", err)
+	}
+
+	return refundable, tx.Commit()
+}
+
+func (r *PostgresPaymentRepository) UpdateRefund(ctx context.Context, id int, providerRefundID, status, failureReason string) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var paymentID int
+	err = tx.QueryRowContext(ctx, `
+		UPDATE refunds
+		SET provider_refund_id = $1, status = $2, failure_reason = $3, updated_at = NOW()
+		WHERE id = $4
+		RETURNING payment_id`, providerRefundID, status, failureReason, id).Scan(&paymentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE payments p
+		SET refunded_amount = r.total,
+			status = CASE WHEN r.total >= p.amount THEN 'refunded' WHEN r.total > 0 THEN 'partially_refunded' ELSE 'paid' END,
+			updated_at = NOW()
+		FROM (SELECT COALESCE(SUM(amount), 0) AS total FROM refunds WHERE payment_id = $1 AND status = 'succeeded') r
+		WHERE p.id = $1 AND p.status IN ('paid', 'partially_refunded', 'refunded')`, paymentID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresPaymentRepository) FailRefund(ctx context.Context, id int, failureReason string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE refunds SET status = 'failed', failure_reason = $1, updated_at = NOW()
+		WHERE id = $2`, failureReason, id)
+	return err
+}
+
+func (r *PostgresPaymentRepository) RecordProviderRefund(ctx context.Context, providerPaymentID, providerRefundID, status string, amount int64) (int, error) {
+	var refundID int
+	err := r.db.GetContext(ctx, &refundID, `SELECT id FROM refunds WHERE provider_refund_id = $1`, providerRefundID)
+	if err == nil {
+		return refundID, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO refunds (organization_id, payment_id, amount, currency, status, provider_refund_id, created_at, updated_at)
+		SELECT organization_id, id, $1, currency, $2, $3, NOW(), NOW()
+		FROM payments WHERE stripe_payment_id = $4
+		RETURNING id`, amount, status, providerRefundID, providerPaymentID).Scan(&refundID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrNotFound
+	}
+	return refundID, err
+}
+
+func (r *PostgresPaymentRepository) SyncRefundedAmount(ctx context.Context, providerPaymentID string, total int64) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE payments
+		SET refunded_amount = GREATEST(refunded_amount, $1),
+			status = CASE WHEN GREATEST(refunded_amount, $1) >= amount THEN 'refunded' ELSE 'partially_refunded' END,
+			updated_at = NOW()
+		WHERE stripe_payment_id = $2 AND status IN ('paid', 'partially_refunded', 'refunded')
+	`, total, providerPaymentID)
+	return err
+}
+
+// getAppointmentPayment loads a payment of an organization with the client
+// and masseur of its appointment; lock is appended to the query.
+func getAppointmentPayment(ctx context.Context, q sqlx.QueryerContext, lock string, id, orgID int) (*models.AppointmentPayment, error) {
+	var payment models.AppointmentPayment
+	err := sqlx.GetContext(ctx, q, &payment, `
+		SELECT `+paymentColumns+`, a.client_id, a.masseur_id
+		FROM payments p
+		JOIN appointments a ON a.id = p.appointment_id
+		WHERE p.id = $1 AND p.organization_id = $2
+		`+lock, id, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// closedDisputeStatuses are the provider dispute statuses that end a
+// dispute.
+var closedDisputeStatuses = []string{"won", "lost", "warning_closed"}
+
+// updateAppointmentStatus mirrors payment state onto the appointment, leaving
+// appointments that were already completed or canceled alone.
+func updateAppointmentStatus(ctx context.Context, tx *sqlx.Tx, appointmentID int, status string) error {
+	if status == "" {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		UPDATE appointments
+		SET status = $1, updated_at = NOW()
+		WHERE id = $2 AND status NOT IN ('completed', 'canceled')
+	`, status, appointmentID)
+	return err
+}
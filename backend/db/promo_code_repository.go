@@ -0,0 +1,251 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// promoColumns selects a promo code with the number of its redemptions that
+// count against its limits.
+const promoColumns = `
+	p.id, p.organization_id, p.code, p.description, p.discount_type, p.percent, p.amount_off, p.currency,
+	p.max_redemptions, p.max_per_client, p.valid_from, p.valid_until, p.service_ids, p.active, p.created_at, p.updated_at,
+	(SELECT COUNT(*) FROM promo_redemptions r WHERE r.promo_code_id = p.id AND r.status <> 'released') AS redemptions`
+
+// PromoCodeRepository stores promo codes and their redemptions. Codes are
+// matched case-insensitively.
+type PromoCodeRepository interface {
+	List(ctx context.Context) ([]models.PromoCode, error)
+	Get(ctx context.Context, id int) (*models.PromoCode, error)
+	GetByCode(ctx context.Context, code string) (*models.PromoCode, error)
+	// Create returns ErrDuplicate if the organization already has the code.
+	Create(ctx context.Context, promo *models.PromoCode) error
+	Update(ctx context.Context, promo *models.PromoCode) error
+	Deactivate(ctx context.Context, id int) error
+	// Redeem reserves a use of a promo code for a payment, returning
+	// ErrLimitReached if the code or the client has no uses left.
+	Redeem(ctx context.Context, redemption *models.PromoRedemption) error
+	// Settle moves the pending redemption of a payment to status, redeemed
+	// or released. It is not tenant scoped as it serves provider webhooks.
+	Settle(ctx context.Context, paymentID int, status string) error
+}
+
+type PostgresPromoCodeRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewPromoCodeRepository(db *sqlx.DB, logger *zap.Logger) PromoCodeRepository {
+	return &PostgresPromoCodeRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresPromoCodeRepository) List(ctx context.Context) ([]models.PromoCode, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + promoColumns + ` FROM promo_codes p WHERE p.organization_id = $1 ORDER BY p.created_at DESC`
+	var promos []models.PromoCode
+	if err := r.db.SelectContext(ctx, &promos, query, orgID); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return promos, nil
+}
+
+func (r *PostgresPromoCodeRepository) Get(ctx context.Context, id int) (*models.PromoCode, error) {
+	return r.get(ctx, `p.id = $2`, id)
+}
+
+func (r *PostgresPromoCodeRepository) GetByCode(ctx context.Context, code string) (*models.PromoCode, error) {
+	return r.get(ctx, `p.code = $2`, strings.ToUpper(strings.TrimSpace(code)))
+}
+
+func (r *PostgresPromoCodeRepository) get(ctx context.Context, condition string, arg interface{}) (*models.PromoCode, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var promo models.PromoCode
+	query := `SELECT ` + promoColumns + ` FROM promo_codes p WHERE p.organization_id = $1 AND ` + condition
+	if err := r.db.GetContext(ctx, &promo, query, orgID, arg); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &promo, nil
+}
+
+func (r *PostgresPromoCodeRepository) Create(ctx context.Context, promo *models.PromoCode) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	promo.OrganizationID = orgID
+	promo.Code = strings.ToUpper(strings.TrimSpace(promo.Code))
+
+	query := `
+		INSERT INTO promo_codes (organization_id, code, description, discount_type, percent, amount_off, currency,
+			max_redemptions, max_per_client, valid_from, valid_until, service_ids, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, NOW(), NOW())
+		ON CONFLICT (organization_id, code) DO NOTHING
+		RETURNING id, created_at, updated_at
+	`
+	err = r.db.QueryRowContext(ctx, query,
+		promo.OrganizationID,
+		promo.Code,
+		promo.Description,
+		promo.DiscountType,
+		promo.Percent,
+		promo.AmountOff,
+		promo.Currency,
+		promo.MaxRedemptions,
+		promo.MaxPerClient,
+		promo.ValidFrom,
+		promo.ValidUntil,
+		promo.ServiceIDs,
+		promo.Active,
+	).Scan(&promo.ID, &promo.CreatedAt, &promo.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+// Update changes everything but the code itself, which clients may already
+// have been given.
+func (r *PostgresPromoCodeRepository) Update(ctx context.Context, promo *models.PromoCode) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE promo_codes
+		SET description = $1, discount_type = $2, percent = $3, amount_off = $4, currency = $5, max_redemptions = $6,
+			max_per_client = $7, valid_from = $8, valid_until = $9, service_ids = $10, active = $11, updated_at = NOW()
+		WHERE id = $12 AND organization_id = $13
+		RETURNING updated_at
+	`
+	err = r.db.QueryRowContext(ctx, query,
+		promo.Description,
+		promo.DiscountType,
+		promo.Percent,
+		promo.AmountOff,
+		promo.Currency,
+		promo.MaxRedemptions,
+		promo.MaxPerClient,
+		promo.ValidFrom,
+		promo.ValidUntil,
+		promo.ServiceIDs,
+		promo.Active,
+		promo.ID,
+		orgID,
+	).Scan(&promo.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (r *PostgresPromoCodeRepository) Deactivate(ctx context.Context, id int) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, `UPDATE promo_codes SET active = FALSE, updated_at = NOW() WHERE id = $1 AND organization_id = $2`, id, orgID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresPromoCodeRepository) Redeem(ctx context.Context, redemption *models.PromoRedemption) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	redemption.OrganizationID = orgID
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Locking the code serializes redemptions so its limits cannot be
+	// overrun by concurrent checkouts.
+	var limits struct {
+		MaxRedemptions *int `db:"max_redemptions"`
+		MaxPerClient   *int `db:"max_per_client"`
+	}
+	err = tx.GetContext(ctx, &limits, `
+		SELECT max_redemptions, max_per_client FROM promo_codes
+		WHERE id = $1 AND organization_id = $2
+		FOR UPDATE`, redemption.PromoCodeID, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var used struct {
+		Total  int `db:"total"`
+		Client int `db:"client"`
+	}
+	err = tx.GetContext(ctx, &used, `
+		SELECT COUNT(*) AS total, COUNT(*) FILTER (WHERE client_id = $2) AS client
+		FROM promo_redemptions
+		WHERE promo_code_id = $1 AND status <> 'released'`, redemption.PromoCodeID, redemption.ClientID)
+	if err != nil {
+		return err
+	}
+	if (limits.MaxRedemptions != nil && used.Total >= *limits.MaxRedemptions) ||
+		(limits.MaxPerClient != nil && used.Client >= *limits.MaxPerClient) {
+		return ErrLimitReached
+	}
+
+	redemption.Status = "pending"
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO promo_redemptions (organization_id, promo_code_id, client_id, payment_id, appointment_id, discount, status, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+		RETURNING id, created_at, updated_at`,
+		redemption.OrganizationID,
+		redemption.PromoCodeID,
+		redemption.ClientID,
+		redemption.PaymentID,
+		redemption.AppointmentID,
+		redemption.Discount,
+		redemption.Status,
+	).Scan(&redemption.ID, &redemption.CreatedAt, &redemption.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("insert redemption: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+func (r *PostgresPromoCodeRepository) Settle(ctx context.Context, paymentID int, status string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE promo_redemptions SET status = $1, updated_at = NOW()
+		WHERE payment_id = $2 AND status = 'pending'`, status, paymentID)
+	return err
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const receiptColumns = `id, organization_id, payment_id, number, prefix, document, issued_at`
+
+// ReceiptRepository stores issued receipts, numbered in sequence per
+// organization.
+type ReceiptRepository interface {
+	GetByPayment(ctx context.Context, paymentID int) (*models.Receipt, error)
+	// Payment returns a payment of the current organization with the
+	// appointment and client its receipt names.
+	Payment(ctx context.Context, paymentID int) (*models.ReceiptPayment, error)
+	// PaymentTotals sums the payments towards the price of an appointment,
+	// tips left out, that are in one of statuses: the promo discounts of all
+	// of them and what was paid before paymentID.
+	PaymentTotals(ctx context.Context, appointmentID, paymentID int, statuses []string) (promoDiscount, previouslyPaid int64, err error)
+	// Issue numbers and stores a payment's receipt. If the payment already
+	// has one, that receipt is returned instead and no number is used up.
+	Issue(ctx context.Context, receipt *models.Receipt) error
+}
+
+type PostgresReceiptRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewReceiptRepository(db *sqlx.DB, logger *zap.Logger) ReceiptRepository {
+	return &PostgresReceiptRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresReceiptRepository) GetByPayment(ctx context.Context, paymentID int) (*models.Receipt, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var receipt models.Receipt
+	query := `SELECT ` + receiptColumns + ` FROM receipts WHERE payment_id = $1 AND organization_id = $2`
+	if err := r.db.GetContext(ctx, &receipt, query, paymentID, orgID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &receipt, nil
+}
+
+func (r *PostgresReceiptRepository) Payment(ctx context.Context, paymentID int) (*models.ReceiptPayment, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var payment models.ReceiptPayment
+	err = r.db.GetContext(ctx, &payment, `
+		SELECT p.id, p.appointment_id, p.amount, p.currency, p.status, p.kind, p.provider, p.discount_amount, p.gift_card_amount, p.price, p.updated_at,
+			a.client_id, a.masseur_id, a.appointment_date, u.email AS client_email
+		FROM payments p
+		JOIN appointments a ON a.id = p.appointment_id
+		JOIN user_profiles u ON u.id = a.client_id
+		WHERE p.id = $1 AND p.organization_id = $2`, paymentID, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (r *PostgresReceiptRepository) PaymentTotals(ctx context.Context, appointmentID, paymentID int, statuses []string) (int64, int64, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var totals struct {
+		PromoDiscount  int64 `db:"promo_discount"`
+		PreviouslyPaid int64 `db:"previously_paid"`
+	}
+	err = r.db.GetContext(ctx, &totals, `
+		SELECT COALESCE(SUM(discount_amount), 0) AS promo_discount,
+			COALESCE(SUM(amount + gift_card_amount) FILTER (WHERE id < $2), 0) AS previously_paid
+		FROM payments
+		WHERE appointment_id = $1 AND organization_id = $3 AND kind <> 'tip' AND status = ANY($4)`,
+		appointmentID, paymentID, orgID, pq.Array(statuses))
+	if err != nil {
+		return 0, 0, err
+	}
+	return totals.PromoDiscount, totals.PreviouslyPaid, nil
+}
+
+func (r *PostgresReceiptRepository) Issue(ctx context.Context, receipt *models.Receipt) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	receipt.OrganizationID = orgID
+
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// The counter row stays locked until commit, so concurrent receipts of
+	// the organization are numbered one after the other.
+	err = tx.GetContext(ctx, &receipt.Number, `
+		INSERT INTO receipt_counters (organization_id, last_number) VALUES ($1, 1)
+		ON CONFLICT (organization_id) DO UPDATE SET last_number = receipt_counters.last_number + 1
+		RETURNING last_number`, orgID)
+	if err != nil {
+		return fmt.Errorf("next receipt number: %w", err)
+	}
+
+	err = tx.GetContext(ctx, receipt, `
+		INSERT INTO receipts (organization_id, payment_id, number, prefix, document, issued_at)
+		VALUES ($1, $2, $3, $4, $5, NOW())
+		ON CONFLICT (payment_id) DO NOTHING
+		RETURNING `+receiptColumns,
+		orgID, receipt.PaymentID, receipt.Number, receipt.Prefix, receipt.Document)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Rolling back gives the number back.
+		tx.Rollback()
+		existing, err := r.GetByPayment(ctx, receipt.PaymentID)
+		if err != nil {
+			return err
+		}
+		*receipt = *existing
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("insert receipt: %w", err)
+	}
+
+	return tx.Commit()
+}
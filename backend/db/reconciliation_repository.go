@@ -0,0 +1,155 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+)
+
+const reconciliationIssueColumns = `id, organization_id, provider, kind, reference, local_status, provider_status, detail, first_seen_at, last_seen_at, resolved_at, resolved_by`
+
+// ReconciliationRepository backs the nightly reconciliation with the payment
+// provider. The record lookups and RecordIssue span all organizations and
+// are not scoped; ListIssues and ResolveIssue are scoped to the tenant in ctx.
+type ReconciliationRepository interface {
+	// PaymentRecords finds the appointment payments, package purchases and
+	// gift card purchases made through any of the provider payments refs.
+	PaymentRecords(ctx context.Context, refs []string) ([]models.ReconciliationRecord, error)
+	// PaymentsCreatedBetween lists the appointment payments made through
+	// provider in [from, to).
+	PaymentsCreatedBetween(ctx context.Context, provider string, from, to time.Time) ([]models.ReconciliationRecord, error)
+	// SubscriptionRecords finds subscriptions by checkout session or
+	// provider subscription ID.
+	SubscriptionRecords(ctx context.Context, sessionIDs, subscriptionIDs []string) ([]models.ReconciliationRecord, error)
+	// RecordIssue opens an issue, or refreshes the open issue of the same
+	// kind for the same reference.
+	RecordIssue(ctx context.Context, issue *models.ReconciliationIssue) error
+	ListIssues(ctx context.Context, resolved *bool, limit, offset int) ([]models.ReconciliationIssue, error)
+	ResolveIssue(ctx context.Context, id int, resolvedBy *int) (*models.ReconciliationIssue, error)
+}
+
+type PostgresReconciliationRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewReconciliationRepository(db *sqlx.DB, logger *zap.Logger) ReconciliationRepository {
+	return &PostgresReconciliationRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresReconciliationRepository) PaymentRecords(ctx context.Context, refs []string) ([]models.ReconciliationRecord, error) {
+	records := []models.ReconciliationRecord{}
+	if len(refs) == 0 {
+		return records, nil
+	}
+
+	err := r.db.SelectContext(ctx, &records, `
+		SELECT 'payment' AS source, stripe_payment_id AS reference, organization_id, status, amount, refunded_amount,
+			dispute_id IS NOT NULL AS disputed
+		FROM payments WHERE stripe_payment_id = ANY($1)
+		UNION ALL
+		SELECT 'package', provider_payment_id, organization_id, status, amount, 0, FALSE
+		FROM package_purchases WHERE provider_payment_id = ANY($1)
+		UNION ALL
+		SELECT 'gift_card', provider_payment_id, organization_id, status, initial_amount, 0, FALSE
+		FROM gift_cards WHERE provider_payment_id = ANY($1)`, pq.Array(refs))
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *PostgresReconciliationRepository) PaymentsCreatedBetween(ctx context.Context, provider string, from, to time.Time) ([]models.ReconciliationRecord, error) {
+	records := []models.ReconciliationRecord{}
+	err := r.db.SelectContext(ctx, &records, `
+		SELECT 'payment' AS source, stripe_payment_id AS reference, organization_id, status, amount, refunded_amount,
+			dispute_id IS NOT NULL AS disputed
+		FROM payments
+		WHERE provider = $1 AND created_at >= $2 AND created_at < $3
+		ORDER BY created_at`, provider, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *PostgresReconciliationRepository) SubscriptionRecords(ctx context.Context, sessionIDs, subscriptionIDs []string) ([]models.ReconciliationRecord, error) {
+	records := []models.ReconciliationRecord{}
+	if len(sessionIDs) == 0 && len(subscriptionIDs) == 0 {
+		return records, nil
+	}
+
+	err := r.db.SelectContext(ctx, &records, `
+		SELECT 'subscription' AS source, stripe_session_id AS reference, COALESCE(stripe_subscription_id, '') AS subscription_id,
+			organization_id, status
+		FROM subscriptions
+		WHERE stripe_session_id = ANY($1) OR stripe_subscription_id = ANY($2)`, pq.Array(sessionIDs), pq.Array(subscriptionIDs))
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (r *PostgresReconciliationRepository) RecordIssue(ctx context.Context, issue *models.ReconciliationIssue) error {
+	return r.db.GetContext(ctx, issue, `
+		INSERT INTO reconciliation_issues (organization_id, provider, kind, reference, local_status, provider_status, detail, first_seen_at, last_seen_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW(), NOW())
+		ON CONFLICT (provider, kind, reference) WHERE resolved_at IS NULL
+		DO UPDATE SET local_status = EXCLUDED.local_status, provider_status = EXCLUDED.provider_status,
+			detail = EXCLUDED.detail, last_seen_at = NOW()
+		RETURNING `+reconciliationIssueColumns,
+		issue.OrganizationID, issue.Provider, issue.Kind, issue.Reference, issue.LocalStatus, issue.ProviderStatus, issue.Detail)
+}
+
+func (r *PostgresReconciliationRepository) ListIssues(ctx context.Context, resolved *bool, limit, offset int) ([]models.ReconciliationIssue, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + reconciliationIssueColumns + ` FROM reconciliation_issues WHERE organization_id = $1`
+	if resolved != nil {
+		if *resolved {
+			query += ` AND resolved_at IS NOT NULL`
+		} else {
+			query += ` AND resolved_at IS NULL`
+		}
+	}
+	query += ` ORDER BY last_seen_at DESC, id DESC LIMIT $2 OFFSET $3`
+
+	issues := []models.ReconciliationIssue{}
+	if err := r.db.SelectContext(ctx, &issues, query, orgID, limit, offset); err != nil {
+		return nil, err
+	}
+	return issues, nil
+}
+
+func (r *PostgresReconciliationRepository) ResolveIssue(ctx context.Context, id int, resolvedBy *int) (*models.ReconciliationIssue, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var issue models.ReconciliationIssue
+	err = r.db.GetContext(ctx, &issue, `
+		UPDATE reconciliation_issues SET resolved_at = NOW(), resolved_by = $1
+		WHERE id = $2 AND organization_id = $3 AND resolved_at IS NULL
+		RETURNING `+reconciliationIssueColumns, resolvedBy, id, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &issue, nil
+}
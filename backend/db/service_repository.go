@@ -0,0 +1,192 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/pricing"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+type ServiceRepository interface {
+	ListServices(ctx context.Context, activeOnly bool) ([]models.Service, error)
+	CreateService(ctx context.Context, service *models.Service) error
+	ListAddOns(ctx context.Context, activeOnly bool) ([]models.AddOn, error)
+	CreateAddOn(ctx context.Context, addOn *models.AddOn) error
+	GetPricingInput(ctx context.Context, appointmentID int) (*pricing.Input, error)
+}
+
+type PostgresServiceRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewServiceRepository(db *sqlx.DB, logger *zap.Logger) ServiceRepository {
+	return &PostgresServiceRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresServiceRepository) ListServices(ctx context.Context, activeOnly bool) ([]models.Service, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, organization_id, name, duration_minutes, price, currency, tax_rate, active, created_at, updated_at
+		FROM services
+		WHERE organization_id = $1 AND (active OR NOT $2)
+		ORDER BY name
+	`
+	var services []models.Service
+	if err := r.db.SelectContext(ctx, &services, query, orgID, activeOnly); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return services, nil
+}
+
+func (r *PostgresServiceRepository) CreateService(ctx context.Context, service *models.Service) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	service.OrganizationID = orgID
+	service.CreatedAt = time.Now()
+	service.UpdatedAt = service.CreatedAt
+
+	query := `
+		INSERT INTO services (organization_id, name, duration_minutes, price, currency, tax_rate, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(ctx, query,
+		service.OrganizationID,
+		service.Name,
+		service.DurationMinutes,
+		service.Price,
+		service.Currency,
+		service.TaxRate,
+		service.Active,
+		service.CreatedAt,
+		service.UpdatedAt,
+	).Scan(&service.ID)
+}
+
+func (r *PostgresServiceRepository) ListAddOns(ctx context.Context, activeOnly bool) ([]models.AddOn, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+		SELECT id, organization_id, name, price, active, created_at, updated_at
+		FROM add_ons
+		WHERE organization_id = $1 AND (active OR NOT $2)
+		ORDER BY name
+	`
+	var addOns []models.AddOn
+	if err := r.db.SelectContext(ctx, &addOns, query, orgID, activeOnly); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+	return addOns, nil
+}
+
+func (r *PostgresServiceRepository) CreateAddOn(ctx context.Context, addOn *models.AddOn) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	addOn.OrganizationID = orgID
+	addOn.CreatedAt = time.Now()
+	addOn.UpdatedAt = addOn.CreatedAt
+
+	query := `
+		INSERT INTO add_ons (organization_id, name, price, active, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id
+	`
+	return r.db.QueryRowContext(ctx, query,
+		addOn.OrganizationID,
+		addOn.Name,
+		addOn.Price,
+		addOn.Active,
+		addOn.CreatedAt,
+		addOn.UpdatedAt,
+	).Scan(&addOn.ID)
+}
+
+func (r *PostgresServiceRepository) GetPricingInput(ctx context.Context, appointmentID int) (*pricing.Input, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var appt struct {
+		ID              int     `db:"id"`
+		ClientID        int     `db:"client_id"`
+		MasseurID       int     `db:"masseur_id"`
+		ServiceID       *int    `db:"service_id"`
+		DiscountPercent float64 `db:"discount_percent"`
+		MasseurCurrency string  `db:"masseur_currency"`
+	}
+	query := `
+		SELECT a.id, a.client_id, a.masseur_id, a.service_id, a.discount_percent,
+			COALESCE(u.currency, '') AS masseur_currency
+		FROM appointments a
+		LEFT JOIN user_profiles u ON u.id = a.masseur_id
+		WHERE a.id = $1 AND a.organization_id = $2
+	`
+	if err := r.db.GetContext(ctx, &appt, query, appointmentID, orgID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	input := &pricing.Input{
+		AppointmentID:   appt.ID,
+		ClientID:        appt.ClientID,
+		MasseurID:       appt.MasseurID,
+		DiscountPercent: appt.DiscountPercent,
+		Currency:        appt.MasseurCurrency,
+	}
+
+	if appt.ServiceID != nil {
+		var service models.Service
+		query = `
+			SELECT id, organization_id, name, duration_minutes, price, currency, tax_rate, active, created_at, updated_at
+			FROM services
+			WHERE id = $1 AND organization_id = $2
+		`
+		// Appointments saved before services were checked on booking can
+		// name another organization's service; they have no price here.
+		err := r.db.GetContext(ctx, &service, query, *appt.ServiceID, orgID)
+		switch {
+		case err == nil:
+			input.Service = &service
+		case !errors.Is(err, sql.ErrNoRows):
+			return nil, fmt.Errorf("service lookup error: %w", err)
+		}
+	}
+
+	query = `
+		SELECT a.id, a.organization_id, a.name, a.price, a.active, a.created_at, a.updated_at
+		FROM appointment_add_ons aa
+		JOIN add_ons a ON a.id = aa.add_on_id
+		WHERE aa.appointment_id = $1 AND a.organization_id = $2
+		ORDER BY a.name
+	`
+	if err := r.db.SelectContext(ctx, &input.AddOns, query, appointmentID, orgID); err != nil {
+		return nil, fmt.Errorf("add-on lookup error: %w", err)
+	}
+
+	return input, nil
+}
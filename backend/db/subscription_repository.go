@@ -0,0 +1,103 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+// SubscriptionRepository stores the subscriptions clients take out through
+// provider checkouts. Methods serving provider webhooks are not tenant
+// scoped.
+type SubscriptionRepository interface {
+	// Create records a pending subscription of the current organization. It
+	// returns ErrDuplicate if the checkout session is already recorded.
+	Create(ctx context.Context, sub *models.Subscription) error
+	// Activate marks the subscription of a checkout session as active under
+	// a provider subscription once the checkout is paid. It returns
+	// ErrNotFound if the session is not recorded (yet).
+	Activate(ctx context.Context, sessionID, subscriptionID string) error
+	// Cancel marks the subscription of a provider subscription as canceled.
+	Cancel(ctx context.Context, subscriptionID string) error
+	// HasActive reports whether a user of the current organization has an
+	// active subscription, whatever other checkouts they started.
+	HasActive(ctx context.Context, userID int) (bool, error)
+}
+
+type PostgresSubscriptionRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewSubscriptionRepository(db *sqlx.DB, logger *zap.Logger) SubscriptionRepository {
+	return &PostgresSubscriptionRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (r *PostgresSubscriptionRepository) Create(ctx context.Context, sub *models.Subscription) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	sub.OrganizationID = orgID
+	sub.CreatedAt = time.Now()
+	sub.UpdatedAt = sub.CreatedAt
+
+	err = r.db.QueryRowContext(ctx, `
+		INSERT INTO subscriptions (organization_id, user_id, provider, stripe_session_id, plan_id, status, created_at, updated_at)
+		SELECT $1, $2, $3, $4, $5, $6, $7, $7
+		WHERE NOT EXISTS (SELECT 1 FROM subscriptions WHERE stripe_session_id = $4)
+		RETURNING id`,
+		sub.OrganizationID, sub.UserID, sub.Provider, sub.StripeSessionID, sub.PlanID, sub.Status, sub.CreatedAt,
+	).Scan(&sub.ID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *PostgresSubscriptionRepository) Activate(ctx context.Context, sessionID, subscriptionID string) error {
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET status = 'active', stripe_subscription_id = $1, updated_at = NOW()
+		WHERE stripe_session_id = $2
+	`, subscriptionID, sessionID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresSubscriptionRepository) Cancel(ctx context.Context, subscriptionID string) error {
+	_, err := r.db.ExecContext(ctx, `
+		UPDATE subscriptions
+		SET status = 'canceled', updated_at = NOW()
+		WHERE stripe_subscription_id = $1
+	`, subscriptionID)
+	return err
+}
+
+func (r *PostgresSubscriptionRepository) HasActive(ctx context.Context, userID int) (bool, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return false, err
+	}
+	var active bool
+	err = r.db.GetContext(ctx, &active, `
+		SELECT EXISTS (
+			SELECT 1 FROM subscriptions
+			WHERE user_id = $1 AND organization_id = $2 AND status = 'active'
+		)`, userID, orgID)
+	return active, err
+}
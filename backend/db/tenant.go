@@ -0,0 +1,29 @@
+package db
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrNoTenant = errors.New("no tenant in context")
+
+type tenantKey struct{}
+
+func WithTenant(ctx context.Context, organizationID int) context.Context {
+	return context.WithValue(ctx, tenantKey{}, organizationID)
+}
+
+func TenantFromContext(ctx context.Context) (int, bool) {
+	id, ok := ctx.Value(tenantKey{}).(int)
+	return id, ok && id > 0
+}
+
+// tenantID is used by every repository query so that a missing tenant fails
+// closed instead of reading across organizations.
+func tenantID(ctx context.Context) (int, error) {
+	id, ok := TenantFromContext(ctx)
+	if !ok {
+		return 0, ErrNoTenant
+	}
+	return id, nil
+}
@@ -0,0 +1,190 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+type UserRepository interface {
+	GetByID(ctx context.Context, id int) (*models.User, error)
+	GetByExternalID(ctx context.Context, externalID string) (*models.User, error)
+	// Upsert creates the user on first sight in the current organization and
+	// otherwise refreshes their email from the auth provider; the role of an
+	// existing member is left alone. Only the Clerk webhooks add users to an
+	// organization; requests use Refresh.
+	Upsert(ctx context.Context, user *models.User) error
+	// Refresh updates the email of a member of the current organization and
+	// loads the rest of their profile into user. It returns ErrNotFound for
+	// users who are not members.
+	Refresh(ctx context.Context, user *models.User) error
+	// UpdateEmailByExternalID refreshes the email in every organization the
+	// external identity belongs to. Roles are per organization and are not
+	// touched.
+	UpdateEmailByExternalID(ctx context.Context, externalID, email string) error
+	SetPayoutAccount(ctx context.Context, id int, accountID, country string) error
+	// SetCurrency sets the currency a masseur takes payments in; empty
+	// falls back to the organization's.
+	SetCurrency(ctx context.Context, id int, currency string) error
+	// SetCustomer stores the provider customer for the user's saved payment
+	// methods unless one is already set, and returns the one in effect.
+	SetCustomer(ctx context.Context, id int, customerID string) (string, error)
+	// UpdatePayoutStatus records the provider's view of a payout account. It
+	// is called from webhooks and is not scoped to a tenant.
+	UpdatePayoutStatus(ctx context.Context, accountID string, chargesEnabled, payoutsEnabled, detailsSubmitted bool) error
+}
+
+type PostgresUserRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewUserRepository(db *sqlx.DB, logger *zap.Logger) UserRepository {
+	return &PostgresUserRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+const userColumns = `id, organization_id, external_id, email, role, stripe_account_id, country, stripe_customer_id, currency, charges_enabled, payouts_enabled, details_submitted, created_at, updated_at`
+
+func (r *PostgresUserRepository) GetByID(ctx context.Context, id int) (*models.User, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.getOne(ctx, `SELECT `+userColumns+` FROM user_profiles WHERE id = $1 AND organization_id = $2`, id, orgID)
+}
+
+func (r *PostgresUserRepository) GetByExternalID(ctx context.Context, externalID string) (*models.User, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.getOne(ctx, `SELECT `+userColumns+` FROM user_profiles WHERE external_id = $1 AND organization_id = $2`, externalID, orgID)
+}
+
+func (r *PostgresUserRepository) Upsert(ctx context.Context, user *models.User) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+	user.OrganizationID = orgID
+
+	query := `
+		INSERT INTO user_profiles (organization_id, external_id, email, role, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, NOW(), NOW())
+		ON CONFLICT (organization_id, external_id) DO UPDATE
+		SET email = EXCLUDED.email, updated_at = NOW()
+		RETURNING id, role, stripe_account_id, created_at, updated_at
+	`
+	return r.db.QueryRowContext(ctx, query,
+		user.OrganizationID,
+		user.ExternalID,
+		user.Email,
+		user.Role,
+	).Scan(&user.ID, &user.Role, &user.StripeAccountID, &user.CreatedAt, &user.UpdatedAt)
+}
+
+func (r *PostgresUserRepository) Refresh(ctx context.Context, user *models.User) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	refreshed, err := r.getOne(ctx, `
+		UPDATE user_profiles SET email = COALESCE(NULLIF($1, ''), email), updated_at = NOW()
+		WHERE external_id = $2 AND organization_id = $3
+		RETURNING `+userColumns, user.Email, user.ExternalID, orgID)
+	if err != nil {
+		return err
+	}
+	*user = *refreshed
+	return nil
+}
+
+func (r *PostgresUserRepository) UpdateEmailByExternalID(ctx context.Context, externalID, email string) error {
+	query := `
+		UPDATE user_profiles
+		SET email = COALESCE(NULLIF($1, ''), email), updated_at = NOW()
+		WHERE external_id = $2
+	`
+	_, err := r.db.ExecContext(ctx, query, email, externalID)
+	return err
+}
+
+func (r *PostgresUserRepository) SetPayoutAccount(ctx context.Context, id int, accountID, country string) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE user_profiles
+		SET stripe_account_id = $1, country = $2, charges_enabled = FALSE, payouts_enabled = FALSE, details_submitted = FALSE, updated_at = NOW()
+		WHERE id = $3 AND organization_id = $4
+	`
+	_, err = r.db.ExecContext(ctx, query, accountID, country, id, orgID)
+	return err
+}
+
+func (r *PostgresUserRepository) SetCurrency(ctx context.Context, id int, currency string) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	res, err := r.db.ExecContext(ctx, `
+		UPDATE user_profiles SET currency = $1, updated_at = NOW()
+		WHERE id = $2 AND organization_id = $3`, currency, id, orgID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PostgresUserRepository) SetCustomer(ctx context.Context, id int, customerID string) (string, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var current string
+	err = r.db.QueryRowContext(ctx, `
+		UPDATE user_profiles SET stripe_customer_id = COALESCE(stripe_customer_id, $1), updated_at = NOW()
+		WHERE id = $2 AND organization_id = $3
+		RETURNING stripe_customer_id`, customerID, id, orgID).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	return current, err
+}
+
+func (r *PostgresUserRepository) UpdatePayoutStatus(ctx context.Context, accountID string, chargesEnabled, payoutsEnabled, detailsSubmitted bool) error {
+	query := `
+		UPDATE user_profiles
+		SET charges_enabled = $1, payouts_enabled = $2, details_submitted = $3, updated_at = NOW()
+		WHERE stripe_account_id = $4
+	`
+	_, err := r.db.ExecContext(ctx, query, chargesEnabled, payoutsEnabled, detailsSubmitted, accountID)
+	return err
+}
+
+func (r *PostgresUserRepository) getOne(ctx context.Context, query string, args ...interface{}) (*models.User, error) {
+	var user models.User
+	if err := r.db.GetContext(ctx, &user, query, args...); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
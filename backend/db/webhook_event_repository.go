@@ -0,0 +1,210 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ozoli99/Harmonia/models"
+
+	"github.com/jmoiron/sqlx"
+	"go.uber.org/zap"
+)
+
+const webhookEventColumns = `id, organization_id, provider, event_id, event_type, provider_type, payload, event, status, attempts, last_error, next_attempt_at, processed_at, received_at, updated_at`
+
+// WebhookEventRepository stores incoming provider webhooks. Record and the
+// claim/mark methods are used before a tenant is known and are not scoped;
+// List, GetByID and Requeue are scoped to the tenant in ctx.
+type WebhookEventRepository interface {
+	Record(ctx context.Context, event *models.WebhookEvent, paymentRef, accountRef string) (bool, error)
+	ClaimDue(ctx context.Context, limit int) ([]models.WebhookEvent, error)
+	MarkProcessed(ctx context.Context, id int, status string) error
+	MarkFailed(ctx context.Context, id int, lastError string, nextAttemptAt *time.Time) error
+	List(ctx context.Context, filters map[string]string, limit, offset int) ([]models.WebhookEvent, error)
+	GetByID(ctx context.Context, id int) (*models.WebhookEvent, error)
+	Requeue(ctx context.Context, id int) error
+}
+
+type PostgresWebhookEventRepository struct {
+	db     *sqlx.DB
+	logger *zap.Logger
+}
+
+func NewWebhookEventRepository(db *sqlx.DB, logger *zap.Logger) WebhookEventRepository {
+	return &PostgresWebhookEventRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// Record inserts event unless one with the same provider and event ID was
+// already received, and reports whether it was new. When the event carries
+// no organization, it is taken from the payment identified by paymentRef or
+// the user owning the payout account accountRef.
+func (r *PostgresWebhookEventRepository) Record(ctx context.Context, event *models.WebhookEvent, paymentRef, accountRef string) (bool, error) {
+	query := `
+		INSERT INTO webhook_events (organization_id, provider, event_id, event_type, provider_type, payload, event, status, received_at, updated_at, next_attempt_at)
+		VALUES (
+			COALESCE(
+				$1,
+				(SELECT organization_id FROM payments WHERE stripe_payment_id = $2 AND $2 <> '' LIMIT 1),
+				(SELECT organization_id FROM user_profiles WHERE stripe_account_id = $9 AND $9 <> '' LIMIT 1)
+			),
+			$3, $4, $5, $6, $7, $8, 'pending', NOW(), NOW(), NOW()
+		)
+		ON CONFLICT (provider, event_id) DO NOTHING
+		RETURNING id, organization_id, received_at
+	`
+	err := r.db.QueryRowContext(ctx, query,
+		event.OrganizationID,
+		paymentRef,
+		event.Provider,
+		event.EventID,
+		event.EventType,
+		event.ProviderType,
+		event.Payload,
+		event.Event,
+		accountRef,
+	).Scan(&event.ID, &event.OrganizationID, &event.ReceivedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ClaimDue marks up to limit events that are due for an attempt as
+// processing and returns them. Events left in processing by a worker that
+// died are picked up again after ten minutes.
+func (r *PostgresWebhookEventRepository) ClaimDue(ctx context.Context, limit int) ([]models.WebhookEvent, error) {
+	query := `
+		UPDATE webhook_events
+		SET status = 'processing', attempts = attempts + 1, updated_at = NOW()
+		WHERE id IN (
+			SELECT id FROM webhook_events
+			WHERE (status IN ('pending', 'failed') AND next_attempt_at <= NOW())
+				OR (status = 'processing' AND updated_at < NOW() - INTERVAL '10 minutes')
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + webhookEventColumns
+
+	var events []models.WebhookEvent
+	if err := r.db.SelectContext(ctx, &events, query, limit); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *PostgresWebhookEventRepository) MarkProcessed(ctx context.Context, id int, status string) error {
+	query := `
+		UPDATE webhook_events
+		SET status = $1, last_error = '', processed_at = NOW(), updated_at = NOW()
+		WHERE id = $2
+	`
+	_, err := r.db.ExecContext(ctx, query, status, id)
+	return err
+}
+
+// MarkFailed records a failed attempt. A nil nextAttemptAt moves the event
+// to the dead-letter state.
+func (r *PostgresWebhookEventRepository) MarkFailed(ctx context.Context, id int, lastError string, nextAttemptAt *time.Time) error {
+	query := `
+		UPDATE webhook_events
+		SET status = CASE WHEN $1::timestamptz IS NULL THEN 'dead' ELSE 'failed' END,
+			last_error = $2, next_attempt_at = COALESCE($1, next_attempt_at), updated_at = NOW()
+		WHERE id = $3
+	`
+	_, err := r.db.ExecContext(ctx, query, nextAttemptAt, lastError, id)
+	return err
+}
+
+func (r *PostgresWebhookEventRepository) List(ctx context.Context, filters map[string]string, limit, offset int) ([]models.WebhookEvent, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + webhookEventColumns + ` FROM webhook_events WHERE organization_id = :organization_id`
+
+	args := map[string]interface{}{
+		"organization_id": orgID,
+	}
+
+	if status, ok := filters["status"]; ok && status != "" {
+		query += " AND status = :status"
+		args["status"] = status
+	}
+	if eventType, ok := filters["event_type"]; ok && eventType != "" {
+		query += " AND event_type = :event_type"
+		args["event_type"] = eventType
+	}
+
+	query += " ORDER BY received_at DESC LIMIT :limit OFFSET :offset"
+	args["limit"] = limit
+	args["offset"] = offset
+
+	var events []models.WebhookEvent
+	namedStmt, err := r.db.PrepareNamedContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("prepare statement error: %w", err)
+	}
+	defer namedStmt.Close()
+
+	if err := namedStmt.SelectContext(ctx, &events, args); err != nil {
+		return nil, fmt.Errorf("select error: %w", err)
+	}
+
+	return events, nil
+}
+
+func (r *PostgresWebhookEventRepository) GetByID(ctx context.Context, id int) (*models.WebhookEvent, error) {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var event models.WebhookEvent
+	query := `SELECT ` + webhookEventColumns + ` FROM webhook_events WHERE id = $1 AND organization_id = $2`
+	err = r.db.GetContext(ctx, &event, query, id, orgID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// Requeue makes a failed or dead event due for processing again with a
+// fresh attempt count.
+func (r *PostgresWebhookEventRepository) Requeue(ctx context.Context, id int) error {
+	orgID, err := tenantID(ctx)
+	if err != nil {
+		return err
+	}
+
+	query := `
+		UPDATE webhook_events
+		SET status = 'pending', attempts = 0, next_attempt_at = NOW(), updated_at = NOW()
+		WHERE id = $1 AND organization_id = $2 AND status IN ('failed', 'dead')
+	`
+	result, err := r.db.ExecContext(ctx, query, id, orgID)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package fees
+
+import (
+	"errors"
+	"math"
+
+	"github.com/ozoli99/Harmonia/models"
+)
+
+// DefaultPercent is charged when an organization has neither a fee policy nor
+// an application fee percentage in its settings.
+const DefaultPercent = 10
+
+var (
+	ErrScopeTarget = errors.New("masseur policies need masseurId, plan policies need planId, default policies neither")
+	ErrTierOrder   = errors.New("tiers must have increasing upTo limits and only the last may be unlimited")
+)
+
+// Calculate returns the platform fee on amount. A policy with tiers uses the
+// first tier whose limit covers amount, charged on the whole amount, in place
+// of its flat percent and fixed amount. The fee never exceeds amount.
+func Calculate(policy *models.FeePolicy, amount int64) int64 {
+	if amount <= 0 {
+		return 0
+	}
+
+	percent, fixed := policy.Percent, policy.FixedAmount
+	for _, tier := range policy.Tiers {
+		if tier.UpTo == 0 || amount <= tier.UpTo {
+			percent, fixed = tier.Percent, tier.FixedAmount
+			break
+		}
+	}
+
+	fee := int64(math.Round(float64(amount)*percent/100)) + fixed
+	if fee > amount {
+		return amount
+	}
+	return fee
+}
+
+// Fallback is the policy used when an organization has no default policy.
+func Fallback(org *models.Organization) *models.FeePolicy {
+	percent := org.Settings.ApplicationFeePercent
+	if percent <= 0 {
+		percent = DefaultPercent
+	}
+	return &models.FeePolicy{OrganizationID: org.ID, Scope: "default", Percent: percent}
+}
+
+// Validate checks what struct tags cannot: the scope target and tier order.
+func Validate(policy *models.FeePolicy) error {
+	switch policy.Scope {
+	case "default":
+		if policy.MasseurID != nil || policy.PlanID != nil {
+			return ErrScopeTarget
+		}
+	case "masseur":
+		if policy.MasseurID == nil || policy.PlanID != nil {
+			return ErrScopeTarget
+		}
+	case "plan":
+		if policy.PlanID == nil || *policy.PlanID == "" || policy.MasseurID != nil {
+			return ErrScopeTarget
+		}
+	}
+
+	for i, tier := range policy.Tiers {
+		last := i == len(policy.Tiers)-1
+		if tier.UpTo == 0 && !last {
+			return ErrTierOrder
+		}
+		if i > 0 && tier.UpTo != 0 && tier.UpTo <= policy.Tiers[i-1].UpTo {
+			return ErrTierOrder
+		}
+	}
+	return nil
+}
@@ -5,6 +5,7 @@ go 1.23.4
 require (
 	github.com/clerkinc/clerk-sdk-go v1.49.1
 	github.com/gin-gonic/gin v1.10.0
+	github.com/go-jose/go-jose/v3 v3.0.0
 	github.com/go-playground/validator/v10 v10.20.0
 	github.com/jmoiron/sqlx v1.4.0
 	github.com/lib/pq v1.10.9
@@ -20,7 +21,6 @@ require (
 	github.com/cloudwego/iasm v0.2.0 // indirect
 	github.com/gabriel-vasile/mimetype v1.4.3 // indirect
 	github.com/gin-contrib/sse v0.1.0 // indirect
-	github.com/go-jose/go-jose/v3 v3.0.0 // indirect
 	github.com/go-playground/locales v0.14.1 // indirect
 	github.com/go-playground/universal-translator v0.18.1 // indirect
 	github.com/goccy/go-json v0.10.2 // indirect
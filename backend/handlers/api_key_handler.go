@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+const apiKeyPrefix = "hk"
+
+var APIKeyScopes = []string{
+	"appointments:read",
+	"appointments:write",
+	"payments:read",
+	"payments:write",
+	"subscriptions:write",
+}
+
+type APIKeyHandler struct {
+	Repo   db.APIKeyRepository
+	Logger *zap.Logger
+}
+
+func NewAPIKeyHandler(repo db.APIKeyRepository, logger *zap.Logger) *APIKeyHandler {
+	return &APIKeyHandler{
+		Repo:   repo,
+		Logger: logger,
+	}
+}
+
+func (h *APIKeyHandler) CreateAPIKey(c *gin.Context) {
+	var request struct {
+		Name               string     `json:"name" binding:"required"`
+		Scopes             []string   `json:"scopes" binding:"required,min=1"`
+		ExpiresAt          *time.Time `json:"expiresAt"`
+		RateLimitPerMinute int        `json:"rateLimitPerMinute"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, scope := range request.Scopes {
+		if !validAPIKeyScope(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unknown scope: %s", scope)})
+			return
+		}
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expiry must be in the future"})
+		return
+	}
+	if request.RateLimitPerMinute <= 0 {
+		request.RateLimitPerMinute = 60
+	}
+
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	prefix, secret, err := generateAPIKey()
+	if err != nil {
+		h.Logger.Error("Failed to generate API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+
+	key := models.APIKey{
+		Name:               request.Name,
+		Prefix:             prefix,
+		KeyHash:            hashAPIKey(secret),
+		Scopes:             request.Scopes,
+		RateLimitPerMinute: request.RateLimitPerMinute,
+		CreatedBy:          principal.ExternalID,
+		ExpiresAt:          request.ExpiresAt,
+		CreatedAt:          time.Now(),
+	}
+	if err := h.Repo.Create(c.Request.Context(), &key); err != nil {
+		h.Logger.Error("Failed to store API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store API key"})
+		return
+	}
+
+	h.Logger.Info("Created API key", zap.Int("api_key_id", key.ID), zap.String("prefix", key.Prefix))
+
+	// The plaintext key is only ever returned here; we keep just its hash.
+	c.JSON(http.StatusCreated, gin.H{
+		"apiKey": key,
+		"key":    fmt.Sprintf("%s_%s_%s", apiKeyPrefix, prefix, secret),
+	})
+}
+
+func (h *APIKeyHandler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.Repo.List(c.Request.Context())
+	if err != nil {
+		h.Logger.Error("Failed to list API keys", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list API keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+func (h *APIKeyHandler) RevokeAPIKey(c *gin.Context) {
+	idParam := c.Param("id")
+	id, err := strconv.Atoi(idParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID"})
+		return
+	}
+
+	if err := h.Repo.Revoke(c.Request.Context(), id); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		h.Logger.Error("Failed to revoke API key", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key"})
+		return
+	}
+
+	h.Logger.Info("Revoked API key", zap.Int("api_key_id", id))
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+func validAPIKeyScope(scope string) bool {
+	if scope == "*" {
+		return true
+	}
+	for _, s := range APIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func generateAPIKey() (string, string, error) {
+	buf := make([]byte, 28)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	encoded := hex.EncodeToString(buf)
+	return encoded[:8], encoded[8:], nil
+}
+
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// splitAPIKey breaks a presented "hk_<prefix>_<secret>" key into its lookup
+// prefix and secret part.
+func splitAPIKey(raw string) (string, string, bool) {
+	parts := strings.Split(raw, "_")
+	if len(parts) != 3 || parts[0] != apiKeyPrefix || parts[1] == "" || parts[2] == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
@@ -1,8 +1,11 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
@@ -14,17 +17,29 @@ import (
 	"go.uber.org/zap"
 )
 
+// PaymentSettler captures or releases the payments held for an appointment
+// once it is completed or canceled.
+type PaymentSettler interface {
+	SettleAppointment(ctx context.Context, appointmentID int, status string) error
+}
+
 type AppointmentHandler struct {
-	Repo      db.AppointmentRepository
-	Validator *validator.Validate
-	Logger    *zap.Logger
+	Repo          db.AppointmentRepository
+	Subscriptions db.SubscriptionRepository
+	Credits       db.CreditRepository
+	Payments      PaymentSettler
+	Validator     *validator.Validate
+	Logger        *zap.Logger
 }
 
-func NewAppointmentHandler(repo db.AppointmentRepository, logger *zap.Logger) *AppointmentHandler {
+func NewAppointmentHandler(repo db.AppointmentRepository, subscriptions db.SubscriptionRepository, credits db.CreditRepository, settler PaymentSettler, logger *zap.Logger) *AppointmentHandler {
 	return &AppointmentHandler{
-		Repo:      repo,
-		Validator: validator.New(),
-		Logger:    logger,
+		Repo:          repo,
+		Subscriptions: subscriptions,
+		Credits:       credits,
+		Payments:      settler,
+		Validator:     validator.New(),
+		Logger:        logger,
 	}
 }
 
@@ -59,23 +74,12 @@ func (h *AppointmentHandler) GetAppointments(c *gin.Context) {
 }
 
 func (h *AppointmentHandler) CreateAppointment(c *gin.Context) {
-	userIDIfc, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
-	}
-	userID, ok := userIDIfc.(string)
+	principal, ok := CurrentPrincipal(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user id type"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	var status string
-	if err := h.Repo.GetSubscriptionStatus(c.Request.Context(), userID, &status); err != nil || status != "active" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Active subscription required"})
-		return
-	}
-	
 	var appt models.Appointment
 	if err := c.ShouldBindJSON(&appt); err != nil {
 		c.Error(fmt.Errorf("invalid JSON: %w", err))
@@ -86,36 +90,60 @@ func (h *AppointmentHandler) CreateAppointment(c *gin.Context) {
 		c.Error(fmt.Errorf("validation error: %w", err))
 		return
 	}
-	
-	clientID, err := strconv.Atoi(userID)
+
+	// Service callers such as the front-desk kiosk book on behalf of a client
+	// named in the body; users always book for themselves.
+	if !principal.IsService() {
+		appt.ClientID = principal.UserID
+	} else if appt.ClientID <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "clientId is required"})
+		return
+	}
+
+	// Clients with package credits can book without a subscription.
+	credits, err := h.Credits.Available(c.Request.Context(), appt.ClientID, appt.ServiceID)
+	if err != nil {
+		c.Error(fmt.Errorf("available credits: %w", err))
+		return
+	}
+	subscribed, err := h.Subscriptions.HasActive(c.Request.Context(), appt.ClientID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "User ID conversion error"})
+		c.Error(fmt.Errorf("subscription status: %w", err))
+		return
+	}
+	if !subscribed && credits == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Active subscription or package credit required"})
+		return
+	}
+
+	if !h.checkMasseurBookable(c, appt.MasseurID) {
 		return
 	}
 
-	appt.ClientID = clientID
+	appt.Status = "pending"
 	appt.CreatedAt = time.Now()
 	appt.UpdatedAt = time.Now()
 
 	err = h.Repo.Create(c.Request.Context(), &appt)
+	if h.rejectService(c, err) {
+		return
+	}
 	if err != nil {
 		c.Error(fmt.Errorf("insert error: %w", err))
 		return
 	}
+	if credits > 0 {
+		appt.PaidWithCredits = h.applyCredits(c, appt.ID)
+	}
 
 	h.Logger.Info("Created appointment", zap.Int("appointment_id", appt.ID))
 	c.JSON(http.StatusCreated, appt)
 }
 
 func (h *AppointmentHandler) UpdateAppointment(c *gin.Context) {
-	userIDIfc, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
-	}
-	userID, ok := userIDIfc.(string)
+	principal, ok := CurrentPrincipal(c)
 	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid user id type"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
@@ -126,15 +154,19 @@ func (h *AppointmentHandler) UpdateAppointment(c *gin.Context) {
 		return
 	}
 
-	var ownerID int
-	if err := h.Repo.GetAppointmentOwner(c.Request.Context(), id, &ownerID); err != nil {
+	current, err := h.Repo.GetByID(c.Request.Context(), id)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
 		return
 	}
-	if strconv.Itoa(ownerID) != userID {
+	if !canModifyAppointment(principal, current) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "You can only modify your own appointments"})
 		return
 	}
+	if current.Status == "completed" || current.Status == "canceled" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Completed and canceled appointments cannot be changed"})
+		return
+	}
 
 	var appt models.Appointment
 	if err := c.ShouldBindJSON(&appt); err != nil {
@@ -146,16 +178,58 @@ func (h *AppointmentHandler) UpdateAppointment(c *gin.Context) {
 		c.Error(fmt.Errorf("validation error: %w", err))
 		return
 	}
-	
+
+	if appt.Status == "" {
+		appt.Status = current.Status
+	}
+	if !h.checkStatusChange(c, principal, current, appt.Status) {
+		return
+	}
+
+	if pricingChanged(current, &appt) {
+		paid, err := h.Repo.HasPayments(c.Request.Context(), id)
+		if err != nil {
+			c.Error(fmt.Errorf("appointment payments: %w", err))
+			return
+		}
+		if paid {
+			c.JSON(http.StatusConflict, gin.H{"error": "The service and add-ons cannot be changed once the appointment has been paid for"})
+			return
+		}
+	}
+
+	if appt.MasseurID != current.MasseurID && !h.checkMasseurBookable(c, appt.MasseurID) {
+		return
+	}
+
+	appt.ClientID = current.ClientID
 	appt.UpdatedAt = time.Now()
 
-	err = h.Repo.Update(c.Request.Context(), id, &appt)
+	// The update only applies if the status is still the one checked above,
+	// so concurrent updates cannot both settle the appointment.
+	err = h.Repo.Update(c.Request.Context(), id, current.Status, &appt)
+	if errors.Is(err, db.ErrConflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": "The appointment was changed by someone else; reload it and try again"})
+		return
+	}
+	if h.rejectService(c, err) {
+		return
+	}
 	if err != nil {
 		c.Error(fmt.Errorf("update error: %w", err))
 		return
 	}
 
 	appt.ID = id
+	if appt.Status != current.Status {
+		// The appointment change stands even if settling fails; held payments
+		// can still be captured or voided through the payment endpoints.
+		if err := h.Payments.SettleAppointment(c.Request.Context(), id, appt.Status); err != nil {
+			h.Logger.Error("Failed to settle appointment payments", zap.Int("appointment_id", id), zap.String("status", appt.Status), zap.Error(err))
+		}
+		h.settleCredits(c, id, appt.Status)
+	}
+
 	h.Logger.Info("Updated appointment", zap.Int("appointment_id", appt.ID))
 	c.JSON(http.StatusOK, appt)
 }
@@ -168,6 +242,25 @@ func (h *AppointmentHandler) DeleteAppointment(c *gin.Context) {
 		return
 	}
 
+	current, err := h.Repo.GetByID(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
+		return
+	}
+
+	// Release held payments and credits of an open appointment first; once
+	// it is gone nothing would. Those of a completed one were used.
+	if current.Status != "completed" {
+		if err := h.Payments.SettleAppointment(c.Request.Context(), id, "canceled"); err != nil {
+			c.Error(fmt.Errorf("void payments: %w", err))
+			return
+		}
+		if _, err := h.Credits.Restore(c.Request.Context(), id); err != nil {
+			c.Error(fmt.Errorf("restore credits: %w", err))
+			return
+		}
+	}
+
 	err = h.Repo.Delete(c.Request.Context(), id)
 	if err != nil {
 		c.Error(fmt.Errorf("delete error: %w", err))
@@ -176,4 +269,228 @@ func (h *AppointmentHandler) DeleteAppointment(c *gin.Context) {
 
 	h.Logger.Info("Deleted appointment", zap.Int("appointment_id", id))
 	c.JSON(http.StatusOK, gin.H{"message": "Appointment deleted successfully"})
+}
+
+// SetAppointmentDiscount sets the discount staff grant on an appointment.
+// Like the service and add-ons it is part of the price, which is fixed once
+// the appointment has been paid for.
+func (h *AppointmentHandler) SetAppointmentDiscount(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid appointment ID"})
+		return
+	}
+
+	var request struct {
+		DiscountPercent *float64 `json:"discountPercent" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if *request.DiscountPercent < 0 || *request.DiscountPercent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "discountPercent must be between 0 and 100"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	paid, err := h.Repo.HasPayments(ctx, id)
+	if err != nil {
+		c.Error(fmt.Errorf("appointment payments: %w", err))
+		return
+	}
+	if paid {
+		c.JSON(http.StatusConflict, gin.H{"error": "The discount cannot be changed once the appointment has been paid for"})
+		return
+	}
+
+	err = h.Repo.SetDiscount(ctx, id, *request.DiscountPercent)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("set discount: %w", err))
+		return
+	}
+
+	h.Logger.Info("Set appointment discount", zap.Int("appointment_id", id), zap.Float64("discount_percent", *request.DiscountPercent))
+	c.JSON(http.StatusOK, gin.H{"id": id, "discountPercent": *request.DiscountPercent})
+}
+
+// pricingChanged reports whether an update changes what an appointment is
+// priced from. Add-ons left out of the update are kept.
+func pricingChanged(current, updated *models.Appointment) bool {
+	if (current.ServiceID == nil) != (updated.ServiceID == nil) ||
+		(current.ServiceID != nil && *current.ServiceID != *updated.ServiceID) {
+		return true
+	}
+	if updated.AddOnIDs == nil {
+		return false
+	}
+	return !slices.Equal(current.AddOnIDs, sortedIDs(updated.AddOnIDs))
+}
+
+func sortedIDs(ids []int) []int {
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	return slices.Compact(sorted)
+}
+
+// defaultCancellationNotice is how long before an appointment clients can
+// still cancel it when the organization does not configure a notice period.
+const defaultCancellationNotice = 24 * time.Hour
+
+// canModifyAppointment reports whether the caller may update an appointment:
+// its client, its masseur, admins and service callers.
+func canModifyAppointment(principal *Principal, appt *models.Appointment) bool {
+	switch {
+	case principal.IsService(), principal.Role == "admin":
+		return true
+	case principal.Role == "masseur":
+		return appt.MasseurID == principal.UserID
+	default:
+		return appt.ClientID == principal.UserID
+	}
+}
+
+// checkStatusChange enforces which status changes an update may make and who
+// may make them. Staff confirm pending appointments and complete or cancel
+// any open one; clients can only cancel, and only up to the organization's
+// notice period before the start. The payment statuses are set by the
+// payment webhooks alone. On failure it writes the response and returns
+// false.
+func (h *AppointmentHandler) checkStatusChange(c *gin.Context, principal *Principal, current *models.Appointment, status string) bool {
+	if status == current.Status {
+		return true
+	}
+	staff := principal.IsService() || principal.Role == "admin" || principal.Role == "masseur"
+
+	switch status {
+	case "confirmed":
+		if current.Status != "pending" {
+			c.JSON(http.StatusConflict, gin.H{"error": "Only pending appointments can be confirmed"})
+			return false
+		}
+		fallthrough
+	case "completed":
+		if !staff {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only staff can confirm or complete appointments"})
+			return false
+		}
+	case "canceled":
+		if staff {
+			return true
+		}
+		notice := cancellationNotice(c)
+		start, err := appointmentStart(current, organizationLocation(c))
+		if err != nil {
+			c.Error(fmt.Errorf("appointment start: %w", err))
+			return false
+		}
+		if time.Now().After(start.Add(-notice)) {
+			c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Appointments can only be canceled up to %g hours before they start", notice.Hours())})
+			return false
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Status cannot be set to %q", status)})
+		return false
+	}
+	return true
+}
+
+// cancellationNotice returns how long before an appointment clients can
+// still cancel it.
+func cancellationNotice(c *gin.Context) time.Duration {
+	if org := currentOrganization(c); org != nil && org.Settings.CancellationNoticeHours > 0 {
+		return time.Duration(org.Settings.CancellationNoticeHours) * time.Hour
+	}
+	return defaultCancellationNotice
+}
+
+// appointmentStart combines the date and start time of an appointment in the
+// organization's time zone.
+func appointmentStart(appt *models.Appointment, loc *time.Location) (time.Time, error) {
+	clock, err := time.Parse("15:04:05", appt.StartTime)
+	if err != nil {
+		if clock, err = time.Parse("15:04", appt.StartTime); err != nil {
+			return time.Time{}, err
+		}
+	}
+	y, m, d := appt.AppointmentDate.Date()
+	return time.Date(y, m, d, clock.Hour(), clock.Minute(), clock.Second(), 0, loc), nil
+}
+
+// rejectService answers a save the repository refused because of the
+// booked service and reports whether it did.
+func (h *AppointmentHandler) rejectService(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, db.ErrServiceUnavailable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown or inactive service"})
+	case errors.Is(err, db.ErrWrongDuration):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The booked time must last exactly as long as the service"})
+	default:
+		return false
+	}
+	return true
+}
+
+// checkMasseurBookable rejects bookings with masseurs who cannot be paid
+// out yet. On failure it writes the response and returns false.
+func (h *AppointmentHandler) checkMasseurBookable(c *gin.Context, masseurID int) bool {
+	ready, err := h.Repo.IsMasseurPayoutReady(c.Request.Context(), masseurID)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown masseur"})
+		return false
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("masseur payout status: %w", err))
+		return false
+	}
+	if !ready {
+		c.JSON(http.StatusConflict, gin.H{"error": "This masseur is not accepting bookings until payout onboarding is complete"})
+		return false
+	}
+	return true
+}
+
+// applyCredits marks a new appointment as paid with package credits and,
+// unless the organization consumes credits on completion, takes the credit
+// in the same step. Failures leave the appointment to be paid normally.
+func (h *AppointmentHandler) applyCredits(c *gin.Context, appointmentID int) bool {
+	org := currentOrganization(c)
+	consume := org == nil || org.Settings.CreditsConsumedOn != "completion"
+	applied, err := h.Credits.Apply(c.Request.Context(), appointmentID, consume)
+	if err != nil {
+		h.Logger.Error("Failed to apply credits", zap.Int("appointment_id", appointmentID), zap.Error(err))
+		return false
+	}
+	return applied
+}
+
+// settleCredits consumes the credit of an appointment paid with credits when
+// it is completed, and returns it when the appointment is canceled.
+func (h *AppointmentHandler) settleCredits(c *gin.Context, appointmentID int, status string) {
+	ctx := c.Request.Context()
+	switch status {
+	case "completed":
+		err := h.Credits.Consume(ctx, appointmentID)
+		if errors.Is(err, db.ErrNoCredits) {
+			// Not paid with credits, or they ran out or expired before the
+			// session; then the appointment is paid normally.
+			return
+		}
+		if err != nil {
+			h.Logger.Error("Failed to consume credit", zap.Int("appointment_id", appointmentID), zap.Error(err))
+		}
+	case "canceled":
+		restored, err := h.Credits.Restore(ctx, appointmentID)
+		if err != nil {
+			h.Logger.Error("Failed to restore credits", zap.Int("appointment_id", appointmentID), zap.Error(err))
+			return
+		}
+		if restored > 0 {
+			h.Logger.Info("Restored credits of canceled appointment", zap.Int("appointment_id", appointmentID))
+		}
+	}
 }
\ No newline at end of file
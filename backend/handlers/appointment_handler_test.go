@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ozoli99/Harmonia/models"
+)
+
+func TestCheckStatusChange(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	h := &AppointmentHandler{}
+	client := &Principal{UserID: 1, Role: "client"}
+	masseur := &Principal{UserID: 2, Role: "masseur"}
+
+	at := func(status string, in time.Duration) *models.Appointment {
+		start := time.Now().UTC().Add(in)
+		return &models.Appointment{
+			ClientID:        1,
+			MasseurID:       2,
+			Status:          status,
+			AppointmentDate: start,
+			StartTime:       start.Format("15:04:05"),
+		}
+	}
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		current   *models.Appointment
+		status    string
+		notice    int
+		wantCode  int
+	}{
+		{name: "unchanged", principal: client, current: at("pending", time.Hour), status: "pending"},
+		{name: "masseur completes", principal: masseur, current: at("confirmed", -time.Hour), status: "completed"},
+		{name: "client completes", principal: client, current: at("confirmed", -time.Hour), status: "completed", wantCode: http.StatusForbidden},
+		{name: "masseur confirms pending", principal: masseur, current: at("pending", time.Hour), status: "confirmed"},
+		{name: "confirm after payment failed", principal: masseur, current: at("payment_failed", time.Hour), status: "confirmed", wantCode: http.StatusConflict},
+		{name: "client cancels in time", principal: client, current: at("confirmed", 48*time.Hour), status: "canceled"},
+		{name: "client cancels too late", principal: client, current: at("confirmed", 2*time.Hour), status: "canceled", wantCode: http.StatusForbidden},
+		{name: "organization notice period", principal: client, current: at("confirmed", 2*time.Hour), status: "canceled", notice: 1},
+		{name: "masseur cancels late", principal: masseur, current: at("confirmed", time.Hour), status: "canceled"},
+		{name: "payment status", principal: masseur, current: at("pending", time.Hour), status: "pending_payment", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(rec)
+			c.Request = httptest.NewRequest(http.MethodPut, "/", nil)
+			c.Set("organization", &models.Organization{Settings: models.OrganizationSettings{CancellationNoticeHours: tt.notice}})
+
+			ok := h.checkStatusChange(c, tt.principal, tt.current, tt.status)
+			if tt.wantCode == 0 {
+				if !ok {
+					t.Fatalf("rejected: %d %s", rec.Code, rec.Body.String())
+				}
+				return
+			}
+			if ok || rec.Code != tt.wantCode {
+				t.Fatalf("ok = %v, status = %d, want rejection with %d", ok, rec.Code, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCanModifyAppointment(t *testing.T) {
+	appt := &models.Appointment{ClientID: 1, MasseurID: 2}
+	tests := []struct {
+		principal *Principal
+		want      bool
+	}{
+		{&Principal{UserID: 1, Role: "client"}, true},
+		{&Principal{UserID: 3, Role: "client"}, false},
+		{&Principal{UserID: 2, Role: "masseur"}, true},
+		{&Principal{UserID: 4, Role: "masseur"}, false},
+		{&Principal{UserID: 5, Role: "admin"}, true},
+		{&Principal{APIKey: &models.APIKey{}}, true},
+	}
+	for _, tt := range tests {
+		if got := canModifyAppointment(tt.principal, appt); got != tt.want {
+			t.Errorf("canModifyAppointment(%+v) = %v, want %v", tt.principal, got, tt.want)
+		}
+	}
+}
@@ -1,14 +1,22 @@
 package handlers
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/ozoli99/Harmonia/auth"
 	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/middleware"
+	"github.com/ozoli99/Harmonia/models"
 	"go.uber.org/zap"
 
 	"github.com/clerkinc/clerk-sdk-go/clerk"
@@ -16,6 +24,107 @@ import (
 
 var ClerkClient clerk.Client
 
+var errUserLookupFailed = errors.New("user lookup failed")
+
+// Identity is the caller as established by an IdentityProvider.
+type Identity struct {
+	UserID string
+	Email  string
+	Role   string
+	OrgID  string
+	// OrgSlug names the organization by slug when the provider has no
+	// external organization IDs, as with local tokens.
+	OrgSlug string
+}
+
+type IdentityProvider interface {
+	VerifyToken(token string) (*Identity, error)
+	// LookupUser returns the details of a user of the current organization
+	// by their external ID.
+	LookupUser(ctx context.Context, userID string) (email string, role string, err error)
+}
+
+// NewIdentityProvider returns the provider selected by cfg.AuthProvider. The
+// local provider signs its own tokens and is refused in production; it has
+// no user directory of its own and looks users up in users.
+func NewIdentityProvider(cfg *config.Config, users db.UserRepository, logger *zap.Logger) (IdentityProvider, error) {
+	switch cfg.AuthProvider {
+	case "", "clerk":
+		InitializeClerk(cfg, logger)
+		return &clerkIdentityProvider{cfg: cfg}, nil
+	case "local":
+		if cfg.IsProduction() {
+			return nil, errors.New("local auth provider cannot be used in production")
+		}
+		issuer, err := auth.NewLocalIssuer(cfg.LocalAuthSigningKey, cfg.LocalAuthIssuer)
+		if err != nil {
+			return nil, err
+		}
+		logger.Warn("Using local auth provider; tokens are not verified by Clerk")
+		return &localIdentityProvider{issuer: issuer, users: users}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth provider %q", cfg.AuthProvider)
+	}
+}
+
+type clerkIdentityProvider struct {
+	cfg *config.Config
+}
+
+func (p *clerkIdentityProvider) VerifyToken(token string) (*Identity, error) {
+	session, err := ClerkClient.VerifyToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	userID := session.Claims.Subject
+	email, role, err := fetchUserDetails(p.cfg, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUserLookupFailed, err)
+	}
+
+	return &Identity{
+		UserID: userID,
+		Email:  email,
+		Role:   role,
+		OrgID:  session.ActiveOrganizationID,
+	}, nil
+}
+
+func (p *clerkIdentityProvider) LookupUser(ctx context.Context, userID string) (string, string, error) {
+	return fetchUserDetails(p.cfg, userID)
+}
+
+type localIdentityProvider struct {
+	issuer *auth.LocalIssuer
+	users  db.UserRepository
+}
+
+func (p *localIdentityProvider) VerifyToken(token string) (*Identity, error) {
+	claims, err := p.issuer.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Identity{
+		UserID:  claims.Subject,
+		Email:   claims.Email,
+		Role:    claims.Role,
+		OrgID:   claims.OrgID,
+		OrgSlug: claims.OrgSlug,
+	}, nil
+}
+
+// LookupUser finds users in user_profiles, so only users who have already
+// signed in to the organization can be looked up.
+func (p *localIdentityProvider) LookupUser(ctx context.Context, userID string) (string, string, error) {
+	user, err := p.users.GetByExternalID(ctx, userID)
+	if err != nil {
+		return "", "", err
+	}
+	return user.Email, user.Role, nil
+}
+
 func InitializeClerk(cfg *config.Config, logger *zap.Logger) {
 	var err error
 	ClerkClient, err = clerk.NewClient(cfg.ClerkSecretKey)
@@ -24,7 +133,57 @@ func InitializeClerk(cfg *config.Config, logger *zap.Logger) {
 	}
 }
 
-func ClerkMiddleware(cfg *config.Config) gin.HandlerFunc {
+// AuthMiddleware accepts either an API key (X-API-Key header) or a bearer
+// token verified by the configured identity provider.
+func AuthMiddleware(provider IdentityProvider, apiKeys db.APIKeyRepository, limiter *middleware.RateLimiter, logger *zap.Logger) gin.HandlerFunc {
+	tokenAuth := TokenMiddleware(provider)
+	return func(c *gin.Context) {
+		rawKey := c.GetHeader("X-API-Key")
+		if rawKey == "" {
+			tokenAuth(c)
+			return
+		}
+
+		prefix, secret, ok := splitAPIKey(rawKey)
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			c.Abort()
+			return
+		}
+
+		key, err := apiKeys.GetByPrefix(c.Request.Context(), prefix)
+		if err != nil || subtle.ConstantTimeCompare([]byte(key.KeyHash), []byte(hashAPIKey(secret))) != 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
+			c.Abort()
+			return
+		}
+
+		now := time.Now()
+		if !key.Active(now) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "API key revoked or expired"})
+			c.Abort()
+			return
+		}
+
+		if !limiter.Allow(key.Prefix, key.RateLimitPerMinute) {
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Rate limit exceeded"})
+			c.Abort()
+			return
+		}
+
+		if err := apiKeys.TouchLastUsed(c.Request.Context(), key.ID, now); err != nil {
+			logger.Warn("Failed to record API key usage", zap.Int("api_key_id", key.ID), zap.Error(err))
+		}
+
+		c.Set("user_id", fmt.Sprintf("apikey:%d", key.ID))
+		c.Set("user_role", "service")
+		c.Set("api_key", key)
+
+		c.Next()
+	}
+}
+
+func TokenMiddleware(provider IdentityProvider) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -41,25 +200,26 @@ func ClerkMiddleware(cfg *config.Config) gin.HandlerFunc {
 		}
 		token := tokenParts[1]
 
-		session, err := ClerkClient.VerifyToken(token)
+		identity, err := provider.VerifyToken(token)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			if errors.Is(err, errUserLookupFailed) {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to fetch user details"})
+			} else {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			}
 			c.Abort()
 			return
 		}
 
-		userID := session.Claims.Subject
-
-		email, role, err := fetchUserDetails(cfg, userID)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to fetch user details"})
-			c.Abort()
-			return
+		c.Set("user_id", identity.UserID)
+		c.Set("user_email", identity.Email)
+		c.Set("user_role", identity.Role)
+		if identity.OrgID != "" {
+			c.Set("clerk_org_id", identity.OrgID)
+		}
+		if identity.OrgSlug != "" {
+			c.Set("org_slug", identity.OrgSlug)
 		}
-
-		c.Set("user_id", userID)
-		c.Set("user_email", email)
-		c.Set("user_role", role)
 
 		c.Next()
 	}
@@ -140,4 +300,36 @@ func RoleMiddleware(allowedRoles ...string) gin.HandlerFunc {
 		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
 		c.Abort()
 	}
-}
\ No newline at end of file
+}
+
+// RouteScopes maps a route, written as "METHOD /full/path", to the scope an
+// API key needs to call it.
+type RouteScopes map[string]string
+
+// APIKeyScopeMiddleware closes every route to API key callers except those
+// listed in scopes, and those only to keys holding the listed scope. Requests
+// authenticated with a Clerk token are not affected.
+func APIKeyScopeMiddleware(scopes RouteScopes) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		keyIfc, exists := c.Get("api_key")
+		if !exists {
+			c.Next()
+			return
+		}
+
+		scope, listed := scopes[c.Request.Method+" "+c.FullPath()]
+		if !listed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Endpoint is not available to API keys"})
+			c.Abort()
+			return
+		}
+		key, ok := keyIfc.(*models.APIKey)
+		if !ok || !key.HasScope(scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "API key lacks required scope"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v3/jwt"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/auth"
+	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/middleware"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+const testSigningKey = "0123456789abcdef0123456789abcdef"
+
+// TestLocalTokenEndToEnd sends locally issued tokens through the same
+// middleware chain as the API and checks who the request runs as.
+func TestLocalTokenEndToEnd(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{
+		Environment:             "development",
+		AuthProvider:            "local",
+		LocalAuthSigningKey:     testSigningKey,
+		TenantBaseDomain:        "harmonia.test",
+		DefaultOrganizationSlug: "spa",
+	}
+	orgs := &stubOrganizations{orgs: []models.Organization{
+		{ID: 1, Slug: "spa"},
+		{ID: 2, Slug: "clinic"},
+	}}
+	users := &stubUsers{users: []models.User{
+		{ID: 10, OrganizationID: 1, ExternalID: "user_a", Role: "admin"},
+		{ID: 11, OrganizationID: 2, ExternalID: "user_a", Role: "client"},
+		{ID: 12, OrganizationID: 1, ExternalID: "user_b", Role: "client"},
+	}}
+
+	logger := zap.NewNop()
+	provider, err := NewIdentityProvider(cfg, users, logger)
+	if err != nil {
+		t.Fatalf("NewIdentityProvider: %v", err)
+	}
+
+	router := gin.New()
+	router.Use(
+		AuthMiddleware(provider, nil, middleware.NewRateLimiter(time.Minute), logger),
+		TenantMiddleware(cfg, orgs, users, logger),
+		IdentityMiddleware(users, logger),
+	)
+	router.GET("/whoami", func(c *gin.Context) {
+		principal, _ := CurrentPrincipal(c)
+		c.JSON(http.StatusOK, gin.H{"org": currentOrganization(c).Slug, "userId": principal.UserID, "role": principal.Role})
+	})
+
+	issuer, err := auth.NewLocalIssuer(testSigningKey, cfg.LocalAuthIssuer)
+	if err != nil {
+		t.Fatalf("NewLocalIssuer: %v", err)
+	}
+	otherIssuer, err := auth.NewLocalIssuer(strings.Repeat("x", 32), cfg.LocalAuthIssuer)
+	if err != nil {
+		t.Fatalf("NewLocalIssuer: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		issuer   *auth.LocalIssuer
+		claims   auth.Claims
+		host     string
+		wantCode int
+		wantOrg  string
+		wantUser int
+		wantRole string
+	}{
+		{
+			name:     "org slug claim",
+			claims:   auth.Claims{Claims: jwt.Claims{Subject: "user_a"}, Role: "admin", OrgSlug: "spa"},
+			wantCode: http.StatusOK, wantOrg: "spa", wantUser: 10, wantRole: "admin",
+		},
+		{
+			name:     "role comes from the membership, not the token",
+			claims:   auth.Claims{Claims: jwt.Claims{Subject: "user_a"}, Role: "admin", OrgSlug: "clinic"},
+			wantCode: http.StatusOK, wantOrg: "clinic", wantUser: 11, wantRole: "client",
+		},
+		{
+			name:     "default organization",
+			claims:   auth.Claims{Claims: jwt.Claims{Subject: "user_b"}, Role: "client"},
+			wantCode: http.StatusOK, wantOrg: "spa", wantUser: 12, wantRole: "client",
+		},
+		{
+			name:     "org slug claim of another organization",
+			claims:   auth.Claims{Claims: jwt.Claims{Subject: "user_b"}, Role: "admin", OrgSlug: "clinic"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "subdomain of another organization",
+			claims:   auth.Claims{Claims: jwt.Claims{Subject: "user_b"}, Role: "admin"},
+			host:     "clinic.harmonia.test",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "unknown organization",
+			claims:   auth.Claims{Claims: jwt.Claims{Subject: "user_a"}, OrgSlug: "nowhere"},
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "token signed with another key",
+			issuer:   otherIssuer,
+			claims:   auth.Claims{Claims: jwt.Claims{Subject: "user_a"}, OrgSlug: "spa"},
+			wantCode: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer := issuer
+			if tt.issuer != nil {
+				signer = tt.issuer
+			}
+			token, err := signer.Issue(tt.claims, time.Minute)
+			if err != nil {
+				t.Fatalf("Issue: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			if tt.host != "" {
+				req.Host = tt.host
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+
+			var got struct {
+				Org    string `json:"org"`
+				UserID int    `json:"userId"`
+				Role   string `json:"role"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if got.Org != tt.wantOrg || got.UserID != tt.wantUser || got.Role != tt.wantRole {
+				t.Errorf("got org %q user %d role %q, want org %q user %d role %q", got.Org, got.UserID, got.Role, tt.wantOrg, tt.wantUser, tt.wantRole)
+			}
+		})
+	}
+}
+
+func TestAPIKeyScopeMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		if scopes := c.GetHeader("X-Test-Scopes"); scopes != "" {
+			c.Set("api_key", &models.APIKey{Scopes: strings.Split(scopes, ",")})
+		}
+	}, APIKeyScopeMiddleware(RouteScopes{"GET /payments": "payments:read"}))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	router.GET("/payments", ok)
+	router.GET("/services", ok)
+
+	tests := []struct {
+		name     string
+		path     string
+		scopes   string
+		wantCode int
+	}{
+		{name: "user on a listed route", path: "/payments", wantCode: http.StatusOK},
+		{name: "user on an unlisted route", path: "/services", wantCode: http.StatusOK},
+		{name: "key with the scope", path: "/payments", scopes: "payments:read", wantCode: http.StatusOK},
+		{name: "key with every scope", path: "/payments", scopes: "*", wantCode: http.StatusOK},
+		{name: "key without the scope", path: "/payments", scopes: "appointments:read", wantCode: http.StatusForbidden},
+		{name: "key on an unlisted route", path: "/services", scopes: "*", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.scopes != "" {
+				req.Header.Set("X-Test-Scopes", tt.scopes)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.wantCode, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+const clerkWebhookTolerance = 5 * time.Minute
+
+type ClerkWebhookHandler struct {
+	Users         db.UserRepository
+	Organizations db.OrganizationRepository
+	Config        *config.Config
+	Logger        *zap.Logger
+}
+
+type clerkUserData struct {
+	ID             string `json:"id"`
+	EmailAddresses []struct {
+		EmailAddress string `json:"email_address"`
+	} `json:"email_addresses"`
+	PublicMetadata struct {
+		Role string `json:"role"`
+	} `json:"public_metadata"`
+}
+
+type clerkMembershipData struct {
+	Organization struct {
+		ID string `json:"id"`
+	} `json:"organization"`
+	PublicUserData struct {
+		UserID     string `json:"user_id"`
+		Identifier string `json:"identifier"`
+	} `json:"public_user_data"`
+}
+
+func NewClerkWebhookHandler(users db.UserRepository, orgs db.OrganizationRepository, cfg *config.Config, logger *zap.Logger) *ClerkWebhookHandler {
+	return &ClerkWebhookHandler{
+		Users:         users,
+		Organizations: orgs,
+		Config:        cfg,
+		Logger:        logger,
+	}
+}
+
+// HandleClerkWebhook keeps user_profiles in sync with Clerk so users exist
+// before their first API call.
+func (h *ClerkWebhookHandler) HandleClerkWebhook(c *gin.Context) {
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	if err := verifySvixSignature(h.Config.ClerkWebhookSecret, c.Request.Header, payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+
+	var event struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &event); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+		return
+	}
+
+	switch event.Type {
+	case "user.created", "user.updated":
+		var user clerkUserData
+		if err := json.Unmarshal(event.Data, &user); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user payload"})
+			return
+		}
+		err = h.syncUser(c, event.Type == "user.created", user)
+
+	case "organizationMembership.created":
+		var membership clerkMembershipData
+		if err := json.Unmarshal(event.Data, &membership); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid membership payload"})
+			return
+		}
+		err = h.syncMembership(c, membership)
+	}
+
+	if err != nil {
+		h.Logger.Error("Failed to process Clerk webhook", zap.String("type", event.Type), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process webhook"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "success"})
+}
+
+func (h *ClerkWebhookHandler) syncUser(c *gin.Context, created bool, data clerkUserData) error {
+	email := ""
+	if len(data.EmailAddresses) > 0 {
+		email = data.EmailAddresses[0].EmailAddress
+	}
+
+	// Roles belong to a membership of one organization, so an update only
+	// refreshes the email; Clerk's global role merely seeds the first one.
+	ctx := c.Request.Context()
+	if !created || h.Config.DefaultOrganizationSlug == "" {
+		return h.Users.UpdateEmailByExternalID(ctx, data.ID, email)
+	}
+	role := data.PublicMetadata.Role
+	if role == "" {
+		role = "client"
+	}
+
+	org, err := h.Organizations.GetBySlug(ctx, h.Config.DefaultOrganizationSlug)
+	if err != nil {
+		return err
+	}
+	user := models.User{ExternalID: data.ID, Email: email, Role: role}
+	if err := h.Users.Upsert(db.WithTenant(ctx, org.ID), &user); err != nil {
+		return err
+	}
+	h.Logger.Info("Created user from Clerk webhook", zap.Int("user_id", user.ID), zap.String("external_id", data.ID))
+	return nil
+}
+
+func (h *ClerkWebhookHandler) syncMembership(c *gin.Context, data clerkMembershipData) error {
+	ctx := c.Request.Context()
+	org, err := h.Organizations.GetByClerkOrgID(ctx, data.Organization.ID)
+	if errors.Is(err, db.ErrNotFound) {
+		h.Logger.Info("Ignoring membership for unknown organization", zap.String("clerk_org_id", data.Organization.ID))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	tenantCtx := db.WithTenant(ctx, org.ID)
+	if _, err := h.Users.GetByExternalID(tenantCtx, data.PublicUserData.UserID); err == nil {
+		return nil
+	} else if !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+
+	user := models.User{ExternalID: data.PublicUserData.UserID, Email: data.PublicUserData.Identifier, Role: "client"}
+	return h.Users.Upsert(tenantCtx, &user)
+}
+
+// verifySvixSignature checks the Svix signature Clerk attaches to webhooks:
+// an HMAC-SHA256 over "<id>.<timestamp>.<body>" keyed with the base64 part of
+// the "whsec_" secret.
+func verifySvixSignature(secret string, header http.Header, payload []byte) error {
+	if secret == "" {
+		return errors.New("webhook secret not configured")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+	if err != nil {
+		return err
+	}
+
+	msgID := header.Get("svix-id")
+	timestamp := header.Get("svix-timestamp")
+	signatures := header.Get("svix-signature")
+	if msgID == "" || timestamp == "" || signatures == "" {
+		return errors.New("missing signature headers")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return err
+	}
+	if delta := time.Since(time.Unix(ts, 0)); delta > clerkWebhookTolerance || delta < -clerkWebhookTolerance {
+		return errors.New("timestamp outside tolerance")
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msgID + "." + timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+
+	for _, sig := range strings.Fields(signatures) {
+		version, value, found := strings.Cut(sig, ",")
+		if !found || version != "v1" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err == nil && hmac.Equal(decoded, expected) {
+			return nil
+		}
+	}
+	return errors.New("no matching signature")
+}
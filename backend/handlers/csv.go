@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ozoli99/Harmonia/currency"
+)
+
+// writeCSV sends rows in the format of the frontend's exportToCsv: a plain
+// header line followed by rows with every value double-quoted.
+func writeCSV(c *gin.Context, filename string, header []string, rows [][]string) {
+	var b strings.Builder
+	b.WriteString(strings.Join(header, ","))
+	for _, row := range rows {
+		b.WriteByte('\n')
+		for i, value := range row {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(`"` + strings.ReplaceAll(value, `"`, `""`) + `"`)
+		}
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	c.Data(http.StatusOK, "text/csv", []byte(b.String()))
+}
+
+// formatAmount renders an amount in minor units of code as a decimal
+// string.
+func formatAmount(amount int64, code string) string {
+	return currency.Format(amount, code)
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ozoli99/Harmonia/currency"
+)
+
+// chargeCurrency normalizes *code, defaulting to the organization's
+// currency, and checks that it is supported and that amounts can be charged
+// in it. On failure it writes the response and returns false.
+func chargeCurrency(c *gin.Context, code *string, amounts ...int64) bool {
+	*code = currency.Normalize(*code)
+	if *code == "" {
+		if org := currentOrganization(c); org != nil {
+			*code = currency.Normalize(org.Settings.Currency)
+		}
+	}
+	if *code == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currency is required"})
+		return false
+	}
+	if !currency.Supported(*code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency", "supported": currency.Codes()})
+		return false
+	}
+	for _, amount := range amounts {
+		if err := currency.Validate(*code, amount); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return false
+		}
+	}
+	return true
+}
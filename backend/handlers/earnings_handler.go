@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+)
+
+// Balance transaction types that move appointment money onto or off a
+// connected account, and those that pay it out to the masseur's bank.
+var (
+	transferTransactionTypes = map[string]bool{"payment": true, "payment_refund": true, "payment_reversal": true, "transfer": true, "transfer_refund": true}
+	payoutTransactionTypes   = map[string]bool{"payout": true, "payout_cancel": true, "payout_failure": true}
+)
+
+type EarningsHandler struct {
+	Repo     db.EarningsRepository
+	Users    db.UserRepository
+	Payments payments.Adapter
+	Logger   *zap.Logger
+}
+
+func NewEarningsHandler(repo db.EarningsRepository, users db.UserRepository, adapter payments.Adapter, logger *zap.Logger) *EarningsHandler {
+	return &EarningsHandler{
+		Repo:     repo,
+		Users:    users,
+		Payments: adapter,
+		Logger:   logger,
+	}
+}
+
+// GetMyEarnings reports the calling masseur's earnings over a period,
+// reconciled against their connected account when they have one. With
+// format=csv it exports the individual payments.
+func (h *EarningsHandler) GetMyEarnings(c *gin.Context) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	from, to, ok := reportPeriod(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	lines, err := h.Repo.Lines(ctx, principal.UserID, from, to)
+	if err != nil {
+		c.Error(fmt.Errorf("earnings lines: %w", err))
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := make([][]string, 0, len(lines))
+		for _, line := range lines {
+			rows = append(rows, []string{
+				strconv.Itoa(line.PaymentID),
+				line.PaidAt.Format(time.RFC3339),
+				line.AppointmentDate.Format("2006-01-02"),
+				line.Kind,
+				line.Status,
+				line.Currency,
+				formatAmount(line.Gross, line.Currency),
+				formatAmount(line.PlatformFee, line.Currency),
+				formatAmount(line.Refunded, line.Currency),
+				formatAmount(line.Net, line.Currency),
+			})
+		}
+		writeCSV(c, "earnings.csv",
+			[]string{"Payment ID", "Paid At", "Appointment Date", "Kind", "Status", "Currency", "Gross", "Platform Fee", "Refunded", "Net"},
+			rows)
+		return
+	}
+
+	summaries, err := h.Repo.Summaries(ctx, map[string]string{"masseur_id": strconv.Itoa(principal.UserID)}, from, to)
+	if err != nil {
+		c.Error(fmt.Errorf("earnings summaries: %w", err))
+		return
+	}
+
+	response := gin.H{
+		"from":      from,
+		"to":        to,
+		"summaries": summaries,
+		"payments":  lines,
+	}
+
+	user, err := h.Users.GetByID(ctx, principal.UserID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		c.Error(fmt.Errorf("load user: %w", err))
+		return
+	}
+	if user != nil && user.StripeAccountID != nil {
+		transactions, err := h.Payments.ListBalanceTransactions(ctx, *user.StripeAccountID, from, to)
+		if err != nil {
+			h.Logger.Warn("Failed to load balance transactions", zap.String("account_id", *user.StripeAccountID), zap.Error(err))
+			response["reconciliation_error"] = "Payout data is currently unavailable"
+		} else {
+			response["reconciliation"] = reconcileEarnings(*user.StripeAccountID, lines, transactions)
+		}
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ListEarnings reports earnings of every masseur, or of masseur_id, over a
+// period. With format=csv it exports the summaries.
+func (h *EarningsHandler) ListEarnings(c *gin.Context) {
+	from, to, ok := reportPeriod(c)
+	if !ok {
+		return
+	}
+
+	summaries, err := h.Repo.Summaries(c.Request.Context(), map[string]string{"masseur_id": c.Query("masseur_id")}, from, to)
+	if err != nil {
+		c.Error(fmt.Errorf("earnings summaries: %w", err))
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := make([][]string, 0, len(summaries))
+		for _, s := range summaries {
+			rows = append(rows, []string{
+				strconv.Itoa(s.MasseurID),
+				s.MasseurEmail,
+				s.Currency,
+				strconv.Itoa(s.Payments),
+				formatAmount(s.Gross, s.Currency),
+				formatAmount(s.Tips, s.Currency),
+				formatAmount(s.PlatformFees, s.Currency),
+				formatAmount(s.Refunds, s.Currency),
+				formatAmount(s.Net, s.Currency),
+			})
+		}
+		writeCSV(c, "earnings.csv",
+			[]string{"Masseur ID", "Masseur", "Currency", "Payments", "Gross", "Tips", "Platform Fees", "Refunds", "Net"},
+			rows)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"from": from, "to": to, "summaries": summaries, "totals": totalEarnings(summaries)})
+}
+
+// totalEarnings adds up summaries per currency, in the order the currencies
+// first appear.
+func totalEarnings(summaries []models.EarningsSummary) []models.EarningsTotal {
+	totals := []models.EarningsTotal{}
+	index := make(map[string]int)
+	for _, s := range summaries {
+		i, ok := index[s.Currency]
+		if !ok {
+			i = len(totals)
+			index[s.Currency] = i
+			totals = append(totals, models.EarningsTotal{Currency: s.Currency})
+		}
+		t := &totals[i]
+		t.Masseurs++
+		t.Payments += s.Payments
+		t.Gross += s.Gross
+		t.Tips += s.Tips
+		t.PlatformFees += s.PlatformFees
+		t.Refunds += s.Refunds
+		t.Net += s.Net
+	}
+	return totals
+}
+
+// reconcileEarnings compares, per currency, the net of payments routed to
+// accountID with what the provider moved onto that account, and totals the
+// payouts made from it.
+func reconcileEarnings(accountID string, lines []models.EarningsLine, transactions []payments.BalanceTransaction) []models.PayoutReconciliation {
+	byCurrency := make(map[string]*models.PayoutReconciliation)
+	var currencies []string
+	get := func(currency string) *models.PayoutReconciliation {
+		r, ok := byCurrency[currency]
+		if !ok {
+			r = &models.PayoutReconciliation{Currency: currency}
+			byCurrency[currency] = r
+			currencies = append(currencies, currency)
+		}
+		return r
+	}
+
+	for _, line := range lines {
+		if line.DestinationAccount == accountID {
+			get(line.Currency).Expected += line.Net
+		}
+	}
+	for _, tx := range transactions {
+		switch {
+		case transferTransactionTypes[tx.Type]:
+			get(tx.Currency).Transferred += tx.Amount
+		case payoutTransactionTypes[tx.Type]:
+			get(tx.Currency).Payouts -= tx.Amount
+		}
+	}
+
+	result := make([]models.PayoutReconciliation, 0, len(currencies))
+	for _, currency := range currencies {
+		r := byCurrency[currency]
+		r.Difference = r.Transferred - r.Expected
+		r.Reconciled = r.Difference == 0
+		result = append(result, *r)
+	}
+	return result
+}
+
+// reportPeriod reads the inclusive from and to dates (YYYY-MM-DD) of a
+// report in the organization's timezone and returns them as a half-open
+// interval. It defaults to the current month. On failure it writes the
+// response and returns false.
+func reportPeriod(c *gin.Context) (time.Time, time.Time, bool) {
+	loc := organizationLocation(c)
+	now := time.Now().In(loc)
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, loc)
+	to := from.AddDate(0, 1, 0)
+
+	if v := c.Query("from"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date (YYYY-MM-DD)"})
+			return time.Time{}, time.Time{}, false
+		}
+		from = t
+	}
+	if v := c.Query("to"); v != "" {
+		t, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a date (YYYY-MM-DD)"})
+			return time.Time{}, time.Time{}, false
+		}
+		to = t.AddDate(0, 0, 1)
+	}
+	if !to.After(from) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "to must not be before from"})
+		return time.Time{}, time.Time{}, false
+	}
+	return from, to, true
+}
+
+// organizationLocation is the time zone of the caller's organization, UTC
+// when it has none or it is unknown.
+func organizationLocation(c *gin.Context) *time.Location {
+	if org := currentOrganization(c); org != nil && org.Settings.Timezone != "" {
+		if loc, err := time.LoadLocation(org.Settings.Timezone); err == nil {
+			return loc
+		}
+	}
+	return time.UTC
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/fees"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+type FeePolicyHandler struct {
+	Repo      db.FeePolicyRepository
+	Validator *validator.Validate
+	Logger    *zap.Logger
+}
+
+func NewFeePolicyHandler(repo db.FeePolicyRepository, logger *zap.Logger) *FeePolicyHandler {
+	return &FeePolicyHandler{
+		Repo:      repo,
+		Validator: validator.New(),
+		Logger:    logger,
+	}
+}
+
+func (h *FeePolicyHandler) ListFeePolicies(c *gin.Context) {
+	policies, err := h.Repo.List(c.Request.Context())
+	if err != nil {
+		h.Logger.Error("Failed to list fee policies", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list fee policies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, policies)
+}
+
+// SaveFeePolicy creates or replaces the policy for the scope and target in
+// the body.
+func (h *FeePolicyHandler) SaveFeePolicy(c *gin.Context) {
+	var policy models.FeePolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.Error(fmt.Errorf("invalid JSON: %w", err))
+		return
+	}
+
+	if err := h.Validator.Struct(policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := fees.Validate(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.Repo.Save(c.Request.Context(), &policy)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown masseur"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("save fee policy: %w", err))
+		return
+	}
+
+	h.Logger.Info("Saved fee policy", zap.Int("fee_policy_id", policy.ID), zap.String("scope", policy.Scope))
+	c.JSON(http.StatusOK, policy)
+}
+
+func (h *FeePolicyHandler) DeleteFeePolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid fee policy ID"})
+		return
+	}
+
+	err = h.Repo.Delete(c.Request.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Fee policy not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("delete fee policy: %w", err))
+		return
+	}
+
+	h.Logger.Info("Deleted fee policy", zap.Int("fee_policy_id", id))
+	c.JSON(http.StatusOK, gin.H{"message": "Fee policy deleted successfully"})
+}
@@ -0,0 +1,430 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/currency"
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/fees"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+	"github.com/ozoli99/Harmonia/webhooks"
+)
+
+// giftCardAlphabet leaves out characters that are easily confused when a
+// code is read out or typed in. Its 32 characters divide a byte evenly.
+const giftCardAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GiftCardHandler sells and issues gift cards and manages their balances.
+// Redeeming them is part of checkout, in PaymentHandler.
+type GiftCardHandler struct {
+	GiftCards db.GiftCardRepository
+	Fees      db.FeePolicyRepository
+	Payments  payments.Adapter
+	Validator *validator.Validate
+	Logger    *zap.Logger
+}
+
+func NewGiftCardHandler(giftCards db.GiftCardRepository, feePolicies db.FeePolicyRepository, adapter payments.Adapter, logger *zap.Logger) *GiftCardHandler {
+	return &GiftCardHandler{
+		GiftCards: giftCards,
+		Fees:      feePolicies,
+		Payments:  adapter,
+		Validator: validator.New(),
+		Logger:    logger,
+	}
+}
+
+type giftCardRequest struct {
+	Amount         int64      `json:"amount" validate:"required,gt=0"`
+	Currency       string     `json:"currency" validate:"required,len=3"`
+	RecipientEmail string     `json:"recipient_email" validate:"omitempty,email"`
+	ExpiresAt      *time.Time `json:"expires_at"`
+}
+
+// PurchaseGiftCard starts the payment of a gift card. The card can be used
+// once the provider reports the payment succeeded; its code is only
+// returned here.
+func (h *GiftCardHandler) PurchaseGiftCard(c *gin.Context) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	org := currentOrganization(c)
+	if org == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
+		return
+	}
+
+	var request giftCardRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Only staff decide when a card expires.
+	request.ExpiresAt = nil
+	if !h.validate(c, &request) {
+		return
+	}
+	// Gift cards are sold by the organization rather than a masseur.
+	if org.StripeAccountID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not onboarded with Stripe"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	policy, err := h.Fees.Resolve(ctx, 0, principal.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		policy, err = fees.Fallback(org), nil
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("resolve fee policy: %w", err))
+		return
+	}
+	applicationFee := fees.Calculate(policy, request.Amount)
+
+	code, err := generateGiftCardCode()
+	if err != nil {
+		c.Error(fmt.Errorf("generate gift card code: %w", err))
+		return
+	}
+
+	intent, err := h.Payments.CreatePaymentIntent(ctx, payments.PaymentIntentRequest{
+		Amount:         request.Amount,
+		Currency:       request.Currency,
+		ApplicationFee: applicationFee,
+		Destination:    org.StripeAccountID,
+		Metadata: map[string]string{
+			"organization_id": strconv.Itoa(org.ID),
+			"client_id":       strconv.Itoa(principal.UserID),
+			"kind":            "gift_card",
+		},
+		IdempotencyKey: providerIdempotencyKey(c, "gift_card"),
+	})
+	if err != nil {
+		h.Logger.Error("Payment provider error", zap.String("provider", h.Payments.Name()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment intent"})
+		return
+	}
+
+	card := newGiftCard(code, &request)
+	card.PurchaserID = &principal.UserID
+	card.Provider = h.Payments.Name()
+	card.ProviderPaymentID = &intent.ID
+	card.ApplicationFee = applicationFee
+	err = h.GiftCards.CreatePurchase(ctx, card)
+	if errors.Is(err, db.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "This gift card purchase was already started"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("store gift card purchase: %w", err))
+		return
+	}
+
+	h.Logger.Info("Gift card purchase started", zap.Int("gift_card_id", card.ID))
+	c.JSON(http.StatusOK, gin.H{"client_secret": intent.ClientSecret, "approval_url": intent.ApprovalURL, "code": code, "gift_card": card})
+}
+
+// GetMyGiftCards lists the gift cards the calling client bought.
+func (h *GiftCardHandler) GetMyGiftCards(c *gin.Context) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	cards, err := h.GiftCards.ListPurchased(c.Request.Context(), principal.UserID)
+	if err != nil {
+		c.Error(fmt.Errorf("list gift cards: %w", err))
+		return
+	}
+	c.JSON(http.StatusOK, cards)
+}
+
+// CheckGiftCard reports the balance of the gift card with the code in the
+// body. The code is posted rather than put in the URL so it stays out of
+// request logs.
+func (h *GiftCardHandler) CheckGiftCard(c *gin.Context) {
+	var request struct {
+		Code string `json:"code" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	card, err := h.GiftCards.GetByCodeHash(c.Request.Context(), hashGiftCardCode(request.Code))
+	if errors.Is(err, db.ErrNotFound) || (err == nil && card.Status == "pending") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("load gift card: %w", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"last4":     card.Last4,
+		"balance":   card.Balance,
+		"currency":  card.Currency,
+		"status":    card.Status,
+		"usable":    card.Usable(time.Now()),
+		"expiresAt": card.ExpiresAt,
+	})
+}
+
+// IssueGiftCard creates a funded gift card without a payment, e.g. as a
+// goodwill gesture or for a card sold at the front desk.
+func (h *GiftCardHandler) IssueGiftCard(c *gin.Context) {
+	var request giftCardRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !h.validate(c, &request) {
+		return
+	}
+
+	code, err := generateGiftCardCode()
+	if err != nil {
+		c.Error(fmt.Errorf("generate gift card code: %w", err))
+		return
+	}
+
+	card := newGiftCard(code, &request)
+	if err := h.GiftCards.Issue(c.Request.Context(), card, staffUserID(c)); err != nil {
+		c.Error(fmt.Errorf("issue gift card: %w", err))
+		return
+	}
+
+	h.Logger.Info("Issued gift card", zap.Int("gift_card_id", card.ID), zap.Int64("amount", card.InitialAmount))
+	c.JSON(http.StatusCreated, gin.H{"code": code, "gift_card": card})
+}
+
+func (h *GiftCardHandler) ListGiftCards(c *gin.Context) {
+	cards, err := h.GiftCards.List(c.Request.Context())
+	if err != nil {
+		h.Logger.Error("Failed to list gift cards", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list gift cards"})
+		return
+	}
+
+	c.JSON(http.StatusOK, cards)
+}
+
+// GetGiftCard returns a gift card with its ledger.
+func (h *GiftCardHandler) GetGiftCard(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gift card ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	card, err := h.GiftCards.Get(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("load gift card: %w", err))
+		return
+	}
+	ledger, err := h.GiftCards.Ledger(ctx, id)
+	if err != nil {
+		c.Error(fmt.Errorf("gift card ledger: %w", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"gift_card": card, "ledger": ledger})
+}
+
+// UpdateGiftCardStatus disables a gift card or enables it again.
+func (h *GiftCardHandler) UpdateGiftCardStatus(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gift card ID"})
+		return
+	}
+	var request struct {
+		Status string `json:"status" binding:"required,oneof=active disabled"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = h.GiftCards.SetStatus(c.Request.Context(), id, request.Status)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("update gift card: %w", err))
+		return
+	}
+
+	h.Logger.Info("Updated gift card status", zap.Int("gift_card_id", id), zap.String("status", request.Status))
+	c.JSON(http.StatusOK, gin.H{"id": id, "status": request.Status})
+}
+
+// AdjustGiftCard corrects a gift card's balance by a positive or negative
+// amount, with a note saying why.
+func (h *GiftCardHandler) AdjustGiftCard(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid gift card ID"})
+		return
+	}
+	var request struct {
+		Amount int64  `json:"amount" binding:"required,ne=0"`
+		Note   string `json:"note" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	card, err := h.GiftCards.Get(c.Request.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("load gift card: %w", err))
+		return
+	}
+	if err := currency.Validate(card.Currency, request.Amount); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, err := h.GiftCards.Adjust(c.Request.Context(), id, request.Amount, request.Note, staffUserID(c))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Gift card not found"})
+		return
+	case errors.Is(err, db.ErrInsufficientBalance):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Adjustment would make the balance negative"})
+		return
+	case err != nil:
+		c.Error(fmt.Errorf("adjust gift card: %w", err))
+		return
+	}
+
+	h.Logger.Info("Adjusted gift card", zap.Int("gift_card_id", id), zap.Int64("amount", request.Amount))
+	c.JSON(http.StatusOK, entry)
+}
+
+func (h *GiftCardHandler) validate(c *gin.Context, request *giftCardRequest) bool {
+	if !chargeCurrency(c, &request.Currency, request.Amount) {
+		return false
+	}
+	if err := h.Validator.Struct(request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if request.ExpiresAt != nil && !request.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+		return false
+	}
+	return true
+}
+
+var giftCardPurchaseStatuses = map[string]string{
+	payments.EventPaymentSucceeded: "active",
+	payments.EventPaymentCanceled:  "canceled",
+}
+
+// RegisterWebhookHandlers wires the gift card purchase event handlers into p.
+func (h *GiftCardHandler) RegisterWebhookHandlers(p *webhooks.Processor) {
+	for eventType := range giftCardPurchaseStatuses {
+		p.Handle(eventType, h.handlePurchaseStatus)
+	}
+}
+
+func (h *GiftCardHandler) handlePurchaseStatus(ctx context.Context, event *payments.Event) error {
+	if event.Payment.Metadata["kind"] != "gift_card" {
+		return nil
+	}
+
+	card, err := h.GiftCards.UpdatePurchaseStatus(ctx, event.Payment.PaymentID, giftCardPurchaseStatuses[event.Type])
+	if errors.Is(err, db.ErrNotFound) {
+		h.Logger.Info("Ignoring payment event for settled gift card purchase", zap.String("payment_id", event.Payment.PaymentID), zap.String("event", event.Type))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("update gift card purchase %s: %w", event.Payment.PaymentID, err)
+	}
+
+	h.Logger.Info("Gift card purchase updated", zap.Int("gift_card_id", card.ID), zap.String("status", card.Status))
+	return nil
+}
+
+func newGiftCard(code string, request *giftCardRequest) *models.GiftCard {
+	normalized := normalizeGiftCardCode(code)
+	return &models.GiftCard{
+		CodeHash:       hashGiftCardCode(code),
+		Last4:          normalized[len(normalized)-4:],
+		InitialAmount:  request.Amount,
+		Currency:       request.Currency,
+		RecipientEmail: request.RecipientEmail,
+		ExpiresAt:      request.ExpiresAt,
+	}
+}
+
+// staffUserID is the user behind a request, or nil for API keys.
+func staffUserID(c *gin.Context) *int {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() {
+		return nil
+	}
+	return &principal.UserID
+}
+
+// generateGiftCardCode returns a random code formatted as
+// XXXX-XXXX-XXXX-XXXX.
+func generateGiftCardCode() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	var code strings.Builder
+	for i, b := range buf {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(giftCardAlphabet[int(b)%len(giftCardAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// normalizeGiftCardCode makes codes match however they were typed in.
+func normalizeGiftCardCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(code))
+}
+
+func hashGiftCardCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeGiftCardCode(code)))
+	return hex.EncodeToString(sum[:])
+}
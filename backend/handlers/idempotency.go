@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+const (
+	idempotencyHeader = "Idempotency-Key"
+	idempotencyTTL    = 24 * time.Hour
+	// idempotencyLease is how long a key can stay in progress before it is
+	// assumed abandoned, e.g. by a crashed process, and can be taken again.
+	idempotencyLease = 5 * time.Minute
+)
+
+type capturingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *capturingWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *capturingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// IdempotencyMiddleware makes POST requests carrying an Idempotency-Key
+// header safe to retry. The first response for a key is stored per tenant
+// and caller and replayed for repeats within 24 hours. Failed requests (5xx)
+// are not stored so that they can be retried, and neither are requests that
+// never finish, once idempotencyLease has passed.
+func IdempotencyMiddleware(repo db.IdempotencyRepository, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > 255 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			c.Abort()
+			return
+		}
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request.Method + " " + c.Request.URL.Path + "\n"))
+		hash.Write(body)
+
+		record := &models.IdempotencyRecord{
+			Caller:      c.GetString("user_id"),
+			Key:         key,
+			RequestHash: hex.EncodeToString(hash.Sum(nil)),
+		}
+		existing, err := repo.Begin(c.Request.Context(), record, idempotencyTTL, idempotencyLease)
+		if err != nil {
+			logger.Error("Failed to claim idempotency key", zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process Idempotency-Key"})
+			c.Abort()
+			return
+		}
+
+		if existing != nil {
+			switch {
+			case existing.RequestHash != record.RequestHash:
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used for a different request"})
+			case existing.Status != "completed":
+				c.JSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is still in progress"})
+			default:
+				c.Header("Idempotent-Replayed", "true")
+				c.Data(existing.ResponseStatus, existing.ResponseType, existing.ResponseBody)
+			}
+			c.Abort()
+			return
+		}
+
+		writer := &capturingWriter{ResponseWriter: c.Writer}
+		c.Writer = writer
+
+		c.Next()
+
+		// Errors passed to c.Error are turned into a 500 further up the chain.
+		status := writer.Status()
+		if status >= http.StatusInternalServerError || len(c.Errors) > 0 {
+			if err := repo.Release(c.Request.Context(), record); err != nil {
+				logger.Error("Failed to release idempotency key", zap.Int("idempotency_key_id", record.ID), zap.Error(err))
+			}
+			return
+		}
+
+		if err := repo.Complete(c.Request.Context(), record, status, writer.Header().Get("Content-Type"), writer.body.Bytes()); err != nil {
+			logger.Error("Failed to store idempotent response", zap.Int("idempotency_key_id", record.ID), zap.Error(err))
+		}
+	}
+}
+
+// providerIdempotencyKey derives the key forwarded to the payment provider
+// from the request's Idempotency-Key. It is namespaced by tenant, caller and
+// operation so keys chosen by different clients cannot collide at the
+// provider. It returns "" when the request has no Idempotency-Key.
+func providerIdempotencyKey(c *gin.Context, operation string) string {
+	key := c.GetHeader(idempotencyHeader)
+	if key == "" {
+		return ""
+	}
+	orgID, _ := db.TenantFromContext(c.Request.Context())
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d\x00%s\x00%s", orgID, c.GetString("user_id"), key)))
+	return operation + "-" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+type ImpersonationHandler struct {
+	Audit    db.AuditRepository
+	Identity IdentityProvider
+	Config   *config.Config
+	Logger   *zap.Logger
+}
+
+type impersonationClaims struct {
+	AdminID   string `json:"admin_id"`
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+func NewImpersonationHandler(audit db.AuditRepository, identity IdentityProvider, cfg *config.Config, logger *zap.Logger) *ImpersonationHandler {
+	return &ImpersonationHandler{
+		Audit:    audit,
+		Identity: identity,
+		Config:   cfg,
+		Logger:   logger,
+	}
+}
+
+func (h *ImpersonationHandler) StartImpersonation(c *gin.Context) {
+	var request struct {
+		UserID string `json:"user_id" binding:"required"`
+		Reason string `json:"reason" binding:"required"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if h.Config.ImpersonationSigningKey == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Impersonation is not configured"})
+		return
+	}
+
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() || principal.Impersonating {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can impersonate"})
+		return
+	}
+
+	adminID := principal.ExternalID
+	if adminID == request.UserID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot impersonate yourself"})
+		return
+	}
+
+	email, role, err := h.Identity.LookupUser(c.Request.Context(), request.UserID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+	if role == "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Admins cannot be impersonated"})
+		return
+	}
+
+	ttl := time.Duration(h.Config.ImpersonationTTLMinutes) * time.Minute
+	if ttl <= 0 {
+		ttl = 15 * time.Minute
+	}
+	expiresAt := time.Now().Add(ttl)
+
+	token, err := signImpersonationToken(h.Config.ImpersonationSigningKey, impersonationClaims{
+		AdminID:   adminID,
+		UserID:    request.UserID,
+		Email:     email,
+		Role:      role,
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		h.Logger.Error("Failed to sign impersonation token", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create impersonation token"})
+		return
+	}
+
+	entry := models.ImpersonationAuditEntry{
+		AdminID:      adminID,
+		TargetUserID: request.UserID,
+		Action:       "start",
+		Reason:       request.Reason,
+		CreatedAt:    time.Now(),
+	}
+	if err := h.Audit.RecordImpersonation(c.Request.Context(), &entry); err != nil {
+		h.Logger.Error("Failed to record impersonation start", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record impersonation"})
+		return
+	}
+
+	h.Logger.Info("Impersonation started", zap.String("real_user_id", adminID), zap.String("effective_user_id", request.UserID))
+	c.JSON(http.StatusOK, gin.H{"token": token, "expires_at": expiresAt})
+}
+
+func (h *ImpersonationHandler) ListImpersonationAudit(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	filters := map[string]string{
+		"admin_id":       c.Query("admin_id"),
+		"target_user_id": c.Query("target_user_id"),
+	}
+
+	entries, err := h.Audit.ListImpersonations(c.Request.Context(), filters, limit, offset)
+	if err != nil {
+		h.Logger.Error("Failed to list impersonation audit", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list impersonation audit"})
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// ImpersonationMiddleware swaps the effective identity to the impersonated
+// user when an admin presents a valid X-Impersonation-Token. The admin stays
+// available as real_user_id, and every such request is written to the audit
+// table.
+func ImpersonationMiddleware(cfg *config.Config, audit db.AuditRepository, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := c.GetHeader("X-Impersonation-Token")
+		if token == "" {
+			c.Next()
+			return
+		}
+
+		if c.GetString("user_role") != "admin" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only admins can impersonate"})
+			c.Abort()
+			return
+		}
+
+		claims, err := verifyImpersonationToken(cfg.ImpersonationSigningKey, token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired impersonation token"})
+			c.Abort()
+			return
+		}
+
+		adminID := c.GetString("user_id")
+		if claims.AdminID != adminID {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Impersonation token was issued to another admin"})
+			c.Abort()
+			return
+		}
+
+		entry := models.ImpersonationAuditEntry{
+			AdminID:      adminID,
+			TargetUserID: claims.UserID,
+			Action:       "request",
+			Method:       c.Request.Method,
+			Path:         c.Request.URL.Path,
+			CreatedAt:    time.Now(),
+		}
+
+		c.Set("real_user_id", adminID)
+		c.Set("impersonating", true)
+		c.Set("user_id", claims.UserID)
+		c.Set("user_email", claims.Email)
+		c.Set("user_role", claims.Role)
+
+		c.Next()
+
+		if c.GetBool("impersonation_blocked") {
+			entry.Action = "blocked"
+		}
+		entry.Status = c.Writer.Status()
+		if err := audit.RecordImpersonation(c.Request.Context(), &entry); err != nil {
+			logger.Error("Failed to record impersonation audit", zap.Error(err))
+		}
+	}
+}
+
+// DestructiveActionMiddleware marks a route as one that moves money or
+// deletes data. Impersonating admins are refused on such routes when
+// ImpersonationBlockDestructive is set; ImpersonationMiddleware records the
+// attempt as blocked.
+func DestructiveActionMiddleware(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if cfg.ImpersonationBlockDestructive && c.GetBool("impersonating") {
+			c.Set("impersonation_blocked", true)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Destructive actions are disabled while impersonating"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+func signImpersonationToken(key string, claims impersonationClaims) (string, error) {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(impersonationSignature(key, encoded)), nil
+}
+
+func verifyImpersonationToken(key, token string) (*impersonationClaims, error) {
+	if key == "" {
+		return nil, errors.New("impersonation signing key not configured")
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, errors.New("malformed token")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, impersonationSignature(key, parts[0])) {
+		return nil, errors.New("invalid signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var claims impersonationClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, errors.New("token expired")
+	}
+	return &claims, nil
+}
+
+func impersonationSignature(key, payload string) []byte {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/currency"
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+var receiptPrefixPattern = regexp.MustCompile(`^[A-Z0-9]{1,12}$`)
+
+type OrganizationHandler struct {
+	Repo   db.OrganizationRepository
+	Logger *zap.Logger
+}
+
+func NewOrganizationHandler(repo db.OrganizationRepository, logger *zap.Logger) *OrganizationHandler {
+	return &OrganizationHandler{
+		Repo:   repo,
+		Logger: logger,
+	}
+}
+
+func (h *OrganizationHandler) GetOrganization(c *gin.Context) {
+	org := currentOrganization(c)
+	if org == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
+		return
+	}
+
+	c.JSON(http.StatusOK, org)
+}
+
+// UpdateOrganizationSettings changes only the fields present in the request;
+// the request is decoded over the current values, so omitted fields,
+// including the Connect account, keep them.
+func (h *OrganizationHandler) UpdateOrganizationSettings(c *gin.Context) {
+	org := currentOrganization(c)
+	if org == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
+		return
+	}
+
+	var request struct {
+		StripeAccountID string                      `json:"stripeAccountId"`
+		Settings        models.OrganizationSettings `json:"settings"`
+	}
+	request.StripeAccountID = org.StripeAccountID
+	request.Settings = org.Settings
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if request.Settings.ApplicationFeePercent < 0 || request.Settings.ApplicationFeePercent > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Application fee percent must be between 0 and 100"})
+		return
+	}
+	if request.Settings.DepositPercent < 0 || request.Settings.DepositPercent >= 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Deposit percent must be at least 0 and below 100"})
+		return
+	}
+	if on := request.Settings.CreditsConsumedOn; on != "" && on != "booking" && on != "completion" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Credits must be consumed on booking or completion"})
+		return
+	}
+	if request.Settings.Currency != "" {
+		request.Settings.Currency = currency.Normalize(request.Settings.Currency)
+		if !currency.Supported(request.Settings.Currency) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency", "supported": currency.Codes()})
+			return
+		}
+	}
+	if request.Settings.ReceiptPrefix != "" && !receiptPrefixPattern.MatchString(request.Settings.ReceiptPrefix) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Receipt prefix must be up to 12 upper-case letters or digits"})
+		return
+	}
+	if request.Settings.Country != "" && !countryCodePattern.MatchString(request.Settings.Country) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Country must be a two-letter ISO 3166-1 code"})
+		return
+	}
+
+	if err := h.Repo.UpdateSettings(c.Request.Context(), org.ID, request.StripeAccountID, request.Settings); err != nil {
+		h.Logger.Error("Failed to update organization settings", zap.Int("organization_id", org.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update organization settings"})
+		return
+	}
+
+	org.StripeAccountID = request.StripeAccountID
+	org.Settings = request.Settings
+	h.Logger.Info("Updated organization settings", zap.Int("organization_id", org.ID))
+	c.JSON(http.StatusOK, org)
+}
+
+// TenantMiddleware resolves the organization for the request from, in order,
+// the API key, the organization claim on the Clerk token, the organization
+// slug claim on a local token, the subdomain and finally the configured
+// default. The tenant is put on the request context so
+// repositories can scope every query to it.
+//
+// Token callers must already have a profile in the organization, created by
+// the Clerk webhooks; the host never grants access to an organization by
+// itself. Their role is the one they hold in that organization, not the one
+// on the token.
+func TenantMiddleware(cfg *config.Config, orgs db.OrganizationRepository, users db.UserRepository, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+		subdomain := tenantSubdomain(c.Request.Host, cfg.TenantBaseDomain)
+
+		var org *models.Organization
+		var err error
+
+		keyIfc, isService := c.Get("api_key")
+		if isService {
+			key := keyIfc.(*models.APIKey)
+			org, err = orgs.GetByID(ctx, key.OrganizationID)
+		} else if clerkOrgID := c.GetString("clerk_org_id"); clerkOrgID != "" {
+			org, err = orgs.GetByClerkOrgID(ctx, clerkOrgID)
+		} else if orgSlug := c.GetString("org_slug"); orgSlug != "" {
+			org, err = orgs.GetBySlug(ctx, orgSlug)
+		} else if subdomain != "" {
+			org, err = orgs.GetBySlug(ctx, subdomain)
+		} else if cfg.DefaultOrganizationSlug != "" {
+			org, err = orgs.GetBySlug(ctx, cfg.DefaultOrganizationSlug)
+		} else {
+			err = db.ErrNotFound
+		}
+
+		if err != nil {
+			if !errors.Is(err, db.ErrNotFound) {
+				logger.Error("Failed to resolve organization", zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve organization"})
+				c.Abort()
+				return
+			}
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not found"})
+			c.Abort()
+			return
+		}
+
+		if subdomain != "" && subdomain != org.Slug {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token does not belong to this organization"})
+			c.Abort()
+			return
+		}
+
+		tenantCtx := db.WithTenant(ctx, org.ID)
+		if !isService {
+			member, err := users.GetByExternalID(tenantCtx, c.GetString("user_id"))
+			if errors.Is(err, db.ErrNotFound) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this organization"})
+				c.Abort()
+				return
+			}
+			if err != nil {
+				logger.Error("Failed to resolve organization membership", zap.Int("organization_id", org.ID), zap.Error(err))
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to resolve organization"})
+				c.Abort()
+				return
+			}
+			c.Set("user_role", member.Role)
+		}
+
+		c.Set("organization", org)
+		c.Request = c.Request.WithContext(tenantCtx)
+
+		c.Next()
+	}
+}
+
+func currentOrganization(c *gin.Context) *models.Organization {
+	orgIfc, exists := c.Get("organization")
+	if !exists {
+		return nil
+	}
+	org, _ := orgIfc.(*models.Organization)
+	return org
+}
+
+func tenantSubdomain(host, baseDomain string) string {
+	if baseDomain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	suffix := "." + strings.ToLower(baseDomain)
+	if !strings.HasSuffix(host, suffix) {
+		return ""
+	}
+	sub := strings.TrimSuffix(host, suffix)
+	if sub == "" || sub == "www" || sub == "api" || strings.Contains(sub, ".") {
+		return ""
+	}
+	return sub
+}
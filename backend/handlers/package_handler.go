@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/fees"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+	"github.com/ozoli99/Harmonia/webhooks"
+)
+
+// PackageHandler sells prepaid packages of session credits and reports
+// clients' credit balances.
+type PackageHandler struct {
+	Credits   db.CreditRepository
+	Fees      db.FeePolicyRepository
+	Payments  payments.Adapter
+	Validator *validator.Validate
+	Logger    *zap.Logger
+}
+
+func NewPackageHandler(credits db.CreditRepository, feePolicies db.FeePolicyRepository, adapter payments.Adapter, logger *zap.Logger) *PackageHandler {
+	return &PackageHandler{
+		Credits:   credits,
+		Fees:      feePolicies,
+		Payments:  adapter,
+		Validator: validator.New(),
+		Logger:    logger,
+	}
+}
+
+func (h *PackageHandler) ListPackages(c *gin.Context) {
+	packages, err := h.Credits.ListPackages(c.Request.Context(), c.Query("all") != "true")
+	if err != nil {
+		h.Logger.Error("Failed to list packages", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list packages"})
+		return
+	}
+
+	c.JSON(http.StatusOK, packages)
+}
+
+func (h *PackageHandler) CreatePackage(c *gin.Context) {
+	var pkg models.Package
+	if err := c.ShouldBindJSON(&pkg); err != nil {
+		c.Error(fmt.Errorf("invalid JSON: %w", err))
+		return
+	}
+	pkg.Active = true
+	if !chargeCurrency(c, &pkg.Currency, pkg.Price) {
+		return
+	}
+
+	if err := h.Validator.Struct(pkg); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := h.Credits.CreatePackage(c.Request.Context(), &pkg)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown service"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("insert error: %w", err))
+		return
+	}
+
+	h.Logger.Info("Created package", zap.Int("package_id", pkg.ID))
+	c.JSON(http.StatusCreated, pkg)
+}
+
+// PurchasePackage starts the payment of a package. Its credits are granted
+// once the provider reports the payment succeeded.
+func (h *PackageHandler) PurchasePackage(c *gin.Context) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	org := currentOrganization(c)
+	if org == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
+		return
+	}
+	packageID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid package ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	pkg, err := h.Credits.GetPackage(ctx, packageID)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && !pkg.Active) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Package not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("load package: %w", err))
+		return
+	}
+	if pkg.Price <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This package cannot be purchased"})
+		return
+	}
+	// Packages are sold by the organization rather than a masseur.
+	if org.StripeAccountID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not onboarded with Stripe"})
+		return
+	}
+
+	policy, err := h.Fees.Resolve(ctx, 0, principal.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		policy, err = fees.Fallback(org), nil
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("resolve fee policy: %w", err))
+		return
+	}
+	applicationFee := fees.Calculate(policy, pkg.Price)
+
+	intent, err := h.Payments.CreatePaymentIntent(ctx, payments.PaymentIntentRequest{
+		Amount:         pkg.Price,
+		Currency:       pkg.Currency,
+		ApplicationFee: applicationFee,
+		Destination:    org.StripeAccountID,
+		Metadata: map[string]string{
+			"organization_id": strconv.Itoa(org.ID),
+			"package_id":      strconv.Itoa(pkg.ID),
+			"client_id":       strconv.Itoa(principal.UserID),
+			"kind":            "package",
+		},
+		IdempotencyKey: providerIdempotencyKey(c, "package"),
+	})
+	if err != nil {
+		h.Logger.Error("Payment provider error", zap.String("provider", h.Payments.Name()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment intent"})
+		return
+	}
+
+	purchase := models.PackagePurchase{
+		ClientID:          principal.UserID,
+		PackageID:         pkg.ID,
+		Credits:           pkg.Credits,
+		Amount:            pkg.Price,
+		Currency:          pkg.Currency,
+		ApplicationFee:    applicationFee,
+		Provider:          h.Payments.Name(),
+		ProviderPaymentID: intent.ID,
+	}
+	if err := h.Credits.CreatePurchase(ctx, &purchase); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store package purchase"})
+		return
+	}
+
+	h.Logger.Info("Package purchase started", zap.Int("purchase_id", purchase.ID), zap.Int("package_id", pkg.ID))
+	c.JSON(http.StatusOK, gin.H{"client_secret": intent.ClientSecret, "approval_url": intent.ApprovalURL, "purchase": purchase})
+}
+
+// GetMyCredits reports the calling client's credits.
+func (h *PackageHandler) GetMyCredits(c *gin.Context) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	h.respondCredits(c, principal.UserID)
+}
+
+// GetClientCredits reports a client's credits to staff.
+func (h *PackageHandler) GetClientCredits(c *gin.Context) {
+	clientID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid client ID"})
+		return
+	}
+	h.respondCredits(c, clientID)
+}
+
+func (h *PackageHandler) respondCredits(c *gin.Context, clientID int) {
+	ctx := c.Request.Context()
+	lots, err := h.Credits.Lots(ctx, clientID)
+	if err != nil {
+		c.Error(fmt.Errorf("credit lots: %w", err))
+		return
+	}
+	ledger, err := h.Credits.Ledger(ctx, clientID)
+	if err != nil {
+		c.Error(fmt.Errorf("credit ledger: %w", err))
+		return
+	}
+	purchases, err := h.Credits.ListPurchases(ctx, clientID)
+	if err != nil {
+		c.Error(fmt.Errorf("package purchases: %w", err))
+		return
+	}
+
+	available := 0
+	for _, lot := range lots {
+		available += lot.Remaining
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"available": available,
+		"lots":      lots,
+		"ledger":    ledger,
+		"purchases": purchases,
+	})
+}
+
+var purchaseStatuses = map[string]string{
+	payments.EventPaymentSucceeded: "paid",
+	payments.EventPaymentFailed:    "failed",
+	payments.EventPaymentCanceled:  "canceled",
+}
+
+// RegisterWebhookHandlers wires the package purchase event handlers into p.
+func (h *PackageHandler) RegisterWebhookHandlers(p *webhooks.Processor) {
+	for eventType := range purchaseStatuses {
+		p.Handle(eventType, h.handlePurchaseStatus)
+	}
+}
+
+func (h *PackageHandler) handlePurchaseStatus(ctx context.Context, event *payments.Event) error {
+	if event.Payment.Metadata["kind"] != "package" {
+		return nil
+	}
+
+	purchase, err := h.Credits.UpdatePurchaseStatus(ctx, event.Payment.PaymentID, purchaseStatuses[event.Type])
+	if errors.Is(err, db.ErrNotFound) {
+		h.Logger.Info("Ignoring payment event for settled package purchase", zap.String("payment_id", event.Payment.PaymentID), zap.String("event", event.Type))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("update package purchase %s: %w", event.Payment.PaymentID, err)
+	}
+
+	h.Logger.Info("Package purchase updated",
+		zap.Int("purchase_id", purchase.ID),
+		zap.Int("client_id", purchase.ClientID),
+		zap.String("status", purchase.Status),
+	)
+	return nil
+}
@@ -1,182 +1,1382 @@
 package handlers
 
 import (
-	"encoding/json"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
-	"github.com/stripe/stripe-go/v81"
-	"github.com/stripe/stripe-go/v81/account"
-	"github.com/stripe/stripe-go/v81/accountlink"
-	"github.com/stripe/stripe-go/v81/paymentintent"
-	"github.com/stripe/stripe-go/v81/webhook"
 	"go.uber.org/zap"
 
 	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/currency"
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/fees"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+	"github.com/ozoli99/Harmonia/pricing"
+	"github.com/ozoli99/Harmonia/webhooks"
 )
 
 type PaymentHandler struct {
-	DB     *sqlx.DB
-	Config *config.Config
-	Logger *zap.Logger
+	Repo      db.PaymentRepository
+	Users     db.UserRepository
+	Services  db.ServiceRepository
+	Fees      db.FeePolicyRepository
+	Promos    db.PromoCodeRepository
+	GiftCards db.GiftCardRepository
+	Payments  payments.Adapter
+	Config    *config.Config
+	Logger    *zap.Logger
 }
 
-func NewPaymentHandler(db *sqlx.DB, cfg *config.Config, logger *zap.Logger) *PaymentHandler {
+func NewPaymentHandler(repo db.PaymentRepository, users db.UserRepository, services db.ServiceRepository, feePolicies db.FeePolicyRepository, promos db.PromoCodeRepository, giftCards db.GiftCardRepository, adapter payments.Adapter, cfg *config.Config, logger *zap.Logger) *PaymentHandler {
 	return &PaymentHandler{
-		DB:     db,
-		Config: cfg,
-		Logger: logger,
+		Repo:      repo,
+		Users:     users,
+		Services:  services,
+		Fees:      feePolicies,
+		Promos:    promos,
+		GiftCards: giftCards,
+		Payments:  adapter,
+		Config:    cfg,
+		Logger:    logger,
 	}
 }
 
-func (h *PaymentHandler) CreateMasseurStripeAccount(c *gin.Context) {
-	userIDIfc, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+func (h *PaymentHandler) GetPaymentQuote(c *gin.Context) {
+	appointmentID, err := strconv.Atoi(c.Param("appointment_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid appointment ID"})
+		return
+	}
+
+	quote, _, ok := h.quoteForCaller(c, appointmentID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, quote)
+}
+
+func (h *PaymentHandler) CreatePaymentIntent(c *gin.Context) {
+	var request struct {
+		AppointmentID int    `json:"appointment_id" binding:"required"`
+		Mode          string `json:"mode" binding:"omitempty,oneof=full deposit authorize balance"`
+		PromoCode     string `json:"promo_code"`
+		GiftCardCode  string `json:"gift_card_code"`
+		// PaymentMethodID pays off-session with one of the client's saved
+		// payment methods.
+		PaymentMethodID string `json:"payment_method_id"`
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	userID := userIDIfc.(string)
 
-	var role string
-	err := h.DB.Get(&role, "SELECT role FROM user_profiles WHERE id = $1", userID)
-	if err != nil || role != "masseur" {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Only masseurs can create Stripe accounts"})
+	org := currentOrganization(c)
+	if org == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
 		return
 	}
-	
-	accParams := &stripe.AccountParams{
-		Type:    stripe.String("express"),
-		Country: stripe.String("US"),
-		Email:   stripe.String("masseur@example.com"),
+
+	quote, input, ok := h.quoteForCaller(c, request.AppointmentID)
+	if !ok {
+		return
+	}
+	if quote.Total <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to pay for this appointment"})
+		return
 	}
-	acc, err := account.New(accParams)
+
+	// Payouts go to the masseur's own connected account when they have one,
+	// otherwise to the organization's account.
+	masseur, err := h.Repo.Payee(c.Request.Context(), request.AppointmentID)
 	if err != nil {
-		h.Logger.Error("Failed to create Stripe account", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Stripe account"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Masseur not found"})
+		return
+	}
+	if masseur.PaidWithCredits {
+		c.JSON(http.StatusConflict, gin.H{"error": "Appointment is paid with package credits"})
+		return
+	}
+	destination := org.StripeAccountID
+	if masseur.StripeAccountID != nil {
+		if !masseur.ChargesEnabled || !masseur.PayoutsEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "Masseur has not finished payout onboarding"})
+			return
+		}
+		destination = *masseur.StripeAccountID
+	}
+	if destination == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Masseur not onboarded with Stripe"})
 		return
 	}
 
-	linkParams := &stripe.AccountLinkParams{
-		Account:    stripe.String(acc.ID),
-		RefreshURL: stripe.String("https://harmonia.com/retry"),
-		ReturnURL:  stripe.String("https://harmonia.com/success"),
-		Type:       stripe.String("account_onboarding"),
+	policy, err := h.Fees.Resolve(c.Request.Context(), input.MasseurID, input.ClientID)
+	if errors.Is(err, db.ErrNotFound) {
+		policy, err = fees.Fallback(org), nil
 	}
-	link, err := accountlink.New(linkParams)
 	if err != nil {
-		h.Logger.Error("Failed to create Stripe onboarding link", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Stripe onboarding link"})
+		c.Error(fmt.Errorf("resolve fee policy: %w", err))
 		return
 	}
+	var feePolicyID *int
+	if policy.ID != 0 {
+		feePolicyID = &policy.ID
+	}
+
+	var promo *models.PromoCode
+	var discount int64
+	if request.PromoCode != "" {
+		if request.Mode == "balance" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Promo codes apply to the first payment of an appointment"})
+			return
+		}
+		promo, discount, ok = h.promoForCheckout(c, request.PromoCode, quote, input)
+		if !ok {
+			return
+		}
+	}
 
-	_, err = h.DB.Exec("UPDATE user_profiles SET stripe_account_id = $1 WHERE id = $2", acc.ID, userID)
+	cur, err := currency.Lookup(quote.Currency)
 	if err != nil {
-		h.Logger.Error("Failed to save Stripe account ID", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save Stripe account ID"})
+		c.Error(fmt.Errorf("checkout currency: %w", err))
+		return
+	}
+	charge, err := h.checkoutCharge(c.Request.Context(), org, request.AppointmentID, request.Mode, cur, quote.Total, discount, policy)
+	if err != nil {
+		var checkoutErr *checkoutError
+		if errors.As(err, &checkoutErr) {
+			c.JSON(checkoutErr.status, gin.H{"error": checkoutErr.message})
+			return
+		}
+		c.Error(fmt.Errorf("checkout charge: %w", err))
+		return
+	}
+
+	var card *models.GiftCard
+	if request.GiftCardCode != "" {
+		card, ok = h.giftCardForCheckout(c, request.GiftCardCode, quote.Currency)
+		if !ok {
+			return
+		}
+		charge.coverWithGiftCard(min(card.Balance, charge.amount))
+	}
+
+	var customerID string
+	if request.PaymentMethodID != "" && charge.amount > 0 {
+		customerID, ok = h.savedPaymentMethod(c, input.ClientID, request.PaymentMethodID)
+		if !ok {
+			return
+		}
+	}
+
+	// Whatever promo codes and gift cards leave to pay goes through the
+	// provider; a checkout they cover completely is settled here.
+	provider, providerPaymentID := "internal", ""
+	var intent *payments.PaymentIntent
+	if charge.amount > 0 {
+		intent, err = h.Payments.CreatePaymentIntent(c.Request.Context(), payments.PaymentIntentRequest{
+			Amount:         charge.amount,
+			Currency:       quote.Currency,
+			ApplicationFee: charge.fee,
+			Destination:    destination,
+			ManualCapture:  charge.captureMethod == "manual",
+			Customer:       customerID,
+			PaymentMethod:  request.PaymentMethodID,
+			OffSession:     customerID != "",
+			Metadata: map[string]string{
+				"appointment_id":  strconv.Itoa(request.AppointmentID),
+				"organization_id": strconv.Itoa(org.ID),
+				"kind":            charge.kind,
+			},
+			IdempotencyKey: providerIdempotencyKey(c, "checkout"),
+		})
+		if err != nil {
+			h.Logger.Error("Payment provider error", zap.String("provider", h.Payments.Name()), zap.Error(err))
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment intent"})
+			return
+		}
+		provider, providerPaymentID = h.Payments.Name(), intent.ID
+	} else if providerPaymentID, err = internalPaymentID(); err != nil {
+		c.Error(fmt.Errorf("generate payment reference: %w", err))
+		return
+	}
+
+	var promoCodeID, giftCardID *int
+	if promo != nil {
+		promoCodeID = &promo.ID
+	}
+	if card != nil && charge.giftCard > 0 {
+		giftCardID = &card.ID
+	}
+
+	// A retried checkout gets the same intent back from the provider, so only
+	// the first attempt records it and reserves its promo code and gift card.
+	payment := &models.Payment{
+		AppointmentID:      request.AppointmentID,
+		Amount:             charge.amount,
+		Currency:           quote.Currency,
+		Status:             "pending",
+		Kind:               charge.kind,
+		CaptureMethod:      charge.captureMethod,
+		Provider:           provider,
+		StripePaymentID:    providerPaymentID,
+		ApplicationFee:     charge.fee,
+		FeePolicyID:        feePolicyID,
+		DestinationAccount: destination,
+		DiscountAmount:     charge.discount,
+		PromoCodeID:        promoCodeID,
+		GiftCardAmount:     charge.giftCard,
+		GiftCardID:         giftCardID,
+		Price:              paymentPrice(quote, input),
+	}
+	err = h.Repo.Create(c.Request.Context(), payment)
+	paymentID := payment.ID
+	switch {
+	case errors.Is(err, db.ErrDuplicate):
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store payment record"})
 		return
+	default:
+		if err := h.reserveDiscounts(c.Request.Context(), paymentID, input.ClientID, request.AppointmentID, promo, charge.discount, giftCardID, charge.giftCard); err != nil {
+			h.abandonCheckout(c.Request.Context(), paymentID, intent)
+			switch {
+			case errors.Is(err, errPromoUsedUp):
+				c.JSON(http.StatusConflict, gin.H{"error": "This promo code has reached its usage limit"})
+			case errors.Is(err, errGiftCardUnavailable):
+				c.JSON(http.StatusConflict, gin.H{"error": "The gift card balance changed, please try again"})
+			default:
+				c.Error(fmt.Errorf("reserve discounts: %w", err))
+			}
+			return
+		}
+		// An off-session payment is already confirmed and its webhook may
+		// have arrived before the payment was stored, so its outcome is
+		// applied here as well.
+		if intent != nil && intent.EventType != "" {
+			if err := h.handlePaymentStatus(c.Request.Context(), &payments.Event{
+				Type: intent.EventType,
+				Payment: &payments.PaymentEvent{
+					PaymentID:   intent.ID,
+					Status:      intent.Status,
+					Amount:      intent.Amount,
+					Currency:    intent.Currency,
+					FailureCode: intent.FailureCode,
+				},
+			}); err != nil {
+				h.Logger.Error("Failed to apply off-session payment status", zap.String("payment_id", intent.ID), zap.Error(err))
+			}
+		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"onboarding_url": link.URL})
+	status := "pending"
+	if intent != nil {
+		if transition, ok := paymentTransitions[intent.EventType]; ok {
+			status = transition.paymentStatus
+		}
+		if status == "failed" {
+			// The client can still complete the payment on-session, e.g.
+			// when the bank asks for authentication.
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"error":         "The saved payment method could not be charged",
+				"failure_code":  intent.FailureCode,
+				"client_secret": intent.ClientSecret,
+				"status":        status,
+			})
+			return
+		}
+	}
+	if intent == nil {
+		if err := h.settleInternalPayment(c.Request.Context(), providerPaymentID); err != nil {
+			c.Error(fmt.Errorf("settle payment %s: %w", providerPaymentID, err))
+			return
+		}
+		status = "paid"
+		intent = &payments.PaymentIntent{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"client_secret":    intent.ClientSecret,
+		"approval_url":     intent.ApprovalURL,
+		"status":           status,
+		"kind":             charge.kind,
+		"capture_method":   charge.captureMethod,
+		"amount":           charge.amount,
+		"discount":         charge.discount,
+		"gift_card_amount": charge.giftCard,
+		"quote":            quote,
+	})
 }
 
-func (h *PaymentHandler) CreatePaymentIntent(c *gin.Context) {
-	var request struct {
-		AppointmentID int64  `json:"appointment_id" binding:"required"`
-		Amount        int64  `json:"amount" binding:"required"`
-		Currency      string `json:"currency"`
+var (
+	errPromoUsedUp         = errors.New("promo code usage limit reached")
+	errGiftCardUnavailable = errors.New("gift card cannot cover the amount")
+)
+
+// promoForCheckout looks up a promo code and works out its discount on a
+// quote. On failure it writes the response and returns false.
+func (h *PaymentHandler) promoForCheckout(c *gin.Context, code string, quote *pricing.Quote, input *pricing.Input) (*models.PromoCode, int64, bool) {
+	promo, err := h.Promos.GetByCode(c.Request.Context(), code)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown promo code"})
+		return nil, 0, false
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("load promo code: %w", err))
+		return nil, 0, false
+	}
+
+	var serviceID *int
+	if input.Service != nil {
+		serviceID = &input.Service.ID
+	}
+	discount, err := pricing.PromoDiscount(promo, quote, serviceID, time.Now())
+	switch {
+	case errors.Is(err, pricing.ErrPromoInactive):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This promo code is not valid at the moment"})
+		return nil, 0, false
+	case errors.Is(err, pricing.ErrPromoService):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This promo code does not apply to this service"})
+		return nil, 0, false
+	case errors.Is(err, pricing.ErrPromoCurrency):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This promo code does not apply to this currency"})
+		return nil, 0, false
+	case err != nil:
+		c.Error(fmt.Errorf("promo discount: %w", err))
+		return nil, 0, false
+	}
+	return promo, discount, true
+}
+
+// giftCardForCheckout looks up a gift card that can pay in currency. On
+// failure it writes the response and returns false.
+func (h *PaymentHandler) giftCardForCheckout(c *gin.Context, code, currency string) (*models.GiftCard, bool) {
+	card, err := h.GiftCards.GetByCodeHash(c.Request.Context(), hashGiftCardCode(code))
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown gift card"})
+		return nil, false
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("load gift card: %w", err))
+		return nil, false
 	}
+	switch {
+	case !card.Usable(time.Now()):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This gift card cannot be used"})
+		return nil, false
+	case card.Currency != currency:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This gift card is for another currency"})
+		return nil, false
+	case card.Balance <= 0:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This gift card has no balance left"})
+		return nil, false
+	}
+	return card, true
+}
+
+// reserveDiscounts records the use of a promo code and holds the gift card
+// amount for a new payment. Both are settled with the payment: kept when it
+// is paid and given back when it is canceled.
+func (h *PaymentHandler) reserveDiscounts(ctx context.Context, paymentID, clientID, appointmentID int, promo *models.PromoCode, discount int64, giftCardID *int, giftCardAmount int64) error {
+	if promo != nil {
+		err := h.Promos.Redeem(ctx, &models.PromoRedemption{
+			PromoCodeID:   promo.ID,
+			ClientID:      clientID,
+			PaymentID:     paymentID,
+			AppointmentID: &appointmentID,
+			Discount:      discount,
+		})
+		if errors.Is(err, db.ErrLimitReached) {
+			return errPromoUsedUp
+		}
+		if err != nil {
+			return fmt.Errorf("redeem promo code: %w", err)
+		}
+	}
+	if giftCardID != nil {
+		err := h.GiftCards.Redeem(ctx, *giftCardID, paymentID, giftCardAmount)
+		if errors.Is(err, db.ErrNotFound) || errors.Is(err, db.ErrInsufficientBalance) {
+			return errGiftCardUnavailable
+		}
+		if err != nil {
+			return fmt.Errorf("redeem gift card: %w", err)
+		}
+	}
+	return nil
+}
+
+// abandonCheckout cancels a payment whose discounts could not be reserved,
+// along with its provider intent.
+func (h *PaymentHandler) abandonCheckout(ctx context.Context, paymentID int, intent *payments.PaymentIntent) {
+	if intent != nil && intent.EventType == payments.EventPaymentSucceeded {
+		// Off-session payments are charged straight away.
+		if _, err := h.Payments.RefundPayment(ctx, payments.RefundRequest{
+			PaymentID:            intent.ID,
+			ReverseTransfer:      true,
+			RefundApplicationFee: true,
+			IdempotencyKey:       "abandon-" + intent.ID,
+		}); err != nil {
+			h.Logger.Error("Failed to refund abandoned payment intent", zap.String("payment_id", intent.ID), zap.Error(err))
+		}
+	} else if intent != nil {
+		if _, err := h.Payments.CancelPayment(ctx, intent.ID); err != nil {
+			h.Logger.Error("Failed to cancel abandoned payment intent", zap.String("payment_id", intent.ID), zap.Error(err))
+		}
+	}
+	if err := h.Repo.Cancel(ctx, paymentID); err != nil {
+		h.Logger.Error("Failed to cancel abandoned payment", zap.Int("payment_id", paymentID), zap.Error(err))
+	}
+	if err := h.settleDiscounts(ctx, paymentID, "canceled"); err != nil {
+		h.Logger.Error("Failed to release discounts of abandoned payment", zap.Int("payment_id", paymentID), zap.Error(err))
+	}
+}
+
+// savedPaymentMethod checks that the calling client saved paymentMethodID
+// and returns their provider customer. On failure it writes the response
+// and returns false.
+func (h *PaymentHandler) savedPaymentMethod(c *gin.Context, clientID int, paymentMethodID string) (string, bool) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() || principal.UserID != clientID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the client can pay with their saved payment methods"})
+		return "", false
+	}
+
+	client, err := h.Users.GetByID(c.Request.Context(), clientID)
+	if err != nil {
+		c.Error(fmt.Errorf("load payment customer: %w", err))
+		return "", false
+	}
+	if client.StripeCustomerID == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown payment method"})
+		return "", false
+	}
+	customerID := *client.StripeCustomerID
+
+	methods, err := h.Payments.ListPaymentMethods(c.Request.Context(), customerID)
+	if err != nil {
+		h.Logger.Error("Payment provider error", zap.String("provider", h.Payments.Name()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load saved payment methods"})
+		return "", false
+	}
+	for _, method := range methods {
+		if method.ID == paymentMethodID {
+			return customerID, true
+		}
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown payment method"})
+	return "", false
+}
+
+// settleInternalPayment marks a payment that needed nothing from the
+// provider as paid and confirms its appointment.
+func (h *PaymentHandler) settleInternalPayment(ctx context.Context, providerPaymentID string) error {
+	_, err := h.Repo.Transition(ctx, db.StatusChange{
+		ProviderPaymentID: providerPaymentID,
+		Status:            "paid",
+		From:              []string{"pending"},
+		AppointmentStatus: "confirmed",
+	}, func(payment *models.Payment) error {
+		return h.settleDiscounts(ctx, payment.ID, payment.Status)
+	})
+	return err
+}
+
+// settleDiscounts keeps the promo code use and gift card hold of a payment
+// once it is paid and gives them back if it is canceled. It is idempotent,
+// so provider event retries can call it again.
+func (h *PaymentHandler) settleDiscounts(ctx context.Context, paymentID int, paymentStatus string) error {
+	switch paymentStatus {
+	case "paid":
+		return h.Promos.Settle(ctx, paymentID, "redeemed")
+	case "canceled":
+		if err := h.GiftCards.Release(ctx, paymentID); err != nil {
+			return fmt.Errorf("release gift card: %w", err)
+		}
+		return h.Promos.Settle(ctx, paymentID, "released")
+	}
+	return nil
+}
+
+// internalPaymentID references a payment settled without the provider.
+func internalPaymentID() (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return "internal_" + hex.EncodeToString(buf), nil
+}
+
+// Payments in these states hold or have taken the client's money.
+var activePaymentStatuses = []string{"processing", "authorized", "paid", "partially_refunded", "disputed"}
+
+type plannedCharge struct {
+	kind          string
+	captureMethod string
+	amount        int64
+	fee           int64
+	discount      int64
+	giftCard      int64
+}
+
+// coverWithGiftCard pays amount of the charge from a gift card. The fee is
+// scaled down with what is left to charge, as the platform takes its fee when
+// a card is sold.
+func (p *plannedCharge) coverWithGiftCard(amount int64) {
+	if amount <= 0 {
+		return
+	}
+	total := p.amount
+	p.amount -= amount
+	p.giftCard = amount
+	p.fee = int64(math.Round(float64(p.fee) * float64(p.amount) / float64(total)))
+	if p.amount == 0 {
+		p.captureMethod = "automatic"
+	}
+}
+
+type checkoutError struct {
+	status  int
+	message string
+}
+
+func (e *checkoutError) Error() string {
+	return e.message
+}
+
+// checkoutCharge works out what a checkout in mode takes for an appointment
+// costing total, less a promo discount, with fees under policy:
+//   - full charges everything now;
+//   - authorize holds everything, to be captured when the appointment is
+//     completed or voided when it is canceled;
+//   - deposit charges the organization's deposit percentage now;
+//   - balance charges what is left after a paid deposit, keeping the
+//     deposit's discount.
+//
+// Deposits carry their share of the fee and the balance the rest, so a split
+// payment costs the same as a full one.
+func (h *PaymentHandler) checkoutCharge(ctx context.Context, org *models.Organization, appointmentID int, mode string, cur currency.Currency, total, discount int64, policy *models.FeePolicy) (*plannedCharge, error) {
+	existing, err := h.Repo.ListByAppointment(ctx, appointmentID, activePaymentStatuses)
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != "balance" {
+		if len(existing) > 0 {
+			return nil, &checkoutError{http.StatusConflict, "Appointment is already paid"}
+		}
+		net := total - discount
+		netFee := fees.Calculate(policy, net)
+		switch {
+		case net <= 0:
+			return &plannedCharge{kind: "full", captureMethod: "automatic", discount: discount}, nil
+		case mode == "authorize":
+			return &plannedCharge{kind: "full", captureMethod: "manual", amount: net, fee: netFee, discount: discount}, nil
+		case mode == "deposit":
+			percent := org.Settings.DepositPercent
+			if percent <= 0 {
+				return nil, &checkoutError{http.StatusBadRequest, "Deposits are not enabled for this organization"}
+			}
+			amount := cur.Round(int64(math.Round(float64(net) * percent / 100)))
+			if amount < cur.Step() {
+				amount = cur.Step()
+			}
+			fee := int64(math.Round(float64(netFee) * float64(amount) / float64(net)))
+			return &plannedCharge{kind: "deposit", captureMethod: "automatic", amount: amount, fee: fee, discount: discount}, nil
+		default:
+			return &plannedCharge{kind: "full", captureMethod: "automatic", amount: net, fee: netFee, discount: discount}, nil
+		}
+	}
+
+	// What was paid towards the deposit counts gift cards as well as the
+	// provider charge.
+	var deposit int64
+	discount = 0
+	for _, p := range existing {
+		switch {
+		case p.Kind != "deposit":
+			return nil, &checkoutError{http.StatusConflict, "Appointment is already paid"}
+		case p.Status != "paid" && p.Status != "partially_refunded":
+			return nil, &checkoutError{http.StatusConflict, "The deposit has not been paid yet"}
+		}
+		deposit += p.Amount + p.GiftCardAmount
+		discount += p.DiscountAmount
+	}
+	if len(existing) == 0 {
+		return nil, &checkoutError{http.StatusConflict, "No deposit has been paid for this appointment"}
+	}
+	net := total - discount
+	if deposit >= net {
+		return nil, &checkoutError{http.StatusBadRequest, "Nothing left to pay for this appointment"}
+	}
+	netFee := fees.Calculate(policy, net)
+	fee := netFee - int64(math.Round(float64(netFee)*float64(deposit)/float64(net)))
+	if fee < 0 {
+		fee = 0
+	}
+	return &plannedCharge{kind: "balance", captureMethod: "automatic", amount: net - deposit, fee: fee}, nil
+}
+
+// paymentPrice records the quote a checkout charged for.
+func paymentPrice(quote *pricing.Quote, input *pricing.Input) *models.PaymentPrice {
+	price := &models.PaymentPrice{
+		Subtotal: quote.Subtotal,
+		Discount: quote.Discount,
+		TaxRate:  input.Service.TaxRate,
+		Tax:      quote.Tax,
+		Total:    quote.Total,
+	}
+	for _, line := range quote.Lines {
+		price.Lines = append(price.Lines, models.ReceiptLine{Description: line.Description, Amount: line.Amount})
+	}
+	return price
+}
+
+// quoteForCaller prices an appointment from the catalog after checking that
+// it belongs to the calling client. On failure it writes the response and
+// returns false.
+func (h *PaymentHandler) quoteForCaller(c *gin.Context, appointmentID int) (*pricing.Quote, *pricing.Input, bool) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, nil, false
+	}
+
+	input, err := h.Services.GetPricingInput(c.Request.Context(), appointmentID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
+			return nil, nil, false
+		}
+		h.Logger.Error("Failed to load appointment pricing", zap.Int("appointment_id", appointmentID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to price appointment"})
+		return nil, nil, false
+	}
+	if !principal.IsService() && input.ClientID != principal.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
+		return nil, nil, false
+	}
+
+	if org := currentOrganization(c); input.Currency == "" && org != nil {
+		input.Currency = org.Settings.Currency
+	}
+
+	quote, err := pricing.Calculate(*input)
+	switch {
+	case errors.Is(err, pricing.ErrCurrencyMismatch):
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("The service is priced in %s but this masseur takes payments in %s",
+			strings.ToUpper(input.Service.Currency), strings.ToUpper(input.Currency))})
+		return nil, nil, false
+	case errors.Is(err, currency.ErrUnsupported):
+		c.JSON(http.StatusConflict, gin.H{"error": "The service is priced in an unsupported currency"})
+		return nil, nil, false
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Appointment has no priced service"})
+		return nil, nil, false
+	}
+	return quote, input, true
+}
+
+// RegisterWebhookHandlers wires the payment event handlers into p.
+func (h *PaymentHandler) RegisterWebhookHandlers(p *webhooks.Processor) {
+	for eventType := range paymentTransitions {
+		p.Handle(eventType, h.handlePaymentStatus)
+	}
+	p.Handle(payments.EventDisputeCreated, h.handleDispute)
+	p.Handle(payments.EventDisputeClosed, h.handleDispute)
+	p.Handle(payments.EventRefundUpdated, h.handleRefundUpdate)
+	p.Handle(payments.EventChargeRefunded, h.handleChargeRefunded)
+}
+
+// paymentTransition describes how a provider payment event moves a payment
+// and its appointment. A payment only moves when it is currently in one of
+// the from states, so late or replayed events cannot undo a later state.
+type paymentTransition struct {
+	paymentStatus     string
+	appointmentStatus string
+	from              []string
+}
+
+var paymentTransitions = map[string]paymentTransition{
+	payments.EventPaymentProcessing: {
+		paymentStatus:     "processing",
+		appointmentStatus: "pending_payment",
+		from:              []string{"pending", "requires_action"},
+	},
+	payments.EventPaymentRequiresAction: {
+		paymentStatus:     "requires_action",
+		appointmentStatus: "pending_payment",
+		from:              []string{"pending", "processing", "failed"},
+	},
+	payments.EventPaymentAuthorized: {
+		paymentStatus:     "authorized",
+		appointmentStatus: "confirmed",
+		from:              []string{"pending", "processing", "requires_action", "failed"},
+	},
+	payments.EventPaymentSucceeded: {
+		paymentStatus:     "paid",
+		appointmentStatus: "confirmed",
+		from:              []string{"pending", "processing", "requires_action", "failed", "authorized"},
+	},
+	payments.EventPaymentFailed: {
+		paymentStatus:     "failed",
+		appointmentStatus: "payment_failed",
+		from:              []string{"pending", "processing", "requires_action"},
+	},
+	payments.EventPaymentCanceled: {
+		paymentStatus:     "canceled",
+		appointmentStatus: "payment_failed",
+		from:              []string{"pending", "processing", "requires_action", "failed", "authorized"},
+	},
+}
+
+func (h *PaymentHandler) handlePaymentStatus(ctx context.Context, event *payments.Event) error {
+	transition := paymentTransitions[event.Type]
+	payment := event.Payment
+	if kind := payment.Metadata["kind"]; kind == "package" || kind == "gift_card" {
+		// Package and gift card purchases are not appointment payments;
+		// PackageHandler and GiftCardHandler apply them.
+		return nil
+	}
+
+	// Discounts are settled before the change is committed so that a failure
+	// leaves the payment in its old state and the retried event settles them
+	// again.
+	updated, err := h.Repo.Transition(ctx, db.StatusChange{
+		ProviderPaymentID: payment.PaymentID,
+		Status:            transition.paymentStatus,
+		From:              transition.from,
+		AppointmentStatus: transition.appointmentStatus,
+		FailureCode:       payment.FailureCode,
+		FailureReason:     payment.FailureReason,
+	}, func(p *models.Payment) error {
+		if err := h.settleDiscounts(ctx, p.ID, p.Status); err != nil {
+			return fmt.Errorf("settle discounts of payment %d: %w", p.ID, err)
+		}
+		return nil
+	})
+	if errors.Is(err, db.ErrNotFound) {
+		h.Logger.Info("Ignoring payment event for payment in later state", zap.String("payment_id", payment.PaymentID), zap.String("event", event.Type))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("update payment %s: %w", payment.PaymentID, err)
+	}
+
+	h.Logger.Info("Payment status updated",
+		zap.String("payment_id", payment.PaymentID),
+		zap.Int("appointment_id", updated.AppointmentID),
+		zap.String("status", transition.paymentStatus),
+		zap.String("failure_reason", payment.FailureReason),
+	)
+	return nil
+}
+
+func (h *PaymentHandler) handleDispute(ctx context.Context, event *payments.Event) error {
+	dispute := event.Dispute
 
+	// A dispute opens on money that was taken and closes from "disputed";
+	// unless it was lost, the payment and appointment go back to where they
+	// were before it.
+	change := db.StatusChange{
+		ProviderPaymentID: dispute.PaymentID,
+		Status:            "disputed",
+		From:              []string{"paid", "partially_refunded", "refunded", "disputed"},
+	}
+	if event.Type == payments.EventDisputeClosed {
+		change.Status, change.From = "", []string{"disputed"}
+		if dispute.Status == "lost" {
+			change.Status, change.AppointmentStatus = "dispute_lost", "dispute_lost"
+		}
+	}
+
+	updated, err := h.Repo.RecordDispute(ctx, change, dispute.DisputeID, dispute.Status, dispute.Reason)
+	if errors.Is(err, db.ErrNotFound) {
+		h.Logger.Info("Ignoring dispute event for payment in later state", zap.String("dispute_id", dispute.DisputeID), zap.String("event", event.Type))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("record dispute %s: %w", dispute.DisputeID, err)
+	}
+
+	h.Logger.Warn("Payment dispute updated",
+		zap.String("dispute_id", dispute.DisputeID),
+		zap.Int("appointment_id", updated.AppointmentID),
+		zap.String("dispute_status", dispute.Status),
+		zap.String("reason", dispute.Reason),
+	)
+	return nil
+}
+
+// paymentExportLimit caps how many payments a CSV export of the payment
+// history holds.
+const paymentExportLimit = 10000
+
+// ListPayments lists the payment history the caller can see: clients their
+// own payments, masseurs the payments they received, admins and service
+// callers every payment. Dates are in the organization's time zone and to
+// is inclusive. With format=csv it exports up to paymentExportLimit
+// payments instead of a page.
+func (h *PaymentHandler) ListPayments(c *gin.Context) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	params := []string{"appointment_id", "min_amount", "max_amount"}
+	filters := map[string]string{
+		"status": c.Query("status"),
+	}
+	switch {
+	case principal.IsService() || principal.Role == "admin":
+		params = append(params, "client_id", "masseur_id")
+	case principal.Role == "client":
+		filters["client_id"] = strconv.Itoa(principal.UserID)
+	case principal.Role == "masseur":
+		filters["masseur_id"] = strconv.Itoa(principal.UserID)
+	default:
+		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+		return
+	}
+
+	for _, param := range params {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		if n, err := strconv.ParseInt(value, 10, 64); err != nil || n < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": param + " must be a non-negative integer"})
+			return
+		}
+		filters[param] = value
+	}
+
+	loc := organizationLocation(c)
+	if v := c.Query("from"); v != "" {
+		from, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "from must be a date (YYYY-MM-DD)"})
+			return
+		}
+		filters["from"] = from.Format(time.RFC3339)
+	}
+	if v := c.Query("to"); v != "" {
+		to, err := time.ParseInLocation("2006-01-02", v, loc)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "to must be a date (YYYY-MM-DD)"})
+			return
+		}
+		filters["to"] = to.AddDate(0, 0, 1).Format(time.RFC3339)
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	csv := c.Query("format") == "csv"
+	if csv {
+		limit, offset = paymentExportLimit, 0
+	}
+
+	entries, err := h.Repo.List(c.Request.Context(), filters, limit, offset)
+	if err != nil {
+		c.Error(fmt.Errorf("list payments: %w", err))
+		return
+	}
+
+	if !csv {
+		c.JSON(http.StatusOK, entries)
+		return
+	}
+
+	rows := make([][]string, 0, len(entries))
+	for _, entry := range entries {
+		service := ""
+		if entry.ServiceName != nil {
+			service = *entry.ServiceName
+		}
+		rows = append(rows, []string{
+			strconv.Itoa(entry.ID),
+			entry.CreatedAt.In(loc).Format(time.RFC3339),
+			strconv.Itoa(entry.AppointmentID),
+			entry.AppointmentDate.Format("2006-01-02"),
+			service,
+			entry.ClientEmail,
+			entry.MasseurEmail,
+			entry.Kind,
+			entry.Status,
+			entry.Currency,
+			formatAmount(entry.Amount, entry.Currency),
+			formatAmount(entry.RefundedAmount, entry.Currency),
+			entry.Provider,
+			entry.StripePaymentID,
+		})
+	}
+	writeCSV(c, "payments.csv",
+		[]string{"Payment ID", "Created At", "Appointment ID", "Appointment Date", "Service", "Client", "Masseur", "Kind", "Status", "Currency", "Amount", "Refunded", "Provider", "Provider Payment ID"},
+		rows)
+}
+
+func (h *PaymentHandler) RefundPayment(c *gin.Context) {
+	paymentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	var request struct {
+		Amount int64  `json:"amount"`
+		Reason string `json:"reason"`
+	}
 	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if request.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund amount must be positive"})
+		return
+	}
+	if request.Reason != "" && request.Reason != "duplicate" && request.Reason != "fraudulent" && request.Reason != "requested_by_customer" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Reason must be duplicate, fraudulent or requested_by_customer"})
+		return
+	}
 
-	if request.Currency == "" {
-		request.Currency = "usd"
+	principal, ok := CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	orgID, ok := db.TenantFromContext(c.Request.Context())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
+		return
 	}
 
-	var masseurStripeID string
-	err := h.DB.Get(&masseurStripeID, `
-		SELECT u.stripe_account_id 
-		FROM appointments a 
-		JOIN user_profiles u ON a.masseur_id = u.id 
-		WHERE a.id = $1`, request.AppointmentID)
-    if err != nil || masseurStripeID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Masseur not onboarded with Stripe"})
+	ctx := c.Request.Context()
+	refund := models.Refund{
+		PaymentID: paymentID,
+		Amount:    request.Amount,
+		Status:    "pending",
+		Reason:    request.Reason,
+	}
+	if !principal.IsService() {
+		refund.CreatedBy = &principal.UserID
+	}
+	var payment *models.AppointmentPayment
+	refundable, err := h.Repo.ReserveRefund(ctx, &refund, func(p *models.AppointmentPayment) error {
+		payment = p
+		switch {
+		case principal.Role == "masseur" && p.MasseurID != principal.UserID:
+			return errNotOwnPayment
+		case p.Status != "paid" && p.Status != "partially_refunded":
+			return errPaymentNotPaid
+		case p.Provider == "internal":
+			// Gift card amounts are given back with a balance adjustment
+			// instead.
+			return errNothingCharged
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	case errors.Is(err, errNotOwnPayment):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only refund payments for your own appointments"})
+		return
+	case errors.Is(err, errPaymentNotPaid):
+		c.JSON(http.StatusConflict, gin.H{"error": "Only paid payments can be refunded"})
+		return
+	case errors.Is(err, errNothingCharged):
+		c.JSON(http.StatusConflict, gin.H{"error": "Nothing was charged through the payment provider"})
+		return
+	case errors.Is(err, db.ErrNotRefundable):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Refund exceeds refundable amount", "refundable": refundable})
+		return
+	case err != nil:
+		c.Error(fmt.Errorf("reserve refund: %w", err))
 		return
 	}
 
-	params := &stripe.PaymentIntentParams{
-		Amount:               stripe.Int64(request.Amount),
-		Currency:             stripe.String(request.Currency),
-		ApplicationFeeAmount: stripe.Int64(request.Amount / 10),
-		TransferData: &stripe.PaymentIntentTransferDataParams{
-			Destination: stripe.String(masseurStripeID),
-		},
+	// Reversing the transfer and refunding the application fee makes Stripe
+	// claw both back in proportion to the refunded amount.
+	result, err := h.Payments.RefundPayment(ctx, payments.RefundRequest{
+		PaymentID:            payment.StripePaymentID,
+		Amount:               refund.Amount,
+		Reason:               refund.Reason,
+		ReverseTransfer:      true,
+		RefundApplicationFee: true,
+		IdempotencyKey:       fmt.Sprintf("refund-%d", refund.ID),
 		Metadata: map[string]string{
-			"appointment_id": strconv.FormatInt(request.AppointmentID, 10),
+			"refund_id":       strconv.Itoa(refund.ID),
+			"payment_id":      strconv.Itoa(payment.ID),
+			"organization_id": strconv.Itoa(orgID),
 		},
+	})
+	if err != nil {
+		h.Logger.Error("Payment provider refund error", zap.Int("payment_id", payment.ID), zap.Error(err))
+		if dbErr := h.Repo.FailRefund(ctx, refund.ID, err.Error()); dbErr != nil {
+			h.Logger.Error("Failed to mark refund failed", zap.Int("refund_id", refund.ID), zap.Error(dbErr))
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refund payment"})
+		return
+	}
+
+	refund.Status = result.Status
+	refund.ProviderRefundID = &result.ID
+	if err := h.Repo.UpdateRefund(ctx, refund.ID, result.ID, result.Status, ""); err != nil {
+		h.Logger.Error("Failed to store refund result", zap.Int("refund_id", refund.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Refund issued but failed to record it"})
+		return
+	}
+
+	h.Logger.Info("Refund created", zap.Int("payment_id", payment.ID), zap.Int("refund_id", refund.ID), zap.Int64("amount", refund.Amount))
+	c.JSON(http.StatusOK, refund)
+}
+
+// CreateTip charges a tip on a completed appointment. Tips are separate
+// payments that go to the masseur's connected account in full, without an
+// application fee.
+func (h *PaymentHandler) CreateTip(c *gin.Context) {
+	var request struct {
+		AppointmentID int   `json:"appointment_id" binding:"required"`
+		Amount        int64 `json:"amount" binding:"required,gt=0"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	org := currentOrganization(c)
+	if org == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
+		return
+	}
+
+	principal, ok := CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
 	}
-	intent, err := paymentintent.New(params)
+
+	// Tips follow the payment the client made for the session, in its
+	// currency, whatever the catalog says today.
+	ctx := c.Request.Context()
+	paid, err := h.Repo.ListByAppointment(ctx, request.AppointmentID, []string{"paid", "partially_refunded"})
 	if err != nil {
-		h.Logger.Error("Stripe error", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create payment intent"})
+		c.Error(fmt.Errorf("appointment %d payments: %w", request.AppointmentID, err))
+		return
+	}
+	if len(paid) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No paid appointment found"})
+		return
+	}
+	payment, err := h.Repo.Get(ctx, paid[0].ID)
+	if err != nil {
+		c.Error(fmt.Errorf("payment %d: %w", paid[0].ID, err))
+		return
+	}
+	if payment.ClientID != principal.UserID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No paid appointment found"})
+		return
+	}
+	tipCurrency := payment.Currency
+	if err := currency.Validate(tipCurrency, request.Amount); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	_, err = h.DB.Exec(`
-		INSERT INTO payments (appointment_id, amount, currency, status, stripe_payment_id, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $6)
-	`, request.AppointmentID, request.Amount, request.Currency, "pending", intent.ID, time.Now())
+	appointment, err := h.Repo.Payee(ctx, request.AppointmentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Appointment not found"})
+		return
+	}
+	if appointment.AppointmentStatus != "completed" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Tips can only be given after the session is completed"})
+		return
+	}
+	if appointment.StripeAccountID == nil || !appointment.ChargesEnabled || !appointment.PayoutsEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "This masseur cannot receive tips"})
+		return
+	}
+	destination := *appointment.StripeAccountID
 
+	intent, err := h.Payments.CreatePaymentIntent(ctx, payments.PaymentIntentRequest{
+		Amount:      request.Amount,
+		Currency:    tipCurrency,
+		Destination: destination,
+		Metadata: map[string]string{
+			"appointment_id":  strconv.Itoa(request.AppointmentID),
+			"organization_id": strconv.Itoa(org.ID),
+			"kind":            "tip",
+		},
+		IdempotencyKey: providerIdempotencyKey(c, "tip"),
+	})
 	if err != nil {
+		h.Logger.Error("Payment provider error", zap.String("provider", h.Payments.Name()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tip payment"})
+		return
+	}
+
+	err = h.Repo.Create(ctx, &models.Payment{
+		AppointmentID:      request.AppointmentID,
+		Amount:             request.Amount,
+		Currency:           tipCurrency,
+		Status:             "pending",
+		Kind:               "tip",
+		CaptureMethod:      "automatic",
+		Provider:           h.Payments.Name(),
+		StripePaymentID:    intent.ID,
+		DestinationAccount: destination,
+	})
+	if err != nil && !errors.Is(err, db.ErrDuplicate) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store payment record"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"client_secret": intent.ClientSecret})
+	h.Logger.Info("Tip created", zap.Int("appointment_id", request.AppointmentID), zap.Int64("amount", request.Amount))
+	c.JSON(http.StatusOK, gin.H{
+		"client_secret": intent.ClientSecret,
+		"approval_url":  intent.ApprovalURL,
+		"kind":          "tip",
+		"amount":        request.Amount,
+		"currency":      tipCurrency,
+	})
+}
+
+func (h *PaymentHandler) handleRefundUpdate(ctx context.Context, event *payments.Event) error {
+	refund := event.Refund
+
+	refundID, err := strconv.Atoi(refund.Metadata["refund_id"])
+	if err != nil {
+		// Refunds issued outside Harmonia (e.g. from the Stripe dashboard)
+		// are recorded the first time we hear about them.
+		refundID, err = h.Repo.RecordProviderRefund(ctx, refund.PaymentID, refund.RefundID, refund.Status, refund.Amount)
+		if err != nil {
+			return fmt.Errorf("record external refund %s: %w", refund.RefundID, err)
+		}
+	}
+
+	if err := h.Repo.UpdateRefund(ctx, refundID, refund.RefundID, refund.Status, refund.FailureReason); err != nil {
+		return fmt.Errorf("update refund %d: %w", refundID, err)
+	}
+	h.Logger.Info("Refund updated", zap.Int("refund_id", refundID), zap.String("status", refund.Status))
+	return nil
+}
+
+func (h *PaymentHandler) handleChargeRefunded(ctx context.Context, event *payments.Event) error {
+	if err := h.Repo.SyncRefundedAmount(ctx, event.Refund.PaymentID, event.Refund.TotalRefunded); err != nil {
+		return fmt.Errorf("sync refunded amount for %s: %w", event.Refund.PaymentID, err)
+	}
+	return nil
 }
 
-func (h *PaymentHandler) HandleStripeWebhook(c *gin.Context) {
-	payload, err := io.ReadAll(c.Request.Body)
+var (
+	errPaymentNotAuthorized = errors.New("payment is not authorized")
+	errCaptureExceedsAmount = errors.New("capture exceeds the authorized amount")
+	errNotOwnPayment        = errors.New("payment is for another masseur's appointment")
+	errProviderFailed       = errors.New("payment provider error")
+	errPaymentNotPaid       = errors.New("payment is not paid")
+	errNothingCharged       = errors.New("nothing was charged through the payment provider")
+)
+
+// CapturePayment captures an authorized payment, in full or, when the
+// session ran short, for less than was authorized.
+func (h *PaymentHandler) CapturePayment(c *gin.Context) {
+	paymentID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
 		return
 	}
 
-	endpointSecret := h.Config.StripeWebhookSecret
-	event, err := webhook.ConstructEvent(payload, c.GetHeader("Stripe-Signature"), endpointSecret)
+	var request struct {
+		Amount int64 `json:"amount"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if request.Amount < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Capture amount must be positive"})
+		return
+	}
+
+	masseurID, ok := settlementCaller(c)
+	if !ok {
+		return
+	}
+	payment, err := h.capture(c.Request.Context(), paymentID, request.Amount, masseurID)
+	h.respondSettlement(c, payment, err)
+}
+
+// VoidPayment releases an authorized payment without taking any money.
+func (h *PaymentHandler) VoidPayment(c *gin.Context) {
+	paymentID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook signature"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
 		return
 	}
 
-	switch event.Type {
-	case "payment_intent.succeeded":
-		var intent stripe.PaymentIntent
-		if err := json.Unmarshal(event.Data.Raw, &intent); err == nil {
-			h.handleSuccessfulPayment(intent)
+	masseurID, ok := settlementCaller(c)
+	if !ok {
+		return
+	}
+	payment, err := h.void(c.Request.Context(), paymentID, masseurID)
+	h.respondSettlement(c, payment, err)
+}
+
+// SettleAppointment captures the authorized payments of an appointment that
+// was completed and voids those of one that was canceled. Other statuses
+// leave them alone.
+func (h *PaymentHandler) SettleAppointment(ctx context.Context, appointmentID int, status string) error {
+	if status != "completed" && status != "canceled" {
+		return nil
+	}
+
+	authorized, err := h.Repo.ListByAppointment(ctx, appointmentID, []string{"authorized"})
+	if err != nil {
+		return fmt.Errorf("authorized payments: %w", err)
+	}
+
+	var errs []error
+	for _, payment := range authorized {
+		var err error
+		if status == "completed" {
+			_, err = h.capture(ctx, payment.ID, 0, 0)
+		} else {
+			_, err = h.void(ctx, payment.ID, 0)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("payment %d: %w", payment.ID, err))
 		}
 	}
+	return errors.Join(errs...)
+}
 
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+// settlementCaller returns, for masseurs, the masseur whose appointments
+// the caller may settle. On failure it writes the response and returns
+// false.
+func settlementCaller(c *gin.Context) (int, bool) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return 0, false
+	}
+	if _, ok := db.TenantFromContext(c.Request.Context()); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
+		return 0, false
+	}
+	if principal.Role == "masseur" {
+		return principal.UserID, true
+	}
+	return 0, true
 }
 
-func (h *PaymentHandler) handleSuccessfulPayment(intent stripe.PaymentIntent) {
-	appointmentID := intent.Metadata["appointment_id"]
-	_, err := h.DB.Exec(`
-		UPDATE payments
-		SET status = 'paid', stripe_payment_id = $1, updated_at = NOW()
-		WHERE appointment_id = $2
-	`, intent.ID, appointmentID)
+func (h *PaymentHandler) respondSettlement(c *gin.Context, payment *models.Payment, err error) {
+	switch {
+	case err == nil:
+		c.JSON(http.StatusOK, payment)
+	case errors.Is(err, db.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+	case errors.Is(err, errNotOwnPayment):
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only settle payments for your own appointments"})
+	case errors.Is(err, errPaymentNotAuthorized):
+		c.JSON(http.StatusConflict, gin.H{"error": "Only authorized payments can be captured or voided"})
+	case errors.Is(err, errCaptureExceedsAmount):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Capture exceeds the authorized amount"})
+	case errors.Is(err, errProviderFailed):
+		h.Logger.Error("Payment provider settlement error", zap.String("payment_id", c.Param("id")), zap.Error(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Payment provider rejected the request"})
+	default:
+		c.Error(fmt.Errorf("settle payment: %w", err))
+	}
+}
 
+// capture captures an authorized payment for amount, or in full when amount
+// is 0, scaling the platform fee down with a partial capture. A non-zero
+// masseurID restricts it to that masseur's appointments. The payment stays
+// locked during the provider call so a capture and a void cannot race.
+func (h *PaymentHandler) capture(ctx context.Context, paymentID int, amount int64, masseurID int) (*models.Payment, error) {
+	payment, err := h.Repo.Settle(ctx, paymentID, func(payment *models.AppointmentPayment) error {
+		if masseurID != 0 && payment.MasseurID != masseurID {
+			return errNotOwnPayment
+		}
+		if payment.Status != "authorized" {
+			return errPaymentNotAuthorized
+		}
+		if amount == 0 {
+			amount = payment.Amount
+		}
+		if amount > payment.Amount {
+			return errCaptureExceedsAmount
+		}
+		fee := payment.ApplicationFee
+		if amount < payment.Amount {
+			fee = int64(math.Round(float64(fee) * float64(amount) / float64(payment.Amount)))
+		}
+
+		_, err := h.Payments.CapturePayment(ctx, payments.CaptureRequest{
+			PaymentID:      payment.StripePaymentID,
+			Amount:         amount,
+			ApplicationFee: fee,
+			IdempotencyKey: fmt.Sprintf("capture-%d-%d", payment.ID, amount),
+		})
+		if err != nil {
+			return fmt.Errorf("%w: %v", errProviderFailed, err)
+		}
+
+		payment.Amount, payment.ApplicationFee, payment.Status = amount, fee, "paid"
+		return h.settleDiscounts(ctx, payment.ID, payment.Status)
+	})
 	if err != nil {
-		h.Logger.Error("Failed to update payment record", zap.Error(err))
-	} else {
-		h.Logger.Info("Payment successful for appointment", zap.String("appointment_id", appointmentID))
+		return nil, err
 	}
-}
\ No newline at end of file
+
+	h.Logger.Info("Payment captured", zap.Int("payment_id", payment.ID), zap.Int64("amount", amount))
+	return payment, nil
+}
+
+// void cancels an authorized payment. A non-zero masseurID restricts it to
+// that masseur's appointments.
+func (h *PaymentHandler) void(ctx context.Context, paymentID, masseurID int) (*models.Payment, error) {
+	payment, err := h.Repo.Settle(ctx, paymentID, func(payment *models.AppointmentPayment) error {
+		if masseurID != 0 && payment.MasseurID != masseurID {
+			return errNotOwnPayment
+		}
+		if payment.Status != "authorized" {
+			return errPaymentNotAuthorized
+		}
+
+		if _, err := h.Payments.CancelPayment(ctx, payment.StripePaymentID); err != nil {
+			return fmt.Errorf("%w: %v", errProviderFailed, err)
+		}
+		payment.Status = "canceled"
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Releasing the gift card hold references the payment, which would wait
+	// on our row lock, so it happens once the lock is gone.
+	if err := h.settleDiscounts(ctx, payment.ID, payment.Status); err != nil {
+		h.Logger.Error("Failed to settle payment discounts", zap.Int("payment_id", payment.ID), zap.Error(err))
+	}
+
+	h.Logger.Info("Payment voided", zap.Int("payment_id", payment.ID))
+	return payment, nil
+}
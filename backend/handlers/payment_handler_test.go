@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/db/memory"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+)
+
+var (
+	testOrg     = &models.Organization{ID: 1, Slug: "spa"}
+	testAdmin   = &Principal{UserID: 1, Role: "admin"}
+	testClient  = &Principal{UserID: 10, Role: "client"}
+	testMasseur = &Principal{UserID: 20, Role: "masseur"}
+)
+
+// newTestPaymentHandler returns a payment handler backed by the in-memory
+// repository, with appointment 100 between testClient and testMasseur and
+// appointment 200 between client 11 and masseur 21.
+func newTestPaymentHandler(t *testing.T) (*PaymentHandler, *memory.PaymentRepository, *stubAdapter) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	repo := memory.NewPaymentRepository()
+	repo.AddAppointment(models.Appointment{ID: 100, OrganizationID: testOrg.ID, ClientID: 10, MasseurID: 20, Status: "confirmed"}, models.User{ID: 20})
+	repo.AddAppointment(models.Appointment{ID: 200, OrganizationID: testOrg.ID, ClientID: 11, MasseurID: 21, Status: "confirmed"}, models.User{ID: 21})
+	adapter := &stubAdapter{}
+	h := NewPaymentHandler(repo, nil, nil, nil, stubPromos{}, stubGiftCards{}, adapter, nil, zap.NewNop())
+	return h, repo, adapter
+}
+
+// addPayment records a payment in testOrg and returns its ID.
+func addPayment(t *testing.T, repo *memory.PaymentRepository, payment models.Payment) int {
+	t.Helper()
+	ctx := db.WithTenant(context.Background(), testOrg.ID)
+	if err := repo.Create(ctx, &payment); err != nil {
+		t.Fatalf("create payment: %v", err)
+	}
+	return payment.ID
+}
+
+func getPayment(t *testing.T, repo *memory.PaymentRepository, id int) *models.AppointmentPayment {
+	t.Helper()
+	payment, err := repo.Get(db.WithTenant(context.Background(), testOrg.ID), id)
+	if err != nil {
+		t.Fatalf("get payment %d: %v", id, err)
+	}
+	return payment
+}
+
+func TestRefundPaymentReservesTheRefundedAmount(t *testing.T) {
+	h, repo, adapter := newTestPaymentHandler(t)
+	id := addPayment(t, repo, models.Payment{AppointmentID: 100, Amount: 1000, Currency: "usd", Status: "paid", Kind: "full", Provider: "stripe", StripePaymentID: "pi_1"})
+	refund := func(amount int64) *httptest.ResponseRecorder {
+		return serveAs(h.RefundPayment, "/payments/:id/refund", testAdmin, testOrg, http.MethodPost, "/payments/"+strconv.Itoa(id)+"/refund", gin.H{"amount": amount})
+	}
+
+	if rec := refund(600); rec.Code != http.StatusOK {
+		t.Fatalf("first refund: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec := refund(600)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("refund over the rest: status = %d, want %d: %s", rec.Code, http.StatusBadRequest, rec.Body.String())
+	}
+	var body struct {
+		Refundable int64 `json:"refundable"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || body.Refundable != 400 {
+		t.Errorf("refundable = %d (%v), want 400", body.Refundable, err)
+	}
+
+	// A refund the provider rejects gives its reservation back.
+	adapter.err = errors.New("provider down")
+	if rec := refund(400); rec.Code != http.StatusBadGateway {
+		t.Fatalf("rejected refund: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	adapter.err = nil
+	if rec := refund(400); rec.Code != http.StatusOK {
+		t.Fatalf("refund of the rest: status = %d: %s", rec.Code, rec.Body.String())
+	}
+
+	if len(adapter.refunds) != 2 {
+		t.Errorf("provider refunds = %d, want 2", len(adapter.refunds))
+	}
+	payment := getPayment(t, repo, id)
+	if payment.Status != "refunded" || payment.RefundedAmount != 1000 {
+		t.Errorf("payment status %q refunded %d, want refunded 1000", payment.Status, payment.RefundedAmount)
+	}
+}
+
+func TestRefundPaymentOfAnotherMasseur(t *testing.T) {
+	h, repo, adapter := newTestPaymentHandler(t)
+	id := addPayment(t, repo, models.Payment{AppointmentID: 200, Amount: 1000, Currency: "usd", Status: "paid", Kind: "full", Provider: "stripe", StripePaymentID: "pi_1"})
+
+	rec := serveAs(h.RefundPayment, "/payments/:id/refund", testMasseur, testOrg, http.MethodPost, "/payments/"+strconv.Itoa(id)+"/refund", gin.H{})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d: %s", rec.Code, http.StatusForbidden, rec.Body.String())
+	}
+	if len(adapter.refunds) != 0 || len(repo.Refunds(id)) != 0 {
+		t.Errorf("refund recorded for another masseur's payment")
+	}
+}
+
+func TestCapturePayment(t *testing.T) {
+	h, repo, adapter := newTestPaymentHandler(t)
+	id := addPayment(t, repo, models.Payment{AppointmentID: 100, Amount: 1000, Currency: "usd", Status: "authorized", Kind: "full", CaptureMethod: "manual", Provider: "stripe", StripePaymentID: "pi_1", ApplicationFee: 100})
+	other := addPayment(t, repo, models.Payment{AppointmentID: 200, Amount: 1000, Currency: "usd", Status: "authorized", Kind: "full", CaptureMethod: "manual", Provider: "stripe", StripePaymentID: "pi_2"})
+	capture := func(principal *Principal, id int, amount int64) *httptest.ResponseRecorder {
+		return serveAs(h.CapturePayment, "/payments/:id/capture", principal, testOrg, http.MethodPost, "/payments/"+strconv.Itoa(id)+"/capture", gin.H{"amount": amount})
+	}
+
+	if rec := capture(testMasseur, other, 0); rec.Code != http.StatusForbidden {
+		t.Errorf("capture of another masseur's payment: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if rec := capture(testMasseur, id, 1500); rec.Code != http.StatusBadRequest {
+		t.Errorf("capture over the authorized amount: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+	if rec := capture(testMasseur, id, 500); rec.Code != http.StatusOK {
+		t.Fatalf("partial capture: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := capture(testAdmin, id, 0); rec.Code != http.StatusConflict {
+		t.Errorf("second capture: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	if len(adapter.captures) != 1 {
+		t.Fatalf("provider captures = %d, want 1", len(adapter.captures))
+	}
+	if got := adapter.captures[0]; got.Amount != 500 || got.ApplicationFee != 50 {
+		t.Errorf("captured %d with fee %d, want 500 with fee 50", got.Amount, got.ApplicationFee)
+	}
+	payment := getPayment(t, repo, id)
+	if payment.Status != "paid" || payment.Amount != 500 || payment.ApplicationFee != 50 {
+		t.Errorf("payment status %q amount %d fee %d, want paid 500 fee 50", payment.Status, payment.Amount, payment.ApplicationFee)
+	}
+}
+
+func TestVoidPayment(t *testing.T) {
+	h, repo, adapter := newTestPaymentHandler(t)
+	id := addPayment(t, repo, models.Payment{AppointmentID: 100, Amount: 1000, Currency: "usd", Status: "authorized", Kind: "full", CaptureMethod: "manual", Provider: "stripe", StripePaymentID: "pi_1"})
+	void := func() *httptest.ResponseRecorder {
+		return serveAs(h.VoidPayment, "/payments/:id/void", testMasseur, testOrg, http.MethodPost, "/payments/"+strconv.Itoa(id)+"/void", nil)
+	}
+
+	adapter.err = errors.New("provider down")
+	if rec := void(); rec.Code != http.StatusBadGateway {
+		t.Fatalf("rejected void: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if status := getPayment(t, repo, id).Status; status != "authorized" {
+		t.Fatalf("status after rejected void = %q, want authorized", status)
+	}
+
+	adapter.err = nil
+	if rec := void(); rec.Code != http.StatusOK {
+		t.Fatalf("void: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := void(); rec.Code != http.StatusConflict {
+		t.Errorf("second void: status = %d, want %d", rec.Code, http.StatusConflict)
+	}
+	if len(adapter.cancels) != 1 || adapter.cancels[0] != "pi_1" {
+		t.Errorf("provider cancels = %v, want [pi_1]", adapter.cancels)
+	}
+	if status := getPayment(t, repo, id).Status; status != "canceled" {
+		t.Errorf("status = %q, want canceled", status)
+	}
+}
+
+func TestSettleAppointmentCapturesOrVoids(t *testing.T) {
+	h, repo, adapter := newTestPaymentHandler(t)
+	held := addPayment(t, repo, models.Payment{AppointmentID: 100, Amount: 1000, Currency: "usd", Status: "authorized", Kind: "full", CaptureMethod: "manual", Provider: "stripe", StripePaymentID: "pi_1"})
+	otherHeld := addPayment(t, repo, models.Payment{AppointmentID: 200, Amount: 1000, Currency: "usd", Status: "authorized", Kind: "full", CaptureMethod: "manual", Provider: "stripe", StripePaymentID: "pi_2"})
+	ctx := db.WithTenant(context.Background(), testOrg.ID)
+
+	if err := h.SettleAppointment(ctx, 100, "confirmed"); err != nil || len(adapter.captures)+len(adapter.cancels) != 0 {
+		t.Fatalf("settling a confirmed appointment: err %v, captures %d, cancels %d", err, len(adapter.captures), len(adapter.cancels))
+	}
+	if err := h.SettleAppointment(ctx, 100, "completed"); err != nil {
+		t.Fatalf("SettleAppointment completed: %v", err)
+	}
+	if err := h.SettleAppointment(ctx, 200, "canceled"); err != nil {
+		t.Fatalf("SettleAppointment canceled: %v", err)
+	}
+
+	if status := getPayment(t, repo, held).Status; status != "paid" {
+		t.Errorf("payment of the completed appointment is %q, want paid", status)
+	}
+	if status := getPayment(t, repo, otherHeld).Status; status != "canceled" {
+		t.Errorf("payment of the canceled appointment is %q, want canceled", status)
+	}
+}
+
+func TestListPaymentsIsScopedToTheCaller(t *testing.T) {
+	h, repo, _ := newTestPaymentHandler(t)
+	own := addPayment(t, repo, models.Payment{AppointmentID: 100, Amount: 1000, Currency: "usd", Status: "paid", Kind: "full", Provider: "stripe", StripePaymentID: "pi_1"})
+	other := addPayment(t, repo, models.Payment{AppointmentID: 200, Amount: 2000, Currency: "usd", Status: "paid", Kind: "full", Provider: "stripe", StripePaymentID: "pi_2"})
+
+	tests := []struct {
+		name      string
+		principal *Principal
+		query     string
+		want      []int
+		wantCode  int
+	}{
+		{name: "client", principal: testClient, want: []int{own}},
+		{name: "client filtering by another client", principal: testClient, query: "?client_id=11", want: []int{own}},
+		{name: "masseur", principal: testMasseur, want: []int{own}},
+		{name: "masseur filtering by another masseur", principal: testMasseur, query: "?masseur_id=21", want: []int{own}},
+		{name: "admin", principal: testAdmin, want: []int{other, own}},
+		{name: "admin filtering by masseur", principal: testAdmin, query: "?masseur_id=21", want: []int{other}},
+		{name: "unknown role", principal: &Principal{UserID: 30, Role: "guest"}, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serveAs(h.ListPayments, "/payments", tt.principal, testOrg, http.MethodGet, "/payments"+tt.query, nil)
+			wantCode := tt.wantCode
+			if wantCode == 0 {
+				wantCode = http.StatusOK
+			}
+			if rec.Code != wantCode {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, wantCode, rec.Body.String())
+			}
+			if wantCode != http.StatusOK {
+				return
+			}
+
+			var entries []models.PaymentHistoryEntry
+			if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			var got []int
+			for _, entry := range entries {
+				got = append(got, entry.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("payments = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisputeRestoresThePaymentAndAppointment(t *testing.T) {
+	h, repo, _ := newTestPaymentHandler(t)
+	won := addPayment(t, repo, models.Payment{AppointmentID: 100, Amount: 1000, RefundedAmount: 300, Currency: "usd", Status: "partially_refunded", Kind: "full", Provider: "stripe", StripePaymentID: "pi_1"})
+	lost := addPayment(t, repo, models.Payment{AppointmentID: 200, Amount: 1000, Currency: "usd", Status: "paid", Kind: "full", Provider: "stripe", StripePaymentID: "pi_2"})
+	dispute := func(eventType, paymentID, disputeID, status string) {
+		t.Helper()
+		err := h.handleDispute(context.Background(), &payments.Event{Type: eventType, Dispute: &payments.DisputeEvent{
+			DisputeID: disputeID,
+			PaymentID: paymentID,
+			Status:    status,
+		}})
+		if err != nil {
+			t.Fatalf("%s %s: %v", eventType, status, err)
+		}
+	}
+	check := func(step string, paymentID, appointmentID int, wantPayment, wantAppointment string) {
+		t.Helper()
+		if got := getPayment(t, repo, paymentID).Status; got != wantPayment {
+			t.Errorf("%s: payment is %q, want %q", step, got, wantPayment)
+		}
+		if got := repo.AppointmentStatus(appointmentID); got != wantAppointment {
+			t.Errorf("%s: appointment is %q, want %q", step, got, wantAppointment)
+		}
+	}
+
+	dispute(payments.EventDisputeCreated, "pi_1", "dp_1", "needs_response")
+	check("opened", won, 100, "disputed", "disputed")
+	dispute(payments.EventDisputeClosed, "pi_1", "dp_1", "won")
+	check("won", won, 100, "partially_refunded", "confirmed")
+
+	// Late and replayed events leave the closed dispute alone.
+	dispute(payments.EventDisputeCreated, "pi_1", "dp_1", "needs_response")
+	dispute(payments.EventDisputeClosed, "pi_1", "dp_1", "won")
+	check("after replays", won, 100, "partially_refunded", "confirmed")
+
+	dispute(payments.EventDisputeCreated, "pi_2", "dp_2", "needs_response")
+	dispute(payments.EventDisputeClosed, "pi_2", "dp_2", "lost")
+	check("lost", lost, 200, "dispute_lost", "dispute_lost")
+	dispute(payments.EventDisputeCreated, "pi_2", "dp_2", "needs_response")
+	check("lost after replay", lost, 200, "dispute_lost", "dispute_lost")
+}
+
+func TestCreateTipFollowsThePaidPayment(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := memory.NewPaymentRepository()
+	account := "acct_20"
+	masseur := models.User{ID: 20, StripeAccountID: &account, ChargesEnabled: true, PayoutsEnabled: true}
+	repo.AddAppointment(models.Appointment{ID: 100, OrganizationID: testOrg.ID, ClientID: 10, MasseurID: 20, Status: "completed"}, masseur)
+	repo.AddAppointment(models.Appointment{ID: 200, OrganizationID: testOrg.ID, ClientID: 11, MasseurID: 20, Status: "completed"}, masseur)
+	adapter := &stubAdapter{}
+	h := NewPaymentHandler(repo, nil, nil, nil, stubPromos{}, stubGiftCards{}, adapter, nil, zap.NewNop())
+	tip := func(appointmentID int) *httptest.ResponseRecorder {
+		return serveAs(h.CreateTip, "/payments/tip", testClient, testOrg, http.MethodPost, "/payments/tip", gin.H{"appointment_id": appointmentID, "amount": 500})
+	}
+
+	if rec := tip(100); rec.Code != http.StatusNotFound {
+		t.Errorf("tip before the session was paid: status = %d, want %d: %s", rec.Code, http.StatusNotFound, rec.Body.String())
+	}
+
+	// The session was paid in euros, whatever the catalog charges now.
+	addPayment(t, repo, models.Payment{AppointmentID: 100, Amount: 1000, Currency: "eur", Status: "paid", Kind: "full", Provider: "stripe", StripePaymentID: "pi_paid"})
+	addPayment(t, repo, models.Payment{AppointmentID: 200, Amount: 1000, Currency: "eur", Status: "paid", Kind: "full", Provider: "stripe", StripePaymentID: "pi_other"})
+
+	if rec := tip(200); rec.Code != http.StatusNotFound {
+		t.Errorf("tip for another client's session: status = %d, want %d: %s", rec.Code, http.StatusNotFound, rec.Body.String())
+	}
+	rec := tip(100)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("tip: status = %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(adapter.intents) != 1 || adapter.intents[0].Currency != "eur" || adapter.intents[0].Destination != account {
+		t.Fatalf("provider intents = %+v, want one in eur to %s", adapter.intents, account)
+	}
+
+	tips, err := repo.List(db.WithTenant(context.Background(), testOrg.ID), map[string]string{"appointment_id": "100"}, 10, 0)
+	if err != nil {
+		t.Fatalf("list payments: %v", err)
+	}
+	found := false
+	for _, p := range tips {
+		if p.Kind == "tip" {
+			found = true
+			if p.Currency != "eur" || p.Amount != 500 {
+				t.Errorf("tip recorded as %d %s, want 500 eur", p.Amount, p.Currency)
+			}
+		}
+	}
+	if !found {
+		t.Error("tip was not recorded")
+	}
+}
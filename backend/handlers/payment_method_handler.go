@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+)
+
+// PaymentMethodHandler lets clients save payment methods with the provider
+// so that later appointments can be paid for without entering them again.
+type PaymentMethodHandler struct {
+	Users    db.UserRepository
+	Payments payments.Adapter
+	Logger   *zap.Logger
+}
+
+func NewPaymentMethodHandler(users db.UserRepository, adapter payments.Adapter, logger *zap.Logger) *PaymentMethodHandler {
+	return &PaymentMethodHandler{
+		Users:    users,
+		Payments: adapter,
+		Logger:   logger,
+	}
+}
+
+// SetupPaymentMethod starts saving a payment method for the calling client.
+// The returned client secret confirms the setup in the browser.
+func (h *PaymentMethodHandler) SetupPaymentMethod(c *gin.Context) {
+	user, ok := h.currentClient(c)
+	if !ok {
+		return
+	}
+
+	customerID, ok := h.ensureCustomer(c, user)
+	if !ok {
+		return
+	}
+
+	setup, err := h.Payments.CreateSetupIntent(c.Request.Context(), customerID, providerIdempotencyKey(c, "setup-intent"))
+	if err != nil {
+		h.providerError(c, "Failed to start saving the payment method", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"setup_intent_id": setup.ID, "client_secret": setup.ClientSecret, "status": setup.Status})
+}
+
+func (h *PaymentMethodHandler) ListPaymentMethods(c *gin.Context) {
+	user, ok := h.currentClient(c)
+	if !ok {
+		return
+	}
+	if user.StripeCustomerID == nil {
+		c.JSON(http.StatusOK, []payments.PaymentMethod{})
+		return
+	}
+
+	methods, err := h.Payments.ListPaymentMethods(c.Request.Context(), *user.StripeCustomerID)
+	if err != nil {
+		h.providerError(c, "Failed to load saved payment methods", err)
+		return
+	}
+	if methods == nil {
+		methods = []payments.PaymentMethod{}
+	}
+
+	c.JSON(http.StatusOK, methods)
+}
+
+func (h *PaymentMethodHandler) RemovePaymentMethod(c *gin.Context) {
+	user, ok := h.currentClient(c)
+	if !ok {
+		return
+	}
+	if user.StripeCustomerID == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment method not found"})
+		return
+	}
+
+	err := h.Payments.DetachPaymentMethod(c.Request.Context(), *user.StripeCustomerID, c.Param("id"))
+	if errors.Is(err, payments.ErrPaymentMethodNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment method not found"})
+		return
+	}
+	if err != nil {
+		h.providerError(c, "Failed to remove the payment method", err)
+		return
+	}
+
+	h.Logger.Info("Payment method removed", zap.Int("user_id", user.ID), zap.String("payment_method_id", c.Param("id")))
+	c.Status(http.StatusNoContent)
+}
+
+// ensureCustomer returns the client's provider customer, creating it on
+// first use. Concurrent first calls may both create one; only the first to
+// be stored is used.
+func (h *PaymentMethodHandler) ensureCustomer(c *gin.Context, user *models.User) (string, bool) {
+	if user.StripeCustomerID != nil {
+		return *user.StripeCustomerID, true
+	}
+
+	customerID, err := h.Payments.CreateCustomer(c.Request.Context(), payments.CustomerRequest{
+		Email: user.Email,
+		Metadata: map[string]string{
+			"user_id":         strconv.Itoa(user.ID),
+			"organization_id": strconv.Itoa(user.OrganizationID),
+		},
+		IdempotencyKey: fmt.Sprintf("customer-%d-%d", user.OrganizationID, user.ID),
+	})
+	if err != nil {
+		h.providerError(c, "Failed to create payment customer", err)
+		return "", false
+	}
+
+	current, err := h.Users.SetCustomer(c.Request.Context(), user.ID, customerID)
+	if err != nil {
+		c.Error(fmt.Errorf("save payment customer: %w", err))
+		return "", false
+	}
+	if current != customerID {
+		h.Logger.Warn("Discarding duplicate payment customer", zap.Int("user_id", user.ID), zap.String("customer_id", customerID))
+	}
+	return current, true
+}
+
+func (h *PaymentMethodHandler) currentClient(c *gin.Context) (*models.User, bool) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	user, err := h.Users.GetByID(c.Request.Context(), principal.UserID)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only clients can save payment methods"})
+		return nil, false
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("load user: %w", err))
+		return nil, false
+	}
+	return user, true
+}
+
+func (h *PaymentMethodHandler) providerError(c *gin.Context, message string, err error) {
+	if errors.Is(err, payments.ErrNotSupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Saved payment methods are not supported by the payment provider"})
+		return
+	}
+	h.Logger.Error(message, zap.String("provider", h.Payments.Name()), zap.Error(err))
+	c.JSON(http.StatusBadGateway, gin.H{"error": message})
+}
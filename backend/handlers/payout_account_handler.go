@@ -0,0 +1,300 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/currency"
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+	"github.com/ozoli99/Harmonia/webhooks"
+)
+
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// PayoutAccountHandler onboards masseurs onto connected payout accounts so
+// that their appointments can be paid out to them directly.
+type PayoutAccountHandler struct {
+	Users    db.UserRepository
+	Payments payments.Adapter
+	Config   *config.Config
+	Logger   *zap.Logger
+}
+
+func NewPayoutAccountHandler(users db.UserRepository, adapter payments.Adapter, cfg *config.Config, logger *zap.Logger) *PayoutAccountHandler {
+	return &PayoutAccountHandler{
+		Users:    users,
+		Payments: adapter,
+		Config:   cfg,
+		Logger:   logger,
+	}
+}
+
+// CreatePayoutAccount starts onboarding for the calling masseur. A masseur
+// who already has an account gets a fresh onboarding link for it instead.
+func (h *PayoutAccountHandler) CreatePayoutAccount(c *gin.Context) {
+	var request struct {
+		Country string `json:"country"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	user, org, ok := h.currentMasseur(c)
+	if !ok {
+		return
+	}
+	if user.StripeAccountID != nil {
+		h.respondWithLink(c, user, org)
+		return
+	}
+
+	country := strings.ToUpper(strings.TrimSpace(request.Country))
+	if country == "" {
+		country = org.Settings.Country
+	}
+	if country == "" {
+		country = h.Config.StripeConnectCountry
+	}
+	if !countryCodePattern.MatchString(country) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "country must be a two-letter ISO 3166-1 code"})
+		return
+	}
+
+	email := user.Email
+	if email == "" {
+		if principal, ok := CurrentPrincipal(c); ok {
+			email = principal.Email
+		}
+	}
+	if email == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Masseur has no email address on file"})
+		return
+	}
+
+	accountRequest := h.onboardingRequest(org)
+	accountRequest.Email = email
+	accountRequest.Country = country
+	accountRequest.IdempotencyKey = providerIdempotencyKey(c, "payout-account")
+
+	acc, err := h.Payments.CreatePayoutAccount(c.Request.Context(), accountRequest)
+	if acc == nil {
+		h.providerError(c, "Failed to create payout account", err)
+		return
+	}
+
+	if dbErr := h.Users.SetPayoutAccount(c.Request.Context(), user.ID, acc.ID, country); dbErr != nil {
+		h.Logger.Error("Failed to save payout account", zap.Int("user_id", user.ID), zap.String("account_id", acc.ID), zap.Error(dbErr))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save payout account"})
+		return
+	}
+	if err != nil {
+		// The account exists; the masseur can ask for a new link.
+		h.providerError(c, "Payout account created but no onboarding link could be issued", err)
+		return
+	}
+
+	h.Logger.Info("Payout account created", zap.Int("user_id", user.ID), zap.String("account_id", acc.ID))
+	c.JSON(http.StatusCreated, gin.H{"account_id": acc.ID, "onboarding_url": acc.OnboardingURL})
+}
+
+// RegenerateOnboardingLink issues a new onboarding link. Links expire after
+// a few minutes and Stripe sends masseurs with an expired link to the
+// refresh URL, which should call this endpoint.
+func (h *PayoutAccountHandler) RegenerateOnboardingLink(c *gin.Context) {
+	user, org, ok := h.currentMasseur(c)
+	if !ok {
+		return
+	}
+	if user.StripeAccountID == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No payout account; create one first"})
+		return
+	}
+
+	h.respondWithLink(c, user, org)
+}
+
+// GetPayoutAccount returns the onboarding state of the calling masseur,
+// refreshed from the provider when it is reachable.
+func (h *PayoutAccountHandler) GetPayoutAccount(c *gin.Context) {
+	user, org, ok := h.currentMasseur(c)
+	if !ok {
+		return
+	}
+	if user.StripeAccountID == nil {
+		c.JSON(http.StatusOK, gin.H{"status": "not_started", "payout_ready": org.StripeAccountID != ""})
+		return
+	}
+
+	acc, err := h.Payments.GetPayoutAccount(c.Request.Context(), *user.StripeAccountID)
+	if err != nil {
+		h.Logger.Warn("Failed to refresh payout account", zap.String("account_id", *user.StripeAccountID), zap.Error(err))
+	} else {
+		user.ChargesEnabled, user.PayoutsEnabled, user.DetailsSubmitted = acc.ChargesEnabled, acc.PayoutsEnabled, acc.DetailsSubmitted
+		if err := h.Users.UpdatePayoutStatus(c.Request.Context(), acc.ID, acc.ChargesEnabled, acc.PayoutsEnabled, acc.DetailsSubmitted); err != nil {
+			h.Logger.Error("Failed to store payout account status", zap.String("account_id", acc.ID), zap.Error(err))
+		}
+	}
+
+	status := "pending"
+	if user.PayoutReady() {
+		status = "active"
+	} else if user.DetailsSubmitted {
+		status = "restricted"
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":            status,
+		"account_id":        *user.StripeAccountID,
+		"country":           user.Country,
+		"currency":          masseurCurrency(user, org),
+		"charges_enabled":   user.ChargesEnabled,
+		"payouts_enabled":   user.PayoutsEnabled,
+		"details_submitted": user.DetailsSubmitted,
+		"payout_ready":      user.PayoutReady(),
+	})
+}
+
+// SetMasseurCurrency sets the currency a masseur takes payments in. An empty
+// currency falls back to the organization's.
+func (h *PayoutAccountHandler) SetMasseurCurrency(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid masseur ID"})
+		return
+	}
+	var request struct {
+		Currency string `json:"currency"`
+	}
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	request.Currency = currency.Normalize(request.Currency)
+	if request.Currency != "" && !currency.Supported(request.Currency) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Unsupported currency", "supported": currency.Codes()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user, err := h.Users.GetByID(ctx, id)
+	if errors.Is(err, db.ErrNotFound) || (err == nil && user.Role != "masseur") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Masseur not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("load masseur: %w", err))
+		return
+	}
+	if err := h.Users.SetCurrency(ctx, id, request.Currency); err != nil {
+		c.Error(fmt.Errorf("set masseur currency: %w", err))
+		return
+	}
+	user.Currency = request.Currency
+
+	h.Logger.Info("Set masseur currency", zap.Int("masseur_id", id), zap.String("currency", request.Currency))
+	c.JSON(http.StatusOK, gin.H{"masseur_id": id, "currency": masseurCurrency(user, currentOrganization(c))})
+}
+
+// masseurCurrency is the currency user takes payments in: their own, or
+// else the organization's.
+func masseurCurrency(user *models.User, org *models.Organization) string {
+	if user.Currency != "" || org == nil {
+		return user.Currency
+	}
+	return org.Settings.Currency
+}
+
+// RegisterWebhookHandlers wires the payout account event handlers into p.
+func (h *PayoutAccountHandler) RegisterWebhookHandlers(p *webhooks.Processor) {
+	p.Handle(payments.EventAccountUpdated, h.handleAccountUpdated)
+}
+
+func (h *PayoutAccountHandler) handleAccountUpdated(ctx context.Context, event *payments.Event) error {
+	acc := event.Account
+	if err := h.Users.UpdatePayoutStatus(ctx, acc.ID, acc.ChargesEnabled, acc.PayoutsEnabled, acc.DetailsSubmitted); err != nil {
+		return fmt.Errorf("update payout account %s: %w", acc.ID, err)
+	}
+
+	h.Logger.Info("Payout account updated",
+		zap.String("account_id", acc.ID),
+		zap.Bool("charges_enabled", acc.ChargesEnabled),
+		zap.Bool("payouts_enabled", acc.PayoutsEnabled),
+	)
+	return nil
+}
+
+// currentMasseur loads the calling masseur. On failure it writes the
+// response and returns false.
+func (h *PayoutAccountHandler) currentMasseur(c *gin.Context) (*models.User, *models.Organization, bool) {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, nil, false
+	}
+
+	org := currentOrganization(c)
+	if org == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
+		return nil, nil, false
+	}
+
+	user, err := h.Users.GetByID(c.Request.Context(), principal.UserID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		h.Logger.Error("Failed to load user", zap.Int("user_id", principal.UserID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+		return nil, nil, false
+	}
+	if err != nil || user.Role != "masseur" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only masseurs can manage payout accounts"})
+		return nil, nil, false
+	}
+	return user, org, true
+}
+
+func (h *PayoutAccountHandler) respondWithLink(c *gin.Context, user *models.User, org *models.Organization) {
+	url, err := h.Payments.CreatePayoutAccountLink(c.Request.Context(), *user.StripeAccountID, h.onboardingRequest(org))
+	if err != nil {
+		h.providerError(c, "Failed to create onboarding link", err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"account_id": *user.StripeAccountID, "onboarding_url": url})
+}
+
+// onboardingRequest fills in the refresh and return URLs, preferring the
+// organization's own over the deployment defaults.
+func (h *PayoutAccountHandler) onboardingRequest(org *models.Organization) payments.PayoutAccountRequest {
+	req := payments.PayoutAccountRequest{
+		RefreshURL: h.Config.StripeConnectRefreshURL,
+		ReturnURL:  h.Config.StripeConnectReturnURL,
+	}
+	if org.Settings.ConnectRefreshURL != "" {
+		req.RefreshURL = org.Settings.ConnectRefreshURL
+	}
+	if org.Settings.ConnectReturnURL != "" {
+		req.ReturnURL = org.Settings.ConnectReturnURL
+	}
+	return req
+}
+
+func (h *PayoutAccountHandler) providerError(c *gin.Context, message string, err error) {
+	if errors.Is(err, payments.ErrNotSupported) {
+		c.JSON(http.StatusNotImplemented, gin.H{"error": "Payout accounts are not supported by the payment provider"})
+		return
+	}
+	h.Logger.Error(message, zap.String("provider", h.Payments.Name()), zap.Error(err))
+	c.JSON(http.StatusBadGateway, gin.H{"error": message})
+}
@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+const principalKey = "principal"
+
+// Principal is the authenticated caller of a request. UserID is our internal
+// user_profiles ID; ExternalID is the auth provider's subject. API key callers
+// have no user and carry the key instead.
+type Principal struct {
+	UserID         int
+	ExternalID     string
+	Email          string
+	Role           string
+	RealExternalID string
+	Impersonating  bool
+	APIKey         *models.APIKey
+}
+
+func (p *Principal) IsService() bool {
+	return p.APIKey != nil
+}
+
+// CurrentPrincipal returns the caller set by IdentityMiddleware.
+func CurrentPrincipal(c *gin.Context) (*Principal, bool) {
+	principalIfc, exists := c.Get(principalKey)
+	if !exists {
+		return nil, false
+	}
+	principal, ok := principalIfc.(*Principal)
+	return principal, ok
+}
+
+// IdentityMiddleware maps the authenticated subject to their profile in the
+// organization and exposes the result through CurrentPrincipal. Profiles are
+// created by the Clerk webhooks, never by requests; callers without one are
+// refused. The role is the one held in the organization.
+func IdentityMiddleware(users db.UserRepository, logger *zap.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := &Principal{
+			ExternalID:     c.GetString("user_id"),
+			Email:          c.GetString("user_email"),
+			Role:           c.GetString("user_role"),
+			RealExternalID: c.GetString("real_user_id"),
+			Impersonating:  c.GetBool("impersonating"),
+		}
+		if principal.RealExternalID == "" {
+			principal.RealExternalID = principal.ExternalID
+		}
+
+		if keyIfc, exists := c.Get("api_key"); exists {
+			principal.APIKey = keyIfc.(*models.APIKey)
+			c.Set(principalKey, principal)
+			c.Next()
+			return
+		}
+
+		if principal.ExternalID == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			c.Abort()
+			return
+		}
+
+		user := models.User{
+			ExternalID: principal.ExternalID,
+			Email:      principal.Email,
+		}
+		var err error
+		if principal.Impersonating {
+			// Never modify profiles on behalf of an impersonated user.
+			var existing *models.User
+			existing, err = users.GetByExternalID(c.Request.Context(), principal.ExternalID)
+			if existing != nil {
+				user = *existing
+			}
+		} else {
+			err = users.Refresh(c.Request.Context(), &user)
+		}
+		if errors.Is(err, db.ErrNotFound) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this organization"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			logger.Error("Failed to resolve user identity", zap.String("external_id", principal.ExternalID), zap.Error(err))
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to resolve user"})
+			c.Abort()
+			return
+		}
+
+		principal.UserID = user.ID
+		principal.Role = user.Role
+		c.Set("user_role", user.Role)
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/currency"
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+type PromoCodeHandler struct {
+	Repo      db.PromoCodeRepository
+	Validator *validator.Validate
+	Logger    *zap.Logger
+}
+
+func NewPromoCodeHandler(repo db.PromoCodeRepository, logger *zap.Logger) *PromoCodeHandler {
+	return &PromoCodeHandler{
+		Repo:      repo,
+		Validator: validator.New(),
+		Logger:    logger,
+	}
+}
+
+func (h *PromoCodeHandler) ListPromoCodes(c *gin.Context) {
+	promos, err := h.Repo.List(c.Request.Context())
+	if err != nil {
+		h.Logger.Error("Failed to list promo codes", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list promo codes"})
+		return
+	}
+
+	c.JSON(http.StatusOK, promos)
+}
+
+func (h *PromoCodeHandler) CreatePromoCode(c *gin.Context) {
+	var promo models.PromoCode
+	if err := c.ShouldBindJSON(&promo); err != nil {
+		c.Error(fmt.Errorf("invalid JSON: %w", err))
+		return
+	}
+	promo.Active = true
+	if !h.validate(c, &promo) {
+		return
+	}
+
+	err := h.Repo.Create(c.Request.Context(), &promo)
+	if errors.Is(err, db.ErrDuplicate) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A promo code with this code already exists"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("insert error: %w", err))
+		return
+	}
+
+	h.Logger.Info("Created promo code", zap.Int("promo_code_id", promo.ID), zap.String("code", promo.Code))
+	c.JSON(http.StatusCreated, promo)
+}
+
+// UpdatePromoCode replaces the terms of a promo code; its code cannot change.
+func (h *PromoCodeHandler) UpdatePromoCode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	existing, err := h.Repo.Get(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promo code not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("load promo code: %w", err))
+		return
+	}
+
+	promo := *existing
+	if err := c.ShouldBindJSON(&promo); err != nil {
+		c.Error(fmt.Errorf("invalid JSON: %w", err))
+		return
+	}
+	promo.ID, promo.Code = existing.ID, existing.Code
+	if !h.validate(c, &promo) {
+		return
+	}
+
+	if err := h.Repo.Update(ctx, &promo); err != nil {
+		c.Error(fmt.Errorf("update error: %w", err))
+		return
+	}
+
+	h.Logger.Info("Updated promo code", zap.Int("promo_code_id", promo.ID))
+	c.JSON(http.StatusOK, promo)
+}
+
+// DeactivatePromoCode stops a promo code from being redeemed. Past
+// redemptions keep referring to it.
+func (h *PromoCodeHandler) DeactivatePromoCode(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid promo code ID"})
+		return
+	}
+
+	err = h.Repo.Deactivate(c.Request.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Promo code not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("deactivate error: %w", err))
+		return
+	}
+
+	h.Logger.Info("Deactivated promo code", zap.Int("promo_code_id", id))
+	c.Status(http.StatusNoContent)
+}
+
+// validate normalizes promo and checks its terms are consistent. On failure
+// it writes the response and returns false.
+func (h *PromoCodeHandler) validate(c *gin.Context, promo *models.PromoCode) bool {
+	promo.Code = strings.ToUpper(strings.TrimSpace(promo.Code))
+	promo.Currency = currency.Normalize(promo.Currency)
+	if promo.ServiceIDs == nil {
+		promo.ServiceIDs = []int64{}
+	}
+
+	if err := h.Validator.Struct(promo); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+
+	var message string
+	switch {
+	case promo.DiscountType == "percent" && promo.Percent <= 0:
+		message = "percent must be greater than 0 for percentage codes"
+	case promo.DiscountType == "fixed" && promo.AmountOff <= 0:
+		message = "amountOff is required for fixed amount codes"
+	case promo.ValidFrom != nil && promo.ValidUntil != nil && !promo.ValidUntil.After(*promo.ValidFrom):
+		message = "validUntil must be after validFrom"
+	}
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return false
+	}
+	if promo.DiscountType == "fixed" {
+		return chargeCurrency(c, &promo.Currency, promo.AmountOff)
+	}
+	return true
+}
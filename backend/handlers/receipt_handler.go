@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/receipts"
+)
+
+// Payments in these states took the client's money and get a receipt.
+var receiptPaymentStatuses = []string{"paid", "partially_refunded", "refunded", "disputed", "dispute_lost"}
+
+var paymentMethodNames = map[string]string{
+	"stripe":   "card",
+	"paypal":   "PayPal",
+	"internal": "gift card",
+}
+
+// ReceiptHandler issues receipts for payments, numbered per organization the
+// first time each one is requested.
+type ReceiptHandler struct {
+	Receipts db.ReceiptRepository
+	Logger   *zap.Logger
+}
+
+func NewReceiptHandler(receiptRepo db.ReceiptRepository, logger *zap.Logger) *ReceiptHandler {
+	return &ReceiptHandler{
+		Receipts: receiptRepo,
+		Logger:   logger,
+	}
+}
+
+// GetReceipt returns the receipt of a payment to its client, the masseur of
+// its appointment or staff, as JSON or, with format=html or format=pdf, as a
+// document to download.
+func (h *ReceiptHandler) GetReceipt(c *gin.Context) {
+	paymentID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+	principal, ok := CurrentPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	org := currentOrganization(c)
+	if org == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	payment, err := h.Receipts.Payment(ctx, paymentID)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		c.Error(fmt.Errorf("payment %d: %w", paymentID, err))
+		return
+	}
+	visible := err == nil && ((principal.IsService() && principal.APIKey.HasScope("payments:read")) || principal.Role == "admin" ||
+		(principal.Role == "client" && payment.ClientID == principal.UserID) ||
+		(principal.Role == "masseur" && payment.MasseurID == principal.UserID))
+	if !visible {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found"})
+		return
+	}
+
+	receipt, err := h.Receipts.GetByPayment(ctx, payment.ID)
+	if errors.Is(err, db.ErrNotFound) {
+		receipt, err = h.issue(ctx, org, payment)
+	}
+	if errors.Is(err, errNoReceipt) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Receipts are issued once a payment is paid"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("receipt for payment %d: %w", payment.ID, err))
+		return
+	}
+
+	filename := "receipt-" + receipt.Reference()
+	switch c.Query("format") {
+	case "pdf":
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.pdf"`, filename))
+		c.Data(http.StatusOK, "application/pdf", receipts.PDF(receipt))
+	case "html":
+		page, err := receipts.HTML(receipt)
+		if err != nil {
+			c.Error(fmt.Errorf("render receipt %d: %w", receipt.ID, err))
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%s.html"`, filename))
+		c.Data(http.StatusOK, "text/html; charset=utf-8", page)
+	default:
+		c.JSON(http.StatusOK, gin.H{"reference": receipt.Reference(), "receipt": receipt})
+	}
+}
+
+var errNoReceipt = errors.New("payment has not been paid")
+
+// issue builds the receipt of a paid payment from the appointment's price as
+// quoted at its checkout and the payments made towards it, and numbers it.
+func (h *ReceiptHandler) issue(ctx context.Context, org *models.Organization, payment *models.ReceiptPayment) (*models.Receipt, error) {
+	if !slices.Contains(receiptPaymentStatuses, payment.Status) {
+		return nil, errNoReceipt
+	}
+
+	settings := org.Settings
+	doc := models.ReceiptDocument{
+		Business: models.ReceiptParty{
+			Name:    settings.BusinessName,
+			Address: settings.BusinessAddress,
+			TaxID:   settings.TaxID,
+			Email:   settings.BusinessEmail,
+		},
+		Customer:        models.ReceiptParty{Name: payment.ClientEmail, Email: payment.ClientEmail},
+		AppointmentID:   payment.AppointmentID,
+		AppointmentDate: payment.AppointmentDate,
+		Kind:            payment.Kind,
+		Method:          paymentMethodNames[payment.Provider],
+		Currency:        payment.Currency,
+		AmountPaid:      payment.Amount + payment.GiftCardAmount,
+		GiftCardAmount:  payment.GiftCardAmount,
+		PaidAt:          payment.UpdatedAt,
+	}
+	if doc.Business.Name == "" {
+		doc.Business.Name = org.Name
+	}
+	if doc.Method == "" {
+		doc.Method = payment.Provider
+	}
+
+	if payment.Kind == "tip" {
+		doc.Lines = []models.ReceiptLine{{Description: "Tip", Amount: doc.AmountPaid}}
+		doc.Subtotal, doc.Total = doc.AmountPaid, doc.AmountPaid
+	} else {
+		// The promo discount is recorded on the first payment of the
+		// appointment; earlier payments are deposits.
+		promoDiscount, previouslyPaid, err := h.Receipts.PaymentTotals(ctx, payment.AppointmentID, payment.ID, receiptPaymentStatuses)
+		if err != nil {
+			return nil, fmt.Errorf("appointment payments: %w", err)
+		}
+		doc.PromoDiscount = promoDiscount
+		doc.PreviouslyPaid = previouslyPaid
+
+		if price := payment.Price; price != nil {
+			doc.Lines = price.Lines
+			doc.Subtotal, doc.Discount, doc.TaxRate, doc.Tax = price.Subtotal, price.Discount, price.TaxRate, price.Tax
+			doc.Total = price.Total - doc.PromoDiscount
+			if payment.Kind == "deposit" {
+				doc.BalanceDue = max(doc.Total-doc.PreviouslyPaid-doc.AmountPaid, 0)
+			}
+		} else {
+			// Payments taken before checkouts recorded the price only
+			// show what was paid.
+			doc.Total = doc.PreviouslyPaid + doc.AmountPaid
+			doc.Lines = []models.ReceiptLine{{Description: "Appointment", Amount: doc.Total + doc.PromoDiscount}}
+			doc.Subtotal = doc.Total + doc.PromoDiscount
+		}
+	}
+
+	receipt := &models.Receipt{
+		PaymentID: payment.ID,
+		Prefix:    settings.ReceiptPrefix,
+		Document:  doc,
+	}
+	if receipt.Prefix == "" {
+		receipt.Prefix = "INV"
+	}
+	if err := h.Receipts.Issue(ctx, receipt); err != nil {
+		return nil, err
+	}
+
+	h.Logger.Info("Issued receipt", zap.Int("payment_id", payment.ID), zap.String("reference", receipt.Reference()))
+	return receipt, nil
+}
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+)
+
+// ReconciliationHandler lets admins review the discrepancies with the payment
+// provider that the nightly reconciliation could not repair.
+type ReconciliationHandler struct {
+	Records db.ReconciliationRepository
+	Logger  *zap.Logger
+}
+
+func NewReconciliationHandler(records db.ReconciliationRepository, logger *zap.Logger) *ReconciliationHandler {
+	return &ReconciliationHandler{
+		Records: records,
+		Logger:  logger,
+	}
+}
+
+// ListReconciliationIssues lists open issues, or with ?status=resolved|all
+// the resolved ones or everything.
+func (h *ReconciliationHandler) ListReconciliationIssues(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	var resolved *bool
+	switch c.DefaultQuery("status", "open") {
+	case "open":
+		resolved = new(bool)
+	case "resolved":
+		resolved = new(bool)
+		*resolved = true
+	case "all":
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be open, resolved or all"})
+		return
+	}
+
+	issues, err := h.Records.ListIssues(c.Request.Context(), resolved, limit, offset)
+	if err != nil {
+		c.Error(fmt.Errorf("list reconciliation issues: %w", err))
+		return
+	}
+
+	c.JSON(http.StatusOK, issues)
+}
+
+func (h *ReconciliationHandler) ResolveReconciliationIssue(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid issue ID"})
+		return
+	}
+
+	issue, err := h.Records.ResolveIssue(c.Request.Context(), id, staffUserID(c))
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Open issue not found"})
+		return
+	}
+	if err != nil {
+		c.Error(fmt.Errorf("resolve reconciliation issue: %w", err))
+		return
+	}
+
+	h.Logger.Info("Resolved reconciliation issue", zap.Int("issue_id", issue.ID), zap.String("reference", issue.Reference))
+	c.JSON(http.StatusOK, issue)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+type ServiceHandler struct {
+	Repo      db.ServiceRepository
+	Validator *validator.Validate
+	Logger    *zap.Logger
+}
+
+func NewServiceHandler(repo db.ServiceRepository, logger *zap.Logger) *ServiceHandler {
+	return &ServiceHandler{
+		Repo:      repo,
+		Validator: validator.New(),
+		Logger:    logger,
+	}
+}
+
+func (h *ServiceHandler) ListServices(c *gin.Context) {
+	services, err := h.Repo.ListServices(c.Request.Context(), c.Query("all") != "true")
+	if err != nil {
+		h.Logger.Error("Failed to list services", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list services"})
+		return
+	}
+
+	c.JSON(http.StatusOK, services)
+}
+
+func (h *ServiceHandler) CreateService(c *gin.Context) {
+	var service models.Service
+	if err := c.ShouldBindJSON(&service); err != nil {
+		c.Error(fmt.Errorf("invalid JSON: %w", err))
+		return
+	}
+	service.Active = true
+	if !chargeCurrency(c, &service.Currency, service.Price) {
+		return
+	}
+
+	if err := h.Validator.Struct(service); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Repo.CreateService(c.Request.Context(), &service); err != nil {
+		c.Error(fmt.Errorf("insert error: %w", err))
+		return
+	}
+
+	h.Logger.Info("Created service", zap.Int("service_id", service.ID))
+	c.JSON(http.StatusCreated, service)
+}
+
+func (h *ServiceHandler) ListAddOns(c *gin.Context) {
+	addOns, err := h.Repo.ListAddOns(c.Request.Context(), c.Query("all") != "true")
+	if err != nil {
+		h.Logger.Error("Failed to list add-ons", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list add-ons"})
+		return
+	}
+
+	c.JSON(http.StatusOK, addOns)
+}
+
+func (h *ServiceHandler) CreateAddOn(c *gin.Context) {
+	var addOn models.AddOn
+	if err := c.ShouldBindJSON(&addOn); err != nil {
+		c.Error(fmt.Errorf("invalid JSON: %w", err))
+		return
+	}
+	addOn.Active = true
+
+	if err := h.Validator.Struct(addOn); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Repo.CreateAddOn(c.Request.Context(), &addOn); err != nil {
+		c.Error(fmt.Errorf("insert error: %w", err))
+		return
+	}
+
+	h.Logger.Info("Created add-on", zap.Int("add_on_id", addOn.ID))
+	c.JSON(http.StatusCreated, addOn)
+}
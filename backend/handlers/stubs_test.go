@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+)
+
+// stubOrganizations serves a fixed set of organizations. Methods the tests
+// do not need panic through the nil embedded interface.
+type stubOrganizations struct {
+	db.OrganizationRepository
+	orgs []models.Organization
+}
+
+func (s *stubOrganizations) GetByID(ctx context.Context, id int) (*models.Organization, error) {
+	return s.find(func(org models.Organization) bool { return org.ID == id })
+}
+
+func (s *stubOrganizations) GetBySlug(ctx context.Context, slug string) (*models.Organization, error) {
+	return s.find(func(org models.Organization) bool { return org.Slug == slug })
+}
+
+func (s *stubOrganizations) GetByClerkOrgID(ctx context.Context, clerkOrgID string) (*models.Organization, error) {
+	return s.find(func(org models.Organization) bool { return org.ClerkOrgID != nil && *org.ClerkOrgID == clerkOrgID })
+}
+
+func (s *stubOrganizations) find(match func(models.Organization) bool) (*models.Organization, error) {
+	for _, org := range s.orgs {
+		if match(org) {
+			org := org
+			return &org, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+// stubUsers serves a fixed set of user profiles, scoped to the tenant on the
+// context like the Postgres repository.
+type stubUsers struct {
+	db.UserRepository
+	users []models.User
+}
+
+func (s *stubUsers) GetByID(ctx context.Context, id int) (*models.User, error) {
+	return s.find(ctx, func(user models.User) bool { return user.ID == id })
+}
+
+func (s *stubUsers) GetByExternalID(ctx context.Context, externalID string) (*models.User, error) {
+	return s.find(ctx, func(user models.User) bool { return user.ExternalID == externalID })
+}
+
+func (s *stubUsers) Refresh(ctx context.Context, user *models.User) error {
+	existing, err := s.GetByExternalID(ctx, user.ExternalID)
+	if err != nil {
+		return err
+	}
+	*user = *existing
+	return nil
+}
+
+func (s *stubUsers) find(ctx context.Context, match func(models.User) bool) (*models.User, error) {
+	orgID, ok := db.TenantFromContext(ctx)
+	if !ok {
+		return nil, db.ErrNoTenant
+	}
+	for _, user := range s.users {
+		if user.OrganizationID == orgID && match(user) {
+			user := user
+			return &user, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+// stubPromos and stubGiftCards accept every settlement of a payment's
+// discounts.
+type stubPromos struct {
+	db.PromoCodeRepository
+}
+
+func (stubPromos) Settle(ctx context.Context, paymentID int, status string) error {
+	return nil
+}
+
+type stubGiftCards struct {
+	db.GiftCardRepository
+}
+
+func (stubGiftCards) Release(ctx context.Context, paymentID int) error {
+	return nil
+}
+
+// stubAdapter records the provider calls handlers make. Calls fail with
+// err when it is set.
+type stubAdapter struct {
+	payments.Adapter
+	err       error
+	intents   []payments.PaymentIntentRequest
+	captures  []payments.CaptureRequest
+	cancels   []string
+	refunds   []payments.RefundRequest
+	checkouts []payments.SubscriptionCheckoutRequest
+}
+
+func (a *stubAdapter) Name() string {
+	return "stripe"
+}
+
+func (a *stubAdapter) CreatePaymentIntent(ctx context.Context, req payments.PaymentIntentRequest) (*payments.PaymentIntent, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	a.intents = append(a.intents, req)
+	id := fmt.Sprintf("pi_%d", len(a.intents))
+	return &payments.PaymentIntent{ID: id, Status: "requires_payment_method", Amount: req.Amount, Currency: req.Currency, ClientSecret: id + "_secret"}, nil
+}
+
+func (a *stubAdapter) CapturePayment(ctx context.Context, req payments.CaptureRequest) (*payments.PaymentIntent, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	a.captures = append(a.captures, req)
+	return &payments.PaymentIntent{ID: req.PaymentID, Status: "succeeded"}, nil
+}
+
+func (a *stubAdapter) CancelPayment(ctx context.Context, paymentID string) (*payments.PaymentIntent, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	a.cancels = append(a.cancels, paymentID)
+	return &payments.PaymentIntent{ID: paymentID, Status: "canceled"}, nil
+}
+
+func (a *stubAdapter) RefundPayment(ctx context.Context, req payments.RefundRequest) (*payments.Refund, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	a.refunds = append(a.refunds, req)
+	return &payments.Refund{ID: fmt.Sprintf("re_%d", len(a.refunds)), PaymentID: req.PaymentID, Status: "succeeded", Amount: req.Amount}, nil
+}
+
+func (a *stubAdapter) CreateSubscriptionCheckout(ctx context.Context, req payments.SubscriptionCheckoutRequest) (*payments.CheckoutSession, error) {
+	if a.err != nil {
+		return nil, a.err
+	}
+	a.checkouts = append(a.checkouts, req)
+	id := fmt.Sprintf("cs_%d", len(a.checkouts))
+	return &payments.CheckoutSession{ID: id, URL: "https://checkout.test/" + id}, nil
+}
+
+// serveAs sends a request to handler, mounted at route, as principal in org,
+// the way the middleware chain would leave it.
+func serveAs(handler gin.HandlerFunc, route string, principal *Principal, org *models.Organization, method, target string, body any) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(db.WithTenant(c.Request.Context(), org.ID))
+		c.Set("organization", org)
+		c.Set(principalKey, principal)
+	})
+	router.Handle(method, route, handler)
+
+	var reader io.Reader
+	if body != nil {
+		payload, _ := json.Marshal(body)
+		reader = bytes.NewReader(payload)
+	}
+	req := httptest.NewRequest(method, target, reader)
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// stubAppointments books every appointment with a masseur who can be paid
+// out.
+type stubAppointments struct {
+	db.AppointmentRepository
+	created []models.Appointment
+}
+
+func (s *stubAppointments) IsMasseurPayoutReady(ctx context.Context, masseurID int) (bool, error) {
+	return true, nil
+}
+
+func (s *stubAppointments) Create(ctx context.Context, appt *models.Appointment) error {
+	appt.ID = len(s.created) + 1
+	s.created = append(s.created, *appt)
+	return nil
+}
+
+// stubCredits is a credit ledger without any credits.
+type stubCredits struct {
+	db.CreditRepository
+}
+
+func (stubCredits) Available(ctx context.Context, clientID int, serviceID *int) (int, error) {
+	return 0, nil
+}
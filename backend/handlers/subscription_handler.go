@@ -2,104 +2,76 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/jmoiron/sqlx"
-	"github.com/stripe/stripe-go/v81"
-	"github.com/stripe/stripe-go/v81/checkout/session"
-	"github.com/stripe/stripe-go/v81/webhook"
 	"go.uber.org/zap"
 
 	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+	"github.com/ozoli99/Harmonia/webhooks"
 )
 
 type SubscriptionHandler struct {
-	DB     *sqlx.DB
-	Config *config.Config
-	Logger *zap.Logger
+	Subscriptions db.SubscriptionRepository
+	Payments      payments.Adapter
+	Config        *config.Config
+	Logger        *zap.Logger
 }
 
-func NewSubscriptionHandler(db *sqlx.DB, cfg *config.Config, logger *zap.Logger) *SubscriptionHandler {
+func NewSubscriptionHandler(subscriptions db.SubscriptionRepository, adapter payments.Adapter, cfg *config.Config, logger *zap.Logger) *SubscriptionHandler {
 	return &SubscriptionHandler{
-		DB:     db,
-		Config: cfg,
-		Logger: logger,
+		Subscriptions: subscriptions,
+		Payments:      adapter,
+		Config:        cfg,
+		Logger:        logger,
 	}
 }
 
-func (h *SubscriptionHandler) HandleStripeSubscriptionWebhook(c *gin.Context) {
-	payload, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
-		return
-	}
-
-	endpointSecret := h.Config.StripeWebhookSecret
-	event, err := webhook.ConstructEvent(payload, c.GetHeader("Stripe-Signature"), endpointSecret)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook signature"})
-		return
-	}
-
-	switch event.Type {
-	case "checkout.session.completed":
-		var session stripe.CheckoutSession
-		if err := json.Unmarshal(event.Data.Raw, &session); err == nil {
-			h.handleSubscriptionSuccess(session)
-		}
-
-	case "customer.subscription.deleted":
-		var sub stripe.Subscription
-		if err := json.Unmarshal(event.Data.Raw, &sub); err == nil {
-			h.handleSubscriptionCancellation(sub)
-		}
-	}
-
-	c.JSON(http.StatusOK, gin.H{"status": "success"})
+// RegisterWebhookHandlers wires the subscription event handlers into p.
+func (h *SubscriptionHandler) RegisterWebhookHandlers(p *webhooks.Processor) {
+	p.Handle(payments.EventSubscriptionCompleted, h.handleSubscriptionSuccess)
+	p.Handle(payments.EventSubscriptionCanceled, h.handleSubscriptionCancellation)
 }
 
-func (h *SubscriptionHandler) handleSubscriptionSuccess(session stripe.CheckoutSession) {
+func (h *SubscriptionHandler) handleSubscriptionSuccess(ctx context.Context, event *payments.Event) error {
+	session := event.Subscription
 	userID := session.Metadata["user_id"]
-	subID := session.Subscription.ID
+	subID := session.SubscriptionID
 
 	if session.PaymentStatus != "paid" {
 		h.Logger.Info("Subscription payment not completed", zap.String("user_id", userID))
-		return
+		return nil
 	}
 
-	_, err := h.DB.ExecContext(context.Background(), `
-		UPDATE subscriptions
-		SET status = 'active', stripe_subscription_id = $1, updated_at = NOW()
-		WHERE user_id = $2
-	`, subID, userID)
-
-	if err != nil {
-		h.Logger.Error("Failed to activate subscription", zap.Error(err))
-	} else {
-		h.Logger.Info("Subscription activated", zap.String("user_id", userID))
+	// The checkout is recorded once the provider returns it, which can be
+	// after this event arrives; the error has the event retried until then.
+	if err := h.Subscriptions.Activate(ctx, session.SessionID, subID); err != nil {
+		return fmt.Errorf("activate subscription of checkout %s: %w", session.SessionID, err)
 	}
-}
 
-func (h *SubscriptionHandler) handleSubscriptionCancellation(sub stripe.Subscription) {
-	_, err := h.DB.ExecContext(context.Background(), `
-		UPDATE subscriptions
-		SET status = 'canceled', updated_at = NOW()
-		WHERE stripe_subscription_id = $1
-	`, sub.ID)
+	h.Logger.Info("Subscription activated", zap.String("user_id", userID))
+	return nil
+}
 
-	if err != nil {
-		h.Logger.Error("Failed to cancel subscription", zap.Error(err))
-	} else {
-		h.Logger.Info("Subscription canceled", zap.String("subscription_id", sub.ID))
+func (h *SubscriptionHandler) handleSubscriptionCancellation(ctx context.Context, event *payments.Event) error {
+	sub := event.Subscription
+	if err := h.Subscriptions.Cancel(ctx, sub.SubscriptionID); err != nil {
+		return fmt.Errorf("cancel subscription %s: %w", sub.SubscriptionID, err)
 	}
+
+	h.Logger.Info("Subscription canceled", zap.String("subscription_id", sub.SubscriptionID))
+	return nil
 }
 
 func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
 	var request struct {
-		PlanID string `json:"plan_id" binding:"required"` // Stripe price_id
+		PlanID string `json:"plan_id" binding:"required"` // Stripe price_id or PayPal plan_id
 	}
 
 	if err := c.ShouldBindJSON(&request); err != nil {
@@ -107,48 +79,54 @@ func (h *SubscriptionHandler) CreateSubscription(c *gin.Context) {
 		return
 	}
 
-	userIDIfc, exists := c.Get("user_id")
-	if !exists {
+	principal, ok := CurrentPrincipal(c)
+	if !ok || principal.IsService() {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
-	userID := userIDIfc.(string)
-
-	params := &stripe.CheckoutSessionParams{
-		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
-		Mode:               stripe.String(string(stripe.CheckoutSessionModeSubscription)),
-		LineItems: []*stripe.CheckoutSessionLineItemParams{
-			{
-				Price:    stripe.String(request.PlanID),
-				Quantity: stripe.Int64(1),
-			},
-		},
-		SubscriptionData: &stripe.CheckoutSessionSubscriptionDataParams{
-			TrialPeriodDays: stripe.Int64(7),
-		},
-		AutomaticTax: &stripe.CheckoutSessionAutomaticTaxParams{
-			Enabled: stripe.Bool(true),
-		},
-		SuccessURL: stripe.String(h.Config.StripeSuccessURL),
-		CancelURL:  stripe.String(h.Config.StripeCancelURL),
-		Metadata: map[string]string{
-			"user_id": userID,
-		},
-	}
+	userID := principal.UserID
 
-	session, err := session.New(params)
-	if err != nil {
-		h.Logger.Error("Stripe error", zap.Error(err))
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Stripe session"})
+	org := currentOrganization(c)
+	if org == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Organization not resolved"})
 		return
 	}
 
-	_, err = h.DB.ExecContext(c.Request.Context(), `
-		INSERT INTO subscriptions (user_id, stripe_session_id, plan_id, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, NOW(), NOW())
-	`, userID, session.ID, request.PlanID, "pending")
+	successURL := h.Config.StripeSuccessURL
+	if org.Settings.StripeSuccessURL != "" {
+		successURL = org.Settings.StripeSuccessURL
+	}
+	cancelURL := h.Config.StripeCancelURL
+	if org.Settings.StripeCancelURL != "" {
+		cancelURL = org.Settings.StripeCancelURL
+	}
 
+	session, err := h.Payments.CreateSubscriptionCheckout(c.Request.Context(), payments.SubscriptionCheckoutRequest{
+		PlanID:     request.PlanID,
+		TrialDays:  7,
+		SuccessURL: successURL,
+		CancelURL:  cancelURL,
+		Metadata: map[string]string{
+			"user_id":         strconv.Itoa(userID),
+			"organization_id": strconv.Itoa(org.ID),
+		},
+		IdempotencyKey: providerIdempotencyKey(c, "subscription"),
+	})
 	if err != nil {
+		h.Logger.Error("Payment provider error", zap.String("provider", h.Payments.Name()), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create checkout session"})
+		return
+	}
+
+	// A retried checkout gets the same session back from the provider.
+	err = h.Subscriptions.Create(c.Request.Context(), &models.Subscription{
+		UserID:          userID,
+		Provider:        h.Payments.Name(),
+		StripeSessionID: session.ID,
+		PlanID:          request.PlanID,
+		Status:          "pending",
+	})
+	if err != nil && !errors.Is(err, db.ErrDuplicate) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store subscription record"})
 		return
 	}
@@ -0,0 +1,108 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/db/memory"
+	"github.com/ozoli99/Harmonia/payments"
+)
+
+func TestSubscriptionActivatesOnlyThePaidCheckout(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := memory.NewSubscriptionRepository()
+	adapter := &stubAdapter{}
+	h := NewSubscriptionHandler(repo, adapter, &config.Config{}, zap.NewNop())
+
+	// The client starts two checkouts and pays for the second one.
+	for _, plan := range []string{"price_basic", "price_premium"} {
+		rec := serveAs(h.CreateSubscription, "/subscriptions/checkout", testClient, testOrg, http.MethodPost, "/subscriptions/checkout", gin.H{"plan_id": plan})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("checkout of %s: status = %d: %s", plan, rec.Code, rec.Body.String())
+		}
+	}
+
+	event := func(sessionID, paymentStatus string) *payments.Event {
+		return &payments.Event{Subscription: &payments.SubscriptionEvent{
+			SubscriptionID: "sub_" + sessionID,
+			SessionID:      sessionID,
+			PaymentStatus:  paymentStatus,
+			Metadata:       map[string]string{"user_id": "10"},
+		}}
+	}
+
+	// Booking needs an active subscription, whichever of the client's
+	// checkouts it came from.
+	appointments := NewAppointmentHandler(&stubAppointments{}, repo, stubCredits{}, nil, zap.NewNop())
+	book := func() int {
+		rec := serveAs(appointments.CreateAppointment, "/appointments", testClient, testOrg, http.MethodPost, "/appointments", gin.H{"masseurId": testMasseur.UserID})
+		return rec.Code
+	}
+	if code := book(); code != http.StatusForbidden {
+		t.Errorf("booking before any checkout was paid: status = %d, want %d", code, http.StatusForbidden)
+	}
+
+	ctx := context.Background()
+	if err := h.handleSubscriptionSuccess(ctx, event("cs_2", "unpaid")); err != nil {
+		t.Fatalf("unpaid checkout: %v", err)
+	}
+	if err := h.handleSubscriptionSuccess(ctx, event("cs_unknown", "paid")); err == nil {
+		t.Error("checkout that was never recorded activated nothing but returned no error")
+	}
+	if err := h.handleSubscriptionSuccess(ctx, event("cs_2", "paid")); err != nil {
+		t.Fatalf("paid checkout: %v", err)
+	}
+
+	subs := repo.List()
+	if len(subs) != 2 {
+		t.Fatalf("subscriptions = %d, want 2", len(subs))
+	}
+	for _, sub := range subs {
+		want := "pending"
+		if sub.StripeSessionID == "cs_2" {
+			want = "active"
+		}
+		if sub.UserID != testClient.UserID || sub.OrganizationID != testOrg.ID {
+			t.Errorf("subscription %s belongs to user %d in org %d", sub.StripeSessionID, sub.UserID, sub.OrganizationID)
+		}
+		if sub.Status != want {
+			t.Errorf("subscription %s (%s) is %q, want %q", sub.StripeSessionID, sub.PlanID, sub.Status, want)
+		}
+	}
+
+	if code := book(); code != http.StatusCreated {
+		t.Errorf("booking with one active and one pending subscription: status = %d, want %d", code, http.StatusCreated)
+	}
+
+	if err := h.handleSubscriptionCancellation(ctx, event("cs_2", "paid")); err != nil {
+		t.Fatalf("cancellation: %v", err)
+	}
+	if code := book(); code != http.StatusForbidden {
+		t.Errorf("booking after the subscription was canceled: status = %d, want %d", code, http.StatusForbidden)
+	}
+	for _, sub := range repo.List() {
+		if sub.StripeSessionID == "cs_2" && sub.Status != "canceled" {
+			t.Errorf("canceled subscription is %q", sub.Status)
+		}
+	}
+}
+
+func TestCreateSubscriptionProviderFailure(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	repo := memory.NewSubscriptionRepository()
+	adapter := &stubAdapter{err: context.DeadlineExceeded}
+	h := NewSubscriptionHandler(repo, adapter, &config.Config{}, zap.NewNop())
+
+	rec := serveAs(h.CreateSubscription, "/subscriptions/checkout", testClient, testOrg, http.MethodPost, "/subscriptions/checkout", gin.H{"plan_id": "price_basic"})
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if subs := repo.List(); len(subs) != 0 {
+		t.Errorf("recorded %d subscriptions for a failed checkout", len(subs))
+	}
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/config"
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/payments"
+	"github.com/ozoli99/Harmonia/webhooks"
+)
+
+// WebhookHandler is the single entry point for payment provider webhooks.
+// Events are verified against the secret of the endpoint they arrived on,
+// stored, and dispatched to the payment and subscription handlers by the
+// webhook processor.
+type WebhookHandler struct {
+	Payments payments.Adapter
+	Events   db.WebhookEventRepository
+	Webhooks *webhooks.Processor
+	Config   *config.Config
+	Logger   *zap.Logger
+}
+
+func NewWebhookHandler(adapter payments.Adapter, events db.WebhookEventRepository, processor *webhooks.Processor, cfg *config.Config, logger *zap.Logger) *WebhookHandler {
+	return &WebhookHandler{
+		Payments: adapter,
+		Events:   events,
+		Webhooks: processor,
+		Config:   cfg,
+		Logger:   logger,
+	}
+}
+
+// HandleStripeWebhook receives events of the platform account.
+func (h *WebhookHandler) HandleStripeWebhook(c *gin.Context) {
+	h.receive(c, "stripe", h.Config.StripeWebhookSecret)
+}
+
+// HandleStripeConnectWebhook receives events of connected masseur accounts,
+// which Stripe delivers to a separate endpoint with its own secret.
+func (h *WebhookHandler) HandleStripeConnectWebhook(c *gin.Context) {
+	h.receive(c, "stripe", h.Config.StripeConnectWebhookSecret)
+}
+
+func (h *WebhookHandler) HandlePayPalWebhook(c *gin.Context) {
+	h.receive(c, "paypal", h.Config.PayPalWebhookID)
+}
+
+// receive verifies a provider webhook and stores it for asynchronous
+// processing. The provider is only told the event was accepted once it is
+// stored, so a failed write makes the provider retry.
+func (h *WebhookHandler) receive(c *gin.Context, provider, secret string) {
+	if h.Payments.Name() != provider {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook endpoint not enabled"})
+		return
+	}
+	if secret == "" {
+		h.Logger.Error("Webhook signing secret not configured", zap.String("path", c.FullPath()))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Webhook endpoint not configured"})
+		return
+	}
+
+	payload, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+
+	event, err := h.Payments.ParseWebhook(c.Request.Context(), payload, c.Request.Header, secret)
+	if err != nil {
+		h.Logger.Warn("Rejected webhook", zap.String("path", c.FullPath()), zap.Error(err))
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+
+	duplicate, err := h.Webhooks.Receive(c.Request.Context(), provider, event, payload)
+	if err != nil {
+		h.Logger.Error("Failed to store webhook event", zap.String("event_id", event.ID), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store webhook event"})
+		return
+	}
+	if duplicate {
+		h.Logger.Info("Duplicate webhook event", zap.String("event_id", event.ID), zap.String("type", event.ProviderType))
+		c.JSON(http.StatusOK, gin.H{"status": "duplicate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "received"})
+}
+
+func (h *WebhookHandler) ListWebhookEvents(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+
+	filters := map[string]string{
+		"status":     c.Query("status"),
+		"event_type": c.Query("event_type"),
+	}
+
+	events, err := h.Events.List(c.Request.Context(), filters, limit, offset)
+	if err != nil {
+		h.Logger.Error("Failed to list webhook events", zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list webhook events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+func (h *WebhookHandler) GetWebhookEvent(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook event ID"})
+		return
+	}
+
+	event, err := h.Events.GetByID(c.Request.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook event not found"})
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Failed to get webhook event", zap.Int("webhook_event_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get webhook event"})
+		return
+	}
+
+	c.JSON(http.StatusOK, event)
+}
+
+// ReplayWebhookEvent requeues a failed or dead-lettered event.
+func (h *WebhookHandler) ReplayWebhookEvent(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook event ID"})
+		return
+	}
+
+	err = h.Events.Requeue(c.Request.Context(), id)
+	if errors.Is(err, db.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No failed webhook event with this ID"})
+		return
+	}
+	if err != nil {
+		h.Logger.Error("Failed to requeue webhook event", zap.Int("webhook_event_id", id), zap.Error(err))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to replay webhook event"})
+		return
+	}
+
+	h.Webhooks.Notify()
+	h.Logger.Info("Webhook event requeued", zap.Int("webhook_event_id", id), zap.String("user_id", c.GetString("user_id")))
+	c.JSON(http.StatusAccepted, gin.H{"status": "requeued"})
+}
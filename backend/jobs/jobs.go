@@ -0,0 +1,69 @@
+// Package jobs runs periodic background work alongside the API server.
+package jobs
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+)
+
+// Every runs fn every interval until ctx is canceled. Failures are logged and
+// the job runs again at the next interval.
+func Every(ctx context.Context, interval time.Duration, name string, logger *zap.Logger, fn func(context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := fn(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("Background job failed", zap.String("job", name), zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Daily runs fn once a day at hour:00 UTC until ctx is canceled. Failures
+// are logged and the job runs again the next day.
+func Daily(ctx context.Context, hour int, name string, logger *zap.Logger, fn func(context.Context) error) {
+	for {
+		now := time.Now().UTC()
+		next := time.Date(now.Year(), now.Month(), now.Day(), hour, 0, 0, 0, time.UTC)
+		if !next.After(now) {
+			next = next.AddDate(0, 0, 1)
+		}
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := fn(ctx); err != nil && ctx.Err() == nil {
+			logger.Error("Background job failed", zap.String("job", name), zap.Error(err))
+		}
+	}
+}
+
+// ExpireCredits writes off package credits whose validity has lapsed. Lapsed
+// credits are unusable either way; this records it in the ledger.
+func ExpireCredits(credits db.CreditRepository, logger *zap.Logger) func(context.Context) error {
+	return func(ctx context.Context) error {
+		expired, err := credits.ExpireDue(ctx)
+		if err != nil {
+			return err
+		}
+		if expired > 0 {
+			logger.Info("Expired package credits", zap.Int64("lots", expired))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"sync"
+	"time"
+)
+
+type RateLimiter struct {
+	mu      sync.Mutex
+	window  time.Duration
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	start time.Time
+	count int
+}
+
+func NewRateLimiter(window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		window:  window,
+		buckets: make(map[string]*rateBucket),
+	}
+}
+
+// Allow reports whether another request for key fits within limit for the
+// current window. A non-positive limit disables limiting.
+func (l *RateLimiter) Allow(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := l.buckets[key]
+	if !ok || now.Sub(bucket.start) >= l.window {
+		l.buckets[key] = &rateBucket{start: now, count: 1}
+		return true
+	}
+	if bucket.count >= limit {
+		return false
+	}
+	bucket.count++
+	return true
+}
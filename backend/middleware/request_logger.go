@@ -13,6 +13,15 @@ func RequestLoggingMiddleware(logger *zap.Logger) gin.HandlerFunc {
 		c.Next()
 		latency := time.Since(start)
 		status := c.Writer.Status()
-		logger.Info("HTTP request", zap.Int("status", status), zap.String("method", c.Request.Method), zap.String("path", c.Request.URL.Path), zap.Duration("latency", latency),)
+		fields := []zap.Field{zap.Int("status", status), zap.String("method", c.Request.Method), zap.String("path", c.Request.URL.Path), zap.Duration("latency", latency)}
+		if userID := c.GetString("user_id"); userID != "" {
+			fields = append(fields, zap.String("effective_user_id", userID))
+			realUserID := c.GetString("real_user_id")
+			if realUserID == "" {
+				realUserID = userID
+			}
+			fields = append(fields, zap.String("real_user_id", realUserID))
+		}
+		logger.Info("HTTP request", fields...)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+type APIKey struct {
+	ID                 int            `db:"id" json:"id"`
+	OrganizationID     int            `db:"organization_id" json:"organizationId"`
+	Name               string         `db:"name" json:"name"`
+	Prefix             string         `db:"prefix" json:"prefix"`
+	KeyHash            string         `db:"key_hash" json:"-"`
+	Scopes             pq.StringArray `db:"scopes" json:"scopes"`
+	RateLimitPerMinute int            `db:"rate_limit_per_minute" json:"rateLimitPerMinute"`
+	CreatedBy          string         `db:"created_by" json:"createdBy"`
+	ExpiresAt          *time.Time     `db:"expires_at" json:"expiresAt"`
+	LastUsedAt         *time.Time     `db:"last_used_at" json:"lastUsedAt"`
+	RevokedAt          *time.Time     `db:"revoked_at" json:"revokedAt"`
+	CreatedAt          time.Time      `db:"created_at" json:"createdAt"`
+}
+
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == "*" {
+			return true
+		}
+	}
+	return false
+}
@@ -4,8 +4,11 @@ import "time"
 
 type Appointment struct {
 	ID              int       `db:"id" json:"id"`
+	OrganizationID  int       `db:"organization_id" json:"organizationId"`
 	ClientID        int       `db:"client_id" json:"clientId"`
 	MasseurID       int       `db:"masseur_id" json:"masseurId"`
+	ServiceID       *int      `db:"service_id" json:"serviceId"`
+	AddOnIDs        []int     `db:"-" json:"addOnIds,omitempty"`
 	AppointmentDate time.Time `db:"appointment_date" json:"appointmentDate"`
 	StartTime       string    `db:"start_time" json:"startTime"`
 	EndTime         string    `db:"end_time" json:"endTime"`
@@ -14,6 +17,7 @@ type Appointment struct {
 	Description     string    `db:"description" json:"description"`
 	Location        string    `db:"location" json:"location"`
 	RecurrenceRule  string    `db:"recurrence_rule" json:"recurrenceRule"`
+	PaidWithCredits bool      `db:"paid_with_credits" json:"paidWithCredits"`
 	CreatedAt       time.Time `db:"created_at" json:"createdAt"`
 	UpdatedAt       time.Time `db:"updated_at" json:"updatedAt"`
 }
\ No newline at end of file
@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// EarningsSummary totals a masseur's payments in one currency over a period.
+// PlatformFees is net of the fee share returned on refunds. Tips are part of
+// Gross and also reported on their own.
+type EarningsSummary struct {
+	MasseurID    int    `db:"masseur_id" json:"masseurId"`
+	MasseurEmail string `db:"masseur_email" json:"masseurEmail"`
+	Currency     string `db:"currency" json:"currency"`
+	Payments     int    `db:"payments" json:"payments"`
+	Gross        int64  `db:"gross" json:"gross"`
+	Tips         int64  `db:"tips" json:"tips"`
+	PlatformFees int64  `db:"platform_fees" json:"platformFees"`
+	Refunds      int64  `db:"refunds" json:"refunds"`
+	Net          int64  `db:"net" json:"net"`
+}
+
+// EarningsTotal adds up the summaries of every masseur in one currency.
+// Amounts in different currencies are never added together.
+type EarningsTotal struct {
+	Currency     string `json:"currency"`
+	Masseurs     int    `json:"masseurs"`
+	Payments     int    `json:"payments"`
+	Gross        int64  `json:"gross"`
+	Tips         int64  `json:"tips"`
+	PlatformFees int64  `json:"platformFees"`
+	Refunds      int64  `json:"refunds"`
+	Net          int64  `json:"net"`
+}
+
+// EarningsLine is a single payment as it counts towards a masseur's
+// earnings.
+type EarningsLine struct {
+	PaymentID          int       `db:"payment_id" json:"paymentId"`
+	AppointmentID      int       `db:"appointment_id" json:"appointmentId"`
+	AppointmentDate    time.Time `db:"appointment_date" json:"appointmentDate"`
+	PaidAt             time.Time `db:"paid_at" json:"paidAt"`
+	Kind               string    `db:"kind" json:"kind"`
+	Status             string    `db:"status" json:"status"`
+	Currency           string    `db:"currency" json:"currency"`
+	Gross              int64     `db:"gross" json:"gross"`
+	PlatformFee        int64     `db:"platform_fee" json:"platformFee"`
+	Refunded           int64     `db:"refunded" json:"refunded"`
+	Net                int64     `db:"net" json:"net"`
+	DestinationAccount string    `db:"destination_account" json:"destinationAccount"`
+}
+
+// PayoutReconciliation compares what a masseur's connected account should
+// have received from payments against the provider's balance transactions.
+type PayoutReconciliation struct {
+	Currency    string `json:"currency"`
+	Expected    int64  `json:"expected"`
+	Transferred int64  `json:"transferred"`
+	Difference  int64  `json:"difference"`
+	Reconciled  bool   `json:"reconciled"`
+	Payouts     int64  `json:"payouts"`
+}
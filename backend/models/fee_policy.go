@@ -0,0 +1,54 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// FeePolicy is how the platform fee on a payment is computed. Policies are
+// scoped to the organization (default), a masseur or a client's
+// subscription plan.
+type FeePolicy struct {
+	ID             int       `db:"id" json:"id"`
+	OrganizationID int       `db:"organization_id" json:"organizationId"`
+	Scope          string    `db:"scope" json:"scope" validate:"required,oneof=default masseur plan"`
+	MasseurID      *int      `db:"masseur_id" json:"masseurId"`
+	PlanID         *string   `db:"plan_id" json:"planId"`
+	Percent        float64   `db:"percent" json:"percent" validate:"gte=0,lte=100"`
+	FixedAmount    int64     `db:"fixed_amount" json:"fixedAmount" validate:"gte=0"`
+	Tiers          FeeTiers  `db:"tiers" json:"tiers" validate:"dive"`
+	CreatedAt      time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// FeeTier applies to payments up to UpTo minor units; 0 means no limit.
+type FeeTier struct {
+	UpTo        int64   `json:"upTo" validate:"gte=0"`
+	Percent     float64 `json:"percent" validate:"gte=0,lte=100"`
+	FixedAmount int64   `json:"fixedAmount" validate:"gte=0"`
+}
+
+type FeeTiers []FeeTier
+
+func (t FeeTiers) Value() (driver.Value, error) {
+	if t == nil {
+		return "[]", nil
+	}
+	return json.Marshal(t)
+}
+
+func (t *FeeTiers) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*t = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, t)
+	case string:
+		return json.Unmarshal([]byte(v), t)
+	default:
+		return fmt.Errorf("cannot scan %T into FeeTiers", src)
+	}
+}
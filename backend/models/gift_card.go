@@ -0,0 +1,44 @@
+package models
+
+import "time"
+
+// GiftCard is a prepaid balance redeemable with a code. The code itself is
+// only shown once, when the card is issued or bought.
+type GiftCard struct {
+	ID                int        `db:"id" json:"id"`
+	OrganizationID    int        `db:"organization_id" json:"organizationId"`
+	CodeHash          string     `db:"code_hash" json:"-"`
+	Last4             string     `db:"last4" json:"last4"`
+	InitialAmount     int64      `db:"initial_amount" json:"initialAmount"`
+	Balance           int64      `db:"balance" json:"balance"`
+	Currency          string     `db:"currency" json:"currency"`
+	Status            string     `db:"status" json:"status"`
+	PurchaserID       *int       `db:"purchaser_id" json:"purchaserId"`
+	RecipientEmail    string     `db:"recipient_email" json:"recipientEmail"`
+	Provider          string     `db:"provider" json:"provider"`
+	ProviderPaymentID *string    `db:"provider_payment_id" json:"providerPaymentId"`
+	ApplicationFee    int64      `db:"application_fee" json:"applicationFee"`
+	ExpiresAt         *time.Time `db:"expires_at" json:"expiresAt"`
+	CreatedAt         time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt         time.Time  `db:"updated_at" json:"updatedAt"`
+}
+
+// Usable reports whether the card can pay for something at now.
+func (g *GiftCard) Usable(now time.Time) bool {
+	if g.Status != "active" {
+		return false
+	}
+	return g.ExpiresAt == nil || now.Before(*g.ExpiresAt)
+}
+
+type GiftCardLedgerEntry struct {
+	ID             int       `db:"id" json:"id"`
+	OrganizationID int       `db:"organization_id" json:"organizationId"`
+	GiftCardID     int       `db:"gift_card_id" json:"giftCardId"`
+	PaymentID      *int      `db:"payment_id" json:"paymentId"`
+	Delta          int64     `db:"delta" json:"delta"`
+	Reason         string    `db:"reason" json:"reason"`
+	Note           string    `db:"note" json:"note"`
+	CreatedBy      *int      `db:"created_by" json:"createdBy"`
+	CreatedAt      time.Time `db:"created_at" json:"createdAt"`
+}
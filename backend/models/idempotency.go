@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// IdempotencyRecord is the stored outcome of a POST made with an
+// Idempotency-Key header.
+type IdempotencyRecord struct {
+	ID             int        `db:"id"`
+	OrganizationID int        `db:"organization_id"`
+	Caller         string     `db:"caller"`
+	Key            string     `db:"key"`
+	RequestHash    string     `db:"request_hash"`
+	Status         string     `db:"status"`
+	ResponseStatus int        `db:"response_status"`
+	ResponseType   string     `db:"response_type"`
+	ResponseBody   []byte     `db:"response_body"`
+	CreatedAt      time.Time  `db:"created_at"`
+	CompletedAt    *time.Time `db:"completed_at"`
+}
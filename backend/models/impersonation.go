@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+type ImpersonationAuditEntry struct {
+	ID             int       `db:"id" json:"id"`
+	OrganizationID int       `db:"organization_id" json:"organizationId"`
+	AdminID      string    `db:"admin_id" json:"adminId"`
+	TargetUserID string    `db:"target_user_id" json:"targetUserId"`
+	Action       string    `db:"action" json:"action"`
+	Method       string    `db:"method" json:"method"`
+	Path         string    `db:"path" json:"path"`
+	Status       int       `db:"status" json:"status"`
+	Reason       string    `db:"reason" json:"reason"`
+	CreatedAt    time.Time `db:"created_at" json:"createdAt"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type Organization struct {
+	ID              int                  `db:"id" json:"id"`
+	Slug            string               `db:"slug" json:"slug"`
+	Name            string               `db:"name" json:"name"`
+	ClerkOrgID      *string              `db:"clerk_org_id" json:"clerkOrgId"`
+	StripeAccountID string               `db:"stripe_account_id" json:"stripeAccountId"`
+	Settings        OrganizationSettings `db:"settings" json:"settings"`
+	CreatedAt       time.Time            `db:"created_at" json:"createdAt"`
+	UpdatedAt       time.Time            `db:"updated_at" json:"updatedAt"`
+}
+
+// OrganizationSettings holds per-tenant overrides of the global config. Zero
+// values fall back to the deployment-wide defaults, except DepositPercent
+// where zero disables deposits. CreditsConsumedOn is "booking" (the default)
+// or "completion". CancellationNoticeHours is how long before an appointment
+// clients can still cancel it, 24 hours when zero. The business details and
+// ReceiptPrefix are printed on receipts.
+type OrganizationSettings struct {
+	Currency                string  `json:"currency,omitempty"`
+	Timezone                string  `json:"timezone,omitempty"`
+	ApplicationFeePercent   float64 `json:"applicationFeePercent,omitempty"`
+	DepositPercent          float64 `json:"depositPercent,omitempty"`
+	StripeSuccessURL        string  `json:"stripeSuccessUrl,omitempty"`
+	StripeCancelURL         string  `json:"stripeCancelUrl,omitempty"`
+	Country                 string  `json:"country,omitempty"`
+	ConnectRefreshURL       string  `json:"connectRefreshUrl,omitempty"`
+	ConnectReturnURL        string  `json:"connectReturnUrl,omitempty"`
+	CreditsConsumedOn       string  `json:"creditsConsumedOn,omitempty"`
+	CancellationNoticeHours int     `json:"cancellationNoticeHours,omitempty"`
+	BusinessName            string  `json:"businessName,omitempty"`
+	BusinessAddress         string  `json:"businessAddress,omitempty"`
+	BusinessEmail           string  `json:"businessEmail,omitempty"`
+	TaxID                   string  `json:"taxId,omitempty"`
+	ReceiptPrefix           string  `json:"receiptPrefix,omitempty"`
+}
+
+func (s OrganizationSettings) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+func (s *OrganizationSettings) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case nil:
+		*s = OrganizationSettings{}
+		return nil
+	case []byte:
+		return json.Unmarshal(v, s)
+	case string:
+		return json.Unmarshal([]byte(v), s)
+	default:
+		return fmt.Errorf("cannot scan %T into OrganizationSettings", src)
+	}
+}
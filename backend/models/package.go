@@ -0,0 +1,59 @@
+package models
+
+import "time"
+
+// Package is a bundle of session credits sold for a single price, e.g.
+// "10 massages".
+type Package struct {
+	ID             int       `db:"id" json:"id"`
+	OrganizationID int       `db:"organization_id" json:"organizationId"`
+	Name           string    `db:"name" json:"name" validate:"required"`
+	Description    string    `db:"description" json:"description"`
+	Credits        int       `db:"credits" json:"credits" validate:"required,gt=0"`
+	Price          int64     `db:"price" json:"price" validate:"gte=0"`
+	Currency       string    `db:"currency" json:"currency" validate:"required,len=3"`
+	ServiceID      *int      `db:"service_id" json:"serviceId"`
+	ValidityDays   *int      `db:"validity_days" json:"validityDays" validate:"omitempty,gt=0"`
+	Active         bool      `db:"active" json:"active"`
+	CreatedAt      time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+type PackagePurchase struct {
+	ID                int        `db:"id" json:"id"`
+	OrganizationID    int        `db:"organization_id" json:"organizationId"`
+	ClientID          int        `db:"client_id" json:"clientId"`
+	PackageID         int        `db:"package_id" json:"packageId"`
+	Credits           int        `db:"credits" json:"credits"`
+	Amount            int64      `db:"amount" json:"amount"`
+	Currency          string     `db:"currency" json:"currency"`
+	ApplicationFee    int64      `db:"application_fee" json:"applicationFee"`
+	Status            string     `db:"status" json:"status"`
+	Provider          string     `db:"provider" json:"provider"`
+	ProviderPaymentID string     `db:"provider_payment_id" json:"providerPaymentId"`
+	ExpiresAt         *time.Time `db:"expires_at" json:"expiresAt"`
+	CreatedAt         time.Time  `db:"created_at" json:"createdAt"`
+	UpdatedAt         time.Time  `db:"updated_at" json:"updatedAt"`
+}
+
+// CreditLot is what is left of one paid purchase.
+type CreditLot struct {
+	PurchaseID  int        `db:"purchase_id" json:"purchaseId"`
+	PackageID   int        `db:"package_id" json:"packageId"`
+	PackageName string     `db:"package_name" json:"packageName"`
+	ServiceID   *int       `db:"service_id" json:"serviceId"`
+	Credits     int        `db:"credits" json:"credits"`
+	Remaining   int        `db:"remaining" json:"remaining"`
+	ExpiresAt   *time.Time `db:"expires_at" json:"expiresAt"`
+}
+
+type CreditLedgerEntry struct {
+	ID             int       `db:"id" json:"id"`
+	OrganizationID int       `db:"organization_id" json:"organizationId"`
+	ClientID       int       `db:"client_id" json:"clientId"`
+	PurchaseID     int       `db:"purchase_id" json:"purchaseId"`
+	AppointmentID  *int      `db:"appointment_id" json:"appointmentId"`
+	Delta          int       `db:"delta" json:"delta"`
+	Reason         string    `db:"reason" json:"reason"`
+	CreatedAt      time.Time `db:"created_at" json:"createdAt"`
+}
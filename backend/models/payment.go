@@ -0,0 +1,109 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type Payment struct {
+	ID                 int           `db:"id" json:"id"`
+	OrganizationID     int           `db:"organization_id" json:"organizationId"`
+	AppointmentID      int           `db:"appointment_id" json:"appointmentId"`
+	Amount             int64         `db:"amount" json:"amount"`
+	Currency           string        `db:"currency" json:"currency"`
+	Status             string        `db:"status" json:"status"`
+	Kind               string        `db:"kind" json:"kind"`
+	CaptureMethod      string        `db:"capture_method" json:"captureMethod"`
+	Provider           string        `db:"provider" json:"provider"`
+	StripePaymentID    string        `db:"stripe_payment_id" json:"providerPaymentId"`
+	RefundedAmount     int64         `db:"refunded_amount" json:"refundedAmount"`
+	ApplicationFee     int64         `db:"application_fee" json:"applicationFee"`
+	FeePolicyID        *int          `db:"fee_policy_id" json:"feePolicyId"`
+	DestinationAccount string        `db:"destination_account" json:"destinationAccount"`
+	DiscountAmount     int64         `db:"discount_amount" json:"discountAmount"`
+	PromoCodeID        *int          `db:"promo_code_id" json:"promoCodeId"`
+	GiftCardAmount     int64         `db:"gift_card_amount" json:"giftCardAmount"`
+	GiftCardID         *int          `db:"gift_card_id" json:"giftCardId"`
+	Price              *PaymentPrice `db:"price" json:"price,omitempty"`
+	FailureCode        string        `db:"failure_code" json:"failureCode"`
+	FailureReason      string        `db:"failure_reason" json:"failureReason"`
+	DisputeID          *string       `db:"dispute_id" json:"disputeId"`
+	DisputeStatus      string        `db:"dispute_status" json:"disputeStatus"`
+	DisputeReason      string        `db:"dispute_reason" json:"disputeReason"`
+	CreatedAt          time.Time     `db:"created_at" json:"createdAt"`
+	UpdatedAt          time.Time     `db:"updated_at" json:"updatedAt"`
+}
+
+// PaymentPrice is the price of an appointment as quoted at the checkout of a
+// payment towards it. Receipts are built from it, so later changes to the
+// catalog do not alter what the client was charged for.
+type PaymentPrice struct {
+	Lines    []ReceiptLine `json:"lines"`
+	Subtotal int64         `json:"subtotal"`
+	Discount int64         `json:"discount"`
+	TaxRate  float64       `json:"taxRate"`
+	Tax      int64         `json:"tax"`
+	Total    int64         `json:"total"`
+}
+
+func (p PaymentPrice) Value() (driver.Value, error) {
+	return json.Marshal(p)
+}
+
+func (p *PaymentPrice) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, p)
+	case string:
+		return json.Unmarshal([]byte(v), p)
+	default:
+		return fmt.Errorf("cannot scan %T into PaymentPrice", src)
+	}
+}
+
+type Refund struct {
+	ID               int       `db:"id" json:"id"`
+	OrganizationID   int       `db:"organization_id" json:"organizationId"`
+	PaymentID        int       `db:"payment_id" json:"paymentId"`
+	Amount           int64     `db:"amount" json:"amount"`
+	Currency         string    `db:"currency" json:"currency"`
+	Status           string    `db:"status" json:"status"`
+	Reason           string    `db:"reason" json:"reason"`
+	ProviderRefundID *string   `db:"provider_refund_id" json:"providerRefundId"`
+	FailureReason    string    `db:"failure_reason" json:"failureReason"`
+	CreatedBy        *int      `db:"created_by" json:"createdBy"`
+	CreatedAt        time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt        time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// AppointmentPayment is a payment together with the client and masseur of
+// its appointment.
+type AppointmentPayment struct {
+	Payment
+	ClientID  int `db:"client_id" json:"clientId"`
+	MasseurID int `db:"masseur_id" json:"masseurId"`
+}
+
+// Payee is where the payments of an appointment go: the masseur's connected
+// account when they have one.
+type Payee struct {
+	AppointmentStatus string  `db:"appointment_status"`
+	PaidWithCredits   bool    `db:"paid_with_credits"`
+	StripeAccountID   *string `db:"stripe_account_id"`
+	ChargesEnabled    bool    `db:"charges_enabled"`
+	PayoutsEnabled    bool    `db:"payouts_enabled"`
+}
+
+// PaymentHistoryEntry is a payment as listed in payment history, with the
+// appointment it was made for.
+type PaymentHistoryEntry struct {
+	AppointmentPayment
+	ClientEmail       string    `db:"client_email" json:"clientEmail"`
+	MasseurEmail      string    `db:"masseur_email" json:"masseurEmail"`
+	AppointmentDate   time.Time `db:"appointment_date" json:"appointmentDate"`
+	StartTime         string    `db:"start_time" json:"startTime"`
+	AppointmentStatus string    `db:"appointment_status" json:"appointmentStatus"`
+	ServiceName       *string   `db:"service_name" json:"serviceName"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PromoCode takes a percentage or a fixed amount off an appointment. An empty
+// ServiceIDs makes it apply to every service; nil limits are unlimited.
+type PromoCode struct {
+	ID             int           `db:"id" json:"id"`
+	OrganizationID int           `db:"organization_id" json:"organizationId"`
+	Code           string        `db:"code" json:"code" validate:"required,max=64"`
+	Description    string        `db:"description" json:"description"`
+	DiscountType   string        `db:"discount_type" json:"discountType" validate:"required,oneof=percent fixed"`
+	Percent        float64       `db:"percent" json:"percent" validate:"gte=0,lte=100"`
+	AmountOff      int64         `db:"amount_off" json:"amountOff" validate:"gte=0"`
+	Currency       string        `db:"currency" json:"currency" validate:"omitempty,len=3"`
+	MaxRedemptions *int          `db:"max_redemptions" json:"maxRedemptions" validate:"omitempty,gt=0"`
+	MaxPerClient   *int          `db:"max_per_client" json:"maxPerClient" validate:"omitempty,gt=0"`
+	ValidFrom      *time.Time    `db:"valid_from" json:"validFrom"`
+	ValidUntil     *time.Time    `db:"valid_until" json:"validUntil"`
+	ServiceIDs     pq.Int64Array `db:"service_ids" json:"serviceIds"`
+	Active         bool          `db:"active" json:"active"`
+	Redemptions    int           `db:"redemptions" json:"redemptions"`
+	CreatedAt      time.Time     `db:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time     `db:"updated_at" json:"updatedAt"`
+}
+
+type PromoRedemption struct {
+	ID             int       `db:"id" json:"id"`
+	OrganizationID int       `db:"organization_id" json:"organizationId"`
+	PromoCodeID    int       `db:"promo_code_id" json:"promoCodeId"`
+	ClientID       int       `db:"client_id" json:"clientId"`
+	PaymentID      int       `db:"payment_id" json:"paymentId"`
+	AppointmentID  *int      `db:"appointment_id" json:"appointmentId"`
+	Discount       int64     `db:"discount" json:"discount"`
+	Status         string    `db:"status" json:"status"`
+	CreatedAt      time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updatedAt"`
+}
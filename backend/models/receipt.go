@@ -0,0 +1,98 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Receipt is the numbered receipt of a payment. Its document is fixed when it
+// is issued, so later changes to the catalog or the business details do not
+// alter receipts already handed out.
+type Receipt struct {
+	ID             int             `db:"id" json:"id"`
+	OrganizationID int             `db:"organization_id" json:"organizationId"`
+	PaymentID      int             `db:"payment_id" json:"paymentId"`
+	Number         int             `db:"number" json:"number"`
+	Prefix         string          `db:"prefix" json:"prefix"`
+	Document       ReceiptDocument `db:"document" json:"document"`
+	IssuedAt       time.Time       `db:"issued_at" json:"issuedAt"`
+}
+
+// Reference is the receipt number as printed, e.g. "INV-000042".
+func (r *Receipt) Reference() string {
+	return fmt.Sprintf("%s-%06d", r.Prefix, r.Number)
+}
+
+// ReceiptDocument is what a receipt shows. Amounts are in the currency's
+// minor unit; Total is what the appointment costs after every discount and
+// AmountPaid what this payment covered of it, gift card included.
+type ReceiptDocument struct {
+	Business        ReceiptParty  `json:"business"`
+	Customer        ReceiptParty  `json:"customer"`
+	AppointmentID   int           `json:"appointmentId"`
+	AppointmentDate time.Time     `json:"appointmentDate"`
+	Kind            string        `json:"kind"`
+	Method          string        `json:"method"`
+	Currency        string        `json:"currency"`
+	Lines           []ReceiptLine `json:"lines"`
+	Subtotal        int64         `json:"subtotal"`
+	Discount        int64         `json:"discount"`
+	TaxRate         float64       `json:"taxRate"`
+	Tax             int64         `json:"tax"`
+	PromoDiscount   int64         `json:"promoDiscount"`
+	Total           int64         `json:"total"`
+	PreviouslyPaid  int64         `json:"previouslyPaid"`
+	AmountPaid      int64         `json:"amountPaid"`
+	GiftCardAmount  int64         `json:"giftCardAmount"`
+	BalanceDue      int64         `json:"balanceDue"`
+	PaidAt          time.Time     `json:"paidAt"`
+}
+
+// ReceiptPayment is a payment with the details of its appointment and client
+// that its receipt shows.
+type ReceiptPayment struct {
+	ID              int           `db:"id"`
+	AppointmentID   int           `db:"appointment_id"`
+	Amount          int64         `db:"amount"`
+	Currency        string        `db:"currency"`
+	Status          string        `db:"status"`
+	Kind            string        `db:"kind"`
+	Provider        string        `db:"provider"`
+	DiscountAmount  int64         `db:"discount_amount"`
+	GiftCardAmount  int64         `db:"gift_card_amount"`
+	Price           *PaymentPrice `db:"price"`
+	UpdatedAt       time.Time     `db:"updated_at"`
+	ClientID        int           `db:"client_id"`
+	MasseurID       int           `db:"masseur_id"`
+	AppointmentDate time.Time     `db:"appointment_date"`
+	ClientEmail     string        `db:"client_email"`
+}
+
+type ReceiptParty struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	TaxID   string `json:"taxId,omitempty"`
+	Email   string `json:"email,omitempty"`
+}
+
+type ReceiptLine struct {
+	Description string `json:"description"`
+	Amount      int64  `json:"amount"`
+}
+
+func (d ReceiptDocument) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+func (d *ReceiptDocument) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	default:
+		return fmt.Errorf("cannot scan %T into ReceiptDocument", src)
+	}
+}
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// ReconciliationIssue is a discrepancy with the payment provider that needs
+// a person to look at it. Reference is the provider's payment, checkout
+// session or subscription ID.
+type ReconciliationIssue struct {
+	ID             int        `db:"id" json:"id"`
+	OrganizationID int        `db:"organization_id" json:"organizationId"`
+	Provider       string     `db:"provider" json:"provider"`
+	Kind           string     `db:"kind" json:"kind"`
+	Reference      string     `db:"reference" json:"reference"`
+	LocalStatus    string     `db:"local_status" json:"localStatus"`
+	ProviderStatus string     `db:"provider_status" json:"providerStatus"`
+	Detail         string     `db:"detail" json:"detail"`
+	FirstSeenAt    time.Time  `db:"first_seen_at" json:"firstSeenAt"`
+	LastSeenAt     time.Time  `db:"last_seen_at" json:"lastSeenAt"`
+	ResolvedAt     *time.Time `db:"resolved_at" json:"resolvedAt"`
+	ResolvedBy     *int       `db:"resolved_by" json:"resolvedBy"`
+}
+
+// ReconciliationRecord is our side of something the provider also tracks.
+// Source is "payment", "package", "gift_card" or "subscription"; Reference
+// is the provider payment ID, or the checkout session ID for subscriptions.
+type ReconciliationRecord struct {
+	Source         string `db:"source"`
+	Reference      string `db:"reference"`
+	SubscriptionID string `db:"subscription_id"`
+	OrganizationID int    `db:"organization_id"`
+	Status         string `db:"status"`
+	Amount         int64  `db:"amount"`
+	RefundedAmount int64  `db:"refunded_amount"`
+	Disputed       bool   `db:"disputed"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type Service struct {
+	ID              int       `db:"id" json:"id"`
+	OrganizationID  int       `db:"organization_id" json:"organizationId"`
+	Name            string    `db:"name" json:"name" validate:"required"`
+	DurationMinutes int       `db:"duration_minutes" json:"durationMinutes" validate:"required,gt=0"`
+	Price           int64     `db:"price" json:"price" validate:"gte=0"`
+	Currency        string    `db:"currency" json:"currency" validate:"required,len=3"`
+	TaxRate         float64   `db:"tax_rate" json:"taxRate" validate:"gte=0,lte=100"`
+	Active          bool      `db:"active" json:"active"`
+	CreatedAt       time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt       time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+type AddOn struct {
+	ID             int       `db:"id" json:"id"`
+	OrganizationID int       `db:"organization_id" json:"organizationId"`
+	Name           string    `db:"name" json:"name" validate:"required"`
+	Price          int64     `db:"price" json:"price" validate:"gte=0"`
+	Active         bool      `db:"active" json:"active"`
+	CreatedAt      time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt      time.Time `db:"updated_at" json:"updatedAt"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "time"
+
+type Subscription struct {
+	ID                   int       `db:"id" json:"id"`
+	OrganizationID       int       `db:"organization_id" json:"organizationId"`
+	UserID               int       `db:"user_id" json:"userId"`
+	Provider             string    `db:"provider" json:"provider"`
+	StripeSessionID      string    `db:"stripe_session_id" json:"sessionId"`
+	StripeSubscriptionID *string   `db:"stripe_subscription_id" json:"subscriptionId"`
+	PlanID               string    `db:"plan_id" json:"planId"`
+	Status               string    `db:"status" json:"status"`
+	CreatedAt            time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt            time.Time `db:"updated_at" json:"updatedAt"`
+}
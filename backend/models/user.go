@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+type User struct {
+	ID               int       `db:"id" json:"id"`
+	OrganizationID   int       `db:"organization_id" json:"organizationId"`
+	ExternalID       string    `db:"external_id" json:"externalId"`
+	Email            string    `db:"email" json:"email"`
+	Role             string    `db:"role" json:"role"`
+	StripeAccountID  *string   `db:"stripe_account_id" json:"stripeAccountId"`
+	Country          string    `db:"country" json:"country"`
+	StripeCustomerID *string   `db:"stripe_customer_id" json:"stripeCustomerId"`
+	Currency         string    `db:"currency" json:"currency"`
+	ChargesEnabled   bool      `db:"charges_enabled" json:"chargesEnabled"`
+	PayoutsEnabled   bool      `db:"payouts_enabled" json:"payoutsEnabled"`
+	DetailsSubmitted bool      `db:"details_submitted" json:"detailsSubmitted"`
+	CreatedAt        time.Time `db:"created_at" json:"createdAt"`
+	UpdatedAt        time.Time `db:"updated_at" json:"updatedAt"`
+}
+
+// PayoutReady reports whether payments can be routed to the user's own
+// connected account.
+func (u *User) PayoutReady() bool {
+	return u.StripeAccountID != nil && u.ChargesEnabled && u.PayoutsEnabled
+}
\ No newline at end of file
@@ -0,0 +1,27 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx/types"
+)
+
+// WebhookEvent is a payment provider webhook as received, together with its
+// normalized form and processing state.
+type WebhookEvent struct {
+	ID             int            `db:"id" json:"id"`
+	OrganizationID *int           `db:"organization_id" json:"organizationId"`
+	Provider       string         `db:"provider" json:"provider"`
+	EventID        string         `db:"event_id" json:"eventId"`
+	EventType      string         `db:"event_type" json:"eventType"`
+	ProviderType   string         `db:"provider_type" json:"providerType"`
+	Payload        types.JSONText `db:"payload" json:"payload"`
+	Event          types.JSONText `db:"event" json:"event"`
+	Status         string         `db:"status" json:"status"`
+	Attempts       int            `db:"attempts" json:"attempts"`
+	LastError      string         `db:"last_error" json:"lastError"`
+	NextAttemptAt  time.Time      `db:"next_attempt_at" json:"nextAttemptAt"`
+	ProcessedAt    *time.Time     `db:"processed_at" json:"processedAt"`
+	ReceivedAt     time.Time      `db:"received_at" json:"receivedAt"`
+	UpdatedAt      time.Time      `db:"updated_at" json:"updatedAt"`
+}
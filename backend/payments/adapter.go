@@ -0,0 +1,271 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/config"
+)
+
+var (
+	ErrNotSupported = errors.New("operation not supported by payment provider")
+	// ErrPaymentMethodNotFound is returned for a saved payment method that
+	// does not exist or belongs to another customer.
+	ErrPaymentMethodNotFound = errors.New("payment method not found")
+)
+
+// Normalized webhook event types. Provider specific event names are mapped
+// onto these by Adapter.ParseWebhook.
+const (
+	EventPaymentSucceeded      = "payment.succeeded"
+	EventPaymentFailed         = "payment.failed"
+	EventPaymentCanceled       = "payment.canceled"
+	EventPaymentProcessing     = "payment.processing"
+	EventPaymentRequiresAction = "payment.requires_action"
+	EventPaymentAuthorized     = "payment.authorized"
+	EventDisputeCreated        = "dispute.created"
+	EventDisputeClosed         = "dispute.closed"
+	EventSubscriptionCompleted = "subscription.checkout_completed"
+	EventSubscriptionCanceled  = "subscription.canceled"
+	EventRefundUpdated         = "refund.updated"
+	EventChargeRefunded        = "charge.refunded"
+	EventAccountUpdated        = "account.updated"
+)
+
+// Adapter is the boundary between Harmonia and a payment provider.
+type Adapter interface {
+	Name() string
+	CreatePaymentIntent(ctx context.Context, req PaymentIntentRequest) (*PaymentIntent, error)
+	CapturePayment(ctx context.Context, req CaptureRequest) (*PaymentIntent, error)
+	CancelPayment(ctx context.Context, paymentID string) (*PaymentIntent, error)
+	RefundPayment(ctx context.Context, req RefundRequest) (*Refund, error)
+	CreateSubscriptionCheckout(ctx context.Context, req SubscriptionCheckoutRequest) (*CheckoutSession, error)
+	CreatePayoutAccount(ctx context.Context, req PayoutAccountRequest) (*PayoutAccount, error)
+	// CreatePayoutAccountLink returns a fresh onboarding link for an existing
+	// account; onboarding links expire after a few minutes.
+	CreatePayoutAccountLink(ctx context.Context, accountID string, req PayoutAccountRequest) (string, error)
+	GetPayoutAccount(ctx context.Context, accountID string) (*PayoutAccount, error)
+	// ListBalanceTransactions returns the balance movements of a payout
+	// account created in [from, to).
+	ListBalanceTransactions(ctx context.Context, accountID string, from, to time.Time) ([]BalanceTransaction, error)
+	// ListPayments, ListSubscriptionCheckouts and ListSubscriptions report the
+	// provider's current view for reconciliation. The first two cover objects
+	// created in [from, to).
+	ListPayments(ctx context.Context, from, to time.Time) ([]PaymentSnapshot, error)
+	ListSubscriptionCheckouts(ctx context.Context, from, to time.Time) ([]SubscriptionEvent, error)
+	ListSubscriptions(ctx context.Context) ([]SubscriptionSnapshot, error)
+	// CreateCustomer, CreateSetupIntent, ListPaymentMethods and
+	// DetachPaymentMethod manage the payment methods a client saved for
+	// later payments.
+	CreateCustomer(ctx context.Context, req CustomerRequest) (string, error)
+	CreateSetupIntent(ctx context.Context, customerID, idempotencyKey string) (*SetupIntent, error)
+	ListPaymentMethods(ctx context.Context, customerID string) ([]PaymentMethod, error)
+	DetachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) error
+	// ParseWebhook verifies the provider signature and normalizes the event.
+	// For Stripe secret is the endpoint signing secret, for PayPal the webhook ID.
+	ParseWebhook(ctx context.Context, payload []byte, header http.Header, secret string) (*Event, error)
+}
+
+type PaymentIntentRequest struct {
+	Amount         int64
+	Currency       string
+	ApplicationFee int64
+	Destination    string
+	ManualCapture  bool
+	// Customer and PaymentMethod charge a saved payment method. With
+	// OffSession the payment is confirmed right away, without the client.
+	Customer       string
+	PaymentMethod  string
+	OffSession     bool
+	Metadata       map[string]string
+	IdempotencyKey string
+}
+
+// PaymentIntent is a payment as created at the provider. EventType is the
+// normalized event its status corresponds to, or empty while it awaits the
+// client. An off-session payment that was declined or needs the client to
+// authenticate comes back failed with FailureCode set; the client can still
+// complete it with ClientSecret.
+type PaymentIntent struct {
+	ID           string
+	Status       string
+	EventType    string
+	Amount       int64
+	Currency     string
+	ClientSecret string
+	ApprovalURL  string
+	FailureCode  string
+}
+
+// CaptureRequest captures a payment created with ManualCapture. A zero
+// Amount captures everything that was authorized.
+type CaptureRequest struct {
+	PaymentID      string
+	Amount         int64
+	ApplicationFee int64
+	IdempotencyKey string
+}
+
+type RefundRequest struct {
+	PaymentID            string
+	Amount               int64
+	Reason               string
+	ReverseTransfer      bool
+	RefundApplicationFee bool
+	Metadata             map[string]string
+	IdempotencyKey       string
+}
+
+type Refund struct {
+	ID        string
+	PaymentID string
+	Status    string
+	Amount    int64
+}
+
+type SubscriptionCheckoutRequest struct {
+	PlanID         string
+	TrialDays      int64
+	SuccessURL     string
+	CancelURL      string
+	Metadata       map[string]string
+	IdempotencyKey string
+}
+
+type CheckoutSession struct {
+	ID  string
+	URL string
+}
+
+type CustomerRequest struct {
+	Email          string
+	Metadata       map[string]string
+	IdempotencyKey string
+}
+
+type SetupIntent struct {
+	ID           string
+	Status       string
+	ClientSecret string
+}
+
+// PaymentMethod is a saved payment method. The card fields are empty for
+// other types.
+type PaymentMethod struct {
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Brand    string `json:"brand,omitempty"`
+	Last4    string `json:"last4,omitempty"`
+	ExpMonth int64  `json:"expMonth,omitempty"`
+	ExpYear  int64  `json:"expYear,omitempty"`
+}
+
+type PayoutAccountRequest struct {
+	Email          string
+	Country        string
+	RefreshURL     string
+	ReturnURL      string
+	IdempotencyKey string
+}
+
+type PayoutAccount struct {
+	ID               string
+	OnboardingURL    string
+	ChargesEnabled   bool
+	PayoutsEnabled   bool
+	DetailsSubmitted bool
+}
+
+// BalanceTransaction is a movement of funds on a payout account. Type is the
+// provider's own, e.g. "payment", "payment_refund" or "payout" for Stripe.
+type BalanceTransaction struct {
+	ID       string
+	Type     string
+	Amount   int64
+	Fee      int64
+	Net      int64
+	Currency string
+	Created  time.Time
+}
+
+// PaymentSnapshot is a payment as the provider currently sees it. EventType
+// is the normalized event its status corresponds to, or empty while the
+// payment still awaits the client.
+type PaymentSnapshot struct {
+	PaymentEvent
+	EventType      string
+	AmountRefunded int64
+	Disputed       bool
+	Created        time.Time
+}
+
+type SubscriptionSnapshot struct {
+	SubscriptionID string
+	Status         string
+	Canceled       bool
+}
+
+type Event struct {
+	ID           string
+	Type         string
+	ProviderType string
+	Payment      *PaymentEvent
+	Subscription *SubscriptionEvent
+	Refund       *RefundEvent
+	Dispute      *DisputeEvent
+	Account      *PayoutAccount
+}
+
+type PaymentEvent struct {
+	PaymentID     string
+	Status        string
+	Amount        int64
+	Currency      string
+	FailureCode   string
+	FailureReason string
+	Metadata      map[string]string
+}
+
+type DisputeEvent struct {
+	DisputeID string
+	PaymentID string
+	Status    string
+	Reason    string
+	Amount    int64
+}
+
+// RefundEvent describes a single refund (EventRefundUpdated) or, for
+// EventChargeRefunded, the total refunded on a payment so far.
+type RefundEvent struct {
+	RefundID      string
+	PaymentID     string
+	Status        string
+	Amount        int64
+	TotalRefunded int64
+	FailureReason string
+	Metadata      map[string]string
+}
+
+type SubscriptionEvent struct {
+	SubscriptionID string
+	SessionID      string
+	PaymentStatus  string
+	Metadata       map[string]string
+}
+
+// New returns the adapter selected by cfg.PaymentAdapter.
+func New(cfg *config.Config, logger *zap.Logger) (Adapter, error) {
+	switch cfg.PaymentAdapter {
+	case "", "stripe":
+		return NewStripeAdapter(cfg.StripeSecretKey, logger), nil
+	case "paypal":
+		return NewPayPalAdapter(cfg.PayPalClientID, cfg.PayPalClientSecret, cfg.PayPalMode, logger)
+	default:
+		return nil, fmt.Errorf("unknown payment adapter %q", cfg.PaymentAdapter)
+	}
+}
@@ -0,0 +1,623 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/stripe/stripe-go/v81"
+	"github.com/stripe/stripe-go/v81/client"
+	"github.com/stripe/stripe-go/v81/webhook"
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/currency"
+)
+
+type StripeAdapter struct {
+	api    *client.API
+	logger *zap.Logger
+}
+
+func NewStripeAdapter(secretKey string, logger *zap.Logger) *StripeAdapter {
+	return &StripeAdapter{
+		api:    client.New(secretKey, nil),
+		logger: logger,
+	}
+}
+
+func (a *StripeAdapter) Name() string {
+	return "stripe"
+}
+
+func (a *StripeAdapter) CreatePaymentIntent(ctx context.Context, req PaymentIntentRequest) (*PaymentIntent, error) {
+	if err := currency.Validate(req.Currency, req.Amount); err != nil {
+		return nil, err
+	}
+	params := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(req.Amount),
+		Currency: stripe.String(req.Currency),
+		Metadata: req.Metadata,
+	}
+	params.Context = ctx
+	if req.Destination != "" {
+		if req.ApplicationFee > 0 {
+			params.ApplicationFeeAmount = stripe.Int64(req.ApplicationFee)
+		}
+		params.TransferData = &stripe.PaymentIntentTransferDataParams{
+			Destination: stripe.String(req.Destination),
+		}
+	}
+	if req.ManualCapture {
+		params.CaptureMethod = stripe.String(string(stripe.PaymentIntentCaptureMethodManual))
+	}
+	if req.Customer != "" {
+		params.Customer = stripe.String(req.Customer)
+	}
+	if req.PaymentMethod != "" {
+		params.PaymentMethod = stripe.String(req.PaymentMethod)
+		if req.OffSession {
+			params.OffSession = stripe.Bool(true)
+			params.Confirm = stripe.Bool(true)
+		}
+	}
+	if req.IdempotencyKey != "" {
+		params.SetIdempotencyKey(req.IdempotencyKey)
+	}
+
+	intent, err := a.api.PaymentIntents.New(params)
+	if err != nil {
+		// A confirmed off-session payment that the card declined or that
+		// needs authentication still exists; hand it back so the client can
+		// complete it.
+		var stripeErr *stripe.Error
+		if errors.As(err, &stripeErr) && stripeErr.Type == stripe.ErrorTypeCard && stripeErr.PaymentIntent != nil {
+			return stripePaymentIntent(stripeErr.PaymentIntent), nil
+		}
+		return nil, err
+	}
+	return stripePaymentIntent(intent), nil
+}
+
+func (a *StripeAdapter) CapturePayment(ctx context.Context, req CaptureRequest) (*PaymentIntent, error) {
+	params := &stripe.PaymentIntentCaptureParams{}
+	params.Context = ctx
+	if req.Amount > 0 {
+		params.AmountToCapture = stripe.Int64(req.Amount)
+	}
+	if req.ApplicationFee > 0 {
+		params.ApplicationFeeAmount = stripe.Int64(req.ApplicationFee)
+	}
+	if req.IdempotencyKey != "" {
+		params.SetIdempotencyKey(req.IdempotencyKey)
+	}
+
+	intent, err := a.api.PaymentIntents.Capture(req.PaymentID, params)
+	if err != nil {
+		return nil, err
+	}
+	return stripePaymentIntent(intent), nil
+}
+
+func (a *StripeAdapter) CancelPayment(ctx context.Context, paymentID string) (*PaymentIntent, error) {
+	params := &stripe.PaymentIntentCancelParams{}
+	params.Context = ctx
+
+	intent, err := a.api.PaymentIntents.Cancel(paymentID, params)
+	if err != nil {
+		return nil, err
+	}
+	return stripePaymentIntent(intent), nil
+}
+
+func (a *StripeAdapter) RefundPayment(ctx context.Context, req RefundRequest) (*Refund, error) {
+	params := &stripe.RefundParams{
+		PaymentIntent:        stripe.String(req.PaymentID),
+		ReverseTransfer:      stripe.Bool(req.ReverseTransfer),
+		RefundApplicationFee: stripe.Bool(req.RefundApplicationFee),
+		Metadata:             req.Metadata,
+	}
+	params.Context = ctx
+	if req.Amount > 0 {
+		params.Amount = stripe.Int64(req.Amount)
+	}
+	if req.Reason != "" {
+		params.Reason = stripe.String(req.Reason)
+	}
+	if req.IdempotencyKey != "" {
+		params.SetIdempotencyKey(req.IdempotencyKey)
+	}
+
+	refund, err := a.api.Refunds.New(params)
+	if err != nil {
+		return nil, err
+	}
+	return &Refund{
+		ID:        refund.ID,
+		PaymentID: req.PaymentID,
+		Status:    string(refund.Status),
+		Amount:    refund.Amount,
+	}, nil
+}
+
+func (a *StripeAdapter) CreateSubscriptionCheckout(ctx context.Context, req SubscriptionCheckoutRequest) (*CheckoutSession, error) {
+	params := &stripe.CheckoutSessionParams{
+		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+		Mode:               stripe.String(string(stripe.CheckoutSessionModeSubscription)),
+		LineItems: []*stripe.CheckoutSessionLineItemParams{
+			{
+				Price:    stripe.String(req.PlanID),
+				Quantity: stripe.Int64(1),
+			},
+		},
+		AutomaticTax: &stripe.CheckoutSessionAutomaticTaxParams{
+			Enabled: stripe.Bool(true),
+		},
+		SuccessURL: stripe.String(req.SuccessURL),
+		CancelURL:  stripe.String(req.CancelURL),
+		Metadata:   req.Metadata,
+	}
+	params.Context = ctx
+	if req.TrialDays > 0 {
+		params.SubscriptionData = &stripe.CheckoutSessionSubscriptionDataParams{
+			TrialPeriodDays: stripe.Int64(req.TrialDays),
+		}
+	}
+	if req.IdempotencyKey != "" {
+		params.SetIdempotencyKey(req.IdempotencyKey)
+	}
+
+	session, err := a.api.CheckoutSessions.New(params)
+	if err != nil {
+		return nil, err
+	}
+	return &CheckoutSession{ID: session.ID, URL: session.URL}, nil
+}
+
+func (a *StripeAdapter) CreatePayoutAccount(ctx context.Context, req PayoutAccountRequest) (*PayoutAccount, error) {
+	accParams := &stripe.AccountParams{
+		Type:    stripe.String("express"),
+		Country: stripe.String(req.Country),
+		Email:   stripe.String(req.Email),
+	}
+	accParams.Context = ctx
+	if req.IdempotencyKey != "" {
+		accParams.SetIdempotencyKey(req.IdempotencyKey)
+	}
+	acc, err := a.api.Accounts.New(accParams)
+	if err != nil {
+		return nil, err
+	}
+
+	account := stripePayoutAccount(acc)
+	account.OnboardingURL, err = a.CreatePayoutAccountLink(ctx, acc.ID, req)
+	return account, err
+}
+
+func (a *StripeAdapter) CreatePayoutAccountLink(ctx context.Context, accountID string, req PayoutAccountRequest) (string, error) {
+	linkParams := &stripe.AccountLinkParams{
+		Account:    stripe.String(accountID),
+		RefreshURL: stripe.String(req.RefreshURL),
+		ReturnURL:  stripe.String(req.ReturnURL),
+		Type:       stripe.String("account_onboarding"),
+	}
+	linkParams.Context = ctx
+	link, err := a.api.AccountLinks.New(linkParams)
+	if err != nil {
+		return "", err
+	}
+	return link.URL, nil
+}
+
+func (a *StripeAdapter) GetPayoutAccount(ctx context.Context, accountID string) (*PayoutAccount, error) {
+	params := &stripe.AccountParams{}
+	params.Context = ctx
+	acc, err := a.api.Accounts.GetByID(accountID, params)
+	if err != nil {
+		return nil, err
+	}
+	return stripePayoutAccount(acc), nil
+}
+
+func (a *StripeAdapter) ListBalanceTransactions(ctx context.Context, accountID string, from, to time.Time) ([]BalanceTransaction, error) {
+	params := &stripe.BalanceTransactionListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThan:         to.Unix(),
+		},
+	}
+	params.Context = ctx
+	params.SetStripeAccount(accountID)
+
+	var transactions []BalanceTransaction
+	iter := a.api.BalanceTransactions.List(params)
+	for iter.Next() {
+		bt := iter.BalanceTransaction()
+		transactions = append(transactions, BalanceTransaction{
+			ID:       bt.ID,
+			Type:     string(bt.Type),
+			Amount:   bt.Amount,
+			Fee:      bt.Fee,
+			Net:      bt.Net,
+			Currency: string(bt.Currency),
+			Created:  time.Unix(bt.Created, 0),
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+func (a *StripeAdapter) ListPayments(ctx context.Context, from, to time.Time) ([]PaymentSnapshot, error) {
+	params := &stripe.PaymentIntentListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThan:         to.Unix(),
+		},
+	}
+	params.Context = ctx
+	params.AddExpand("data.latest_charge")
+
+	var snapshots []PaymentSnapshot
+	iter := a.api.PaymentIntents.List(params)
+	for iter.Next() {
+		intent := iter.PaymentIntent()
+		snapshot := PaymentSnapshot{
+			PaymentEvent: *stripePaymentEvent(intent),
+			EventType:    stripeIntentEventType(intent),
+			Created:      time.Unix(intent.Created, 0),
+		}
+		if intent.Status == stripe.PaymentIntentStatusSucceeded {
+			snapshot.Amount = intent.AmountReceived
+		}
+		if charge := intent.LatestCharge; charge != nil {
+			snapshot.AmountRefunded = charge.AmountRefunded
+			snapshot.Disputed = charge.Disputed
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return snapshots, nil
+}
+
+func (a *StripeAdapter) ListSubscriptionCheckouts(ctx context.Context, from, to time.Time) ([]SubscriptionEvent, error) {
+	params := &stripe.CheckoutSessionListParams{
+		CreatedRange: &stripe.RangeQueryParams{
+			GreaterThanOrEqual: from.Unix(),
+			LesserThan:         to.Unix(),
+		},
+		Status: stripe.String(string(stripe.CheckoutSessionStatusComplete)),
+	}
+	params.Context = ctx
+
+	var sessions []SubscriptionEvent
+	iter := a.api.CheckoutSessions.List(params)
+	for iter.Next() {
+		session := iter.CheckoutSession()
+		if session.Mode != stripe.CheckoutSessionModeSubscription || session.Subscription == nil {
+			continue
+		}
+		sessions = append(sessions, SubscriptionEvent{
+			SubscriptionID: session.Subscription.ID,
+			SessionID:      session.ID,
+			PaymentStatus:  string(session.PaymentStatus),
+			Metadata:       session.Metadata,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (a *StripeAdapter) ListSubscriptions(ctx context.Context) ([]SubscriptionSnapshot, error) {
+	params := &stripe.SubscriptionListParams{
+		Status: stripe.String("all"),
+	}
+	params.Context = ctx
+
+	var subscriptions []SubscriptionSnapshot
+	iter := a.api.Subscriptions.List(params)
+	for iter.Next() {
+		sub := iter.Subscription()
+		subscriptions = append(subscriptions, SubscriptionSnapshot{
+			SubscriptionID: sub.ID,
+			Status:         string(sub.Status),
+			Canceled: sub.Status == stripe.SubscriptionStatusCanceled ||
+				sub.Status == stripe.SubscriptionStatusIncompleteExpired,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return subscriptions, nil
+}
+
+func (a *StripeAdapter) CreateCustomer(ctx context.Context, req CustomerRequest) (string, error) {
+	params := &stripe.CustomerParams{
+		Metadata: req.Metadata,
+	}
+	params.Context = ctx
+	if req.Email != "" {
+		params.Email = stripe.String(req.Email)
+	}
+	if req.IdempotencyKey != "" {
+		params.SetIdempotencyKey(req.IdempotencyKey)
+	}
+
+	customer, err := a.api.Customers.New(params)
+	if err != nil {
+		return "", err
+	}
+	return customer.ID, nil
+}
+
+func (a *StripeAdapter) CreateSetupIntent(ctx context.Context, customerID, idempotencyKey string) (*SetupIntent, error) {
+	params := &stripe.SetupIntentParams{
+		Customer:           stripe.String(customerID),
+		Usage:              stripe.String(string(stripe.SetupIntentUsageOffSession)),
+		PaymentMethodTypes: stripe.StringSlice([]string{"card"}),
+	}
+	params.Context = ctx
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+
+	intent, err := a.api.SetupIntents.New(params)
+	if err != nil {
+		return nil, err
+	}
+	return &SetupIntent{
+		ID:           intent.ID,
+		Status:       string(intent.Status),
+		ClientSecret: intent.ClientSecret,
+	}, nil
+}
+
+func (a *StripeAdapter) ListPaymentMethods(ctx context.Context, customerID string) ([]PaymentMethod, error) {
+	params := &stripe.PaymentMethodListParams{
+		Customer: stripe.String(customerID),
+	}
+	params.Context = ctx
+
+	var methods []PaymentMethod
+	iter := a.api.PaymentMethods.List(params)
+	for iter.Next() {
+		methods = append(methods, stripePaymentMethod(iter.PaymentMethod()))
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+	return methods, nil
+}
+
+func (a *StripeAdapter) DetachPaymentMethod(ctx context.Context, customerID, paymentMethodID string) error {
+	params := &stripe.PaymentMethodParams{}
+	params.Context = ctx
+
+	method, err := a.api.PaymentMethods.Get(paymentMethodID, params)
+	var stripeErr *stripe.Error
+	if errors.As(err, &stripeErr) && stripeErr.HTTPStatusCode == http.StatusNotFound {
+		return ErrPaymentMethodNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if method.Customer == nil || method.Customer.ID != customerID {
+		return ErrPaymentMethodNotFound
+	}
+
+	detachParams := &stripe.PaymentMethodDetachParams{}
+	detachParams.Context = ctx
+	_, err = a.api.PaymentMethods.Detach(paymentMethodID, detachParams)
+	return err
+}
+
+func stripePaymentMethod(method *stripe.PaymentMethod) PaymentMethod {
+	pm := PaymentMethod{
+		ID:   method.ID,
+		Type: string(method.Type),
+	}
+	if method.Card != nil {
+		pm.Brand = string(method.Card.Brand)
+		pm.Last4 = method.Card.Last4
+		pm.ExpMonth = method.Card.ExpMonth
+		pm.ExpYear = method.Card.ExpYear
+	}
+	return pm
+}
+
+func stripePayoutAccount(acc *stripe.Account) *PayoutAccount {
+	return &PayoutAccount{
+		ID:               acc.ID,
+		ChargesEnabled:   acc.ChargesEnabled,
+		PayoutsEnabled:   acc.PayoutsEnabled,
+		DetailsSubmitted: acc.DetailsSubmitted,
+	}
+}
+
+func (a *StripeAdapter) ParseWebhook(ctx context.Context, payload []byte, header http.Header, secret string) (*Event, error) {
+	stripeEvent, err := webhook.ConstructEvent(payload, header.Get("Stripe-Signature"), secret)
+	if err != nil {
+		return nil, err
+	}
+
+	event := &Event{
+		ID:           stripeEvent.ID,
+		ProviderType: string(stripeEvent.Type),
+	}
+
+	switch stripeEvent.Type {
+	case "payment_intent.succeeded", "payment_intent.payment_failed", "payment_intent.canceled",
+		"payment_intent.processing", "payment_intent.requires_action", "payment_intent.amount_capturable_updated":
+		var intent stripe.PaymentIntent
+		if err := json.Unmarshal(stripeEvent.Data.Raw, &intent); err != nil {
+			return nil, err
+		}
+		event.Type = stripePaymentEventTypes[string(stripeEvent.Type)]
+		event.Payment = stripePaymentEvent(&intent)
+
+	case "charge.dispute.created", "charge.dispute.closed":
+		var dispute stripe.Dispute
+		if err := json.Unmarshal(stripeEvent.Data.Raw, &dispute); err != nil {
+			return nil, err
+		}
+		event.Type = EventDisputeCreated
+		if stripeEvent.Type == "charge.dispute.closed" {
+			event.Type = EventDisputeClosed
+		}
+		event.Dispute = &DisputeEvent{
+			DisputeID: dispute.ID,
+			Status:    string(dispute.Status),
+			Reason:    string(dispute.Reason),
+			Amount:    dispute.Amount,
+		}
+		if dispute.PaymentIntent != nil {
+			event.Dispute.PaymentID = dispute.PaymentIntent.ID
+		}
+
+	case "account.updated":
+		var acc stripe.Account
+		if err := json.Unmarshal(stripeEvent.Data.Raw, &acc); err != nil {
+			return nil, err
+		}
+		event.Type = EventAccountUpdated
+		event.Account = stripePayoutAccount(&acc)
+
+	case "checkout.session.completed":
+		var session stripe.CheckoutSession
+		if err := json.Unmarshal(stripeEvent.Data.Raw, &session); err != nil {
+			return nil, err
+		}
+		event.Type = EventSubscriptionCompleted
+		event.Subscription = &SubscriptionEvent{
+			SessionID:     session.ID,
+			PaymentStatus: string(session.PaymentStatus),
+			Metadata:      session.Metadata,
+		}
+		if session.Subscription != nil {
+			event.Subscription.SubscriptionID = session.Subscription.ID
+		}
+
+	case "refund.created", "refund.updated", "refund.failed":
+		var refund stripe.Refund
+		if err := json.Unmarshal(stripeEvent.Data.Raw, &refund); err != nil {
+			return nil, err
+		}
+		event.Type = EventRefundUpdated
+		event.Refund = &RefundEvent{
+			RefundID: refund.ID,
+			Status:   string(refund.Status),
+			Amount:   refund.Amount,
+			Metadata: refund.Metadata,
+		}
+		if refund.PaymentIntent != nil {
+			event.Refund.PaymentID = refund.PaymentIntent.ID
+		}
+		if refund.FailureReason != "" {
+			event.Refund.FailureReason = string(refund.FailureReason)
+		}
+
+	case "charge.refunded":
+		var charge stripe.Charge
+		if err := json.Unmarshal(stripeEvent.Data.Raw, &charge); err != nil {
+			return nil, err
+		}
+		event.Type = EventChargeRefunded
+		event.Refund = &RefundEvent{
+			TotalRefunded: charge.AmountRefunded,
+			Metadata:      charge.Metadata,
+		}
+		if charge.PaymentIntent != nil {
+			event.Refund.PaymentID = charge.PaymentIntent.ID
+		}
+
+	case "customer.subscription.deleted":
+		var sub stripe.Subscription
+		if err := json.Unmarshal(stripeEvent.Data.Raw, &sub); err != nil {
+			return nil, err
+		}
+		event.Type = EventSubscriptionCanceled
+		event.Subscription = &SubscriptionEvent{
+			SubscriptionID: sub.ID,
+			Metadata:       sub.Metadata,
+		}
+	}
+
+	return event, nil
+}
+
+var stripePaymentEventTypes = map[string]string{
+	"payment_intent.succeeded":       EventPaymentSucceeded,
+	"payment_intent.payment_failed":  EventPaymentFailed,
+	"payment_intent.canceled":        EventPaymentCanceled,
+	"payment_intent.processing":      EventPaymentProcessing,
+	"payment_intent.requires_action": EventPaymentRequiresAction,
+	// Sent when a manually captured intent is authorized and awaits capture.
+	"payment_intent.amount_capturable_updated": EventPaymentAuthorized,
+}
+
+// stripeIntentEventType maps the current status of an intent onto the event
+// a webhook would have delivered for it.
+func stripeIntentEventType(intent *stripe.PaymentIntent) string {
+	switch intent.Status {
+	case stripe.PaymentIntentStatusSucceeded:
+		return EventPaymentSucceeded
+	case stripe.PaymentIntentStatusRequiresCapture:
+		return EventPaymentAuthorized
+	case stripe.PaymentIntentStatusCanceled:
+		return EventPaymentCanceled
+	case stripe.PaymentIntentStatusProcessing:
+		return EventPaymentProcessing
+	case stripe.PaymentIntentStatusRequiresAction:
+		return EventPaymentRequiresAction
+	case stripe.PaymentIntentStatusRequiresPaymentMethod:
+		if intent.LastPaymentError != nil {
+			return EventPaymentFailed
+		}
+	}
+	return ""
+}
+
+func stripePaymentIntent(intent *stripe.PaymentIntent) *PaymentIntent {
+	pi := &PaymentIntent{
+		ID:           intent.ID,
+		Status:       string(intent.Status),
+		EventType:    stripeIntentEventType(intent),
+		Amount:       intent.Amount,
+		Currency:     string(intent.Currency),
+		ClientSecret: intent.ClientSecret,
+	}
+	if intent.LastPaymentError != nil {
+		pi.FailureCode = string(intent.LastPaymentError.Code)
+		if intent.LastPaymentError.DeclineCode != "" {
+			pi.FailureCode = string(intent.LastPaymentError.DeclineCode)
+		}
+	}
+	return pi
+}
+
+func stripePaymentEvent(intent *stripe.PaymentIntent) *PaymentEvent {
+	event := &PaymentEvent{
+		PaymentID: intent.ID,
+		Status:    string(intent.Status),
+		Amount:    intent.Amount,
+		Currency:  string(intent.Currency),
+		Metadata:  intent.Metadata,
+	}
+	if intent.LastPaymentError != nil {
+		event.FailureCode = string(intent.LastPaymentError.Code)
+		if intent.LastPaymentError.DeclineCode != "" {
+			event.FailureCode = string(intent.LastPaymentError.DeclineCode)
+		}
+		event.FailureReason = intent.LastPaymentError.Msg
+	}
+	if intent.Status == stripe.PaymentIntentStatusCanceled && intent.CancellationReason != "" {
+		event.FailureReason = string(intent.CancellationReason)
+	}
+	return event
+}
@@ -0,0 +1,138 @@
+package pricing
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/ozoli99/Harmonia/currency"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+var (
+	ErrNoService     = errors.New("appointment has no service")
+	ErrPromoInactive = errors.New("promo code is not active")
+	ErrPromoService  = errors.New("promo code does not apply to this service")
+	ErrPromoCurrency = errors.New("promo code is for another currency")
+	// ErrCurrencyMismatch means the service is priced in another currency
+	// than the masseur takes payments in.
+	ErrCurrencyMismatch = errors.New("service is priced in another currency")
+)
+
+// Input is everything the price of an appointment depends on, loaded from the
+// database rather than from the client.
+type Input struct {
+	AppointmentID   int
+	ClientID        int
+	MasseurID       int
+	Service         *models.Service
+	AddOns          []models.AddOn
+	DiscountPercent float64
+	// Currency is the currency the masseur takes payments in, if known.
+	Currency string
+}
+
+type Line struct {
+	Description string `json:"description"`
+	Amount      int64  `json:"amount"`
+}
+
+type Quote struct {
+	AppointmentID   int    `json:"appointmentId"`
+	Currency        string `json:"currency"`
+	DurationMinutes int    `json:"durationMinutes"`
+	Lines           []Line `json:"lines"`
+	Subtotal        int64  `json:"subtotal"`
+	Discount        int64  `json:"discount"`
+	Tax             int64  `json:"tax"`
+	Total           int64  `json:"total"`
+}
+
+// Calculate prices an appointment. The service is charged at its catalog
+// price for its catalog duration, which the booked times are checked against
+// when the appointment is saved; add-ons are added at face value, the
+// discount applies to the subtotal and tax is charged on the discounted
+// amount. All amounts are in the currency's minor unit, rounded to amounts
+// the currency can be charged in.
+func Calculate(in Input) (*Quote, error) {
+	if in.Service == nil {
+		return nil, ErrNoService
+	}
+	cur, err := currency.Lookup(in.Service.Currency)
+	if err != nil {
+		return nil, err
+	}
+	if in.Currency != "" && currency.Normalize(in.Currency) != cur.Code {
+		return nil, ErrCurrencyMismatch
+	}
+
+	quote := &Quote{
+		AppointmentID:   in.AppointmentID,
+		Currency:        cur.Code,
+		DurationMinutes: in.Service.DurationMinutes,
+	}
+
+	servicePrice := cur.Round(in.Service.Price)
+	quote.Lines = append(quote.Lines, Line{Description: in.Service.Name, Amount: servicePrice})
+	quote.Subtotal = servicePrice
+
+	for _, addOn := range in.AddOns {
+		price := cur.Round(addOn.Price)
+		quote.Lines = append(quote.Lines, Line{Description: addOn.Name, Amount: price})
+		quote.Subtotal += price
+	}
+
+	if in.DiscountPercent > 0 {
+		quote.Discount = cur.Round(roundDiv(float64(quote.Subtotal)*math.Min(in.DiscountPercent, 100), 100))
+	}
+
+	taxable := quote.Subtotal - quote.Discount
+	quote.Tax = cur.Round(roundDiv(float64(taxable)*in.Service.TaxRate, 100))
+	quote.Total = taxable + quote.Tax
+
+	return quote, nil
+}
+
+// PromoDiscount works out what promo takes off a quote for serviceID at now.
+// Percentages apply to the total including tax, and the discount never
+// exceeds the total.
+func PromoDiscount(promo *models.PromoCode, quote *Quote, serviceID *int, now time.Time) (int64, error) {
+	if !promo.Active || (promo.ValidFrom != nil && now.Before(*promo.ValidFrom)) || (promo.ValidUntil != nil && !now.Before(*promo.ValidUntil)) {
+		return 0, ErrPromoInactive
+	}
+	if len(promo.ServiceIDs) > 0 {
+		applies := false
+		for _, id := range promo.ServiceIDs {
+			if serviceID != nil && int64(*serviceID) == id {
+				applies = true
+				break
+			}
+		}
+		if !applies {
+			return 0, ErrPromoService
+		}
+	}
+
+	var discount int64
+	switch promo.DiscountType {
+	case "percent":
+		discount = roundDiv(float64(quote.Total)*math.Min(promo.Percent, 100), 100)
+		if cur, err := currency.Lookup(quote.Currency); err == nil {
+			discount = cur.Round(discount)
+		}
+	case "fixed":
+		if !strings.EqualFold(promo.Currency, quote.Currency) {
+			return 0, ErrPromoCurrency
+		}
+		discount = promo.AmountOff
+	}
+	if discount > quote.Total {
+		discount = quote.Total
+	}
+	return discount, nil
+}
+
+func roundDiv(numerator, denominator float64) int64 {
+	return int64(math.Round(numerator / denominator))
+}
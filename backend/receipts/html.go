@@ -0,0 +1,72 @@
+package receipts
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/ozoli99/Harmonia/models"
+)
+
+var htmlTemplate = template.Must(template.New("receipt").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Reference}}</title>
+<style>
+body { font-family: Helvetica, Arial, sans-serif; color: #1f2937; max-width: 720px; margin: 40px auto; padding: 0 16px; }
+h1 { font-size: 24px; margin-bottom: 4px; }
+.meta { color: #6b7280; margin-bottom: 32px; }
+.parties { display: flex; justify-content: space-between; margin-bottom: 32px; }
+.parties div { white-space: pre-line; }
+table { width: 100%; border-collapse: collapse; }
+th, td { padding: 8px 0; text-align: left; }
+th { border-bottom: 1px solid #d1d5db; }
+td.amount, th.amount { text-align: right; }
+tr.strong td { font-weight: bold; border-top: 1px solid #d1d5db; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="meta">No. {{.Reference}} &middot; Issued {{.IssuedAt.Format "2006-01-02"}} &middot; Appointment on {{.Doc.AppointmentDate.Format "2006-01-02"}}</div>
+<div class="parties">
+<div><strong>{{.Doc.Business.Name}}</strong>
+{{.Doc.Business.Address}}{{if .Doc.Business.TaxID}}
+Tax ID: {{.Doc.Business.TaxID}}{{end}}{{if .Doc.Business.Email}}
+{{.Doc.Business.Email}}{{end}}</div>
+<div><strong>Billed to</strong>
+{{.Doc.Customer.Name}}{{if .Doc.Customer.Email}}
+{{.Doc.Customer.Email}}{{end}}</div>
+</div>
+<table>
+<thead><tr><th>Description</th><th class="amount">Amount</th></tr></thead>
+<tbody>
+{{range .Lines}}<tr><td>{{.Label}}</td><td class="amount">{{.Amount}}</td></tr>
+{{end}}{{range .Totals}}<tr{{if .Strong}} class="strong"{{end}}><td>{{.Label}}</td><td class="amount">{{.Amount}}</td></tr>
+{{end}}</tbody>
+</table>
+</body>
+</html>
+`))
+
+// HTML renders a receipt as a standalone HTML page.
+func HTML(receipt *models.Receipt) ([]byte, error) {
+	doc := &receipt.Document
+	lines := make([]row, 0, len(doc.Lines))
+	for _, line := range doc.Lines {
+		lines = append(lines, row{Label: line.Description, Amount: formatMoney(line.Amount, doc.Currency)})
+	}
+
+	var buf bytes.Buffer
+	err := htmlTemplate.Execute(&buf, map[string]interface{}{
+		"Title":     title(doc),
+		"Reference": receipt.Reference(),
+		"IssuedAt":  receipt.IssuedAt,
+		"Doc":       doc,
+		"Lines":     lines,
+		"Totals":    totals(doc),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
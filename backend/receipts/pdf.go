@@ -0,0 +1,180 @@
+package receipts
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/ozoli99/Harmonia/models"
+)
+
+// A4 in points, and the margins receipts are laid out within.
+const (
+	pageWidth    = 595
+	pageHeight   = 842
+	marginLeft   = 56
+	marginRight  = pageWidth - 56
+	marginTop    = pageHeight - 64
+	marginBottom = 64
+)
+
+// The standard PDF fonts need no embedding. Amounts are set in Courier,
+// whose fixed advance of 0.6em lets them be right-aligned without metrics.
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+	fontMono    = "F3"
+	fontMonoB   = "F4"
+)
+
+var fontNames = []string{"Helvetica", "Helvetica-Bold", "Courier", "Courier-Bold"}
+
+// PDF renders a receipt as a PDF document.
+func PDF(receipt *models.Receipt) []byte {
+	doc := &receipt.Document
+	w := &pdfWriter{}
+	w.newPage()
+
+	w.text(marginLeft, w.y, fontBold, 20, title(doc))
+	w.y -= 22
+	w.text(marginLeft, w.y, fontRegular, 10, fmt.Sprintf("No. %s  |  Issued %s  |  Appointment on %s",
+		receipt.Reference(), receipt.IssuedAt.Format("2006-01-02"), doc.AppointmentDate.Format("2006-01-02")))
+	w.y -= 36
+
+	business := []string{doc.Business.Address}
+	if doc.Business.TaxID != "" {
+		business = append(business, "Tax ID: "+doc.Business.TaxID)
+	}
+	business = append(business, doc.Business.Email)
+	top := w.y
+	w.block(marginLeft, doc.Business.Name, business)
+	left := w.y
+	w.y = top
+	w.block(pageWidth/2, "Billed to", []string{doc.Customer.Name, doc.Customer.Email})
+	w.y = min(w.y, left) - 16
+
+	w.row("Description", "Amount", true)
+	w.rule()
+	for _, line := range doc.Lines {
+		w.row(line.Description, formatMoney(line.Amount, doc.Currency), false)
+	}
+	w.rule()
+	for _, r := range totals(doc) {
+		w.row(r.Label, r.Amount, r.Strong)
+	}
+
+	return w.bytes()
+}
+
+type pdfWriter struct {
+	pages []*bytes.Buffer
+	y     float64
+}
+
+func (w *pdfWriter) newPage() {
+	w.pages = append(w.pages, &bytes.Buffer{})
+	w.y = marginTop
+}
+
+// ensure starts a new page unless height fits above the bottom margin.
+func (w *pdfWriter) ensure(height float64) {
+	if w.y-height < marginBottom {
+		w.newPage()
+	}
+}
+
+func (w *pdfWriter) text(x, y float64, font string, size float64, s string) {
+	fmt.Fprintf(w.pages[len(w.pages)-1], "BT /%s %g Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, escapePDF(s))
+}
+
+// block writes a bold heading with the non-empty lines under it.
+func (w *pdfWriter) block(x float64, heading string, lines []string) {
+	w.text(x, w.y, fontBold, 11, heading)
+	for _, line := range lines {
+		if line == "" {
+			continue
+		}
+		for _, part := range strings.Split(line, "\n") {
+			w.y -= 14
+			w.text(x, w.y, fontRegular, 10, part)
+		}
+	}
+	w.y -= 14
+}
+
+// row writes a label on the left and an amount aligned to the right margin.
+func (w *pdfWriter) row(label, amount string, strong bool) {
+	w.ensure(18)
+	labelFont, amountFont := fontRegular, fontMono
+	if strong {
+		labelFont, amountFont = fontBold, fontMonoB
+	}
+	w.text(marginLeft, w.y, labelFont, 10, label)
+	w.text(marginRight-float64(len(amount))*6, w.y, amountFont, 10, amount)
+	w.y -= 18
+}
+
+func (w *pdfWriter) rule() {
+	fmt.Fprintf(w.pages[len(w.pages)-1], "0.8 G %d %.2f m %d %.2f l S 0 G\n", marginLeft, w.y+12, marginRight, w.y+12)
+}
+
+// bytes assembles the document: catalog, page tree, fonts, then a page and
+// its content stream for every page, followed by the cross-reference table.
+func (w *pdfWriter) bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	firstPage := 3 + len(fontNames)
+	kids := make([]string, len(w.pages))
+	for i := range w.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", firstPage+2*i)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(w.pages)))
+	var fonts strings.Builder
+	for i, name := range fontNames {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", name))
+		fmt.Fprintf(&fonts, "/F%d %d 0 R ", i+1, 3+i)
+	}
+	for i, page := range w.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << %s>> >> /Contents %d 0 R >>",
+			pageWidth, pageHeight, fonts.String(), firstPage+2*i+1))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// escapePDF encodes s for a literal string in WinAnsiEncoding. Characters
+// outside Latin-1 and the euro sign are replaced with '?'.
+func escapePDF(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '€':
+			b.WriteString(`\200`)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, `\%03o`, r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
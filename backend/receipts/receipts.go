@@ -0,0 +1,71 @@
+// Package receipts renders issued receipts as HTML and PDF.
+package receipts
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ozoli99/Harmonia/currency"
+	"github.com/ozoli99/Harmonia/models"
+)
+
+// row is a labelled amount in a receipt's totals.
+type row struct {
+	Label  string
+	Amount string
+	Strong bool
+}
+
+// totals lists the rows under a receipt's line items, leaving out the ones
+// that do not apply to it.
+func totals(doc *models.ReceiptDocument) []row {
+	money := func(amount int64) string { return formatMoney(amount, doc.Currency) }
+
+	rows := []row{{Label: "Subtotal", Amount: money(doc.Subtotal)}}
+	if doc.Discount > 0 {
+		rows = append(rows, row{Label: "Discount", Amount: money(-doc.Discount)})
+	}
+	if doc.Tax > 0 || doc.TaxRate > 0 {
+		rows = append(rows, row{Label: fmt.Sprintf("Tax (%s%%)", formatRate(doc.TaxRate)), Amount: money(doc.Tax)})
+	}
+	if doc.PromoDiscount > 0 {
+		rows = append(rows, row{Label: "Promo code", Amount: money(-doc.PromoDiscount)})
+	}
+	rows = append(rows, row{Label: "Total", Amount: money(doc.Total), Strong: true})
+	if doc.PreviouslyPaid > 0 {
+		rows = append(rows, row{Label: "Previously paid", Amount: money(-doc.PreviouslyPaid)})
+	}
+	if doc.GiftCardAmount > 0 {
+		rows = append(rows, row{Label: "Paid by gift card", Amount: money(doc.GiftCardAmount)})
+	}
+	if charged := doc.AmountPaid - doc.GiftCardAmount; charged > 0 {
+		rows = append(rows, row{Label: "Paid by " + doc.Method, Amount: money(charged)})
+	}
+	rows = append(rows, row{Label: "Amount paid", Amount: money(doc.AmountPaid), Strong: true})
+	if doc.BalanceDue > 0 {
+		rows = append(rows, row{Label: "Balance due", Amount: money(doc.BalanceDue)})
+	}
+	return rows
+}
+
+var kindTitles = map[string]string{
+	"deposit": "Deposit receipt",
+	"balance": "Balance receipt",
+	"tip":     "Tip receipt",
+}
+
+func title(doc *models.ReceiptDocument) string {
+	if t, ok := kindTitles[doc.Kind]; ok {
+		return t
+	}
+	return "Receipt"
+}
+
+// formatMoney renders an amount in minor units with its currency code.
+func formatMoney(amount int64, code string) string {
+	return currency.Format(amount, code) + " " + strings.ToUpper(code)
+}
+
+func formatRate(rate float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", rate), "0"), ".")
+}
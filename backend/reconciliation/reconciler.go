@@ -0,0 +1,412 @@
+// Package reconciliation compares payments and subscriptions with the payment
+// provider to catch drift left behind by lost webhooks.
+package reconciliation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+)
+
+const (
+	// lookback is how far back each run looks. Nightly runs overlap so a
+	// failed run is covered by the next one.
+	lookback = 72 * time.Hour
+	// settleDelay leaves recent payments alone; their webhooks may still be
+	// on the way.
+	settleDelay = 15 * time.Minute
+	// localMargin narrows the window for our own payments, whose provider
+	// payment is created moments before the row.
+	localMargin = time.Hour
+)
+
+// Issue kinds.
+const (
+	IssueStatusMismatch    = "status_mismatch"
+	IssueAmountMismatch    = "amount_mismatch"
+	IssueRefundMismatch    = "refund_mismatch"
+	IssueDisputeMissing    = "dispute_missing"
+	IssueMissingLocally    = "missing_locally"
+	IssueMissingAtProvider = "missing_at_provider"
+)
+
+// Provider is the part of payments.Adapter the reconciler reads. A local
+// stand-in can implement it when no provider account is available.
+type Provider interface {
+	Name() string
+	ListPayments(ctx context.Context, from, to time.Time) ([]payments.PaymentSnapshot, error)
+	ListSubscriptionCheckouts(ctx context.Context, from, to time.Time) ([]payments.SubscriptionEvent, error)
+	ListSubscriptions(ctx context.Context) ([]payments.SubscriptionSnapshot, error)
+}
+
+// EventSink receives the events synthesized to repair drift;
+// webhooks.Processor implements it.
+type EventSink interface {
+	Receive(ctx context.Context, provider string, event *payments.Event, payload []byte) (bool, error)
+}
+
+// Reconciler repairs drift that is safe to repair by feeding the events the
+// provider would have sent through the regular webhook handlers, whose
+// status guards keep a stale event from undoing a later state. Everything
+// else is recorded as an issue for an admin.
+type Reconciler struct {
+	provider Provider
+	records  db.ReconciliationRepository
+	sink     EventSink
+	logger   *zap.Logger
+}
+
+func NewReconciler(provider Provider, records db.ReconciliationRepository, sink EventSink, logger *zap.Logger) *Reconciler {
+	return &Reconciler{
+		provider: provider,
+		records:  records,
+		sink:     sink,
+		logger:   logger,
+	}
+}
+
+type report struct {
+	checked  int
+	repaired int
+	issues   int
+}
+
+// Run reconciles the payments and subscription checkouts created during the
+// lookback window and every subscription the provider knows of.
+func (r *Reconciler) Run(ctx context.Context) error {
+	to := time.Now().Add(-settleDelay)
+	from := to.Add(-lookback)
+
+	var rep report
+	err := r.reconcilePayments(ctx, from, to, &rep)
+	if errors.Is(err, payments.ErrNotSupported) {
+		r.logger.Info("Payment provider does not support reconciliation", zap.String("provider", r.provider.Name()))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reconcile payments: %w", err)
+	}
+	if err := r.reconcileSubscriptions(ctx, from, to, &rep); err != nil {
+		return fmt.Errorf("reconcile subscriptions: %w", err)
+	}
+
+	r.logger.Info("Reconciliation finished",
+		zap.String("provider", r.provider.Name()),
+		zap.Int("checked", rep.checked),
+		zap.Int("repaired", rep.repaired),
+		zap.Int("issues", rep.issues),
+	)
+	return nil
+}
+
+// agreeingStatuses lists, per record source, the local statuses consistent
+// with the provider's current state.
+var agreeingStatuses = map[string]map[string][]string{
+	"payment": {
+		payments.EventPaymentSucceeded:      {"paid", "partially_refunded", "refunded", "disputed", "dispute_lost"},
+		payments.EventPaymentAuthorized:     {"authorized"},
+		payments.EventPaymentCanceled:       {"canceled"},
+		payments.EventPaymentFailed:         {"failed"},
+		payments.EventPaymentProcessing:     {"processing"},
+		payments.EventPaymentRequiresAction: {"requires_action"},
+	},
+	"package": {
+		payments.EventPaymentSucceeded: {"paid"},
+		payments.EventPaymentFailed:    {"failed"},
+		payments.EventPaymentCanceled:  {"canceled"},
+	},
+	"gift_card": {
+		payments.EventPaymentSucceeded: {"active", "disabled"},
+		payments.EventPaymentCanceled:  {"canceled"},
+	},
+}
+
+// settled reports whether status is final for source. Moving a settled
+// record is never automatic.
+func settled(source, status string) bool {
+	statuses := agreeingStatuses[source]
+	return slices.Contains(statuses[payments.EventPaymentSucceeded], status) ||
+		slices.Contains(statuses[payments.EventPaymentCanceled], status)
+}
+
+func (r *Reconciler) reconcilePayments(ctx context.Context, from, to time.Time, rep *report) error {
+	snapshots, err := r.provider.ListPayments(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	refs := make([]string, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		refs = append(refs, snapshot.PaymentID)
+	}
+	records, err := r.records.PaymentRecords(ctx, refs)
+	if err != nil {
+		return fmt.Errorf("load payment records: %w", err)
+	}
+	local := make(map[string]models.ReconciliationRecord, len(records))
+	for _, record := range records {
+		local[record.Reference] = record
+	}
+
+	seen := make(map[string]bool, len(snapshots))
+	for _, snapshot := range snapshots {
+		seen[snapshot.PaymentID] = true
+		rep.checked++
+
+		record, ok := local[snapshot.PaymentID]
+		if !ok {
+			if err := r.checkUnknownPayment(ctx, snapshot, rep); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := r.comparePayment(ctx, snapshot, record, rep); err != nil {
+			return err
+		}
+	}
+
+	created, err := r.records.PaymentsCreatedBetween(ctx, r.provider.Name(), from.Add(localMargin), to)
+	if err != nil {
+		return fmt.Errorf("load local payments: %w", err)
+	}
+	for _, record := range created {
+		if seen[record.Reference] {
+			continue
+		}
+		err := r.recordIssue(ctx, rep, record.OrganizationID, IssueMissingAtProvider, record.Reference, record.Status, "",
+			"the provider has no payment with this ID")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkUnknownPayment reports money taken for one of our organizations that
+// has no payment or purchase behind it.
+func (r *Reconciler) checkUnknownPayment(ctx context.Context, snapshot payments.PaymentSnapshot, rep *report) error {
+	if snapshot.EventType != payments.EventPaymentSucceeded && snapshot.EventType != payments.EventPaymentAuthorized {
+		return nil
+	}
+	orgID, err := strconv.Atoi(snapshot.Metadata["organization_id"])
+	if err != nil {
+		// Not created by Harmonia.
+		return nil
+	}
+	return r.recordIssue(ctx, rep, orgID, IssueMissingLocally, snapshot.PaymentID, "", snapshot.Status,
+		fmt.Sprintf("%d %s taken for a %q payment that is not recorded", snapshot.Amount, snapshot.Currency, snapshot.Metadata["kind"]))
+}
+
+func (r *Reconciler) comparePayment(ctx context.Context, snapshot payments.PaymentSnapshot, record models.ReconciliationRecord, rep *report) error {
+	if snapshot.EventType == "" {
+		// Still waiting on the client; nothing to compare yet.
+		return nil
+	}
+
+	expected, tracked := agreeingStatuses[record.Source][snapshot.EventType]
+	if !tracked {
+		return nil
+	}
+	if !slices.Contains(expected, record.Status) {
+		if settled(record.Source, record.Status) {
+			return r.recordIssue(ctx, rep, record.OrganizationID, IssueStatusMismatch, snapshot.PaymentID, record.Status, snapshot.Status,
+				fmt.Sprintf("the provider reports %s but the %s is already settled", snapshot.EventType, record.Source))
+		}
+		event := &payments.Event{
+			ID:      fmt.Sprintf("reconcile:%s:%s", snapshot.PaymentID, snapshot.EventType),
+			Type:    snapshot.EventType,
+			Payment: &snapshot.PaymentEvent,
+		}
+		return r.repair(ctx, rep, event, snapshot, record.OrganizationID, IssueStatusMismatch, snapshot.PaymentID, record.Status, snapshot.Status)
+	}
+
+	if record.Source != "payment" || snapshot.EventType != payments.EventPaymentSucceeded {
+		return nil
+	}
+	if snapshot.Amount != record.Amount {
+		err := r.recordIssue(ctx, rep, record.OrganizationID, IssueAmountMismatch, snapshot.PaymentID, record.Status, snapshot.Status,
+			fmt.Sprintf("recorded %d %s, the provider took %d", record.Amount, snapshot.Currency, snapshot.Amount))
+		if err != nil {
+			return err
+		}
+	}
+	switch {
+	case snapshot.AmountRefunded > record.RefundedAmount:
+		event := &payments.Event{
+			ID:   fmt.Sprintf("reconcile:%s:refunded:%d", snapshot.PaymentID, snapshot.AmountRefunded),
+			Type: payments.EventChargeRefunded,
+			Refund: &payments.RefundEvent{
+				PaymentID:     snapshot.PaymentID,
+				TotalRefunded: snapshot.AmountRefunded,
+				Metadata:      snapshot.Metadata,
+			},
+		}
+		if err := r.repair(ctx, rep, event, snapshot, record.OrganizationID, IssueRefundMismatch, snapshot.PaymentID, record.Status, snapshot.Status); err != nil {
+			return err
+		}
+	case snapshot.AmountRefunded < record.RefundedAmount:
+		err := r.recordIssue(ctx, rep, record.OrganizationID, IssueRefundMismatch, snapshot.PaymentID, record.Status, snapshot.Status,
+			fmt.Sprintf("recorded %d refunded, the provider refunded %d", record.RefundedAmount, snapshot.AmountRefunded))
+		if err != nil {
+			return err
+		}
+	}
+	if snapshot.Disputed && !record.Disputed {
+		return r.recordIssue(ctx, rep, record.OrganizationID, IssueDisputeMissing, snapshot.PaymentID, record.Status, snapshot.Status,
+			"the provider reports a dispute that is not recorded")
+	}
+	return nil
+}
+
+func (r *Reconciler) reconcileSubscriptions(ctx context.Context, from, to time.Time, rep *report) error {
+	sessions, err := r.provider.ListSubscriptionCheckouts(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	subscriptions, err := r.provider.ListSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+
+	sessionIDs := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		sessionIDs = append(sessionIDs, session.SessionID)
+	}
+	subscriptionIDs := make([]string, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		subscriptionIDs = append(subscriptionIDs, sub.SubscriptionID)
+	}
+	records, err := r.records.SubscriptionRecords(ctx, sessionIDs, subscriptionIDs)
+	if err != nil {
+		return fmt.Errorf("load subscription records: %w", err)
+	}
+	bySession := make(map[string]models.ReconciliationRecord, len(records))
+	bySubscription := make(map[string]models.ReconciliationRecord, len(records))
+	for _, record := range records {
+		bySession[record.Reference] = record
+		if record.SubscriptionID != "" {
+			bySubscription[record.SubscriptionID] = record
+		}
+	}
+
+	for _, session := range sessions {
+		rep.checked++
+		if session.PaymentStatus != "paid" {
+			continue
+		}
+		record, ok := bySession[session.SessionID]
+		if !ok {
+			if orgID, err := strconv.Atoi(session.Metadata["organization_id"]); err == nil {
+				err := r.recordIssue(ctx, rep, orgID, IssueMissingLocally, session.SessionID, "", "paid",
+					"subscription checkout completed but not recorded")
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if record.Status != "pending" {
+			continue
+		}
+		event := &payments.Event{
+			ID:           fmt.Sprintf("reconcile:%s:completed", session.SessionID),
+			Type:         payments.EventSubscriptionCompleted,
+			Subscription: &session,
+		}
+		if err := r.repair(ctx, rep, event, session, record.OrganizationID, IssueStatusMismatch, session.SessionID, record.Status, "paid"); err != nil {
+			return err
+		}
+	}
+
+	for _, sub := range subscriptions {
+		record, ok := bySubscription[sub.SubscriptionID]
+		if !ok {
+			continue
+		}
+		rep.checked++
+
+		switch {
+		case sub.Canceled && record.Status == "active":
+			event := &payments.Event{
+				ID:   fmt.Sprintf("reconcile:%s:canceled", sub.SubscriptionID),
+				Type: payments.EventSubscriptionCanceled,
+				Subscription: &payments.SubscriptionEvent{
+					SubscriptionID: sub.SubscriptionID,
+					Metadata:       map[string]string{"organization_id": strconv.Itoa(record.OrganizationID)},
+				},
+			}
+			if err := r.repair(ctx, rep, event, sub, record.OrganizationID, IssueStatusMismatch, sub.SubscriptionID, record.Status, sub.Status); err != nil {
+				return err
+			}
+		case !sub.Canceled && record.Status == "canceled":
+			err := r.recordIssue(ctx, rep, record.OrganizationID, IssueStatusMismatch, sub.SubscriptionID, record.Status, sub.Status,
+				"canceled here but still running at the provider")
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// repair queues event for the webhook handlers. Event IDs are derived from
+// the drift they repair, so drift that survived an earlier repair comes back
+// as a duplicate and is reported instead.
+func (r *Reconciler) repair(ctx context.Context, rep *report, event *payments.Event, source interface{}, orgID int, kind, reference, localStatus, providerStatus string) error {
+	event.ProviderType = "reconciliation"
+	payload, err := json.Marshal(source)
+	if err != nil {
+		return err
+	}
+
+	duplicate, err := r.sink.Receive(ctx, r.provider.Name(), event, payload)
+	if err != nil {
+		return fmt.Errorf("queue repair %s: %w", event.ID, err)
+	}
+	if duplicate {
+		return r.recordIssue(ctx, rep, orgID, kind, reference, localStatus, providerStatus,
+			fmt.Sprintf("still out of sync after replaying %s", event.Type))
+	}
+
+	rep.repaired++
+	r.logger.Info("Queued reconciliation repair",
+		zap.Int("organization_id", orgID),
+		zap.String("reference", reference),
+		zap.String("event", event.Type),
+	)
+	return nil
+}
+
+func (r *Reconciler) recordIssue(ctx context.Context, rep *report, orgID int, kind, reference, localStatus, providerStatus, detail string) error {
+	issue := &models.ReconciliationIssue{
+		OrganizationID: orgID,
+		Provider:       r.provider.Name(),
+		Kind:           kind,
+		Reference:      reference,
+		LocalStatus:    localStatus,
+		ProviderStatus: providerStatus,
+		Detail:         detail,
+	}
+	if err := r.records.RecordIssue(ctx, issue); err != nil {
+		return fmt.Errorf("record %s issue for %s: %w", kind, reference, err)
+	}
+
+	rep.issues++
+	r.logger.Warn("Reconciliation issue",
+		zap.Int("organization_id", orgID),
+		zap.String("kind", kind),
+		zap.String("reference", reference),
+		zap.String("detail", detail),
+	)
+	return nil
+}
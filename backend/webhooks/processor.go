@@ -0,0 +1,224 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/ozoli99/Harmonia/db"
+	"github.com/ozoli99/Harmonia/models"
+	"github.com/ozoli99/Harmonia/payments"
+)
+
+const (
+	maxAttempts  = 8
+	batchSize    = 20
+	pollInterval = 15 * time.Second
+	baseBackoff  = 30 * time.Second
+	maxBackoff   = time.Hour
+)
+
+// HandlerFunc applies a normalized provider event. Returning an error
+// schedules the event for another attempt, which runs every handler for the
+// event type again, so handlers must be idempotent.
+type HandlerFunc func(ctx context.Context, event *payments.Event) error
+
+// Processor persists incoming webhook events and applies them
+// asynchronously, retrying failures with backoff until they are moved to
+// the dead-letter state.
+type Processor struct {
+	events   db.WebhookEventRepository
+	handlers map[string][]HandlerFunc
+	logger   *zap.Logger
+	wake     chan struct{}
+}
+
+func NewProcessor(events db.WebhookEventRepository, logger *zap.Logger) *Processor {
+	return &Processor{
+		events:   events,
+		handlers: make(map[string][]HandlerFunc),
+		logger:   logger,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Handle registers fn for a normalized event type. Handlers of the same type
+// run in registration order. Events without a handler are stored and marked
+// ignored.
+func (p *Processor) Handle(eventType string, fn HandlerFunc) {
+	p.handlers[eventType] = append(p.handlers[eventType], fn)
+}
+
+// Receive stores event for processing and reports whether it had already
+// been received. It returns only after the event is durably stored.
+func (p *Processor) Receive(ctx context.Context, provider string, event *payments.Event, payload []byte) (bool, error) {
+	normalized, err := json.Marshal(event)
+	if err != nil {
+		return false, err
+	}
+	if !json.Valid(payload) {
+		// PayPal and Stripe both send JSON; keep anything else inspectable.
+		payload, _ = json.Marshal(string(payload))
+	}
+
+	record := &models.WebhookEvent{
+		OrganizationID: eventOrganization(event),
+		Provider:       provider,
+		EventID:        event.ID,
+		EventType:      event.Type,
+		ProviderType:   event.ProviderType,
+		Payload:        payload,
+		Event:          normalized,
+	}
+	var accountRef string
+	if event.Account != nil {
+		accountRef = event.Account.ID
+	}
+	inserted, err := p.events.Record(ctx, record, eventPaymentRef(event), accountRef)
+	if err != nil {
+		return false, err
+	}
+	if !inserted {
+		return true, nil
+	}
+
+	p.Notify()
+	return false, nil
+}
+
+// Notify wakes the worker so newly due events are processed without waiting
+// for the next poll.
+func (p *Processor) Notify() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run processes due events until ctx is canceled.
+func (p *Processor) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		p.processDue(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-p.wake:
+		}
+	}
+}
+
+func (p *Processor) processDue(ctx context.Context) {
+	for ctx.Err() == nil {
+		events, err := p.events.ClaimDue(ctx, batchSize)
+		if err != nil {
+			p.logger.Error("Failed to claim webhook events", zap.Error(err))
+			return
+		}
+		for i := range events {
+			p.process(ctx, &events[i])
+		}
+		if len(events) < batchSize {
+			return
+		}
+	}
+}
+
+func (p *Processor) process(ctx context.Context, record *models.WebhookEvent) {
+	logger := p.logger.With(
+		zap.Int("webhook_event_id", record.ID),
+		zap.String("event_id", record.EventID),
+		zap.String("event_type", record.EventType),
+		zap.Int("attempt", record.Attempts),
+	)
+
+	handlers := p.handlers[record.EventType]
+	if len(handlers) == 0 {
+		if err := p.events.MarkProcessed(ctx, record.ID, "ignored"); err != nil {
+			logger.Error("Failed to mark webhook event ignored", zap.Error(err))
+		}
+		return
+	}
+
+	var event payments.Event
+	err := json.Unmarshal(record.Event, &event)
+	for _, handler := range handlers {
+		if err != nil {
+			break
+		}
+		err = p.apply(ctx, handler, &event)
+	}
+	if err == nil {
+		if err := p.events.MarkProcessed(ctx, record.ID, "processed"); err != nil {
+			logger.Error("Failed to mark webhook event processed", zap.Error(err))
+		}
+		return
+	}
+
+	var next *time.Time
+	if record.Attempts < maxAttempts {
+		at := time.Now().Add(backoff(record.Attempts))
+		next = &at
+		logger.Warn("Webhook event failed, will retry", zap.Time("next_attempt_at", at), zap.Error(err))
+	} else {
+		logger.Error("Webhook event failed permanently", zap.Error(err))
+	}
+	if err := p.events.MarkFailed(ctx, record.ID, err.Error(), next); err != nil {
+		logger.Error("Failed to record webhook event failure", zap.Error(err))
+	}
+}
+
+func (p *Processor) apply(ctx context.Context, handler HandlerFunc, event *payments.Event) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return handler(ctx, event)
+}
+
+func backoff(attempt int) time.Duration {
+	d := baseBackoff << (attempt - 1)
+	if d <= 0 || d > maxBackoff {
+		return maxBackoff
+	}
+	return d
+}
+
+func eventOrganization(event *payments.Event) *int {
+	var metadata map[string]string
+	switch {
+	case event.Payment != nil:
+		metadata = event.Payment.Metadata
+	case event.Refund != nil:
+		metadata = event.Refund.Metadata
+	case event.Subscription != nil:
+		metadata = event.Subscription.Metadata
+	}
+
+	id, err := strconv.Atoi(metadata["organization_id"])
+	if err != nil {
+		return nil
+	}
+	return &id
+}
+
+func eventPaymentRef(event *payments.Event) string {
+	switch {
+	case event.Payment != nil:
+		return event.Payment.PaymentID
+	case event.Refund != nil:
+		return event.Refund.PaymentID
+	case event.Dispute != nil:
+		return event.Dispute.PaymentID
+	}
+	return ""
+}